@@ -9,6 +9,8 @@ import (
 	"sync"
 	"time"
 
+	"Agentic_Engine/inference"
+
 	"github.com/gorilla/mux"
 )
 
@@ -39,6 +41,7 @@ type AnalyticsService struct {
 	cache         map[int64]*AnalyticsSummary
 	cacheMutex    sync.RWMutex
 	cacheExpiry   time.Duration
+	experiments   *inference.ExperimentManager
 }
 
 // NewAnalyticsService creates a new analytics service
@@ -161,10 +164,35 @@ func (s *AnalyticsService) GetAnalyticsSummary(ctx context.Context, userID int64
 	return summary, nil
 }
 
+// SetExperimentManager sets the source of prompt experiment statistics
+func (s *AnalyticsService) SetExperimentManager(experiments *inference.ExperimentManager) {
+	s.experiments = experiments
+}
+
+// GetExperimentReports returns per-variant statistics for every experiment
+func (s *AnalyticsService) GetExperimentReports(ctx context.Context) ([]*inference.ExperimentReport, error) {
+	if s.experiments == nil {
+		return nil, fmt.Errorf("experiments are not configured")
+	}
+
+	var reports []*inference.ExperimentReport
+	for _, experiment := range s.experiments.List() {
+		report, err := s.experiments.Report(experiment.ID)
+		if err != nil {
+			// Deleted since it was listed
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
 // RegisterHandlers registers the analytics API handlers
 func (s *AnalyticsService) RegisterHandlers(router *mux.Router) {
 	router.HandleFunc("/api/v1/analytics/summary", s.handleGetAnalyticsSummary).Methods("GET")
 	router.HandleFunc("/api/v1/analytics/top-capabilities", s.handleGetTopCapabilities).Methods("GET")
+	router.HandleFunc("/api/v1/analytics/experiments", s.handleGetExperimentReports).Methods("GET")
+	router.HandleFunc("/api/v1/analytics/experiments/{id}", s.handleGetExperimentReport).Methods("GET")
 }
 
 // handleGetAnalyticsSummary handles GET /api/v1/analytics/summary
@@ -215,4 +243,37 @@ func (s *AnalyticsService) handleGetTopCapabilities(w http.ResponseWriter, r *ht
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"capabilities": topCapabilities,
 	})
-}
\ No newline at end of file
+}
+
+// handleGetExperimentReports handles GET /api/v1/analytics/experiments
+func (s *AnalyticsService) handleGetExperimentReports(w http.ResponseWriter, r *http.Request) {
+	reports, err := s.GetExperimentReports(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get experiment reports: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"experiments": reports,
+	})
+}
+
+// handleGetExperimentReport handles GET /api/v1/analytics/experiments/{id}
+func (s *AnalyticsService) handleGetExperimentReport(w http.ResponseWriter, r *http.Request) {
+	if s.experiments == nil {
+		http.Error(w, "Experiments are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	report, err := s.experiments.Report(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get experiment report: %v", err), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"report": report,
+	})
+}
@@ -90,6 +90,9 @@ func setupServices(
 
 	// Create analytics service
 	analyticsService := NewAnalyticsService(workflowService)
+	if coreInference != nil {
+		analyticsService.SetExperimentManager(coreInference.Experiments())
+	}
 
 	return &ServiceContainer{
 		AuthService:           authService,
@@ -3,8 +3,11 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"time"
@@ -25,6 +28,7 @@ type SimpleAPIServer struct {
 	dbPath             string
 	inferenceService   *inference.InferenceService
 	workflowService    *WorkflowOrchestrationService // Added workflow orchestration service
+	analyticsService   *AnalyticsService
 	shutdownSignalChan chan<- struct{}               // Channel to signal main to shut down
 }
 
@@ -47,16 +51,23 @@ func NewSimpleAPIServer(port int, dbPath string, shutdownSignal chan<- struct{},
 	// Initialize repositories
 	agentRepo := database.NewSimpleAgentRepository(agentCollection)
 
-	// Initialize inference service
-	// Assuming NewInferenceService takes the database. Adjust if its signature is different.
-	infService, err := inference.NewInferenceService(db)
-	if err != nil {
-		db.Close() // Clean up database if inference service init fails
-		return nil, fmt.Errorf("failed to initialize inference service: %w", err)
+	// Use the caller's inference service when provided, otherwise create one.
+	infService := inferenceService
+	if infService == nil {
+		infService, err = inference.NewInferenceService(db)
+		if err != nil {
+			db.Close() // Clean up database if inference service init fails
+			return nil, fmt.Errorf("failed to initialize inference service: %w", err)
+		}
 	}
 
 	// Initialize workflow orchestration service
 	workflowService := NewWorkflowOrchestrationService()
+	workflowService.SetInferenceService(infService)
+
+	// Analytics reports on workflows and prompt experiments
+	analyticsService := NewAnalyticsService(workflowService)
+	analyticsService.SetExperimentManager(infService.Experiments())
 
 	apiServer := &SimpleAPIServer{
 		db:                 db,
@@ -65,6 +76,7 @@ func NewSimpleAPIServer(port int, dbPath string, shutdownSignal chan<- struct{},
 		dbPath:             dbPath,
 		inferenceService:   infService,      // Store the inference service
 		workflowService:    workflowService, // Store the workflow service
+		analyticsService:   analyticsService,
 		router:             mux.NewRouter(), // Initialize the router for the APIServer instance
 		shutdownSignalChan: shutdownSignal,
 	}
@@ -102,10 +114,75 @@ func (s *SimpleAPIServer) setupRoutes() {
 	api.HandleFunc("/settings/api-keys", s.handleAPIKeys).Methods("POST")
 	api.HandleFunc("/inference/models", s.handleInferenceModels).Methods("GET")
 	api.HandleFunc("/inference/moa/{type}", s.handleMOASettings).Methods("POST")
+	api.HandleFunc("/inference/generate", s.handleInferenceGenerate).Methods("POST")
+	api.HandleFunc("/inference/cache/threshold", s.handleSemanticCacheThreshold).Methods("POST")
+	api.HandleFunc("/inference/cache", s.handleClearSemanticCache).Methods("DELETE")
+	api.HandleFunc("/inference/hedging", s.handleHedgeDelay).Methods("POST")
+	api.HandleFunc("/inference/tools/generate", s.handleToolGenerate).Methods("POST")
+	api.HandleFunc("/inference/structured", s.handleStructuredGenerate).Methods("POST")
+	api.HandleFunc("/inference/chunked", s.handleChunkedGenerate).Methods("POST")
+	api.HandleFunc("/inference/ensemble", s.handleEnsembleGenerate).Methods("POST")
+	api.HandleFunc("/tools", s.listToolsHandler).Methods("GET")
+	api.HandleFunc("/tools", s.registerToolHandler).Methods("POST")
+	api.HandleFunc("/tools/{name}", s.deleteToolHandler).Methods("DELETE")
+
+	// Prompt template routes
+	api.HandleFunc("/prompts", s.listPromptTemplatesHandler).Methods("GET")
+	api.HandleFunc("/prompts", s.createPromptTemplateHandler).Methods("POST")
+	api.HandleFunc("/prompts/preview", s.previewPromptTemplateHandler).Methods("POST")
+	api.HandleFunc("/prompts/{name}", s.getPromptTemplateHandler).Methods("GET")
+	api.HandleFunc("/prompts/{name}", s.updatePromptTemplateHandler).Methods("PUT")
+	api.HandleFunc("/prompts/{name}", s.deletePromptTemplateHandler).Methods("DELETE")
+	api.HandleFunc("/prompts/{name}/versions", s.listPromptTemplateVersionsHandler).Methods("GET")
+	api.HandleFunc("/prompts/{name}/render", s.renderPromptTemplateHandler).Methods("POST")
+
+	// Prompt experiment routes
+	api.HandleFunc("/experiments", s.listExperimentsHandler).Methods("GET")
+	api.HandleFunc("/experiments", s.createExperimentHandler).Methods("POST")
+	api.HandleFunc("/experiments/generations/{id}/feedback", s.experimentFeedbackHandler).Methods("POST")
+	api.HandleFunc("/experiments/{id}", s.getExperimentHandler).Methods("GET")
+	api.HandleFunc("/experiments/{id}", s.deleteExperimentHandler).Methods("DELETE")
+	api.HandleFunc("/experiments/{id}/start", s.setExperimentActiveHandler(true)).Methods("POST")
+	api.HandleFunc("/experiments/{id}/stop", s.setExperimentActiveHandler(false)).Methods("POST")
+	api.HandleFunc("/experiments/{id}/generate", s.handleExperimentGenerate).Methods("POST")
+
+	// Offline evaluation routes
+	api.HandleFunc("/evaluations/datasets", s.listEvalDatasetsHandler).Methods("GET")
+	api.HandleFunc("/evaluations/datasets", s.createEvalDatasetHandler).Methods("POST")
+	api.HandleFunc("/evaluations/datasets/{id}", s.getEvalDatasetHandler).Methods("GET")
+	api.HandleFunc("/evaluations/datasets/{id}", s.deleteEvalDatasetHandler).Methods("DELETE")
+	api.HandleFunc("/evaluations/runs", s.listEvalRunsHandler).Methods("GET")
+	api.HandleFunc("/evaluations/runs", s.handleEvalRun).Methods("POST")
+	api.HandleFunc("/evaluations/runs/{id}", s.getEvalRunHandler).Methods("GET")
+	api.HandleFunc("/evaluations/compare", s.compareEvalRunsHandler).Methods("GET")
+
+	// Batch job routes
+	api.HandleFunc("/batches", s.listBatchJobsHandler).Methods("GET")
+	api.HandleFunc("/batches", s.createBatchJobHandler).Methods("POST")
+	api.HandleFunc("/batches/{id}", s.getBatchJobHandler).Methods("GET")
+	api.HandleFunc("/batches/{id}", s.deleteBatchJobHandler).Methods("DELETE")
+	api.HandleFunc("/batches/{id}/pause", s.batchJobActionHandler((*inference.BatchManager).Pause)).Methods("POST")
+	api.HandleFunc("/batches/{id}/resume", s.batchJobActionHandler((*inference.BatchManager).Resume)).Methods("POST")
+	api.HandleFunc("/batches/{id}/cancel", s.batchJobActionHandler((*inference.BatchManager).Cancel)).Methods("POST")
+	api.HandleFunc("/batches/{id}/results", s.batchJobResultsHandler).Methods("GET")
+
+	// Guardrail routes
+	api.HandleFunc("/guardrails/pii", s.getPIIGuardrailHandler).Methods("GET")
+	api.HandleFunc("/guardrails/pii", s.updatePIIGuardrailHandler).Methods("PUT")
+
+	// Conversation session routes
+	api.HandleFunc("/sessions", s.createSessionHandler).Methods("POST")
+	api.HandleFunc("/sessions", s.listSessionsHandler).Methods("GET")
+	api.HandleFunc("/sessions/{id}", s.getSessionHandler).Methods("GET")
+	api.HandleFunc("/sessions/{id}", s.deleteSessionHandler).Methods("DELETE")
+	api.HandleFunc("/sessions/{id}/history", s.getSessionHistoryHandler).Methods("GET")
 
 	// Register workflow orchestration routes
 	s.workflowService.RegisterHandlers(api)
 
+	// Register analytics routes (they carry the full /api/v1 path)
+	s.analyticsService.RegisterHandlers(s.router)
+
 	// Static file serving for UI
 	s.router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
 
@@ -141,7 +218,7 @@ func (s *SimpleAPIServer) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Session-Token")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -195,6 +272,14 @@ func (s *SimpleAPIServer) createAgentHandler(w http.ResponseWriter, r *http.Requ
 		agent.Collection = "default"
 	}
 
+	// Agents may only reference prompt templates that exist
+	if agent.PromptTemplate != "" {
+		if _, err := s.inferenceService.PromptTemplates().Get(agent.PromptTemplate, agent.PromptTemplateVersion); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Create agent
 	ctx := context.Background()
 	if err := s.agentRepo.CreateAgent(ctx, &agent); err != nil {
@@ -340,7 +425,7 @@ func (s *SimpleAPIServer) handleInferenceModels(w http.ResponseWriter, r *http.R
 		"primary": {
 			"llama-4-scout-17b-16e-instruct",
 			"gpt-4",
-			"claude-3-sonnet",
+			inference.DefaultAnthropicModel,
 		},
 		"fallback": {
 			"gemini-1.5-flash-latest",
@@ -384,3 +469,1323 @@ func (s *SimpleAPIServer) handleMOASettings(w http.ResponseWriter, r *http.Reque
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// inferenceContext derives the context of an inference call from the request.
+// It ends when the client disconnects or when the server's write timeout would
+// prevent the response from being delivered anyway.
+func (s *SimpleAPIServer) inferenceContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := sessionContext(r)
+	if s.httpServer != nil && s.httpServer.WriteTimeout > 0 {
+		return context.WithTimeout(ctx, s.httpServer.WriteTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// agentContext scopes ctx to the agent a request runs under, so that the
+// agent's PII redaction flag applies. It responds 404 for unknown agents.
+func (s *SimpleAPIServer) agentContext(w http.ResponseWriter, ctx context.Context, agentID string) (context.Context, *database.SimpleAgent, bool) {
+	ctx, agent, err := s.inferenceService.WithAgent(ctx, agentID)
+	if err != nil {
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return ctx, nil, false
+	}
+	return ctx, agent, true
+}
+
+// sessionContext returns the request context carrying the session access
+// token sent in the X-Session-Token header.
+func sessionContext(r *http.Request) context.Context {
+	if token := r.Header.Get("X-Session-Token"); token != "" {
+		return inference.WithSessionToken(r.Context(), token)
+	}
+	return r.Context()
+}
+
+// inferenceGenerateRequest is the body of /inference/generate.
+type inferenceGenerateRequest struct {
+	SessionID   string                  `json:"session_id"`
+	AgentID     string                  `json:"agent_id"` // Optional; applies the agent's PII redaction setting and prompt template
+	Model       string                  `json:"model"`
+	Prompt      string                  `json:"prompt"`
+	Instruction string                  `json:"instruction"`
+	HedgeDelay  *int                    `json:"hedge_delay_ms"` // Optional per-request hedging; 0 turns it off
+	Attachments []inference.ContentPart `json:"attachments"`    // Optional base64 images and files
+	Variables   map[string]interface{}  `json:"variables"`      // Optional values for the agent's prompt template
+}
+
+// maxUploadMemory is the part of a multipart upload kept in memory; the rest
+// is buffered to temporary files.
+const maxUploadMemory = 32 << 20
+
+// maxGenerateRequestBytes bounds the body of /inference/generate, JSON or
+// multipart. Base64 attachments are a third larger than the files they carry.
+const maxGenerateRequestBytes = 64 << 20
+
+// parseGenerateUpload reads a multipart/form-data generation request: the
+// request fields as form values and each uploaded file as an attachment.
+func parseGenerateUpload(r *http.Request, request *inferenceGenerateRequest) error {
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		return fmt.Errorf("invalid multipart form: %w", err)
+	}
+	request.SessionID = r.FormValue("session_id")
+	request.AgentID = r.FormValue("agent_id")
+	request.Model = r.FormValue("model")
+	request.Prompt = r.FormValue("prompt")
+	request.Instruction = r.FormValue("instruction")
+	if raw := r.FormValue("hedge_delay_ms"); raw != "" {
+		delay, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid hedge_delay_ms '%s'", raw)
+		}
+		request.HedgeDelay = &delay
+	}
+	for _, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				return fmt.Errorf("failed to read upload '%s': %w", header.Filename, err)
+			}
+			data, err := io.ReadAll(io.LimitReader(file, inference.MaxContentPartBytes+1))
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read upload '%s': %w", header.Filename, err)
+			}
+			mimeType, _, _ := mime.ParseMediaType(header.Header.Get("Content-Type"))
+			if mimeType == "" || mimeType == "application/octet-stream" {
+				mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+			}
+			request.Attachments = append(request.Attachments, inference.NewContentPart(header.Filename, mimeType, data))
+		}
+	}
+	return nil
+}
+
+// Inference generation handler. Accepts JSON, with optional base64
+// "attachments", or multipart/form-data with uploaded files.
+func (s *SimpleAPIServer) handleInferenceGenerate(w http.ResponseWriter, r *http.Request) {
+	var request inferenceGenerateRequest
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxGenerateRequestBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	if mediaType == "multipart/form-data" {
+		err = parseGenerateUpload(r, &request)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&request)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil && mediaType == "multipart/form-data" {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Prompt == "" {
+		http.Error(w, "prompt is required", http.StatusBadRequest)
+		return
+	}
+	if request.HedgeDelay != nil && *request.HedgeDelay < 0 {
+		http.Error(w, "hedge_delay_ms must not be negative", http.StatusBadRequest)
+		return
+	}
+	if len(request.Attachments) > inference.MaxContentParts {
+		http.Error(w, fmt.Sprintf("at most %d attachments are allowed", inference.MaxContentParts), http.StatusBadRequest)
+		return
+	}
+	for _, attachment := range request.Attachments {
+		if err := attachment.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := s.inferenceContext(r)
+	defer cancel()
+	ctx, agent, ok := s.agentContext(w, ctx, request.AgentID)
+	if !ok {
+		return
+	}
+	if agent != nil && agent.PromptTemplate != "" {
+		// The prompt is available to the template as {{.Prompt}}
+		vars := map[string]interface{}{"Prompt": request.Prompt}
+		for name, value := range request.Variables {
+			vars[name] = value
+		}
+		ref := inference.PromptTemplateRef{Name: agent.PromptTemplate, Version: agent.PromptTemplateVersion}
+		request.Prompt, err = s.inferenceService.PromptTemplates().Render(ref, vars)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render the agent's prompt template: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if request.HedgeDelay != nil {
+		ctx = inference.WithHedgeDelay(ctx, time.Duration(*request.HedgeDelay)*time.Millisecond)
+	}
+	var result *inference.GenerationResult
+	if len(request.Attachments) > 0 {
+		result, err = s.inferenceService.GenerateTextWithParts(ctx, request.SessionID, request.Model, request.Prompt, request.Instruction, request.Attachments)
+	} else {
+		result, err = s.inferenceService.GenerateTextWithMetadata(ctx, request.SessionID, request.Model, request.Prompt, request.Instruction)
+	}
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, inference.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, inference.ErrMultimodalUnsupported) || errors.Is(err, inference.ErrInvalidContentPart) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Generation timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating text: %v", err)
+		http.Error(w, fmt.Sprintf("Generation failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"session_id": request.SessionID,
+		"response":   result.Content,
+		"metadata":   result.Metadata,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Semantic cache threshold handler
+func (s *SimpleAPIServer) handleSemanticCacheThreshold(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Threshold float32 `json:"threshold"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.inferenceService.SetSemanticCacheThreshold(request.Threshold); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"threshold": request.Threshold,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Hedge delay handler
+func (s *SimpleAPIServer) handleHedgeDelay(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		DelayMs int `json:"delay_ms"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.inferenceService.SetHedgeDelay(time.Duration(request.DelayMs) * time.Millisecond); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"delay_ms": request.DelayMs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Semantic cache clear handler
+func (s *SimpleAPIServer) handleClearSemanticCache(w http.ResponseWriter, r *http.Request) {
+	if err := s.inferenceService.ClearSemanticCache(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// Tool-calling generation handler
+func (s *SimpleAPIServer) handleToolGenerate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SessionID     string   `json:"session_id"`
+		Model         string   `json:"model"`
+		Prompt        string   `json:"prompt"`
+		Instruction   string   `json:"instruction"`
+		Tools         []string `json:"tools"`
+		MaxIterations int      `json:"max_iterations"`
+		AgentID       string   `json:"agent_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Prompt == "" {
+		http.Error(w, "prompt is required", http.StatusBadRequest)
+		return
+	}
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := s.inferenceContext(r)
+	defer cancel()
+	ctx, _, ok := s.agentContext(w, ctx, request.AgentID)
+	if !ok {
+		return
+	}
+	trace, err := s.inferenceService.GenerateTextWithTools(ctx, request.SessionID, request.Model, request.Prompt, request.Instruction, request.Tools, request.MaxIterations)
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, inference.ErrSessionNotFound) || errors.Is(err, inference.ErrToolNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Generation timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating text with tools: %v", err)
+		http.Error(w, fmt.Sprintf("Generation failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"session_id": request.SessionID,
+		"response":   trace.Answer,
+		"trace":      trace,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Structured output generation handler
+func (s *SimpleAPIServer) handleStructuredGenerate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SessionID string          `json:"session_id"`
+		Content   string          `json:"content"`
+		Schema    json.RawMessage `json:"schema"`
+		AgentID   string          `json:"agent_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Content == "" || len(request.Schema) == 0 {
+		http.Error(w, "content and schema are required", http.StatusBadRequest)
+		return
+	}
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The schema may be sent as a JSON object or as a string containing one
+	schema := string(request.Schema)
+	var schemaString string
+	if err := json.Unmarshal(request.Schema, &schemaString); err == nil {
+		schema = schemaString
+	}
+	var schemaObject map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &schemaObject); err != nil {
+		http.Error(w, "schema must be a JSON object", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := s.inferenceContext(r)
+	defer cancel()
+	ctx, _, ok := s.agentContext(w, ctx, request.AgentID)
+	if !ok {
+		return
+	}
+	result, err := s.inferenceService.GenerateStructuredOutput(ctx, request.SessionID, request.Content, schema)
+	var validationErr *inference.SchemaValidationError
+	if errors.As(err, &validationErr) {
+		respondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":             validationErr.Error(),
+			"validation_errors": validationErr.Errors,
+			"response":          validationErr.Response,
+		})
+		return
+	}
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, inference.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Generation timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating structured output: %v", err)
+		http.Error(w, fmt.Sprintf("Generation failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"session_id": request.SessionID,
+		"result":     json.RawMessage(result),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Chunked (context manager) generation handler
+func (s *SimpleAPIServer) handleChunkedGenerate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Provider             string `json:"provider"`
+		Prompt               string `json:"prompt"`
+		Instruction          string `json:"instruction"`
+		Synthesis            string `json:"synthesis"`
+		SynthesisInstruction string `json:"synthesis_instruction"`
+		AgentID              string `json:"agent_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Prompt == "" || request.Provider == "" {
+		http.Error(w, "prompt and provider are required", http.StatusBadRequest)
+		return
+	}
+	mode, err := inference.ParseSynthesisMode(request.Synthesis)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := s.inferenceContext(r)
+	defer cancel()
+	ctx, _, ok := s.agentContext(w, ctx, request.AgentID)
+	if !ok {
+		return
+	}
+	result, err := s.inferenceService.GenerateTextWithSynthesis(ctx, request.Prompt, request.Instruction, request.Provider, mode, request.SynthesisInstruction)
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Generation timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating chunked text: %v", err)
+		http.Error(w, fmt.Sprintf("Generation failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"response":  result,
+		"synthesis": mode.String(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Ensemble generation handler
+func (s *SimpleAPIServer) handleEnsembleGenerate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SessionID   string `json:"session_id"`
+		Prompt      string `json:"prompt"`
+		Instruction string `json:"instruction"`
+		AgentID     string `json:"agent_id"`
+		inference.EnsembleOptions
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Prompt == "" {
+		http.Error(w, "prompt is required", http.StatusBadRequest)
+		return
+	}
+	if request.Mode != "" && request.Mode != inference.EnsembleVote && request.Mode != inference.EnsembleJudge {
+		http.Error(w, "mode must be 'vote' or 'judge'", http.StatusBadRequest)
+		return
+	}
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := s.inferenceContext(r)
+	defer cancel()
+	ctx, _, ok := s.agentContext(w, ctx, request.AgentID)
+	if !ok {
+		return
+	}
+	result, err := s.inferenceService.GenerateTextWithEnsemble(ctx, request.SessionID, request.Prompt, request.Instruction, request.EnsembleOptions)
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, inference.ErrSessionNotFound) || errors.Is(err, inference.ErrEnsembleModelNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, inference.ErrEnsembleTooSmall) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Generation timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating ensemble text: %v", err)
+		http.Error(w, fmt.Sprintf("Generation failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"session_id": request.SessionID,
+		"response":   result.Answer,
+		"ensemble":   result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// List tools handler
+func (s *SimpleAPIServer) listToolsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.inferenceService.Tools().List())
+}
+
+// Register HTTP tool handler
+func (s *SimpleAPIServer) registerToolHandler(w http.ResponseWriter, r *http.Request) {
+	var tool inference.ToolDefinition
+	if err := json.NewDecoder(r.Body).Decode(&tool); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.inferenceService.Tools().RegisterHTTP(tool); errors.Is(err, inference.ErrToolEndpointNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tool.Headers = nil // Header values are not echoed back
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tool)
+}
+
+// Delete tool handler
+func (s *SimpleAPIServer) deleteToolHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := s.inferenceService.Tools().Unregister(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// promptTemplateVersion reads the optional ?version= query parameter (0 = latest).
+func promptTemplateVersion(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("version")
+	if raw == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version: %s", raw)
+	}
+	return version, nil
+}
+
+// respondWithPromptTemplateError maps prompt template errors to HTTP statuses.
+func respondWithPromptTemplateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, inference.ErrPromptTemplateNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, inference.ErrPromptTemplateInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error handling prompt template: %v", err)
+		http.Error(w, "Prompt template operation failed", http.StatusInternalServerError)
+	}
+}
+
+// List prompt templates handler (latest version of each)
+func (s *SimpleAPIServer) listPromptTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.inferenceService.PromptTemplates().List())
+}
+
+// Create prompt template handler. Creating an existing name adds a new version.
+func (s *SimpleAPIServer) createPromptTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Body        string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := s.inferenceService.PromptTemplates().Create(r.Context(), request.Name, request.Description, request.Body)
+	if err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+// Get prompt template handler
+func (s *SimpleAPIServer) getPromptTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := promptTemplateVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := s.inferenceService.PromptTemplates().Get(mux.Vars(r)["name"], version)
+	if err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+// Update prompt template handler. Versions are immutable, so this stores a new version.
+func (s *SimpleAPIServer) updatePromptTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Description string `json:"description"`
+		Body        string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	registry := s.inferenceService.PromptTemplates()
+	latest, err := registry.Get(name, 0)
+	if err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+	if request.Description == "" {
+		request.Description = latest.Description
+	}
+
+	tmpl, err := registry.Create(r.Context(), name, request.Description, request.Body)
+	if err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+// Delete prompt template handler. Without ?version= every user version is removed.
+func (s *SimpleAPIServer) deletePromptTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := promptTemplateVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.inferenceService.PromptTemplates().Delete(r.Context(), mux.Vars(r)["name"], version); err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// List prompt template versions handler
+func (s *SimpleAPIServer) listPromptTemplateVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.inferenceService.PromptTemplates().Versions(mux.Vars(r)["name"])
+	if err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// promptRenderResponse is returned by the render and preview endpoints.
+type promptRenderResponse struct {
+	Name             string   `json:"name,omitempty"`
+	Version          int      `json:"version,omitempty"`
+	Prompt           string   `json:"prompt"`
+	Variables        []string `json:"variables"`
+	MissingVariables []string `json:"missing_variables,omitempty"`
+}
+
+// Render prompt template handler
+func (s *SimpleAPIServer) renderPromptTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Version   int                    `json:"version"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := s.inferenceService.PromptTemplates().Get(mux.Vars(r)["name"], request.Version)
+	if err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+	s.writeRenderedPromptTemplate(w, tmpl, request.Variables)
+}
+
+// Preview prompt template handler, renders a body without storing it
+func (s *SimpleAPIServer) previewPromptTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Body      string                 `json:"body"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := inference.NewPromptTemplate("", "", request.Body)
+	if err != nil {
+		respondWithPromptTemplateError(w, err)
+		return
+	}
+	s.writeRenderedPromptTemplate(w, tmpl, request.Variables)
+}
+
+// writeRenderedPromptTemplate renders tmpl and writes the promptRenderResponse.
+func (s *SimpleAPIServer) writeRenderedPromptTemplate(w http.ResponseWriter, tmpl *inference.PromptTemplate, vars map[string]interface{}) {
+	prompt, err := tmpl.Render(vars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promptRenderResponse{
+		Name:             tmpl.Name,
+		Version:          tmpl.Version,
+		Prompt:           prompt,
+		Variables:        tmpl.Variables,
+		MissingVariables: tmpl.MissingVariables(vars),
+	})
+}
+
+// List experiments handler
+func (s *SimpleAPIServer) listExperimentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.inferenceService.Experiments().List())
+}
+
+// Create experiment handler
+func (s *SimpleAPIServer) createExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	var request inference.Experiment
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	experiment, err := s.inferenceService.Experiments().Create(r.Context(), request)
+	if err != nil {
+		respondWithExperimentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(experiment)
+}
+
+// Get experiment handler
+func (s *SimpleAPIServer) getExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	experiment, err := s.inferenceService.Experiments().Get(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(experiment)
+}
+
+// Delete experiment handler
+func (s *SimpleAPIServer) deleteExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.inferenceService.Experiments().Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+		respondWithExperimentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setExperimentActiveHandler returns a handler that starts or stops an experiment
+func (s *SimpleAPIServer) setExperimentActiveHandler(active bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if err := s.inferenceService.Experiments().SetActive(r.Context(), id, active); err != nil {
+			respondWithExperimentError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     id,
+			"active": active,
+		})
+	}
+}
+
+// Experiment generate handler
+func (s *SimpleAPIServer) handleExperimentGenerate(w http.ResponseWriter, r *http.Request) {
+	var request inference.ExperimentRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	request.ExperimentID = mux.Vars(r)["id"]
+	if request.Prompt == "" && len(request.Variables) == 0 {
+		http.Error(w, "prompt or variables are required", http.StatusBadRequest)
+		return
+	}
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := s.inferenceContext(r)
+	defer cancel()
+	generation, err := s.inferenceService.GenerateWithExperiment(ctx, request)
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, inference.ErrExperimentNotFound) || errors.Is(err, inference.ErrSessionNotFound) || errors.Is(err, inference.ErrPromptTemplateNotFound) ||
+		errors.Is(err, inference.ErrAgentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, inference.ErrExperimentInactive) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Generation timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating experiment text: %v", err)
+		http.Error(w, fmt.Sprintf("Generation failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"session_id":    request.SessionID,
+		"response":      generation.Response,
+		"generation_id": generation.ID,
+		"variant":       generation.Variant,
+		"generation":    generation,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Experiment feedback handler, scores a generation served by an experiment
+func (s *SimpleAPIServer) experimentFeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Score   *float64 `json:"score"`
+		Comment string   `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Score == nil {
+		http.Error(w, "score is required", http.StatusBadRequest)
+		return
+	}
+
+	generation, err := s.inferenceService.Experiments().RecordFeedback(r.Context(), mux.Vars(r)["id"], *request.Score, request.Comment)
+	if err != nil {
+		respondWithExperimentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(generation)
+}
+
+// respondWithExperimentError maps experiment errors to HTTP statuses.
+func respondWithExperimentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, inference.ErrExperimentNotFound), errors.Is(err, inference.ErrExperimentGenerationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, inference.ErrExperimentInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error handling experiment: %v", err)
+		http.Error(w, fmt.Sprintf("Experiment update failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// respondWithEvalError maps evaluation errors to HTTP statuses.
+func respondWithEvalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, inference.ErrEvalDatasetNotFound), errors.Is(err, inference.ErrEvalRunNotFound), errors.Is(err, inference.ErrPromptTemplateNotFound),
+		errors.Is(err, inference.ErrAgentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, inference.ErrEvalInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error handling evaluation: %v", err)
+		http.Error(w, fmt.Sprintf("Evaluation failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// List evaluation datasets handler
+func (s *SimpleAPIServer) listEvalDatasetsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.inferenceService.Evaluations().ListDatasets())
+}
+
+// Create evaluation dataset handler
+func (s *SimpleAPIServer) createEvalDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	var dataset inference.EvalDataset
+	if err := json.NewDecoder(r.Body).Decode(&dataset); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.inferenceService.Evaluations().CreateDataset(r.Context(), dataset)
+	if err != nil {
+		respondWithEvalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// Get evaluation dataset handler
+func (s *SimpleAPIServer) getEvalDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	dataset, err := s.inferenceService.Evaluations().GetDataset(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithEvalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dataset)
+}
+
+// Delete evaluation dataset handler
+func (s *SimpleAPIServer) deleteEvalDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.inferenceService.Evaluations().DeleteDataset(r.Context(), mux.Vars(r)["id"]); err != nil {
+		respondWithEvalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// List evaluation runs handler, optionally filtered by ?dataset_id=
+func (s *SimpleAPIServer) listEvalRunsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.inferenceService.Evaluations().ListRuns(r.URL.Query().Get("dataset_id")))
+}
+
+// Evaluation run handler. The run continues in the background; poll
+// GET /evaluations/runs/{id} until its status is completed or failed.
+func (s *SimpleAPIServer) handleEvalRun(w http.ResponseWriter, r *http.Request) {
+	var config inference.EvalRunConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	report, err := s.inferenceService.Evaluations().Start(r.Context(), config)
+	if err != nil {
+		respondWithEvalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Get evaluation run handler
+func (s *SimpleAPIServer) getEvalRunHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := s.inferenceService.Evaluations().GetRun(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithEvalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Compare evaluation runs handler, takes ?baseline= and ?candidate= run IDs
+func (s *SimpleAPIServer) compareEvalRunsHandler(w http.ResponseWriter, r *http.Request) {
+	baselineID := r.URL.Query().Get("baseline")
+	candidateID := r.URL.Query().Get("candidate")
+	if baselineID == "" || candidateID == "" {
+		http.Error(w, "baseline and candidate parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	comparison, err := s.inferenceService.Evaluations().CompareRuns(baselineID, candidateID)
+	if err != nil {
+		respondWithEvalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
+
+// respondWithBatchError maps batch job errors to HTTP statuses.
+func respondWithBatchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, inference.ErrBatchJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, inference.ErrBatchInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, inference.ErrBatchJobState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Error handling batch job: %v", err)
+		http.Error(w, fmt.Sprintf("Batch job failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// List batch jobs handler
+func (s *SimpleAPIServer) listBatchJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.inferenceService.Batches().List())
+}
+
+// Create batch job handler. The JSONL input is the request body, or the "file"
+// field of a multipart/form-data upload; name, concurrency and
+// requests_per_minute are query parameters or form fields.
+func (s *SimpleAPIServer) createBatchJobHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.inferenceService.IsRunning() {
+		http.Error(w, "Inference service is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Read the body as is unless it is an upload; curl --data-binary sends
+	// JSONL as application/x-www-form-urlencoded
+	input := io.Reader(r.Body)
+	optionValue := r.URL.Query().Get
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		input = file
+		optionValue = r.FormValue
+	}
+	var options inference.BatchJobOptions
+	options.Name = optionValue("name")
+	for field, target := range map[string]*int{"concurrency": &options.Concurrency, "requests_per_minute": &options.RequestsPerMinute} {
+		if raw := optionValue(field); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s '%s'", field, raw), http.StatusBadRequest)
+				return
+			}
+			*target = value
+		}
+	}
+
+	job, err := s.inferenceService.Batches().Create(input, options)
+	if err != nil {
+		respondWithBatchError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Get batch job handler, reports status and progress
+func (s *SimpleAPIServer) getBatchJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := s.inferenceService.Batches().Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithBatchError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Delete batch job handler, cancels the job and removes its results
+func (s *SimpleAPIServer) deleteBatchJobHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.inferenceService.Batches().Delete(mux.Vars(r)["id"]); err != nil {
+		respondWithBatchError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// batchJobActionHandler returns a handler that pauses, resumes or cancels a batch job
+func (s *SimpleAPIServer) batchJobActionHandler(action func(*inference.BatchManager, string) (*inference.BatchJob, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := action(s.inferenceService.Batches(), mux.Vars(r)["id"])
+		if err != nil {
+			respondWithBatchError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+// Batch job results handler, downloads the results written so far as JSONL
+func (s *SimpleAPIServer) batchJobResultsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	results, err := s.inferenceService.Batches().OpenResults(id)
+	if err != nil {
+		respondWithBatchError(w, err)
+		return
+	}
+	defer results.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"batch-%s.jsonl\"", id))
+	if _, err := io.Copy(w, results); err != nil {
+		log.Printf("Error sending results of batch job %s: %v", id, err)
+	}
+}
+
+// Get PII guardrail handler, reports the configuration and audit counts
+func (s *SimpleAPIServer) getPIIGuardrailHandler(w http.ResponseWriter, r *http.Request) {
+	guardrail := s.inferenceService.PIIGuardrail()
+	response := map[string]interface{}{
+		"config": guardrail.Config(),
+		"audit":  guardrail.Audit(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Update PII guardrail handler, replaces the enabled flag, types and custom rules
+func (s *SimpleAPIServer) updatePIIGuardrailHandler(w http.ResponseWriter, r *http.Request) {
+	var config inference.PIIGuardrailConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	guardrail := s.inferenceService.PIIGuardrail()
+	if err := guardrail.Configure(config); err != nil {
+		if errors.Is(err, inference.ErrPIIRuleInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error configuring PII guardrail: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to configure PII guardrail: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(guardrail.Config())
+}
+
+// Create session handler
+func (s *SimpleAPIServer) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		OwnerID        int64  `json:"owner_id"`
+		Model          string `json:"model"`
+		MemoryStrategy string `json:"memory_strategy"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	strategy, err := inference.ParseMemoryStrategy(request.MemoryStrategy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.inferenceService.CreateSession(request.OwnerID, request.Model, strategy)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+// List sessions handler
+func (s *SimpleAPIServer) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	var ownerID int64
+	if ownerIDStr := r.URL.Query().Get("owner_id"); ownerIDStr != "" {
+		var err error
+		ownerID, err = strconv.ParseInt(ownerIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid owner_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sessions := s.inferenceService.ListSessions(ownerID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// Get session handler
+func (s *SimpleAPIServer) getSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	session, err := s.inferenceService.GetSession(sessionContext(r), sessionID)
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// Delete session handler
+func (s *SimpleAPIServer) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	err := s.inferenceService.DeleteSession(sessionContext(r), sessionID)
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Get session history handler
+func (s *SimpleAPIServer) getSessionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	offset, limit := 0, 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		if offset, err = strconv.Atoi(offsetStr); err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	history, total, err := s.inferenceService.GetSessionHistoryPage(sessionContext(r), sessionID, offset, limit)
+	if errors.Is(err, inference.ErrSessionAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, inference.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting session history: %v", err)
+		http.Error(w, "Failed to get session history", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"session_id": sessionID,
+		"messages":   history,
+		"offset":     offset,
+		"total":      total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
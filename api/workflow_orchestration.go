@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -32,6 +33,8 @@ type WorkflowRequest struct {
 	TargetID     string                 `json:"target_id" binding:"required"`
 	CapabilityID string                 `json:"capability_id" binding:"required"`
 	Input        map[string]interface{} `json:"input" binding:"required"`
+	// Optional prompt template rendered with Input in place of Input["prompt"]
+	PromptTemplate *inference.PromptTemplateRef `json:"prompt_template,omitempty"`
 }
 
 // WorkflowResult represents the result of a workflow
@@ -47,6 +50,8 @@ type WorkflowResult struct {
 	Output       map[string]interface{} `json:"output,omitempty"`
 	Error        string                 `json:"error,omitempty"`
 	OwnerID      int64                  `json:"owner_id"`
+	// Template the prompt was rendered from, with the version that was resolved
+	PromptTemplate *inference.PromptTemplateRef `json:"prompt_template,omitempty"`
 }
 
 // WorkflowOrchestrationService manages workflow orchestration
@@ -88,6 +93,27 @@ func (s *WorkflowOrchestrationService) StartWorkflow(ctx context.Context, req Wo
 		OwnerID:      userID,
 	}
 
+	// Pin the template version now so later edits don't change a running workflow
+	if req.PromptTemplate != nil {
+		if s.inferenceService == nil {
+			return nil, fmt.Errorf("prompt templates are unavailable without an inference service")
+		}
+		tmpl, err := s.inferenceService.PromptTemplates().Resolve(*req.PromptTemplate)
+		if err != nil {
+			return nil, err
+		}
+		result.PromptTemplate = &inference.PromptTemplateRef{Name: tmpl.Name, Version: tmpl.Version}
+	}
+
+	// The workflow runs under the agent's PII redaction flag
+	if s.inferenceService != nil {
+		scoped, _, err := s.inferenceService.WithAgent(ctx, req.AgentID)
+		if err != nil {
+			return nil, err
+		}
+		ctx = scoped
+	}
+
 	// Store workflow in memory
 	s.workflows[workflowID] = result
 
@@ -104,15 +130,26 @@ func (s *WorkflowOrchestrationService) executeWorkflow(ctx context.Context, resu
 	result.Status = WorkflowStatusRunning
 	s.mutex.Unlock()
 
-	// Extract prompt from input
-	prompt, ok := result.Input["prompt"].(string)
-	if !ok {
-		s.completeWorkflowWithError(result, "Missing or invalid prompt in input")
-		return
+	// Render the prompt template, or extract the prompt from input
+	var prompt string
+	if result.PromptTemplate != nil {
+		rendered, err := s.inferenceService.PromptTemplates().Render(*result.PromptTemplate, result.Input)
+		if err != nil {
+			s.completeWorkflowWithError(result, fmt.Sprintf("Failed to render prompt template: %v", err))
+			return
+		}
+		prompt = rendered
+	} else {
+		var ok bool
+		prompt, ok = result.Input["prompt"].(string)
+		if !ok {
+			s.completeWorkflowWithError(result, "Missing or invalid prompt in input")
+			return
+		}
 	}
 
 	// Simulate workflow execution
-	log.Printf("Executing workflow %s with prompt: %s", result.ID, prompt)
+	log.Printf("Executing workflow %s for agent %s (prompt: %d characters)", result.ID, result.AgentID, len(prompt))
 
 	// Simulate processing time with context awareness
 	var output string
@@ -234,6 +271,14 @@ func (s *WorkflowOrchestrationService) handleStartWorkflow(w http.ResponseWriter
 	}
 
 	result, err := s.StartWorkflow(r.Context(), req, userID)
+	if errors.Is(err, inference.ErrAgentNotFound) {
+		http.Error(w, fmt.Sprintf("Failed to start workflow: %v", err), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, inference.ErrPromptTemplateNotFound) {
+		http.Error(w, fmt.Sprintf("Failed to start workflow: %v", err), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to start workflow: %v", err), http.StatusInternalServerError)
 		return
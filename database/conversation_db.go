@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ConversationDB manages the conversation history database
+type ConversationDB struct {
+	db *sql.DB
+}
+
+// NewConversationDB creates a new conversation database connection
+func NewConversationDB(dbPath string) (*ConversationDB, error) {
+	// Ensure the directory exists
+	dbDir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create conversation database directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open conversation database: %w", err)
+	}
+
+	// Initialize schema if needed
+	if err := initConversationSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &ConversationDB{db: db}, nil
+}
+
+// GetDB returns the underlying database connection
+func (c *ConversationDB) GetDB() *sql.DB {
+	return c.db
+}
+
+// Close closes the database connection
+func (c *ConversationDB) Close() error {
+	return c.db.Close()
+}
+
+// initConversationSchema initializes the conversation database schema
+func initConversationSchema(db *sql.DB) error {
+	// Create sessions table
+	sessionsTable := `
+	CREATE TABLE IF NOT EXISTS conversation_sessions (
+		id TEXT PRIMARY KEY,
+		owner_id INTEGER NOT NULL DEFAULT 0,
+		model TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Create messages table
+	messagesTable := `
+	CREATE TABLE IF NOT EXISTS conversation_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		content TEXT NOT NULL,
+		tokens INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (session_id) REFERENCES conversation_sessions(id) ON DELETE CASCADE
+	);`
+
+	// Create indexes for the common lookups
+	sessionsOwnerIndex := `CREATE INDEX IF NOT EXISTS idx_conversation_sessions_owner ON conversation_sessions(owner_id, updated_at);`
+	messagesSessionIndex := `CREATE INDEX IF NOT EXISTS idx_conversation_messages_session ON conversation_messages(session_id, id);`
+
+	// Execute all schema creation statements
+	for _, schema := range []string{
+		sessionsTable,
+		messagesTable,
+		sessionsOwnerIndex,
+		messagesSessionIndex,
+	} {
+		if _, err := db.Exec(schema); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	// Apply schema changes made after the initial version
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		return err
+	}
+	migrator.AddMigration(1, "Add memory strategy to conversation sessions",
+		`ALTER TABLE conversation_sessions ADD COLUMN memory_strategy TEXT NOT NULL DEFAULT 'window'`,
+		`ALTER TABLE conversation_sessions DROP COLUMN memory_strategy`)
+	migrator.AddMigration(2, "Add access token hash to conversation sessions",
+		`ALTER TABLE conversation_sessions ADD COLUMN token_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE conversation_sessions DROP COLUMN token_hash`)
+	migrator.AddMigration(3, "Add running summary to conversation sessions",
+		`ALTER TABLE conversation_sessions ADD COLUMN summary TEXT NOT NULL DEFAULT '';
+		ALTER TABLE conversation_sessions ADD COLUMN summarized_count INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE conversation_sessions DROP COLUMN summarized_count;
+		ALTER TABLE conversation_sessions DROP COLUMN summary`)
+	if err := migrator.MigrateUp(); err != nil {
+		return fmt.Errorf("failed to migrate conversation schema: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConversationSchemaMigratesOldDatabases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.db")
+
+	// A database written before memory strategies, access tokens and summaries existed
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE conversation_sessions (id TEXT PRIMARY KEY, owner_id INTEGER NOT NULL DEFAULT 0, model TEXT NOT NULL DEFAULT '', created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO conversation_sessions (id, owner_id, model) VALUES ('old-session', 7, 'llama')`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatalf("failed to create old schema: %v", err)
+		}
+	}
+	old.Close()
+
+	for i := 0; i < 2; i++ { // Opening again must not re-apply migrations
+		db, err := NewConversationDB(path)
+		if err != nil {
+			t.Fatalf("NewConversationDB failed on open %d: %v", i+1, err)
+		}
+		session, err := NewConversationRepository(db.GetDB()).GetSession(context.Background(), "old-session")
+		if err != nil {
+			t.Fatalf("GetSession failed: %v", err)
+		}
+		if session.OwnerID != 7 || session.Model != "llama" || session.MemoryStrategy != "window" || session.TokenHash != "" {
+			t.Errorf("Expected the old session with default strategy and no token, got %+v", session)
+		}
+		if summary, count, err := NewConversationRepository(db.GetDB()).GetSummary(context.Background(), "old-session"); err != nil || summary != "" || count != 0 {
+			t.Errorf("Expected an empty summary, got %q/%d (%v)", summary, count, err)
+		}
+		migrator, _ := NewSQLiteMigrator(db.GetDB())
+		if version, _ := migrator.GetCurrentVersion(); version != 3 {
+			t.Errorf("Expected schema version 3, got %d", version)
+		}
+		db.Close()
+	}
+}
+
+func TestConversationRepositoryPagesMessages(t *testing.T) {
+	db, err := NewConversationDB(filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatalf("NewConversationDB failed: %v", err)
+	}
+	defer db.Close()
+	repo := NewConversationRepository(db.GetDB())
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if err := repo.CreateSession(ctx, &ConversationSession{ID: "s", MemoryStrategy: "window", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := repo.AddMessage(ctx, &ConversationMessage{SessionID: "s", Role: "user", Content: fmt.Sprintf("message %d", i), CreatedAt: now}); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+
+	page, err := repo.GetMessages(ctx, "s", 1, 2)
+	if err != nil {
+		t.Fatalf("GetMessages failed: %v", err)
+	}
+	if len(page) != 2 || page[0].Content != "message 2" || page[1].Content != "message 3" {
+		t.Errorf("Expected messages 2 and 3, got %+v", page)
+	}
+	if all, _ := repo.GetMessages(ctx, "s", 3, 0); len(all) != 2 {
+		t.Errorf("Expected a limit of 0 to return the remaining 2 messages, got %d", len(all))
+	}
+	if count, _ := repo.CountMessages(ctx, "s"); count != 5 {
+		t.Errorf("Expected 5 messages, got %d", count)
+	}
+
+	if err := repo.DeleteSession(ctx, "s"); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if count, _ := repo.CountMessages(ctx, "s"); count != 0 {
+		t.Errorf("Expected deleting the session to delete its messages, got %d", count)
+	}
+}
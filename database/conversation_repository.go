@@ -0,0 +1,257 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ConversationSession represents a stored conversation session
+type ConversationSession struct {
+	ID             string    `json:"id" db:"id"`
+	OwnerID        int64     `json:"owner_id" db:"owner_id"`
+	Model          string    `json:"model" db:"model"`
+	MemoryStrategy string    `json:"memory_strategy" db:"memory_strategy"`
+	TokenHash      string    `json:"-" db:"token_hash"` // SHA-256 of the session's access token
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ConversationMessage represents a stored message of a conversation session
+type ConversationMessage struct {
+	ID        int64     `json:"id" db:"id"`
+	SessionID string    `json:"session_id" db:"session_id"`
+	Role      string    `json:"role" db:"role"`
+	Content   string    `json:"content" db:"content"`
+	Tokens    int       `json:"tokens" db:"tokens"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// ConversationRepository handles database operations for conversation sessions and messages
+type ConversationRepository struct {
+	db *sql.DB
+}
+
+// NewConversationRepository creates a new conversation repository
+func NewConversationRepository(db *sql.DB) *ConversationRepository {
+	return &ConversationRepository{
+		db: db,
+	}
+}
+
+// CreateSession inserts a new session
+func (r *ConversationRepository) CreateSession(ctx context.Context, session *ConversationSession) error {
+	query := `INSERT INTO conversation_sessions (id, owner_id, model, memory_strategy, token_hash, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := r.db.ExecContext(ctx, query, session.ID, session.OwnerID, session.Model, session.MemoryStrategy, session.TokenHash, session.CreatedAt, session.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (r *ConversationRepository) GetSession(ctx context.Context, id string) (*ConversationSession, error) {
+	query := `SELECT id, owner_id, model, memory_strategy, token_hash, created_at, updated_at FROM conversation_sessions WHERE id = ?`
+
+	var session ConversationSession
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.OwnerID,
+		&session.Model,
+		&session.MemoryStrategy,
+		&session.TokenHash,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("session not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// ListSessions retrieves the sessions of an owner, most recently updated first.
+// An ownerID of 0 lists every session.
+func (r *ConversationRepository) ListSessions(ctx context.Context, ownerID int64) ([]*ConversationSession, error) {
+	query := `SELECT id, owner_id, model, memory_strategy, token_hash, created_at, updated_at FROM conversation_sessions WHERE (? = 0 OR owner_id = ?) ORDER BY updated_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, ownerID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*ConversationSession
+	for rows.Next() {
+		var session ConversationSession
+		if err := rows.Scan(
+			&session.ID,
+			&session.OwnerID,
+			&session.Model,
+			&session.MemoryStrategy,
+			&session.TokenHash,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// TouchSession updates the last activity time of a session
+func (r *ConversationRepository) TouchSession(ctx context.Context, id string, updatedAt time.Time) error {
+	query := `UPDATE conversation_sessions SET updated_at = ? WHERE id = ?`
+
+	if _, err := r.db.ExecContext(ctx, query, updatedAt, id); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteSession deletes a session and all of its messages
+func (r *ConversationRepository) DeleteSession(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM conversation_messages WHERE session_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete session messages: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM conversation_sessions WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return tx.Commit()
+}
+
+// GetSummary returns the running summary of a session and the number of
+// leading messages it covers
+func (r *ConversationRepository) GetSummary(ctx context.Context, id string) (string, int, error) {
+	query := `SELECT summary, summarized_count FROM conversation_sessions WHERE id = ?`
+
+	var summary string
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&summary, &count); err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, fmt.Errorf("session not found: %w", err)
+		}
+		return "", 0, fmt.Errorf("failed to get summary: %w", err)
+	}
+
+	return summary, count, nil
+}
+
+// UpdateSummary stores the running summary of a session
+func (r *ConversationRepository) UpdateSummary(ctx context.Context, id string, summary string, count int) error {
+	query := `UPDATE conversation_sessions SET summary = ?, summarized_count = ? WHERE id = ?`
+
+	if _, err := r.db.ExecContext(ctx, query, summary, count, id); err != nil {
+		return fmt.Errorf("failed to update summary: %w", err)
+	}
+
+	return nil
+}
+
+// AddMessage appends a message to a session
+func (r *ConversationRepository) AddMessage(ctx context.Context, message *ConversationMessage) error {
+	query := `INSERT INTO conversation_messages (session_id, role, content, tokens, created_at) VALUES (?, ?, ?, ?, ?)`
+
+	result, err := r.db.ExecContext(ctx, query, message.SessionID, message.Role, message.Content, message.Tokens, message.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add message: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get message ID: %w", err)
+	}
+	message.ID = id
+
+	return nil
+}
+
+// GetMessages retrieves the messages of a session in chronological order.
+// A limit of 0 or less returns all messages from offset onwards.
+func (r *ConversationRepository) GetMessages(ctx context.Context, sessionID string, offset, limit int) ([]*ConversationMessage, error) {
+	if limit <= 0 {
+		limit = -1 // SQLite treats a negative LIMIT as unlimited
+	}
+	query := `SELECT id, session_id, role, content, tokens, created_at FROM conversation_messages WHERE session_id = ? ORDER BY id ASC LIMIT ? OFFSET ?`
+
+	rows, err := r.db.QueryContext(ctx, query, sessionID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*ConversationMessage
+	for rows.Next() {
+		var message ConversationMessage
+		if err := rows.Scan(
+			&message.ID,
+			&message.SessionID,
+			&message.Role,
+			&message.Content,
+			&message.Tokens,
+			&message.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, &message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// CountMessages returns the number of messages stored for a session
+func (r *ConversationRepository) CountMessages(ctx context.Context, sessionID string) (int, error) {
+	query := `SELECT COUNT(*) FROM conversation_messages WHERE session_id = ?`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, sessionID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	return count, nil
+}
+
+// DeleteMessages removes all messages of a session but keeps the session
+func (r *ConversationRepository) DeleteMessages(ctx context.Context, sessionID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM conversation_messages WHERE session_id = ?`, sessionID); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/philippgille/chromem-go"
+)
+
+const (
+	// DefaultEmbeddingDimensions is the vector size used by the hashed n-gram embedder.
+	DefaultEmbeddingDimensions = 384
+	// DefaultOpenAIEmbeddingModel is used when EMBEDDING_MODEL is not set.
+	DefaultOpenAIEmbeddingModel = "text-embedding-3-small"
+	// DefaultOpenAIEmbeddingBaseURL is used when EMBEDDING_BASE_URL is not set.
+	DefaultOpenAIEmbeddingBaseURL = "https://api.openai.com/v1"
+
+	defaultOpenAIEmbeddingDimensions = 1536
+)
+
+// Embedder turns text into a fixed-size vector for chromem collections.
+type Embedder interface {
+	// Embed returns the embedding for the given text.
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// Dimensions returns the length of every vector produced by Embed.
+	Dimensions() int
+	// Name identifies the embedder in logs and error messages.
+	Name() string
+}
+
+// BatchEmbedder is implemented by embedders that can embed several texts in
+// one call, such as remote APIs where each call is a network round trip.
+type BatchEmbedder interface {
+	Embedder
+	// EmbedBatch returns one embedding per text, in the order of texts.
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedTexts embeds texts with one batched call when the embedder supports
+// it, and one call per text otherwise.
+func EmbedTexts(ctx context.Context, embedder Embedder, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	if batch, ok := embedder.(BatchEmbedder); ok {
+		return batch.EmbedBatch(ctx, texts)
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector, err := embedder.Embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// NewEmbedderFromEnv selects an embedder based on environment configuration:
+//
+//   - EMBEDDING_PROVIDER: "hash" (default) or "openai"
+//   - EMBEDDING_BASE_URL: base URL of an OpenAI-compatible API (openai only)
+//   - EMBEDDING_API_KEY: API key, falls back to OPENAI_API_KEY (openai only)
+//   - EMBEDDING_MODEL: embedding model name (openai only)
+//   - EMBEDDING_DIMENSIONS: vector size
+func NewEmbedderFromEnv() (Embedder, error) {
+	dimensions := 0
+	if raw := os.Getenv("EMBEDDING_DIMENSIONS"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid EMBEDDING_DIMENSIONS '%s'", raw)
+		}
+		dimensions = parsed
+	}
+
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("EMBEDDING_PROVIDER")))
+	switch provider {
+	case "", "hash":
+		if dimensions == 0 {
+			dimensions = DefaultEmbeddingDimensions
+		}
+		return NewHashEmbedder(dimensions), nil
+	case "openai":
+		apiKey := os.Getenv("EMBEDDING_API_KEY")
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENAI_API_KEY")
+		}
+		return NewOpenAIEmbedder(os.Getenv("EMBEDDING_BASE_URL"), apiKey, os.Getenv("EMBEDDING_MODEL"), dimensions), nil
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDING_PROVIDER '%s' (expected 'hash' or 'openai')", provider)
+	}
+}
+
+// HashEmbedder is a deterministic, offline embedder. Words and character
+// trigrams are hashed into a fixed number of buckets (the "hashing trick"),
+// so texts sharing vocabulary produce similar vectors without any model.
+type HashEmbedder struct {
+	dimensions int
+}
+
+// NewHashEmbedder creates a hashed n-gram embedder producing vectors of the given size.
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	if dimensions <= 0 {
+		dimensions = DefaultEmbeddingDimensions
+	}
+	return &HashEmbedder{dimensions: dimensions}
+}
+
+// Dimensions returns the vector size.
+func (e *HashEmbedder) Dimensions() int { return e.dimensions }
+
+// Name identifies the embedder.
+func (e *HashEmbedder) Name() string { return fmt.Sprintf("hash-ngram-%d", e.dimensions) }
+
+// Embed returns the normalized hashed n-gram vector for text.
+func (e *HashEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vector := make([]float32, e.dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		e.add(vector, "w:"+word, 1.0)
+		padded := []rune("#" + word + "#")
+		for i := 0; i+3 <= len(padded); i++ {
+			e.add(vector, "t:"+string(padded[i:i+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		// Text without any tokens still needs a valid unit vector; a zero
+		// vector would normalize to NaN inside chromem.
+		vector[0] = 1
+		return vector, nil
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+	return vector, nil
+}
+
+// add hashes a feature into a bucket, using one hash bit as the sign so that
+// collisions tend to cancel out rather than accumulate.
+func (e *HashEmbedder) add(vector []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	index := int(sum % uint64(e.dimensions))
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	vector[index] += weight
+}
+
+// OpenAIEmbedder calls the /embeddings endpoint of any OpenAI-compatible API,
+// including local servers such as Ollama, LM Studio or LocalAI.
+type OpenAIEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimensions int
+	// sendDimensions is set when the caller explicitly asked for a size, which
+	// is forwarded so that models supporting shortened embeddings honor it.
+	sendDimensions bool
+	client         *http.Client
+}
+
+// NewOpenAIEmbedder creates an embedder for an OpenAI-compatible API. Empty
+// values fall back to the OpenAI defaults.
+func NewOpenAIEmbedder(baseURL, apiKey, model string, dimensions int) *OpenAIEmbedder {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIEmbeddingBaseURL
+	}
+	if model == "" {
+		model = DefaultOpenAIEmbeddingModel
+	}
+	sendDimensions := dimensions > 0
+	if !sendDimensions {
+		dimensions = defaultOpenAIEmbeddingDimensions
+	}
+	return &OpenAIEmbedder{
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		apiKey:         apiKey,
+		model:          model,
+		dimensions:     dimensions,
+		sendDimensions: sendDimensions,
+		client:         &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Dimensions returns the vector size.
+func (e *OpenAIEmbedder) Dimensions() int { return e.dimensions }
+
+// Name identifies the embedder.
+func (e *OpenAIEmbedder) Name() string { return "openai:" + e.model }
+
+// Embed requests the embedding for text from the API.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := e.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// EmbedBatch requests the embeddings for all texts in a single API call.
+func (e *OpenAIEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	payload := map[string]interface{}{
+		"model": e.model,
+		"input": texts,
+	}
+	if e.sendDimensions {
+		payload["dimensions"] = e.dimensions
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedding request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %w", err)
+	}
+	if len(parsed.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding API returned %d embeddings for %d inputs", len(parsed.Data), len(texts))
+	}
+	// Results carry the index of their input and need not be in order
+	vectors := make([][]float32, len(texts))
+	for _, item := range parsed.Data {
+		if item.Index < 0 || item.Index >= len(texts) || vectors[item.Index] != nil || len(item.Embedding) == 0 {
+			return nil, fmt.Errorf("embedding API returned an invalid embedding for index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
+
+// embeddingFuncFor adapts an Embedder to chromem, rejecting vectors whose size
+// differs from what the collection was created with.
+func embeddingFuncFor(collectionName string, embedder Embedder) chromem.EmbeddingFunc {
+	expected := embedder.Dimensions()
+	return func(ctx context.Context, text string) ([]float32, error) {
+		vector, err := embedder.Embed(ctx, text)
+		if err != nil {
+			return nil, fmt.Errorf("embedder %s failed: %w", embedder.Name(), err)
+		}
+		if len(vector) != expected {
+			return nil, fmt.Errorf("embedder %s returned %d dimensions for collection '%s', expected %d", embedder.Name(), len(vector), collectionName, expected)
+		}
+		return vector, nil
+	}
+}
+
+// checkCollectionDimensions verifies that documents already stored in the
+// collection were embedded with the same vector size as the active embedder.
+// chromem refuses to compare vectors of different lengths, so a probe query
+// with a unit vector of the expected size fails fast on a mismatch.
+func checkCollectionDimensions(ctx context.Context, collection *chromem.Collection, name string, embedder Embedder) error {
+	if collection.Count() == 0 {
+		return nil
+	}
+	probe := make([]float32, embedder.Dimensions())
+	probe[0] = 1
+	results, err := collection.QueryEmbedding(ctx, probe, 1, nil, nil)
+	if err != nil {
+		log.Printf("[WARN] SimpleDomainDB: Collection '%s' does not match embedder %s: %v", name, embedder.Name(), err)
+		return fmt.Errorf("collection '%s' was embedded with a different dimension than embedder %s (%d); re-create the collection or start with -clean-db: %w", name, embedder.Name(), embedder.Dimensions(), err)
+	}
+	if len(results) > 0 && math.IsNaN(float64(results[0].Similarity)) {
+		// Documents stored with the old zero-vector placeholder have no usable
+		// embedding. They still load, but similarity queries ignore them.
+		log.Printf("[WARN] SimpleDomainDB: Collection '%s' contains zero-vector embeddings from an earlier version. Re-create it to enable similarity search.", name)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/philippgille/chromem-go"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func TestHashEmbedderIsDeterministicAndNormalized(t *testing.T) {
+	embedder := NewHashEmbedder(0)
+	ctx := context.Background()
+	first, err := embedder.Embed(ctx, "The quick brown fox jumps over the lazy dog")
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	second, _ := NewHashEmbedder(0).Embed(ctx, "The quick brown fox jumps over the lazy dog")
+	if len(first) != DefaultEmbeddingDimensions {
+		t.Fatalf("Expected %d dimensions, got %d", DefaultEmbeddingDimensions, len(first))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected identical vectors for identical text, differ at %d", i)
+		}
+	}
+
+	for _, text := range []string{"The quick brown fox", "", "!!!"} {
+		vector, _ := embedder.Embed(ctx, text)
+		if norm := math.Sqrt(cosine(vector, vector)); math.Abs(norm-1) > 1e-5 {
+			t.Errorf("Expected a unit vector for %q, got norm %f", text, norm)
+		}
+	}
+
+	related, _ := embedder.Embed(ctx, "A quick brown fox jumped over a lazy dog")
+	unrelated, _ := embedder.Embed(ctx, "Quarterly revenue grew by eight percent")
+	if cosine(first, related) <= cosine(first, unrelated) {
+		t.Errorf("Expected related text to be closer (%f) than unrelated text (%f)", cosine(first, related), cosine(first, unrelated))
+	}
+}
+
+func TestOpenAIEmbedderBatchesInputs(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("Unexpected request %s with auth %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var body struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Expected an input array: %v", err)
+		}
+		// Answer in reverse order; the index maps each embedding to its input
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []item
+		for i := len(body.Input) - 1; i >= 0; i-- {
+			data = append(data, item{Index: i, Embedding: []float32{float32(len(body.Input[i])), 0}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	embedder := NewOpenAIEmbedder(server.URL+"/v1", "test-key", "", 2)
+	vectors, err := EmbedTexts(context.Background(), embedder, []string{"a", "bbb", "cc"})
+	if err != nil {
+		t.Fatalf("EmbedTexts failed: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected one request for three inputs, got %d", requests)
+	}
+	if len(vectors) != 3 || vectors[0][0] != 1 || vectors[1][0] != 3 || vectors[2][0] != 2 {
+		t.Errorf("Expected embeddings in input order, got %v", vectors)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "invalid model"}`, http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	if _, err := NewOpenAIEmbedder(failing.URL, "", "", 0).Embed(context.Background(), "x"); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("Expected the API status in the error, got %v", err)
+	}
+}
+
+// fixedEmbedder returns vectors of a size that may differ from what it reports.
+type fixedEmbedder struct{ reported, actual int }
+
+func (e fixedEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vector := make([]float32, e.actual)
+	vector[0] = 1
+	return vector, nil
+}
+func (e fixedEmbedder) Dimensions() int { return e.reported }
+func (e fixedEmbedder) Name() string    { return "fixed" }
+
+func TestCollectionDimensionMismatch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "domain.db")
+	db, err := NewSimpleDomainDBWithEmbedder(path, NewHashEmbedder(8))
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	collection, err := db.GetOrCreateCollection("docs")
+	if err != nil {
+		t.Fatalf("GetOrCreateCollection failed: %v", err)
+	}
+	if err := collection.AddDocument(ctx, chromem.Document{ID: "1", Content: "stored with eight dimensions"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+
+	reopened, err := NewSimpleDomainDBWithEmbedder(path, NewHashEmbedder(16))
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	if _, err := reopened.GetOrCreateCollection("docs"); err == nil || !strings.Contains(err.Error(), "different dimension") {
+		t.Errorf("Expected a dimension mismatch error, got %v", err)
+	}
+
+	embed := embeddingFuncFor("docs", fixedEmbedder{reported: 4, actual: 3})
+	if _, err := embed(ctx, "text"); err == nil || !strings.Contains(err.Error(), "returned 3 dimensions") {
+		t.Errorf("Expected vectors of the wrong size to be rejected, got %v", err)
+	}
+}
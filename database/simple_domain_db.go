@@ -3,7 +3,7 @@ package database
 import (
 	"context"
 	"fmt"
-	"os"
+	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -14,11 +14,27 @@ import (
 
 // SimpleDomainDB is a simplified version using the correct chromem-go API
 type SimpleDomainDB struct {
-	db *chromem.DB
+	db       *chromem.DB
+	embedder Embedder
 }
 
-// NewSimpleDomainDB creates a new simplified domain database
+// NewSimpleDomainDB creates a new simplified domain database using the
+// embedder selected by the EMBEDDING_* environment variables.
 func NewSimpleDomainDB(persistencePath string) (*SimpleDomainDB, error) {
+	embedder, err := NewEmbedderFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure embedder: %w", err)
+	}
+	return NewSimpleDomainDBWithEmbedder(persistencePath, embedder)
+}
+
+// NewSimpleDomainDBWithEmbedder creates a new simplified domain database that
+// embeds documents with the given embedder.
+func NewSimpleDomainDBWithEmbedder(persistencePath string, embedder Embedder) (*SimpleDomainDB, error) {
+	if embedder == nil {
+		return nil, fmt.Errorf("embedder cannot be nil")
+	}
+
 	var db *chromem.DB
 
 	// Create database with or without persistence
@@ -33,8 +49,10 @@ func NewSimpleDomainDB(persistencePath string) (*SimpleDomainDB, error) {
 		db = chromem.NewDB()
 	}
 
+	log.Printf("SimpleDomainDB: Using embedder %s (%d dimensions)", embedder.Name(), embedder.Dimensions())
 	return &SimpleDomainDB{
-		db: db,
+		db:       db,
+		embedder: embedder,
 	}, nil
 }
 
@@ -43,39 +61,28 @@ func (sdb *SimpleDomainDB) GetDB() *chromem.DB {
 	return sdb.db
 }
 
-// GetOrCreateCollection gets or creates a collection with Cerebras embeddings
+// Embedder returns the embedder used for this database's collections
+func (sdb *SimpleDomainDB) Embedder() Embedder {
+	return sdb.embedder
+}
+
+// GetOrCreateCollection gets or creates a collection embedded with the
+// configured embedder. Existing collections are checked for a matching
+// vector dimension.
 func (sdb *SimpleDomainDB) GetOrCreateCollection(name string) (*chromem.Collection, error) {
-	// Get API key from environment
-	apiKey := os.Getenv("CEREBRAS_API_KEY")
-	if apiKey == "" {
-		// Fallback to zero vector if no API key (for testing)
-		embeddingFunc := func(ctx context.Context, text string) ([]float32, error) {
-			return make([]float32, 384), nil // 384 is a common embedding dimension
-		}
-		return sdb.db.GetOrCreateCollection(name, nil, embeddingFunc)
+	metadata := map[string]string{
+		"embedder":             sdb.embedder.Name(),
+		"embedding_dimensions": strconv.Itoa(sdb.embedder.Dimensions()),
 	}
-
-	// Create embedding function (currently returns zero vectors)
-	cerebrasEmbedding, err := createCerebrasEmbeddingFunc()
+	collection, err := sdb.db.GetOrCreateCollection(name, metadata, embeddingFuncFor(name, sdb.embedder))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Cerebras embedding function: %w", err)
+		return nil, err
 	}
 
-	return sdb.db.GetOrCreateCollection(name, nil, cerebrasEmbedding)
-}
-
-// createCerebrasEmbeddingFunc creates an embedding function (currently returns zero vectors)
-func createCerebrasEmbeddingFunc() (func(context.Context, string) ([]float32, error), error) {
-	// Import the cerebras package - this will be added when we use it
-	// For now, create a simple wrapper that will use your Cerebras implementation
-	return func(ctx context.Context, text string) ([]float32, error) {
-		// Use your Cerebras embeddings implementation
-		// Based on your repository structure, this should work:
-		// return cerebras.CreateEmbedding(ctx, text, apiKey)
-
-		// For now, return a zero vector to avoid the 401 error until we can test the integration
-		return make([]float32, 384), nil
-	}, nil
+	if err := checkCollectionDimensions(context.Background(), collection, name, sdb.embedder); err != nil {
+		return nil, err
+	}
+	return collection, nil
 }
 
 // Close closes the database
@@ -96,6 +103,11 @@ type SimpleAgent struct {
 	ContractAddr string    `json:"contract_addr"`
 	OwnerID      int64     `json:"owner_id"`
 	CreatedAt    time.Time `json:"created_at"`
+	// Prompt template the agent runs with; version 0 means the latest version
+	PromptTemplate        string `json:"prompt_template,omitempty"`
+	PromptTemplateVersion int    `json:"prompt_template_version,omitempty"`
+	// Whether PII is redacted from the agent's prompts; nil uses the engine default
+	RedactPII *bool `json:"redact_pii,omitempty"`
 }
 
 // SimpleAgentRepository handles agent persistence using the correct chromem-go API
@@ -133,6 +145,12 @@ func (r *SimpleAgentRepository) CreateAgent(ctx context.Context, agent *SimpleAg
 		"owner_id":      fmt.Sprintf("%d", agent.OwnerID),
 		"created_at":    agent.CreatedAt.Format(time.RFC3339),
 		"capabilities":  strings.Join(agent.Capabilities, ","),
+
+		"prompt_template":         agent.PromptTemplate,
+		"prompt_template_version": strconv.Itoa(agent.PromptTemplateVersion),
+	}
+	if agent.RedactPII != nil {
+		metadata["redact_pii"] = strconv.FormatBool(*agent.RedactPII)
 	}
 
 	// Create document for chromem-go
@@ -207,6 +225,14 @@ func documentToSimpleAgent(doc chromem.Document) (*SimpleAgent, error) {
 		capabilities = strings.Split(capStr, ",")
 	}
 
+	// Agents stored before prompt templates existed have no version
+	templateVersion, _ := strconv.Atoi(doc.Metadata["prompt_template_version"])
+
+	var redactPII *bool
+	if value, err := strconv.ParseBool(doc.Metadata["redact_pii"]); err == nil {
+		redactPII = &value
+	}
+
 	agent := &SimpleAgent{
 		ID:           doc.ID,
 		Name:         doc.Metadata["name"],
@@ -218,6 +244,10 @@ func documentToSimpleAgent(doc chromem.Document) (*SimpleAgent, error) {
 		ContractAddr: doc.Metadata["contract_addr"],
 		OwnerID:      ownerID,
 		CreatedAt:    createdAt,
+
+		PromptTemplate:        doc.Metadata["prompt_template"],
+		PromptTemplateVersion: templateVersion,
+		RedactPII:             redactPII,
 	}
 
 	return agent, nil
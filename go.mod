@@ -15,6 +15,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/philippgille/chromem-go v0.7.0
 	github.com/wk8/go-ordered-map/v2 v2.1.8
+	golang.org/x/net v0.40.0
 )
 
 require (
@@ -86,7 +87,6 @@ require (
 	github.com/stretchr/objx v0.5.2 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
 	golang.org/x/crypto v0.38.0
-	golang.org/x/net v0.40.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.25.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
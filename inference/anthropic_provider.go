@@ -0,0 +1,655 @@
+package inference
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/guiperry/gollm_cerebras/config"
+	"github.com/guiperry/gollm_cerebras/providers"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+	"github.com/guiperry/gollm_cerebras/utils"
+)
+
+// DefaultAnthropicModel is used when no model is configured.
+const DefaultAnthropicModel = "claude-3-5-sonnet-latest"
+
+const (
+	anthropicAPIURL     = "https://api.anthropic.com/v1/messages"
+	anthropicAPIVersion = "2023-06-01"
+)
+
+// --- Anthropic Messages API Structs ---
+
+// AnthropicContentBlock is one block of message content: text, a tool_use
+// request from the model, or a tool_result.
+type AnthropicContentBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	Source    *AnthropicSource `json:"source,omitempty"`
+}
+
+// AnthropicSource is the base64 data of an image or document block.
+type AnthropicSource struct {
+	Type      string `json:"type"` // "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// AnthropicMessage is a user or assistant turn.
+type AnthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+// AnthropicTool describes a tool the model may use.
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+// AnthropicToolChoice is "auto", "any", "none" or a named "tool".
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// AnthropicMessagesRequest is the body of POST /v1/messages.
+type AnthropicMessagesRequest struct {
+	Model         string               `json:"model"`
+	MaxTokens     int                  `json:"max_tokens"`
+	System        string               `json:"system,omitempty"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+// AnthropicError is the error object of error responses and stream events.
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// AnthropicMessagesResponse is a non-streaming response (or an error).
+type AnthropicMessagesResponse struct {
+	ID         string                  `json:"id"`
+	Type       string                  `json:"type"`
+	Role       string                  `json:"role"`
+	Model      string                  `json:"model"`
+	Content    []AnthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *AnthropicError `json:"error,omitempty"`
+}
+
+// anthropicStreamEvent is the data of one server-sent event.
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	ContentBlock *AnthropicContentBlock `json:"content_block,omitempty"`
+	Error        *AnthropicError        `json:"error,omitempty"`
+}
+
+// errAnthropicSkipToken tells gollm's stream reader that an event carries no text.
+var errAnthropicSkipToken = errors.New("skip token")
+
+// --- AnthropicProvider Implementation ---
+
+// AnthropicProvider implements providers.Provider for the Anthropic Messages API
+type AnthropicProvider struct {
+	endpoint     string
+	apiKey       string
+	model        string
+	maxTokens    int
+	temperature  *float64
+	topP         *float64
+	extraHeaders map[string]string
+	logger       utils.Logger
+
+	mutex sync.Mutex
+}
+
+// --- Registration ---
+func init() {
+	registry := providers.GetDefaultRegistry()
+	registry.Register("anthropic", NewAnthropicProvider)
+	log.Println("Registered Anthropic provider constructor with gollm registry")
+}
+
+// NewAnthropicProvider creates a new Anthropic provider instance.
+func NewAnthropicProvider(apiKey, model string, extraHeaders map[string]string) providers.Provider {
+	provider := &AnthropicProvider{
+		endpoint:     anthropicAPIURL,
+		apiKey:       apiKey,
+		model:        model,
+		maxTokens:    4096,
+		extraHeaders: make(map[string]string),
+		logger:       utils.NewLogger(utils.LogLevelInfo),
+	}
+	if endpoint := os.Getenv("ANTHROPIC_API_ENDPOINT"); endpoint != "" {
+		provider.endpoint = endpoint
+	}
+	if provider.model == "" {
+		provider.model = DefaultAnthropicModel
+	}
+	for k, v := range extraHeaders {
+		provider.extraHeaders[k] = v
+	}
+	log.Printf("NewAnthropicProvider created: model=%s", provider.model)
+	return provider
+}
+
+// Name returns the provider's identifier.
+func (p *AnthropicProvider) Name() string {
+	return "anthropic"
+}
+
+// Endpoint returns the API endpoint URL, ANTHROPIC_API_ENDPOINT when set.
+func (p *AnthropicProvider) Endpoint() string {
+	return p.endpoint
+}
+
+// Headers returns the necessary HTTP headers. Anthropic authenticates with
+// x-api-key rather than a bearer token.
+func (p *AnthropicProvider) Headers() map[string]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	headers := map[string]string{
+		"Content-Type":      "application/json",
+		"Accept":            "application/json",
+		"anthropic-version": anthropicAPIVersion,
+		"User-Agent":        "Wordpress-Inference-Engine/1.0 (via Gollm Provider)",
+	}
+	if p.apiKey != "" {
+		headers["x-api-key"] = p.apiKey
+	} else {
+		p.logger.Warn("Anthropic API key is missing when generating headers")
+	}
+	for k, v := range p.extraHeaders {
+		headers[k] = v
+	}
+	return headers
+}
+
+// convertMessagesToAnthropic maps gollm messages to Anthropic turns. System
+// messages are lifted into the system prompt, tool results become user turns
+// (the tool loop records them as plain text, not tool_result blocks), and
+// consecutive turns of the same role are merged because the API requires
+// alternating roles starting with the user.
+func convertMessagesToAnthropic(messages []gollm_types.MemoryMessage) (string, []AnthropicMessage) {
+	var system []string
+	apiMessages := make([]AnthropicMessage, 0, len(messages))
+	for _, msg := range messages {
+		role := "user"
+		text := msg.Content
+		switch strings.ToLower(msg.Role) {
+		case "system":
+			system = append(system, msg.Content)
+			continue
+		case "assistant", "ai":
+			role = "assistant"
+		case "tool":
+			text = "[tool]: " + msg.Content
+		}
+		// Attachments go before the text that refers to them
+		var blocks []AnthropicContentBlock
+		for _, part := range contentParts(msg) {
+			blockType := "image"
+			if !part.IsImage() {
+				blockType = "document"
+			}
+			blocks = append(blocks, AnthropicContentBlock{Type: blockType, Source: &AnthropicSource{Type: "base64", MediaType: part.MIMEType, Data: part.Data}})
+		}
+		if strings.TrimSpace(text) != "" {
+			blocks = append(blocks, AnthropicContentBlock{Type: "text", Text: text})
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if last := len(apiMessages) - 1; last >= 0 && apiMessages[last].Role == role {
+			apiMessages[last].Content = append(apiMessages[last].Content, blocks...)
+			continue
+		}
+		if len(apiMessages) == 0 && role == "assistant" {
+			apiMessages = append(apiMessages, AnthropicMessage{Role: "user", Content: []AnthropicContentBlock{{Type: "text", Text: "(conversation continues)"}}})
+		}
+		apiMessages = append(apiMessages, AnthropicMessage{Role: role, Content: blocks})
+	}
+	return strings.Join(system, "\n\n"), apiMessages
+}
+
+// convertToolsToAnthropic maps gollm tools; their parameters are already JSON schemas.
+func convertToolsToAnthropic(gollmTools []utils.Tool) []AnthropicTool {
+	if len(gollmTools) == 0 {
+		return nil
+	}
+	apiTools := make([]AnthropicTool, 0, len(gollmTools))
+	for _, tool := range gollmTools {
+		if tool.Type != "" && tool.Type != "function" {
+			log.Printf("Warning: Skipping non-function tool type '%s'", tool.Type)
+			continue
+		}
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		apiTools = append(apiTools, AnthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+	return apiTools
+}
+
+// getAnthropicToolChoice maps the OpenAI-style choices used elsewhere:
+// "required" becomes "any" and any other name selects that tool.
+func getAnthropicToolChoice(gollmChoice interface{}) *AnthropicToolChoice {
+	choice, ok := gollmChoice.(string)
+	if !ok || choice == "" {
+		return &AnthropicToolChoice{Type: "auto"}
+	}
+	switch strings.ToLower(choice) {
+	case "auto", "none", "any":
+		return &AnthropicToolChoice{Type: strings.ToLower(choice)}
+	case "required":
+		return &AnthropicToolChoice{Type: "any"}
+	}
+	return &AnthropicToolChoice{Type: "tool", Name: choice}
+}
+
+// newRequest builds a request with the provider defaults and options applied.
+func (p *AnthropicProvider) newRequest(system string, messages []AnthropicMessage, options map[string]interface{}) AnthropicMessagesRequest {
+	p.mutex.Lock()
+	req := AnthropicMessagesRequest{
+		Model:       p.model,
+		MaxTokens:   p.maxTokens,
+		System:      system,
+		Messages:    messages,
+		Temperature: p.temperature,
+		TopP:        p.topP,
+	}
+	apiKey := p.apiKey
+	p.mutex.Unlock()
+
+	if sp, ok := options["system_prompt"].(string); ok && sp != "" && !strings.Contains(req.System, sp) {
+		req.System = strings.TrimSpace(sp + "\n\n" + req.System)
+	}
+	if m, ok := options["model"].(string); ok && m != "" {
+		req.Model = m
+	}
+	switch mt := options["max_tokens"].(type) {
+	case int:
+		if mt > 0 {
+			req.MaxTokens = mt
+		}
+	case float64:
+		if mt > 0 {
+			req.MaxTokens = int(mt)
+		}
+	}
+	if tVal, ok := options["temperature"].(float64); ok {
+		req.Temperature = &tVal
+	}
+	if pVal, ok := options["top_p"].(float64); ok {
+		req.TopP = &pVal
+	}
+	if stopVal, ok := options["stop"].([]string); ok {
+		req.StopSequences = stopVal
+	}
+	if toolsVal, ok := options["tools"].([]utils.Tool); ok && len(toolsVal) > 0 {
+		req.Tools = convertToolsToAnthropic(toolsVal)
+		req.ToolChoice = getAnthropicToolChoice(options["tool_choice"])
+	}
+	if stream, ok := options["stream"].(bool); ok && stream {
+		req.Stream = true
+	}
+
+	p.logger.Debug("Preparing Anthropic request", "provider", p.Name(), "model", req.Model, "streaming", req.Stream, "num_msgs", len(req.Messages), "has_tools", len(req.Tools) > 0)
+	if apiKey == "" {
+		p.logger.Warn("API key is not set for Anthropic provider")
+	}
+	return req
+}
+
+// PrepareRequest creates the request body for a standard API call.
+func (p *AnthropicProvider) PrepareRequest(prompt string, options map[string]interface{}) ([]byte, error) {
+	// gollm flattens the system prompt into the text as well; send it once, as system
+	if sp, ok := options["system_prompt"].(string); ok && sp != "" {
+		prompt = strings.TrimPrefix(prompt, "System: "+sp+"\n\n")
+	}
+	messages := []AnthropicMessage{{Role: "user", Content: []AnthropicContentBlock{{Type: "text", Text: prompt}}}}
+	return json.Marshal(p.newRequest("", messages, options))
+}
+
+// PrepareRequestWithSchema asks for JSON matching schema in the system prompt;
+// the Messages API has no response format parameter.
+func (p *AnthropicProvider) PrepareRequestWithSchema(prompt string, options map[string]interface{}, schema interface{}) ([]byte, error) {
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	merged := make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		merged[k] = v
+	}
+	instruction := "Respond only with JSON that conforms to this JSON schema:\n" + string(schemaJSON)
+	if sp, ok := options["system_prompt"].(string); ok && sp != "" {
+		prompt = strings.TrimPrefix(prompt, "System: "+sp+"\n\n")
+		instruction = sp + "\n\n" + instruction
+	}
+	merged["system_prompt"] = instruction
+	return p.PrepareRequest(prompt, merged)
+}
+
+// PrepareRequestWithMessages handles message lists, system prompts and tools.
+func (p *AnthropicProvider) PrepareRequestWithMessages(messages []gollm_types.MemoryMessage, options map[string]interface{}) ([]byte, error) {
+	system, apiMessages := convertMessagesToAnthropic(messages)
+	if len(apiMessages) == 0 {
+		return nil, errors.New("anthropic request needs at least one user or assistant message")
+	}
+	return json.Marshal(p.newRequest(system, apiMessages, options))
+}
+
+// SupportsContentPart reports whether the part can be sent as an image or
+// document block.
+func (p *AnthropicProvider) SupportsContentPart(part ContentPart) bool {
+	switch part.MIMEType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf":
+		return true
+	}
+	return false
+}
+
+// PrepareMultimodalRequest is PrepareRequestWithMessages; attachments are
+// converted with the messages (see convertMessagesToAnthropic).
+func (p *AnthropicProvider) PrepareMultimodalRequest(messages []gollm_types.MemoryMessage, options map[string]interface{}) ([]byte, error) {
+	return p.PrepareRequestWithMessages(messages, options)
+}
+
+// ParseResponse extracts the generated text. Tool use is left to
+// HandleFunctionCalls, so a response that only calls tools has no text.
+func (p *AnthropicProvider) ParseResponse(body []byte) (string, error) {
+	var response AnthropicMessagesResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		p.logger.Error("Failed to unmarshal Anthropic response", "error", err, "body", string(body))
+		return "", fmt.Errorf("failed to unmarshal Anthropic response: %w", err)
+	}
+	if response.Type == "error" || response.Error != nil {
+		return "", classifyAnthropicError(response.Error, 0)
+	}
+
+	var text strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if response.StopReason == "tool_use" {
+		p.logger.Info("Tool calls requested by model (handled by HandleFunctionCalls)")
+		return text.String(), nil
+	}
+	if text.Len() == 0 {
+		return "", errors.New("no text content returned from Anthropic")
+	}
+	return text.String(), nil
+}
+
+// ClassifyError classifies a non-200 response by the error in its body, so
+// "prompt is too long" reads as context_length_exceeded rather than a plain 400.
+func (p *AnthropicProvider) ClassifyError(statusCode int, body []byte) error {
+	var response AnthropicMessagesResponse
+	if err := json.Unmarshal(body, &response); err != nil || response.Error == nil {
+		return nil
+	}
+	return classifyAnthropicError(response.Error, statusCode)
+}
+
+// classifyAnthropicError phrases API errors with the status code and markers
+// the delegator's fallback and chunking decisions look for. A statusCode of 0
+// (errors inside a 200 response or a stream) is derived from the error type.
+func classifyAnthropicError(apiErr *AnthropicError, statusCode int) error {
+	if apiErr == nil {
+		apiErr = &AnthropicError{Type: "api_error", Message: "unknown error"}
+	}
+	message := strings.ToLower(apiErr.Message)
+	typeStatusCode := 500
+	contextLength := false
+	switch apiErr.Type {
+	case "invalid_request_error":
+		typeStatusCode = 400
+		contextLength = strings.Contains(message, "prompt is too long") || strings.Contains(message, "context window") || strings.Contains(message, "too many tokens")
+	case "authentication_error":
+		typeStatusCode = 401
+	case "permission_error":
+		typeStatusCode = 403
+	case "not_found_error":
+		typeStatusCode = 404
+	case "request_too_large":
+		typeStatusCode = 413
+		contextLength = true
+	case "rate_limit_error":
+		typeStatusCode = 429
+	case "overloaded_error":
+		typeStatusCode = 529
+	}
+	if statusCode == 0 {
+		statusCode = typeStatusCode
+	}
+	kind := apiErr.Type
+	if contextLength {
+		kind = "context_length_exceeded"
+	}
+	return &ProviderError{
+		Provider:   "anthropic",
+		StatusCode: statusCode,
+		Body:       apiErr.Message,
+		Err:        fmt.Errorf("anthropic API error (status code %d, %s): %s", statusCode, kind, apiErr.Message),
+	}
+}
+
+// SetExtraHeaders configures additional HTTP headers.
+func (p *AnthropicProvider) SetExtraHeaders(extraHeaders map[string]string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.extraHeaders == nil {
+		p.extraHeaders = make(map[string]string)
+	}
+	for k, v := range extraHeaders {
+		p.extraHeaders[k] = v
+	}
+	p.logger.Debug("Anthropic extra headers set", "headers", p.extraHeaders)
+}
+
+// HandleFunctionCalls returns the tool_use blocks of a response as gollm tools.
+func (p *AnthropicProvider) HandleFunctionCalls(body []byte) ([]byte, error) {
+	var response AnthropicMessagesResponse
+	if err := json.Unmarshal(body, &response); err != nil || response.StopReason != "tool_use" {
+		return body, nil // Not a response with tool calls
+	}
+	gollmToolCalls := make([]utils.Tool, 0, len(response.Content))
+	for _, block := range response.Content {
+		if block.Type != "tool_use" {
+			continue
+		}
+		var args map[string]interface{}
+		json.Unmarshal(block.Input, &args)
+		gollmToolCalls = append(gollmToolCalls, utils.Tool{
+			Type:     "function",
+			Function: utils.Function{Name: block.Name, Parameters: args},
+		})
+	}
+	if len(gollmToolCalls) == 0 {
+		return body, nil
+	}
+	p.logger.Info("Handling tool calls", "count", len(gollmToolCalls))
+	return json.Marshal(gollmToolCalls)
+}
+
+// SupportsJSONSchema reports false: the Messages API has no response format,
+// so gollm adds the schema to the prompt instead.
+func (p *AnthropicProvider) SupportsJSONSchema() bool {
+	return false
+}
+
+// SetDefaultOptions applies global configuration defaults.
+func (p *AnthropicProvider) SetDefaultOptions(cfg *config.Config) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if cfg == nil {
+		return
+	}
+	if cfg.APIKeys != nil {
+		if apiKey, ok := cfg.APIKeys[p.Name()]; ok && apiKey != "" && p.apiKey == "" {
+			p.apiKey = apiKey
+		}
+	}
+	if (p.model == "" || p.model == DefaultAnthropicModel) && cfg.Model != "" {
+		p.model = cfg.Model
+	}
+	if (p.maxTokens == 0 || p.maxTokens == 4096) && cfg.MaxTokens > 0 {
+		p.maxTokens = cfg.MaxTokens
+	}
+	if p.temperature == nil && cfg.Temperature > 0 {
+		p.setOptionInternal("temperature", cfg.Temperature)
+	}
+	if p.topP == nil && cfg.TopP > 0 {
+		p.setOptionInternal("top_p", cfg.TopP)
+	}
+
+	p.logger.Info("Anthropic default options applied", "model", p.model, "maxTokens", p.maxTokens)
+}
+
+// setOptionInternal is called by SetDefaultOptions/SetOption without locking
+func (p *AnthropicProvider) setOptionInternal(key string, value interface{}) {
+	switch key {
+	case "api_key":
+		if v, ok := value.(string); ok {
+			p.apiKey = v
+		}
+	case "model":
+		if v, ok := value.(string); ok {
+			p.model = v
+		}
+	case "max_tokens":
+		switch v := value.(type) {
+		case int:
+			if v > 0 {
+				p.maxTokens = v
+			}
+		case float64:
+			if v > 0 {
+				p.maxTokens = int(v)
+			}
+		}
+	case "temperature":
+		if v, ok := value.(float64); ok {
+			p.temperature = &v
+		}
+	case "top_p":
+		if v, ok := value.(float64); ok {
+			p.topP = &v
+		}
+	default:
+		p.logger.Warn("Attempted to set unknown option for AnthropicProvider", "key", key)
+	}
+}
+
+// SetOption sets a specific configuration option.
+func (p *AnthropicProvider) SetOption(key string, value interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.logger.Debug("Anthropic setting option", "key", key)
+	p.setOptionInternal(key, value)
+}
+
+// SetLogger configures the logger for the provider.
+func (p *AnthropicProvider) SetLogger(logger utils.Logger) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if logger != nil {
+		p.logger = logger
+		p.logger.Debug("Logger configured for Anthropic provider")
+	}
+}
+
+// SupportsStreaming indicates whether the provider supports streaming.
+func (p *AnthropicProvider) SupportsStreaming() bool {
+	return true
+}
+
+// PrepareStreamRequest creates a request body for streaming API calls.
+func (p *AnthropicProvider) PrepareStreamRequest(prompt string, options map[string]interface{}) ([]byte, error) {
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	options["stream"] = true
+	return p.PrepareRequest(prompt, options)
+}
+
+// ParseStreamResponse processes the data of one streaming event. Text deltas
+// are returned, message_stop ends the stream, error events are classified like
+// ParseResponse errors, and everything else (pings, block boundaries, tool
+// input deltas) is skipped.
+func (p *AnthropicProvider) ParseStreamResponse(chunk []byte) (string, error) {
+	trimmedChunk := strings.TrimSpace(string(chunk))
+	if trimmedChunk == "" {
+		return "", errAnthropicSkipToken
+	}
+
+	var event anthropicStreamEvent
+	if err := json.Unmarshal([]byte(trimmedChunk), &event); err != nil {
+		p.logger.Error("Failed to unmarshal Anthropic stream event", "error", err, "chunk", trimmedChunk)
+		return "", fmt.Errorf("failed to unmarshal stream event: %w", err)
+	}
+
+	switch event.Type {
+	case "content_block_delta":
+		if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+			return event.Delta.Text, nil
+		}
+	case "content_block_start":
+		if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+			p.logger.Info("Received tool use in stream", "tool", event.ContentBlock.Name)
+		}
+	case "message_delta":
+		if event.Delta.StopReason != "" {
+			p.logger.Debug("Stream finished", "reason", event.Delta.StopReason)
+		}
+	case "message_stop":
+		return "", io.EOF
+	case "error":
+		p.logger.Error("Received error event in stream", "error", event.Error)
+		return "", classifyAnthropicError(event.Error, 0)
+	}
+	return "", errAnthropicSkipToken
+}
+
+// --- Compile-time Interface Check ---
+var _ providers.Provider = (*AnthropicProvider)(nil)
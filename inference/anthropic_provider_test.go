@@ -0,0 +1,152 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/guiperry/gollm_cerebras/config"
+	"github.com/guiperry/gollm_cerebras/llm"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+	"github.com/guiperry/gollm_cerebras/utils"
+)
+
+func TestAnthropicProviderPreparesMessagesRequest(t *testing.T) {
+	provider := NewAnthropicProvider("sk-ant-test", "", nil).(*AnthropicProvider)
+	if headers := provider.Headers(); headers["x-api-key"] != "sk-ant-test" || headers["anthropic-version"] == "" {
+		t.Errorf("Expected x-api-key and anthropic-version headers, got %v", headers)
+	}
+
+	body, err := provider.PrepareRequestWithMessages([]gollm_types.MemoryMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "What time is it?"},
+		{Role: "assistant", Content: `{"tool_calls": [{"name": "get_current_time"}]}`},
+		{Role: "tool", Content: "12:00"},
+		{Role: "user", Content: "Thanks"},
+	}, map[string]interface{}{
+		"tools":       []utils.Tool{{Type: "function", Function: utils.Function{Name: "get_current_time", Description: "Current time"}}},
+		"tool_choice": "required",
+	})
+	if err != nil {
+		t.Fatalf("PrepareRequestWithMessages failed: %v", err)
+	}
+	var req AnthropicMessagesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("request is not valid JSON: %v", err)
+	}
+	if req.Model != DefaultAnthropicModel || req.System != "Be brief." {
+		t.Errorf("Expected default model and system prompt, got %q / %q", req.Model, req.System)
+	}
+	// The tool result and the follow-up merge into one user turn
+	if len(req.Messages) != 3 || req.Messages[2].Role != "user" || len(req.Messages[2].Content) != 2 {
+		t.Errorf("Expected user/assistant/user turns, got %+v", req.Messages)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].InputSchema["type"] != "object" || req.ToolChoice.Type != "any" {
+		t.Errorf("Expected one tool with an object schema and tool_choice any, got %+v / %+v", req.Tools, req.ToolChoice)
+	}
+}
+
+func TestAnthropicProviderParsesResponsesAndErrors(t *testing.T) {
+	provider := NewAnthropicProvider("sk-ant-test", "", nil).(*AnthropicProvider)
+
+	text, err := provider.ParseResponse([]byte(`{"type": "message", "content": [{"type": "text", "text": "Hello"}], "stop_reason": "end_turn"}`))
+	if err != nil || text != "Hello" {
+		t.Errorf("Expected 'Hello', got %q (%v)", text, err)
+	}
+
+	toolUse := []byte(`{"type": "message", "stop_reason": "tool_use", "content": [
+		{"type": "text", "text": "Let me check."},
+		{"type": "tool_use", "id": "toolu_1", "name": "get_current_time", "input": {"zone": "UTC"}}]}`)
+	text, err = provider.ParseResponse(toolUse)
+	if err != nil || text != "Let me check." {
+		t.Fatalf("Expected the text of a tool_use response, got %q (%v)", text, err)
+	}
+	_, collector := withToolCallCollector(context.Background(), nil)
+	collector.collect(context.Background(), provider, toolUse)
+	calls := collector.take(1)
+	if len(calls) != 1 || calls[0].Name != "get_current_time" || string(calls[0].Arguments) != `{"zone":"UTC"}` {
+		t.Errorf("Expected a get_current_time call the tool loop can run, got %+v", calls)
+	}
+
+	_, err = provider.ParseResponse([]byte(`{"type": "error", "error": {"type": "invalid_request_error", "message": "prompt is too long: 250000 tokens > 200000 maximum"}}`))
+	if err == nil || !strings.Contains(err.Error(), "context_length_exceeded") {
+		t.Errorf("Expected a context_length_exceeded error, got %v", err)
+	}
+	if err := classifyAnthropicError(&AnthropicError{Type: "overloaded_error", Message: "Overloaded"}, 0); !strings.Contains(err.Error(), "status code 5") {
+		t.Errorf("Expected an overloaded error to read as a 5xx, got %v", err)
+	}
+
+	if token, err := provider.ParseStreamResponse([]byte(`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hi"}}`)); err != nil || token != "Hi" {
+		t.Errorf("Expected stream token 'Hi', got %q (%v)", token, err)
+	}
+	if _, err := provider.ParseStreamResponse([]byte(`{"type": "ping"}`)); err == nil || err.Error() != "skip token" {
+		t.Errorf("Expected ping to be skipped, got %v", err)
+	}
+	if _, err := provider.ParseStreamResponse([]byte(`{"type": "message_stop"}`)); err != io.EOF {
+		t.Errorf("Expected io.EOF at message_stop, got %v", err)
+	}
+}
+
+func TestAnthropicErrorStatusesDriveFallback(t *testing.T) {
+	responses := map[string]struct {
+		status int
+		body   string
+	}{
+		"long":       {http.StatusBadRequest, `{"type": "error", "error": {"type": "invalid_request_error", "message": "prompt is too long: 210000 tokens > 200000 maximum"}}`},
+		"overloaded": {529, `{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`},
+		"stream":     {http.StatusOK, "event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": \"Hel\"}}\n\nevent: error\ndata: {\"type\": \"error\", \"error\": {\"type\": \"overloaded_error\", \"message\": \"Overloaded\"}}\n\n"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request AnthropicMessagesRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		prompt, _, _ := strings.Cut(request.Messages[0].Content[0].Text, "\n")
+		response := responses[prompt]
+		w.WriteHeader(response.status)
+		w.Write([]byte(response.body))
+	}))
+	defer server.Close()
+	t.Setenv("ANTHROPIC_API_ENDPOINT", server.URL+"/v1/messages")
+
+	instance, err := NewProviderLLM(server.Client(),
+		config.SetProvider("anthropic"),
+		config.SetAPIKey("sk-ant-REDACTED"),
+		config.SetModel(DefaultAnthropicModel),
+		config.SetMaxRetries(0),
+	)
+	if err != nil {
+		t.Fatalf("NewProviderLLM failed: %v", err)
+	}
+	delegator := &DelegatorService{}
+
+	_, err = instance.Generate(context.Background(), llm.NewPrompt("long"))
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.StatusCode != http.StatusBadRequest || !isContextLengthError(err) {
+		t.Errorf("Expected a 400 context_length_exceeded error, got %v", err)
+	}
+
+	_, err = instance.Generate(context.Background(), llm.NewPrompt("overloaded"))
+	if !errors.As(err, &providerErr) || providerErr.StatusCode != 529 || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Errorf("Expected a 529 overloaded error, got %v", err)
+	}
+	if isContextLengthError(err) || !delegator.shouldFallbackOnError(err) {
+		t.Errorf("Expected an overloaded error to fall back without chunking, got %v", err)
+	}
+
+	// An error event ends a stream with the classified error instead of being dropped
+	stream, err := instance.Stream(context.Background(), llm.NewPrompt("stream"))
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	defer stream.Close()
+	if token, err := stream.Next(context.Background()); err != nil || token.Text != "Hel" {
+		t.Fatalf("Expected the first token 'Hel', got %+v (%v)", token, err)
+	}
+	if _, err := stream.Next(context.Background()); !errors.As(err, &providerErr) || providerErr.StatusCode != 529 {
+		t.Errorf("Expected the stream's overloaded error, got %v", err)
+	}
+}
@@ -0,0 +1,524 @@
+package inference
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrBatchJobNotFound is returned when no batch job has the given ID.
+	ErrBatchJobNotFound = errors.New("batch job not found")
+	// ErrBatchInvalid is returned when a batch input or its options are rejected.
+	ErrBatchInvalid = errors.New("invalid batch job")
+	// ErrBatchJobState is returned when a job cannot be paused, resumed or cancelled in its current status.
+	ErrBatchJobState = errors.New("batch job cannot change status")
+)
+
+const (
+	defaultBatchConcurrency = 4
+	maxBatchConcurrency     = 32
+	maxBatchLineBytes       = 10 << 20
+)
+
+// BatchJobStatus is the lifecycle state of a batch job.
+type BatchJobStatus string
+
+const (
+	BatchJobRunning   BatchJobStatus = "running"
+	BatchJobPaused    BatchJobStatus = "paused"
+	BatchJobCompleted BatchJobStatus = "completed"
+	BatchJobCancelled BatchJobStatus = "cancelled"
+)
+
+// BatchRequest is one line of a batch input file.
+type BatchRequest struct {
+	ID           string `json:"id,omitempty"` // Caller's identifier, echoed in the result
+	SessionID    string `json:"session_id,omitempty"`
+	SessionToken string `json:"session_token,omitempty"` // Access token of SessionID
+	Model        string `json:"model,omitempty"`
+	Prompt       string `json:"prompt"`
+	Instruction  string `json:"instruction,omitempty"`
+	AgentID      string `json:"agent_id,omitempty"` // Applies the agent's PII redaction flag
+}
+
+// BatchResult is one line of a batch result file.
+type BatchResult struct {
+	Line      int                    `json:"line"` // 1-based line of the request in the input
+	ID        string                 `json:"id,omitempty"`
+	Status    string                 `json:"status"` // "succeeded" or "failed"
+	Response  string                 `json:"response,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"` // As returned by /inference/generate
+	Error     string                 `json:"error,omitempty"`
+	LatencyMs int64                  `json:"latency_ms"`
+}
+
+// BatchJobOptions configures how a batch job is processed.
+type BatchJobOptions struct {
+	Name              string `json:"name,omitempty"`
+	Concurrency       int    `json:"concurrency,omitempty"`         // Workers, default 4, at most 32
+	RequestsPerMinute int    `json:"requests_per_minute,omitempty"` // Limits this job below the shared limit; 0 adds no limit
+}
+
+// BatchJob reports a batch job's configuration and progress.
+type BatchJob struct {
+	ID                string         `json:"id"`
+	Name              string         `json:"name,omitempty"`
+	Status            BatchJobStatus `json:"status"`
+	Concurrency       int            `json:"concurrency"`
+	RequestsPerMinute int            `json:"requests_per_minute"` // Job limit; 0 means only the shared limit applies
+	Total             int            `json:"total"`
+	Processed         int            `json:"processed"`
+	Succeeded         int            `json:"succeeded"`
+	Failed            int            `json:"failed"`
+	Percent           float64        `json:"percent"`
+	CreatedAt         time.Time      `json:"created_at"`
+	FinishedAt        *time.Time     `json:"finished_at,omitempty"`
+}
+
+// batchLine is a parsed request waiting to be processed.
+type batchLine struct {
+	line    int
+	request BatchRequest
+}
+
+// batchJob is a job with its pending requests and result file.
+type batchJob struct {
+	info     BatchJob
+	pending  []batchLine
+	results  *os.File
+	path     string
+	interval time.Duration // Minimum spacing between the job's requests, 0 is unlimited
+	nextSlot time.Time
+	resume   chan struct{} // Closed when a paused job resumes
+	cancel   context.CancelFunc
+	mutex    sync.Mutex
+}
+
+// batchRateLimiter spaces requests at least interval apart (0 is unlimited).
+type batchRateLimiter struct {
+	interval time.Duration
+	nextSlot time.Time
+	mutex    sync.Mutex
+}
+
+func newBatchRateLimiter(requestsPerMinute int) *batchRateLimiter {
+	limiter := &batchRateLimiter{}
+	if requestsPerMinute > 0 {
+		limiter.interval = time.Minute / time.Duration(requestsPerMinute)
+	}
+	return limiter
+}
+
+// reserve takes the next slot if it is due, and otherwise returns how long
+// to wait for it.
+func (l *batchRateLimiter) reserve(now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if wait := l.nextSlot.Sub(now); wait > 0 {
+		return wait
+	}
+	l.nextSlot = now.Add(l.interval)
+	return 0
+}
+
+// BatchManager runs batch jobs through the inference service. Jobs are kept in
+// memory; their results are written to JSONL files in the output directory.
+type BatchManager struct {
+	service   *InferenceService
+	outputDir string
+	limiter   *batchRateLimiter // Shared by all jobs
+	jobs      map[string]*batchJob
+	mutex     sync.RWMutex
+}
+
+// NewBatchManager creates a batch manager writing results to outputDir.
+// requestsPerMinute limits the requests of all jobs together (0 is unlimited).
+func NewBatchManager(service *InferenceService, outputDir string, requestsPerMinute int) *BatchManager {
+	return &BatchManager{
+		service:   service,
+		outputDir: outputDir,
+		limiter:   newBatchRateLimiter(requestsPerMinute),
+		jobs:      make(map[string]*batchJob),
+	}
+}
+
+// batchOutputDirFromEnv reads BATCH_OUTPUT_DIR, defaulting to a directory
+// under the system temp directory.
+func batchOutputDirFromEnv() string {
+	if dir := os.Getenv("BATCH_OUTPUT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "agentic_engine_batches")
+}
+
+// batchRequestsPerMinuteFromEnv reads BATCH_REQUESTS_PER_MINUTE (default 60, 0 is unlimited).
+func batchRequestsPerMinuteFromEnv() int {
+	raw := os.Getenv("BATCH_REQUESTS_PER_MINUTE")
+	if raw == "" {
+		return 60
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		log.Printf("[WARN] BatchManager: Invalid BATCH_REQUESTS_PER_MINUTE '%s'. Using default 60.", raw)
+		return 60
+	}
+	return value
+}
+
+// Create reads a JSONL file of BatchRequests and starts processing it. Lines
+// that are not valid requests are recorded as failed results right away;
+// blank lines are ignored.
+func (m *BatchManager) Create(input io.Reader, options BatchJobOptions) (*BatchJob, error) {
+	concurrency := options.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	if concurrency < 0 || concurrency > maxBatchConcurrency {
+		return nil, fmt.Errorf("%w: concurrency must be between 1 and %d", ErrBatchInvalid, maxBatchConcurrency)
+	}
+	requestsPerMinute := options.RequestsPerMinute
+	if requestsPerMinute < 0 {
+		requestsPerMinute = 0
+	}
+
+	var pending []batchLine
+	var rejected []BatchResult
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxBatchLineBytes)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		var request BatchRequest
+		if err := json.Unmarshal([]byte(raw), &request); err != nil {
+			rejected = append(rejected, BatchResult{Line: lineNumber, Status: "failed", Error: fmt.Sprintf("invalid JSON: %v", err)})
+			continue
+		}
+		if request.Prompt == "" {
+			rejected = append(rejected, BatchResult{Line: lineNumber, ID: request.ID, Status: "failed", Error: "prompt is required"})
+			continue
+		}
+		pending = append(pending, batchLine{line: lineNumber, request: request})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to read input: %v", ErrBatchInvalid, err)
+	}
+	if len(pending)+len(rejected) == 0 {
+		return nil, fmt.Errorf("%w: the input has no requests", ErrBatchInvalid)
+	}
+
+	if err := os.MkdirAll(m.outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create batch output directory: %w", err)
+	}
+	id := uuid.New().String()
+	path := filepath.Join(m.outputDir, id+".jsonl")
+	results, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch results file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &batchJob{
+		info: BatchJob{
+			ID:                id,
+			Name:              options.Name,
+			Status:            BatchJobRunning,
+			Concurrency:       concurrency,
+			RequestsPerMinute: requestsPerMinute,
+			Total:             len(pending) + len(rejected),
+			CreatedAt:         time.Now(),
+		},
+		pending: pending,
+		results: results,
+		path:    path,
+		cancel:  cancel,
+	}
+	if requestsPerMinute > 0 {
+		job.interval = time.Minute / time.Duration(requestsPerMinute)
+	}
+	for _, result := range rejected {
+		job.record(result)
+	}
+
+	m.mutex.Lock()
+	m.jobs[id] = job
+	m.mutex.Unlock()
+
+	log.Printf("BatchManager: Started job %s with %d requests (%d invalid lines, %d workers, %d requests/minute)", id, job.info.Total, len(rejected), concurrency, requestsPerMinute)
+	go m.run(ctx, job)
+	return job.snapshot(), nil
+}
+
+// run processes a job's pending requests with its worker pool.
+func (m *BatchManager) run(ctx context.Context, job *batchJob) {
+	var wg sync.WaitGroup
+	for i := 0; i < job.info.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				line, ok := job.take(ctx, m.limiter)
+				if !ok {
+					return
+				}
+				result := m.process(ctx, line)
+				if ctx.Err() != nil {
+					// Cancelled mid-request; the line stays unprocessed
+					return
+				}
+				job.record(result)
+			}
+		}()
+	}
+	wg.Wait()
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if job.info.Status != BatchJobCancelled {
+		job.info.Status = BatchJobCompleted
+	}
+	now := time.Now()
+	job.info.FinishedAt = &now
+	if err := job.results.Close(); err != nil {
+		log.Printf("[ERROR] BatchManager: Failed to close results of job %s: %v", job.info.ID, err)
+	}
+	log.Printf("BatchManager: Job %s %s, %d/%d processed (%d failed)", job.info.ID, job.info.Status, job.info.Processed, job.info.Total, job.info.Failed)
+}
+
+// process generates the response to one request.
+func (m *BatchManager) process(ctx context.Context, line batchLine) BatchResult {
+	result := BatchResult{Line: line.line, ID: line.request.ID}
+	start := time.Now()
+	if line.request.SessionID != "" {
+		ctx = WithSessionToken(ctx, line.request.SessionToken)
+	}
+	ctx, _, err := m.service.WithAgent(ctx, line.request.AgentID)
+	var generated *GenerationResult
+	if err == nil {
+		generated, err = m.service.GenerateTextWithMetadata(ctx, line.request.SessionID, line.request.Model, line.request.Prompt, line.request.Instruction)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = "succeeded"
+	result.Response = generated.Content
+	if len(generated.Metadata) > 0 {
+		result.Metadata = generated.Metadata
+	}
+	return result
+}
+
+// take returns the next pending request once the job is not paused and both
+// the job's and the shared rate limit allow it. It returns false when the job
+// is cancelled or no requests are left.
+func (j *batchJob) take(ctx context.Context, shared *batchRateLimiter) (batchLine, bool) {
+	for {
+		j.mutex.Lock()
+		// A job paused after its last request was taken has nothing to wait for
+		if len(j.pending) == 0 || ctx.Err() != nil {
+			j.mutex.Unlock()
+			return batchLine{}, false
+		}
+		if j.info.Status == BatchJobPaused {
+			resume := j.resume
+			j.mutex.Unlock()
+			select {
+			case <-resume:
+				continue
+			case <-ctx.Done():
+				return batchLine{}, false
+			}
+		}
+		now := time.Now()
+		wait := j.nextSlot.Sub(now)
+		if wait <= 0 {
+			wait = shared.reserve(now)
+		}
+		if wait > 0 {
+			j.mutex.Unlock()
+			select {
+			case <-time.After(wait):
+				continue // Check for a pause that happened while waiting
+			case <-ctx.Done():
+				return batchLine{}, false
+			}
+		}
+		line := j.pending[0]
+		j.pending = j.pending[1:]
+		j.nextSlot = now.Add(j.interval)
+		j.mutex.Unlock()
+		return line, true
+	}
+}
+
+// record appends a result to the results file and updates the progress.
+func (j *batchJob) record(result BatchResult) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := json.NewEncoder(j.results).Encode(result); err != nil {
+		log.Printf("[ERROR] BatchManager: Failed to write result of line %d of job %s: %v", result.Line, j.info.ID, err)
+	}
+	j.info.Processed++
+	if result.Status == "succeeded" {
+		j.info.Succeeded++
+	} else {
+		j.info.Failed++
+	}
+}
+
+// snapshot copies the job's report with its current progress.
+func (j *batchJob) snapshot() *BatchJob {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	info := j.info
+	if info.Total > 0 {
+		info.Percent = float64(info.Processed) * 100 / float64(info.Total)
+	}
+	return &info
+}
+
+// Get returns a batch job's progress.
+func (m *BatchManager) Get(id string) (*BatchJob, error) {
+	job, err := m.job(id)
+	if err != nil {
+		return nil, err
+	}
+	return job.snapshot(), nil
+}
+
+// List returns all batch jobs, newest first.
+func (m *BatchManager) List() []BatchJob {
+	m.mutex.RLock()
+	jobs := make([]BatchJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job.snapshot())
+	}
+	m.mutex.RUnlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Pause stops a running job from starting new requests. Requests already
+// sent finish and are recorded.
+func (m *BatchManager) Pause(id string) (*BatchJob, error) {
+	job, err := m.job(id)
+	if err != nil {
+		return nil, err
+	}
+	job.mutex.Lock()
+	if job.info.Status != BatchJobRunning {
+		status := job.info.Status
+		job.mutex.Unlock()
+		return nil, fmt.Errorf("%w: job %s is %s", ErrBatchJobState, id, status)
+	}
+	job.info.Status = BatchJobPaused
+	job.resume = make(chan struct{})
+	job.mutex.Unlock()
+	log.Printf("BatchManager: Paused job %s", id)
+	return job.snapshot(), nil
+}
+
+// Resume continues a paused job.
+func (m *BatchManager) Resume(id string) (*BatchJob, error) {
+	job, err := m.job(id)
+	if err != nil {
+		return nil, err
+	}
+	job.mutex.Lock()
+	if job.info.Status != BatchJobPaused {
+		status := job.info.Status
+		job.mutex.Unlock()
+		return nil, fmt.Errorf("%w: job %s is %s", ErrBatchJobState, id, status)
+	}
+	job.info.Status = BatchJobRunning
+	close(job.resume)
+	job.mutex.Unlock()
+	log.Printf("BatchManager: Resumed job %s", id)
+	return job.snapshot(), nil
+}
+
+// Cancel stops a running or paused job. Requests in flight are abandoned and,
+// like the requests not yet started, have no result line.
+func (m *BatchManager) Cancel(id string) (*BatchJob, error) {
+	job, err := m.job(id)
+	if err != nil {
+		return nil, err
+	}
+	job.mutex.Lock()
+	if job.info.Status != BatchJobRunning && job.info.Status != BatchJobPaused {
+		status := job.info.Status
+		job.mutex.Unlock()
+		return nil, fmt.Errorf("%w: job %s is %s", ErrBatchJobState, id, status)
+	}
+	job.info.Status = BatchJobCancelled
+	job.mutex.Unlock()
+	job.cancel()
+	log.Printf("BatchManager: Cancelled job %s", id)
+	return job.snapshot(), nil
+}
+
+// Delete cancels a job if needed and removes it with its results file.
+func (m *BatchManager) Delete(id string) error {
+	m.mutex.Lock()
+	job, ok := m.jobs[id]
+	delete(m.jobs, id)
+	m.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrBatchJobNotFound, id)
+	}
+	job.mutex.Lock()
+	if job.info.Status == BatchJobRunning || job.info.Status == BatchJobPaused {
+		job.info.Status = BatchJobCancelled
+	}
+	job.mutex.Unlock()
+	job.cancel()
+	if err := os.Remove(job.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove results of batch job %s: %w", id, err)
+	}
+	log.Printf("BatchManager: Deleted job %s", id)
+	return nil
+}
+
+// OpenResults opens a job's results file. Results are in completion order, so
+// they can be downloaded while the job is still running; use the line field
+// to match them with the input.
+func (m *BatchManager) OpenResults(id string) (io.ReadCloser, error) {
+	job, err := m.job(id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(job.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results of batch job %s: %w", id, err)
+	}
+	return file, nil
+}
+
+// job looks up a job by ID.
+func (m *BatchManager) job(id string) (*batchJob, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBatchJobNotFound, id)
+	}
+	return job, nil
+}
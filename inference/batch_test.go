@@ -0,0 +1,180 @@
+package inference
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForBatchJob polls a job until its workers have stopped.
+func waitForBatchJob(t *testing.T, batches *BatchManager, id string) *BatchJob {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := batches.Get(id)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("batch job %s did not finish", id)
+	return nil
+}
+
+func TestBatchJobWritesPerLineResults(t *testing.T) {
+	t.Setenv("BATCH_OUTPUT_DIR", t.TempDir())
+	t.Setenv("BATCH_REQUESTS_PER_MINUTE", "0")
+	service := startFakeInferenceService(t, `{
+		"default": "rewritten",
+		"rules": [{"match": "broken", "error": "400"}]
+	}`)
+	input := strings.Join([]string{
+		`{"id": "home", "prompt": "rewrite the home page"}`,
+		`not json`,
+		``,
+		`{"id": "about", "prompt": "rewrite the broken about page"}`,
+		`{"id": "empty"}`,
+	}, "\n")
+
+	created, err := service.Batches().Create(strings.NewReader(input), BatchJobOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if created.Total != 4 {
+		t.Errorf("Expected 4 requests (blank line ignored), got %d", created.Total)
+	}
+	job := waitForBatchJob(t, service.Batches(), created.ID)
+	if job.Status != BatchJobCompleted || job.Processed != 4 || job.Succeeded != 1 || job.Failed != 3 || job.Percent != 100 {
+		t.Errorf("Expected a completed job with 1 success and 3 failures, got %+v", job)
+	}
+
+	results, err := service.Batches().OpenResults(created.ID)
+	if err != nil {
+		t.Fatalf("OpenResults failed: %v", err)
+	}
+	defer results.Close()
+	byLine := make(map[int]BatchResult)
+	scanner := bufio.NewScanner(results)
+	for scanner.Scan() {
+		var result BatchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("result line is not valid JSON: %v", err)
+		}
+		byLine[result.Line] = result
+	}
+	if byLine[1].Status != "succeeded" || byLine[1].ID != "home" || byLine[1].Response != "rewritten" {
+		t.Errorf("Expected line 1 to succeed, got %+v", byLine[1])
+	}
+	if byLine[2].Status != "failed" || !strings.Contains(byLine[2].Error, "invalid JSON") {
+		t.Errorf("Expected line 2 to fail as invalid JSON, got %+v", byLine[2])
+	}
+	if byLine[4].Status != "failed" || byLine[4].ID != "about" || byLine[5].Error != "prompt is required" {
+		t.Errorf("Expected lines 4 and 5 to fail, got %+v / %+v", byLine[4], byLine[5])
+	}
+}
+
+func TestBatchJobPauseResumeCancel(t *testing.T) {
+	t.Setenv("BATCH_OUTPUT_DIR", t.TempDir())
+	t.Setenv("BATCH_REQUESTS_PER_MINUTE", "0")
+	service := startFakeInferenceService(t, `{"rules": [{"response": "done", "latency_ms": 20}]}`)
+	batches := service.Batches()
+	input := strings.Repeat(`{"prompt": "page"}`+"\n", 20)
+
+	created, err := batches.Create(strings.NewReader(input), BatchJobOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := batches.Pause(created.ID); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	// With one worker, only the request already taken may finish while paused
+	if paused, _ := batches.Get(created.ID); paused.Status != BatchJobPaused || paused.Processed > 1 {
+		t.Errorf("Expected no progress while paused, got %+v", paused)
+	}
+	if _, err := batches.Pause(created.ID); !errors.Is(err, ErrBatchJobState) {
+		t.Errorf("Expected pausing a paused job to fail with ErrBatchJobState, got %v", err)
+	}
+	if _, err := batches.Resume(created.ID); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if job := waitForBatchJob(t, batches, created.ID); job.Status != BatchJobCompleted || job.Succeeded != 20 {
+		t.Errorf("Expected all 20 requests to succeed after resuming, got %+v", job)
+	}
+
+	created, err = batches.Create(strings.NewReader(input), BatchJobOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := batches.Cancel(created.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if job := waitForBatchJob(t, batches, created.ID); job.Status != BatchJobCancelled || job.Processed >= 20 {
+		t.Errorf("Expected a cancelled job with requests left, got %+v", job)
+	}
+	if _, err := batches.Resume(created.ID); !errors.Is(err, ErrBatchJobState) {
+		t.Errorf("Expected resuming a cancelled job to fail with ErrBatchJobState, got %v", err)
+	}
+}
+
+func TestBatchJobRateLimit(t *testing.T) {
+	job := &batchJob{interval: 50 * time.Millisecond, pending: make([]batchLine, 3)}
+	job.info.Status = BatchJobRunning
+	unlimited := newBatchRateLimiter(0)
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, ok := job.take(ctx, unlimited); !ok {
+			t.Fatalf("take %d returned no request", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected three requests to take at least two intervals, took %v", elapsed)
+	}
+	if _, ok := job.take(ctx, unlimited); ok {
+		t.Errorf("Expected take to stop when no requests are left")
+	}
+
+	// The shared limit spaces the requests of all jobs together
+	shared := &batchRateLimiter{interval: 50 * time.Millisecond}
+	first := &batchJob{pending: make([]batchLine, 2)}
+	second := &batchJob{pending: make([]batchLine, 2)}
+	start = time.Now()
+	for i := 0; i < 2; i++ {
+		for _, job := range []*batchJob{first, second} {
+			if _, ok := job.take(ctx, shared); !ok {
+				t.Fatalf("take %d returned no request", i)
+			}
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected four requests of two jobs to take at least three intervals, took %v", elapsed)
+	}
+}
+
+func TestPausedBatchJobWithNothingPendingDoesNotBlock(t *testing.T) {
+	job := &batchJob{}
+	job.info.Status = BatchJobPaused
+	job.resume = make(chan struct{})
+	taken := make(chan bool, 1)
+	go func() {
+		_, ok := job.take(context.Background(), newBatchRateLimiter(0))
+		taken <- ok
+	}()
+	select {
+	case ok := <-taken:
+		if ok {
+			t.Errorf("Expected no request from an empty job")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected take to return for a paused job without pending requests")
+	}
+}
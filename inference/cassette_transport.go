@@ -0,0 +1,279 @@
+package inference
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CassetteMode selects whether a CassetteTransport records or replays.
+type CassetteMode string
+
+const (
+	// CassetteRecord forwards requests and saves every exchange to the cassette.
+	CassetteRecord CassetteMode = "record"
+	// CassetteReplay answers requests from the cassette without any network.
+	CassetteReplay CassetteMode = "replay"
+)
+
+// ErrCassetteMiss is returned in replay mode for a request the cassette has no answer for.
+var ErrCassetteMiss = errors.New("no recorded interaction matches request")
+
+const cassetteRedacted = "REDACTED"
+
+// cassetteReplayAPIKey lets providers without a configured key start in replay
+// mode; gollm rejects empty or short keys.
+const cassetteReplayAPIKey = "cassette-replay-placeholder-key"
+
+// Query parameters and headers that carry credentials and never reach a cassette.
+var (
+	cassetteSecretParams  = []string{"key", "api_key", "apikey", "access_token"}
+	cassetteSecretHeaders = []string{"Authorization", "X-Api-Key", "Api-Key", "X-Goog-Api-Key", "Cookie"}
+)
+
+// CassetteRequest is the recorded, redacted half of an exchange.
+type CassetteRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// CassetteResponse is the recorded answer to a CassetteRequest.
+type CassetteResponse struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// CassetteInteraction is one request/response pair.
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+type cassetteFile struct {
+	Interactions []CassetteInteraction `json:"interactions"`
+}
+
+// CassetteTransport is an http.RoundTripper that records provider exchanges to
+// a cassette file or replays them. Replayed requests match on method, URL path
+// and normalized body (JSON compared structurally), so credentials and query
+// strings may differ from the recording. Identical requests are answered in
+// recorded order; once used up, the last match is repeated.
+type CassetteTransport struct {
+	mode CassetteMode
+	path string
+	next http.RoundTripper
+
+	mutex        sync.Mutex
+	interactions []CassetteInteraction
+	used         []bool
+}
+
+// NewCassetteTransport opens the cassette at path. Replay mode loads it;
+// record mode starts an empty cassette and sends requests through next
+// (http.DefaultTransport when nil).
+func NewCassetteTransport(path string, mode CassetteMode, next http.RoundTripper) (*CassetteTransport, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	t := &CassetteTransport{mode: mode, path: path, next: next}
+	switch mode {
+	case CassetteRecord:
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+		}
+	case CassetteReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette '%s': %w", path, err)
+		}
+		var file cassetteFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette '%s': %w", path, err)
+		}
+		t.interactions = file.Interactions
+		t.used = make([]bool, len(file.Interactions))
+	default:
+		return nil, fmt.Errorf("unknown cassette mode '%s' (expected %s or %s)", mode, CassetteRecord, CassetteReplay)
+	}
+	return t, nil
+}
+
+// Mode reports whether the transport records or replays.
+func (t *CassetteTransport) Mode() CassetteMode {
+	return t.mode
+}
+
+// RoundTrip records or replays req.
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cassette: failed to read request body: %w", err)
+		}
+	}
+	if t.mode == CassetteReplay {
+		return t.replay(req, body)
+	}
+	return t.record(req, body)
+}
+
+func (t *CassetteTransport) replay(req *http.Request, body []byte) (*http.Response, error) {
+	key := normalizeCassetteBody(string(body))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	match := -1
+	for i, interaction := range t.interactions {
+		recorded, err := url.Parse(interaction.Request.URL)
+		if err != nil || interaction.Request.Method != req.Method || recorded.Path != req.URL.Path {
+			continue
+		}
+		if normalizeCassetteBody(interaction.Request.Body) != key {
+			continue
+		}
+		match = i
+		if !t.used[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("cassette '%s': %w: %s %s", t.path, ErrCassetteMiss, req.Method, req.URL.Path)
+	}
+	t.used[match] = true
+	recorded := t.interactions[match].Response
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Headers.Clone(),
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *CassetteTransport) record(req *http.Request, body []byte) (*http.Response, error) {
+	if body != nil {
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cassette: failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := CassetteInteraction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     redactCassetteURL(req.URL),
+			Headers: redactCassetteHeaders(req.Header),
+			Body:    string(body),
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    redactCassetteHeaders(resp.Header),
+			Body:       string(respBody),
+		},
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.interactions = append(t.interactions, interaction)
+	if err := t.saveLocked(); err != nil {
+		log.Printf("[WARN] CassetteTransport: Failed to save cassette '%s': %v", t.path, err)
+	}
+	return resp, nil
+}
+
+// saveLocked rewrites the cassette after each recording so an interrupted run
+// keeps what it captured.
+func (t *CassetteTransport) saveLocked() error {
+	data, err := json.MarshalIndent(cassetteFile{Interactions: t.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, data, 0o644)
+}
+
+// redactCassetteURL masks credentials passed in the query, like Gemini's ?key=.
+func redactCassetteURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for name := range query {
+		for _, secret := range cassetteSecretParams {
+			if strings.EqualFold(name, secret) {
+				query.Set(name, cassetteRedacted)
+			}
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	redacted.User = nil
+	return redacted.String()
+}
+
+func redactCassetteHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	for _, name := range cassetteSecretHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, cassetteRedacted)
+		}
+	}
+	return redacted
+}
+
+// normalizeCassetteBody makes semantically equal bodies compare equal: JSON is
+// re-encoded with sorted keys, anything else is trimmed.
+func normalizeCassetteBody(body string) string {
+	trimmed := strings.TrimSpace(body)
+	var value interface{}
+	if err := json.Unmarshal([]byte(trimmed), &value); err == nil {
+		if normalized, err := json.Marshal(value); err == nil {
+			return string(normalized)
+		}
+	}
+	return trimmed
+}
+
+// cassetteFromEnv opens the cassette named by LLM_CASSETTE in the
+// LLM_CASSETTE_MODE mode (default replay), recording through next. It returns
+// nil when LLM_CASSETTE is unset. The InferenceService sends provider requests
+// through it; other HTTP clients in the process are not affected.
+func cassetteFromEnv(next http.RoundTripper) (*CassetteTransport, error) {
+	path := os.Getenv("LLM_CASSETTE")
+	if path == "" {
+		return nil, nil
+	}
+	mode := CassetteMode(strings.ToLower(os.Getenv("LLM_CASSETTE_MODE")))
+	if mode == "" {
+		mode = CassetteReplay
+	}
+	transport, err := NewCassetteTransport(path, mode, next)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("InferenceService: Provider HTTP traffic is using cassette '%s' (mode: %s).", path, mode)
+	return transport, nil
+}
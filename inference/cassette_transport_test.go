@@ -0,0 +1,138 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassetteRecordsRedactedAndReplays(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"echo": ` + string(body) + `}`))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "cassettes", "gemini.json")
+
+	recorder, err := NewCassetteTransport(path, CassetteRecord, nil)
+	if err != nil {
+		t.Fatalf("NewCassetteTransport(record) failed: %v", err)
+	}
+	client := &http.Client{Transport: recorder}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1beta/models/gemini:generateContent?key=super-secret", strings.NewReader(`{"prompt": "hi", "n": 1}`))
+	req.Header.Set("Authorization", "Bearer super-secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("recorded request failed: %v", err)
+	}
+	recorded, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cassette not written: %v", err)
+	}
+	if strings.Contains(string(data), "super-secret") {
+		t.Errorf("cassette leaks the API key:\n%s", data)
+	}
+
+	player, err := NewCassetteTransport(path, CassetteReplay, nil)
+	if err != nil {
+		t.Fatalf("NewCassetteTransport(replay) failed: %v", err)
+	}
+	client = &http.Client{Transport: player}
+	// A different key and key order in the body still match the recording
+	resp, err = client.Post(server.URL+"/v1beta/models/gemini:generateContent?key=other", "application/json", strings.NewReader(`{"n":1,"prompt":"hi"}`))
+	if err != nil {
+		t.Fatalf("replayed request failed: %v", err)
+	}
+	replayed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(replayed) != string(recorded) || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected replay %q (200), got %q (%d)", recorded, replayed, resp.StatusCode)
+	}
+	if calls != 1 {
+		t.Errorf("Expected replay to skip the server, got %d calls", calls)
+	}
+
+	_, err = client.Post(server.URL+"/v1beta/models/gemini:generateContent", "application/json", strings.NewReader(`{"prompt": "bye"}`))
+	if !errors.Is(err, ErrCassetteMiss) {
+		t.Errorf("Expected ErrCassetteMiss for an unrecorded body, got %v", err)
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestServiceRecordsAndReplaysThroughItsOwnTransport(t *testing.T) {
+	for _, name := range []string{"GEMINI_API_KEY", "ANTHROPIC_API_KEY", "LOCAL_LLM_MODEL", "FAKE_LLM_SCRIPT", "LLM_CASSETTE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("CEREBRAS_API_KEY", "csk-test-key-long-enough-for-gollm")
+	t.Setenv("DEEPSEEK_API_KEY", "sk-test-key-long-enough-for-gollm")
+	defaultTransport := http.DefaultTransport
+	calls := 0
+	upstream := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"choices": [{"message": {"role": "assistant", "content": "recorded answer"}, "finish_reason": "stop"}]}`)),
+			Request:    req,
+		}, nil
+	})
+	path := filepath.Join(t.TempDir(), "service.json")
+
+	generate := func(transport http.RoundTripper) string {
+		t.Helper()
+		service, err := NewInferenceService(nil)
+		if err != nil {
+			t.Fatalf("NewInferenceService failed: %v", err)
+		}
+		service.SetHTTPTransport(transport)
+		if err := service.Start(); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		defer service.Stop()
+		response, err := service.GenerateText(context.Background(), "", "", "Say hello", "")
+		if err != nil {
+			t.Fatalf("GenerateText failed: %v", err)
+		}
+		return response
+	}
+
+	recorder, err := NewCassetteTransport(path, CassetteRecord, upstream)
+	if err != nil {
+		t.Fatalf("NewCassetteTransport(record) failed: %v", err)
+	}
+	if response := generate(recorder); response != "recorded answer" || calls != 1 {
+		t.Fatalf("Expected one recorded call answering 'recorded answer', got %q after %d calls", response, calls)
+	}
+
+	// Replay needs neither the upstream nor API keys
+	t.Setenv("CEREBRAS_API_KEY", "")
+	t.Setenv("DEEPSEEK_API_KEY", "")
+	player, err := NewCassetteTransport(path, CassetteReplay, nil)
+	if err != nil {
+		t.Fatalf("NewCassetteTransport(replay) failed: %v", err)
+	}
+	if response := generate(player); response != "recorded answer" || calls != 1 {
+		t.Errorf("Expected the replayed answer without upstream calls, got %q after %d calls", response, calls)
+	}
+	if http.DefaultTransport != defaultTransport {
+		t.Errorf("Expected http.DefaultTransport to be left alone, got %T", http.DefaultTransport)
+	}
+}
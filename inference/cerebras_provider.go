@@ -289,13 +289,12 @@ func convertToolsToCerebras(gollmTools []utils.Tool) ([]CerebrasTool, error) {
 			Properties: orderedmap.New[string, *jsonschema.Schema](),
 		}
 
-		// Handle parameters if they exist
+		// Handle parameters if they exist; they are a JSON schema document
 		if tool.Function.Parameters != nil {
-			reflector := jsonschema.Reflector{}
-			if schema := reflector.Reflect(tool.Function.Parameters); schema != nil {
+			if schema, err := toJSONSchema(tool.Function.Parameters); err == nil {
 				paramsSchema = schema
 			} else {
-				log.Printf("Warning: Failed to reflect jsonschema from tool parameters")
+				log.Printf("Warning: Failed to convert tool parameters to jsonschema: %v", err)
 			}
 		}
 
@@ -477,21 +476,50 @@ func (p *CerebrasProvider) PrepareRequest(prompt string, options map[string]inte
 	return json.Marshal(req)
 }
 
+// toJSONSchema converts a schema given as *jsonschema.Schema, JSON document
+// (string, []byte or decoded map) or Go value into a *jsonschema.Schema.
+func toJSONSchema(schema interface{}) (*jsonschema.Schema, error) {
+	var schemaJSON []byte
+	switch s := schema.(type) {
+	case *jsonschema.Schema:
+		return s, nil
+	case string:
+		schemaJSON = []byte(s)
+	case []byte:
+		schemaJSON = s
+	case json.RawMessage:
+		schemaJSON = s
+	case map[string]interface{}:
+		encoded, err := json.Marshal(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal schema: %w", err)
+		}
+		schemaJSON = encoded
+	default:
+		reflector := jsonschema.Reflector{}
+		schemaPtr := reflector.Reflect(schema)
+		if schemaPtr == nil {
+			return nil, fmt.Errorf("failed to reflect jsonschema from provided schema type: %T", schema)
+		}
+		return schemaPtr, nil
+	}
+
+	schemaPtr := &jsonschema.Schema{}
+	if err := json.Unmarshal(schemaJSON, schemaPtr); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON schema: %w", err)
+	}
+	return schemaPtr, nil
+}
+
 // PrepareRequestWithSchema uses the ResponseFormat field.
 func (p *CerebrasProvider) PrepareRequestWithSchema(prompt string, options map[string]interface{}, schema interface{}) ([]byte, error) {
 	if !p.SupportsJSONSchema() {
 		return nil, errors.New("internal error: PrepareRequestWithSchema called but SupportsJSONSchema is false")
 	}
 
-	var schemaPtr *jsonschema.Schema
-	if s, ok := schema.(*jsonschema.Schema); ok {
-		schemaPtr = s
-	} else {
-		reflector := jsonschema.Reflector{}
-		schemaPtr = reflector.Reflect(schema)
-		if schemaPtr == nil {
-			return nil, fmt.Errorf("failed to reflect jsonschema from provided schema type: %T", schema)
-		}
+	schemaPtr, err := toJSONSchema(schema)
+	if err != nil {
+		return nil, err
 	}
 
 	if options == nil {
@@ -676,12 +704,19 @@ func (p *CerebrasProvider) HandleFunctionCalls(body []byte) ([]byte, error) {
 			// We need to convert CerebrasToolCall back to a format gollm understands (like utils.ToolCall)
 			gollmToolCalls := make([]utils.Tool, 0, len(toolCalls))
 			for _, tc := range toolCalls {
-				// Basic conversion, assuming gollm's utils.ToolCall matches structure
+				// The arguments are a JSON string; they are carried as the parameters
+				var args map[string]interface{}
+				if tc.Function.Arguments != "" {
+					if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+						p.logger.Warn("Failed to decode tool call arguments", "tool", tc.Function.Name, "error", err)
+					}
+				}
 				gollmToolCalls = append(gollmToolCalls, utils.Tool{
 					//ID:   tc.ID,
 					Type: tc.Type,
 					Function: utils.Function{
-						Name: tc.Function.Name,
+						Name:       tc.Function.Name,
+						Parameters: args,
 					},
 				})
 			}
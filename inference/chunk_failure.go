@@ -0,0 +1,170 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChunkFailurePolicy defines what happens when a chunk cannot be processed.
+type ChunkFailurePolicy int
+
+const (
+	// BestEffortPolicy processes every chunk, retrying each up to the configured
+	// retry count. Failed chunks leave a placeholder in the output and are
+	// listed in the returned ChunkErrors.
+	BestEffortPolicy ChunkFailurePolicy = iota
+	// FailFastPolicy stops at the first failed chunk without retrying and
+	// cancels the chunks still in flight.
+	FailFastPolicy
+	// RetryThenFailPolicy retries each chunk (at least defaultChunkRetries times
+	// when no retry count is set) and fails the whole call if one still fails.
+	RetryThenFailPolicy
+)
+
+// String returns the name used for the policy in configs and requests.
+func (p ChunkFailurePolicy) String() string {
+	switch p {
+	case FailFastPolicy:
+		return "fail_fast"
+	case RetryThenFailPolicy:
+		return "retry_then_fail"
+	default:
+		return "best_effort"
+	}
+}
+
+// ParseChunkFailurePolicy converts a name ("best_effort", "fail_fast",
+// "retry_then_fail") into a ChunkFailurePolicy. An empty name selects
+// BestEffortPolicy.
+func ParseChunkFailurePolicy(name string) (ChunkFailurePolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "best_effort":
+		return BestEffortPolicy, nil
+	case "fail_fast":
+		return FailFastPolicy, nil
+	case "retry_then_fail":
+		return RetryThenFailPolicy, nil
+	default:
+		return BestEffortPolicy, fmt.Errorf("unknown chunk failure policy %q (expected best_effort, fail_fast or retry_then_fail)", name)
+	}
+}
+
+const (
+	// defaultMaxParallelism bounds concurrent chunk calls so large inputs don't
+	// open one provider request per chunk at once.
+	defaultMaxParallelism = 4
+	// defaultChunkRetries is used by RetryThenFailPolicy when no retry count is set.
+	defaultChunkRetries = 2
+	// chunkRetryDelay is the base backoff between attempts on the same chunk.
+	chunkRetryDelay = time.Second
+	// defaultSequentialDelay paces sequential chunk calls to stay under
+	// provider rate limits.
+	defaultSequentialDelay = 10 * time.Second
+)
+
+// sequentialDelayFromEnv reads CHUNK_SEQUENTIAL_DELAY, a duration such as
+// "2s" between the chunk calls of sequential processing (default 10s, "0"
+// for none, e.g. with the fake provider).
+func sequentialDelayFromEnv() time.Duration {
+	raw := os.Getenv("CHUNK_SEQUENTIAL_DELAY")
+	if raw == "" {
+		return defaultSequentialDelay
+	}
+	delay, err := time.ParseDuration(raw)
+	if err != nil || delay < 0 {
+		log.Printf("[WARN] ContextManager: Invalid CHUNK_SEQUENTIAL_DELAY '%s'. Using default %v.", raw, defaultSequentialDelay)
+		return defaultSequentialDelay
+	}
+	return delay
+}
+
+// ChunkError records a chunk that could not be processed.
+type ChunkError struct {
+	Chunk    int // 1-based chunk index
+	Attempts int // Number of calls made for the chunk (0 if it never started)
+	Err      error
+}
+
+func (e *ChunkError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("chunk %d failed after %d attempts: %v", e.Chunk, e.Attempts, e.Err)
+	}
+	if e.Attempts == 0 {
+		return fmt.Sprintf("chunk %d not processed: %v", e.Chunk, e.Err)
+	}
+	return fmt.Sprintf("chunk %d failed: %v", e.Chunk, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// ChunkErrors lists every chunk that failed during one call, ordered by chunk.
+// errors.Is and errors.As see through it to the individual chunk errors.
+type ChunkErrors []*ChunkError
+
+func (e ChunkErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, chunkErr := range e {
+		messages[i] = chunkErr.Error()
+	}
+	return fmt.Sprintf("%d chunk(s) failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+func (e ChunkErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, chunkErr := range e {
+		errs[i] = chunkErr
+	}
+	return errs
+}
+
+// sorted returns the errors ordered by chunk index, or nil if there are none.
+func (e ChunkErrors) sorted() error {
+	if len(e) == 0 {
+		return nil
+	}
+	sort.Slice(e, func(i, j int) bool { return e[i].Chunk < e[j].Chunk })
+	return e
+}
+
+// chunkRetries returns how many extra attempts a failed chunk gets under the
+// current policy.
+func (cm *ContextManager) chunkRetries() int {
+	switch cm.failurePolicy {
+	case FailFastPolicy:
+		return 0
+	case RetryThenFailPolicy:
+		if cm.maxChunkRetries <= 0 {
+			return defaultChunkRetries
+		}
+	}
+	return max(0, cm.maxChunkRetries)
+}
+
+// generateChunk calls the LLM for one chunk, retrying with a linear backoff.
+// It returns the number of attempts made alongside the result.
+func (cm *ContextManager) generateChunk(ctx context.Context, llm TextGenerator, prompt string, chunk int) (string, int, error) {
+	retries := cm.chunkRetries()
+	for attempt := 1; ; attempt++ {
+		result, err := llm.GenerateText(ctx, prompt)
+		if err == nil {
+			return result, attempt, nil
+		}
+		if attempt > retries || ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			return "", attempt, err
+		}
+		log.Printf("ContextManager: Chunk %d attempt %d failed, retrying: %v", chunk, attempt, err)
+		select {
+		case <-ctx.Done():
+			return "", attempt, err
+		case <-time.After(chunkRetryDelay * time.Duration(attempt)):
+		}
+	}
+}
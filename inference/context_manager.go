@@ -3,12 +3,15 @@ package inference
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
 	"strings"
 	"sync"
 	"time" // Import time package
+
+	"Agentic_Engine/database"
 )
 
 // ChunkingStrategy defines how to split the text.
@@ -21,6 +24,15 @@ const (
 	ChunkBySentence
 	// ChunkByTokenCount splits text based on estimated token count.
 	ChunkByTokenCount
+	// ChunkByMarkdownHeading splits Markdown on its heading hierarchy, keeping
+	// fenced code blocks, lists and tables intact.
+	ChunkByMarkdownHeading
+	// ChunkByHTMLBlock splits HTML on headings and block elements, keeping
+	// tables, lists and <pre> code intact.
+	ChunkByHTMLBlock
+	// ChunkBySemanticBoundary embeds sentences and splits where the similarity of
+	// neighbouring sentences drops, so each chunk covers one topic.
+	ChunkBySemanticBoundary
 )
 
 // ProcessingMode defines how chunks should be processed.
@@ -33,6 +45,47 @@ const (
 	SequentialProcessing
 )
 
+// SynthesisMode defines how the outputs of individual chunks are combined.
+type SynthesisMode int
+
+const (
+	// MapOnlySynthesis joins chunk outputs with a separator (no extra LLM calls).
+	MapOnlySynthesis SynthesisMode = iota
+	// MapReduceSynthesis hierarchically combines chunk outputs with the reduce
+	// instruction until a single result remains.
+	MapReduceSynthesis
+	// RefineSynthesis builds an answer from the first chunk and refines it with
+	// each following chunk in order.
+	RefineSynthesis
+)
+
+// String returns the name used for the synthesis mode in configs and requests.
+func (m SynthesisMode) String() string {
+	switch m {
+	case MapReduceSynthesis:
+		return "map_reduce"
+	case RefineSynthesis:
+		return "refine"
+	default:
+		return "map"
+	}
+}
+
+// ParseSynthesisMode converts a name ("map", "map_reduce", "refine") into a
+// SynthesisMode. An empty name selects MapOnlySynthesis.
+func ParseSynthesisMode(name string) (SynthesisMode, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "map", "map_only":
+		return MapOnlySynthesis, nil
+	case "map_reduce", "mapreduce", "reduce":
+		return MapReduceSynthesis, nil
+	case "refine":
+		return RefineSynthesis, nil
+	default:
+		return MapOnlySynthesis, fmt.Errorf("unknown synthesis mode %q (expected map, map_reduce or refine)", name)
+	}
+}
+
 // ContextManager handles chunking and processing of large text inputs.
 type ContextManager struct {
 	// inferenceService TextGenerator // REMOVED: LLM will be passed to ProcessLargePrompt
@@ -42,6 +95,17 @@ type ContextManager struct {
 	chunkOverlap       int              // Number of tokens to overlap between chunks
 	modelName          string           // Model name for token estimation
 	contextTokenBudget int              // Max tokens for summary context in sequential mode
+	synthesisMode      SynthesisMode    // How chunk outputs are combined
+	reduceInstruction  string           // Instruction for combining outputs (map-reduce)
+	refineInstruction  string           // Instruction for refining the answer (refine)
+	reduceTokenLimit   int              // Max tokens per reduce call (0 uses maxChunkSize)
+	maxParallelism     int              // Max concurrent chunk calls in parallel mode (0 = one per chunk)
+	maxChunkRetries    int              // Extra attempts for a failed chunk
+	failurePolicy      ChunkFailurePolicy
+	embedder           database.Embedder // Embeds sentences for ChunkBySemanticBoundary (nil uses the hash embedder)
+	semanticThreshold  float64           // Similarity below which a semantic chunk ends
+	minChunkSize       int               // Tokens a semantic chunk needs before it may end at a boundary
+	sequentialDelay    time.Duration     // Pause after each sequential chunk call to a provider (0 = none)
 }
 
 // ContextManagerOption defines a functional option for configuring ContextManager.
@@ -82,11 +146,94 @@ func WithContextTokenBudget(budget int) ContextManagerOption {
 	}
 }
 
+// WithSynthesisMode sets how chunk outputs are combined.
+func WithSynthesisMode(mode SynthesisMode) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.synthesisMode = mode
+	}
+}
+
+// WithReduceInstruction sets the instruction used to combine partial results
+// in map-reduce mode.
+func WithReduceInstruction(instruction string) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.reduceInstruction = instruction
+	}
+}
+
+// WithRefineInstruction sets the instruction used to refine the running answer
+// in refine mode.
+func WithRefineInstruction(instruction string) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.refineInstruction = instruction
+	}
+}
+
+// WithReduceTokenLimit sets the maximum number of tokens sent in a single
+// reduce call. Zero uses the max chunk size.
+func WithReduceTokenLimit(limit int) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.reduceTokenLimit = limit
+	}
+}
+
+// WithMaxParallelism limits how many chunks are processed at once in parallel
+// mode. Zero or a negative value starts one call per chunk.
+func WithMaxParallelism(n int) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.maxParallelism = n
+	}
+}
+
+// WithChunkRetries sets how many times a failed chunk is retried.
+func WithChunkRetries(retries int) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.maxChunkRetries = retries
+	}
+}
+
+// WithChunkFailurePolicy sets what happens when a chunk fails.
+func WithChunkFailurePolicy(policy ChunkFailurePolicy) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.failurePolicy = policy
+	}
+}
+
+// WithEmbedder sets the embedder used by ChunkBySemanticBoundary.
+func WithEmbedder(embedder database.Embedder) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.embedder = embedder
+	}
+}
+
+// WithSemanticThreshold sets the similarity between neighbouring sentences
+// below which ChunkBySemanticBoundary starts a new chunk.
+func WithSemanticThreshold(threshold float64) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.semanticThreshold = threshold
+	}
+}
+
+// WithMinChunkSize sets how many tokens a ChunkBySemanticBoundary chunk must
+// hold before a drop in similarity may end it.
+func WithMinChunkSize(size int) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.minChunkSize = size
+	}
+}
+
+// WithSequentialDelay sets the pause after each chunk call in sequential mode.
+func WithSequentialDelay(delay time.Duration) ContextManagerOption {
+	return func(cm *ContextManager) {
+		cm.sequentialDelay = delay
+	}
+}
+
 // TextGenerator defines the minimal interface needed for generating text
 // This allows passing different LLM instances (like those from gollm).
+// The context carries the caller's deadline and cancellation to the provider call.
 type TextGenerator interface {
-	GenerateText(prompt string) (string, error)
-	// Add other methods if needed by the context manager, e.g., Generate(ctx, prompt)
+	GenerateText(ctx context.Context, prompt string) (string, error)
 }
 
 // NewContextManager creates a new ContextManager with the given options.
@@ -101,6 +248,14 @@ func NewContextManager(strategy ChunkingStrategy, opts ...ContextManagerOption)
 		chunkOverlap:       100,                // Default overlap
 		modelName:          "gpt-4",            // Default model for token estimation
 		contextTokenBudget: 250,                // Default token budget for context summary
+		synthesisMode:      MapOnlySynthesis,   // Default to joining chunk outputs
+		reduceInstruction:  defaultReduceInstruction,
+		refineInstruction:  defaultRefineInstruction,
+		maxParallelism:     defaultMaxParallelism,
+		failurePolicy:      BestEffortPolicy, // Keep partial results by default
+		semanticThreshold:  defaultSemanticThreshold,
+		minChunkSize:       defaultSemanticMinChunkSize,
+		sequentialDelay:    defaultSequentialDelay,
 	}
 
 	// Apply options
@@ -157,6 +312,12 @@ func (cm *ContextManager) splitIntoChunks(text string) []string {
 		// Split based on estimated token count
 		return cm.splitByTokenCount(text)
 
+	case ChunkByMarkdownHeading, ChunkByHTMLBlock:
+		return chunkTexts(cm.splitIntoSections(context.Background(), text))
+
+	case ChunkBySemanticBoundary:
+		return cm.splitBySemanticBoundary(context.Background(), text)
+
 	default:
 		log.Printf("[WARN] Unknown chunking strategy: %d. Falling back to paragraph.", cm.strategy)
 		// Set to ChunkByParagraph and retry
@@ -165,6 +326,41 @@ func (cm *ContextManager) splitIntoChunks(text string) []string {
 	}
 }
 
+// splitIntoSections splits the text like splitIntoChunks, additionally recording
+// the heading path of each chunk for the structure-aware strategies. The context
+// bounds the embedding calls of ChunkBySemanticBoundary.
+func (cm *ContextManager) splitIntoSections(ctx context.Context, text string) []textChunk {
+	switch cm.strategy {
+	case ChunkByMarkdownHeading:
+		return cm.packStructuredBlocks(splitMarkdownBlocks(text), "\n\n")
+
+	case ChunkByHTMLBlock:
+		blocks, err := cm.splitHTMLBlocks(text)
+		if err != nil {
+			log.Printf("[WARN] Could not parse HTML for chunking: %v. Falling back to paragraphs.", err)
+			blocks = nil
+			for _, paragraph := range strings.Split(text, "\n\n") {
+				if trimmed := strings.TrimSpace(paragraph); trimmed != "" {
+					blocks = append(blocks, structuredBlock{Text: trimmed})
+				}
+			}
+		}
+		return cm.packStructuredBlocks(blocks, "\n")
+	}
+
+	var texts []string
+	if cm.strategy == ChunkBySemanticBoundary {
+		texts = cm.splitBySemanticBoundary(ctx, text)
+	} else {
+		texts = cm.splitIntoChunks(text)
+	}
+	chunks := make([]textChunk, len(texts))
+	for i, chunkText := range texts {
+		chunks[i] = textChunk{Text: chunkText}
+	}
+	return chunks
+}
+
 // groupSentencesIntoChunks groups sentences into larger chunks to avoid too many small chunks.
 func (cm *ContextManager) groupSentencesIntoChunks(sentences []string) []string {
 	if len(sentences) == 0 {
@@ -301,11 +497,18 @@ func (cm *ContextManager) splitByTokenCount(text string) []string {
 // and reassembles the results.
 // Accepts the TextGenerator (LLM instance) to use for processing.
 func (cm *ContextManager) ProcessLargePrompt(ctx context.Context, llm TextGenerator, largePrompt string, instructionPerChunk string) (string, error) {
+	return cm.processLargePrompt(ctx, llm, largePrompt, instructionPerChunk, cm.synthesisMode, "")
+}
+
+// processLargePrompt chunks and maps the input, then combines the chunk outputs
+// according to the synthesis mode. An empty synthesisInstruction uses the
+// configured reduce or refine instruction.
+func (cm *ContextManager) processLargePrompt(ctx context.Context, llm TextGenerator, largePrompt string, instructionPerChunk string, synthesis SynthesisMode, synthesisInstruction string) (string, error) {
 	if llm == nil {
 		return "", fmt.Errorf("context manager cannot process: TextGenerator (LLM) is nil")
 	}
 
-	chunks := cm.splitIntoChunks(largePrompt)
+	chunks := cm.splitIntoSections(ctx, largePrompt)
 	if len(chunks) == 0 {
 		return "", fmt.Errorf("prompt resulted in zero chunks")
 	}
@@ -319,39 +522,94 @@ func (cm *ContextManager) ProcessLargePrompt(ctx context.Context, llm TextGenera
 			return "sequential"
 		}())
 
+	// Refine walks the chunks itself, carrying the answer from one to the next
+	if synthesis == RefineSynthesis {
+		return cm.processWithRefine(ctx, llm, chunks, instructionPerChunk, synthesisInstruction)
+	}
+
 	// Choose processing method based on mode
+	var outputs []string
+	var err error
 	if cm.processingMode == SequentialProcessing {
-		return cm.processSequentially(ctx, llm, chunks, instructionPerChunk)
+		// Sequential mode re-splits the text around its running summary, so
+		// heading paths are not carried over
+		outputs, err = cm.processSequentially(ctx, llm, chunkTexts(chunks), instructionPerChunk)
+	} else {
+		// Default to parallel processing
+		outputs, err = cm.processInParallel(ctx, llm, chunks, instructionPerChunk)
 	}
-
-	// Default to parallel processing
-	return cm.processInParallel(ctx, llm, chunks, instructionPerChunk)
+	if err != nil || synthesis != MapReduceSynthesis {
+		return strings.Join(outputs, chunkSeparator), err
+	}
+	return cm.reduceOutputs(ctx, llm, outputs, instructionPerChunk, synthesisInstruction)
 }
 
-// processInParallel processes chunks in parallel for speed.
+// processInParallel processes chunks concurrently, at most maxParallelism at a
+// time, and applies the failure policy to chunks that still fail after retries.
 // Accepts the TextGenerator (LLM instance).
-func (cm *ContextManager) processInParallel(_ context.Context, llm TextGenerator, chunks []string, instructionPerChunk string) (string, error) {
+// It returns the output of every chunk, in order. Failed chunks hold an
+// "[ERROR PROCESSING CHUNK n]" placeholder and are listed in the ChunkErrors.
+func (cm *ContextManager) processInParallel(ctx context.Context, llm TextGenerator, chunks []textChunk, instructionPerChunk string) ([]string, error) {
+	// runCtx is cancelled to stop outstanding chunks under the fail-fast policies
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopOnFailure := cm.failurePolicy == FailFastPolicy || cm.failurePolicy == RetryThenFailPolicy
+
+	limit := cm.maxParallelism
+	if limit <= 0 || limit > len(chunks) {
+		limit = len(chunks)
+	}
+	slots := make(chan struct{}, limit)
+
 	var wg sync.WaitGroup
-	var lastError error
-	var errMutex sync.Mutex                     // To safely write to lastError from goroutines
+	var failures ChunkErrors
+	var errMutex sync.Mutex                     // To safely record failures from goroutines
 	resultsArray := make([]string, len(chunks)) // Store results in order
 
+	recordFailure := func(index int, attempts int, err error) {
+		resultsArray[index] = fmt.Sprintf("[ERROR PROCESSING CHUNK %d]", index+1) // Placeholder
+		if ctx.Err() == nil && runCtx.Err() != nil && (attempts == 0 || errors.Is(err, context.Canceled)) {
+			return // Stopped because another chunk failed; only that failure is reported
+		}
+		errMutex.Lock()
+		failures = append(failures, &ChunkError{Chunk: index + 1, Attempts: attempts, Err: err})
+		errMutex.Unlock()
+		if stopOnFailure {
+			cancel()
+		}
+	}
+
 	for i, chunk := range chunks {
+		// Take the slot before starting the goroutine, so chunks start in order
+		// and at most limit goroutines run at a time
+		acquired := false
+		select {
+		case slots <- struct{}{}:
+			acquired = true
+		case <-runCtx.Done():
+		}
+		if err := runCtx.Err(); err != nil {
+			// The deadline passed, the request was cancelled or another chunk
+			// failed under a fail-fast policy; don't start new calls
+			if acquired {
+				<-slots
+			}
+			recordFailure(i, 0, err)
+			continue
+		}
 		wg.Add(1)
-		go func(index int, chunkText string) {
+		go func(index int, chunk textChunk) {
 			defer wg.Done()
+			defer func() { <-slots }()
 			log.Printf("ContextManager: Processing chunk %d/%d in parallel...", index+1, len(chunks))
 
 			// Construct prompt for this chunk
-			chunkPrompt := fmt.Sprintf("%s\n\n---\n%s\n---", instructionPerChunk, chunkText)
+			chunkPrompt := chunk.prompt(instructionPerChunk)
 
-			result, err := llm.GenerateText(chunkPrompt) // Use the passed LLM
+			result, attempts, err := cm.generateChunk(runCtx, llm, chunkPrompt, index+1) // Use the passed LLM
 			if err != nil {
-				errMutex.Lock()
-				lastError = fmt.Errorf("error processing chunk %d: %w", index+1, err)
-				errMutex.Unlock()
 				log.Printf("ContextManager: Error on chunk %d: %v", index+1, err)
-				resultsArray[index] = fmt.Sprintf("[ERROR PROCESSING CHUNK %d]", index+1) // Placeholder
+				recordFailure(index, attempts, err)
 				return
 			}
 			resultsArray[index] = result
@@ -361,19 +619,14 @@ func (cm *ContextManager) processInParallel(_ context.Context, llm TextGenerator
 
 	wg.Wait() // Wait for all goroutines to finish
 
-	// Reassemble results in order
-	finalResult := strings.Join(resultsArray, "\n\n---\n\n") // Join with a separator
-
-	log.Println("ContextManager: Finished processing all chunks in parallel.")
-	return finalResult, lastError
+	log.Printf("ContextManager: Finished processing all chunks in parallel (%d failed, policy %s).", len(failures), cm.failurePolicy)
+	return resultsArray, failures.sorted() // Results are in chunk order
 }
 
 // processSequentially processes chunks in sequence, passing context between them.
 // Accepts the TextGenerator (LLM instance).
-
-// processSequentially processes chunks in sequence, passing context between them.
-// Accepts the TextGenerator (LLM instance).
-func (cm *ContextManager) processSequentially(_ context.Context, llm TextGenerator, chunks []string, instructionPerChunk string) (string, error) {
+// It returns the output of every chunk, in order.
+func (cm *ContextManager) processSequentially(ctx context.Context, llm TextGenerator, chunks []string, instructionPerChunk string) ([]string, error) {
 	// Instead of using pre-split chunks, we'll manage the text dynamically.
 	// Join the pre-split chunks back together for this approach.
 	// A better long-term solution might be to pass the raw text here.
@@ -386,6 +639,11 @@ func (cm *ContextManager) processSequentially(_ context.Context, llm TextGenerat
 
 	for remainingText != "" {
 		chunkIndex++
+		if err := ctx.Err(); err != nil {
+			// Stop at the deadline and return what was processed so far
+			log.Printf("ContextManager: Stopping before chunk %d: %v", chunkIndex, err)
+			return results, fmt.Errorf("processing stopped before chunk %d: %w", chunkIndex, err)
+		}
 		// Estimate tokens for the base instruction and current summary
 		instructionTokens := estimateTokens(instructionPerChunk, cm.modelName)
 		summaryTokens := estimateTokens(previousOutputSummary, cm.modelName)
@@ -454,15 +712,14 @@ func (cm *ContextManager) processSequentially(_ context.Context, llm TextGenerat
 		log.Printf("ContextManager: Sequential Prompt for Chunk %d:\n%s\n", chunkIndex, chunkPrompt)
 		// --- End logging ---
 
-		result, err := llm.GenerateText(chunkPrompt) // Use the passed LLM
+		result, attempts, err := cm.generateChunk(ctx, llm, chunkPrompt, chunkIndex) // Use the passed LLM
 		if err != nil {
-			// If an error occurs, return the results obtained so far and the error
+			// Later chunks depend on this one's output, so sequential mode always
+			// stops here and returns the results obtained so far
 
 			log.Printf("ContextManager: Error on chunk %d: %v", chunkIndex, err)
 			results = append(results, fmt.Sprintf("[ERROR PROCESSING CHUNK %d]", chunkIndex))
-			return strings.Join(results, "\n\n---\n\n"),
-
-				fmt.Errorf("error processing chunk %d: %w", chunkIndex, err)
+			return results, ChunkErrors{{Chunk: chunkIndex, Attempts: attempts, Err: err}}
 		}
 
 		results = append(results, result)
@@ -473,20 +730,21 @@ func (cm *ContextManager) processSequentially(_ context.Context, llm TextGenerat
 		log.Printf("ContextManager: Generated summary for next chunk context: %s", previousOutputSummary)
 
 		// --- Conditional Delay ---
-		if adapter, ok := llm.(*LLMAdapter); ok { // Check if it's our adapter
+		if adapter, ok := llm.(*LLMAdapter); ok && cm.sequentialDelay > 0 { // Check if it's our adapter
 			// Access the underlying gollm LLM and its provider
-			if adapter.ProviderName != "" { // Check if provider name is available
-				log.Printf("ContextManager: Adding 10s delay after chunk %d (Provider: %s)...", chunkIndex, adapter.ProviderName)
-				time.Sleep(10 * time.Second) // Apply delay
+			if adapter.ProviderName != "" {
+				log.Printf("ContextManager: Adding %v delay after chunk %d (Provider: %s)...", cm.sequentialDelay, chunkIndex, adapter.ProviderName)
+				select { // Apply delay, unless the deadline passes first
+				case <-ctx.Done():
+				case <-time.After(cm.sequentialDelay):
+				}
 			}
 		}
 		// --- END Conditional Delay ---
 	} // End of loop through remainingText
-	return strings.Join(results, "\n\n---\n\n"), nil
+	return results, nil
 }
 
-// Reassemble results in order
-
 // summarizeForContext creates a short summary of the text for context passing.
 // It aims to stay within the provided token budget.
 func (cm *ContextManager) summarizeForContext(text string, budget int) string {
@@ -623,6 +881,20 @@ func (cm *ContextManager) ProcessLargePromptWithMode(
 	return result, err
 }
 
+// ProcessLargePromptWithSynthesis processes a large prompt with a specific synthesis
+// mode, overriding the default mode for this call only. A non-empty
+// synthesisInstruction replaces the configured reduce or refine instruction.
+func (cm *ContextManager) ProcessLargePromptWithSynthesis(
+	ctx context.Context,
+	largePrompt string,
+	instructionPerChunk string,
+	mode SynthesisMode,
+	synthesisInstruction string,
+	llm TextGenerator, // Pass the LLM instance
+) (string, error) {
+	return cm.processLargePrompt(ctx, llm, largePrompt, instructionPerChunk, mode, synthesisInstruction)
+}
+
 // GetChunkingStrategy returns the current chunking strategy.
 func (cm *ContextManager) GetChunkingStrategy() ChunkingStrategy {
 	return cm.strategy
@@ -657,6 +929,69 @@ func (cm *ContextManager) SetChunkOverlap(overlap int) {
 	log.Printf("ContextManager: Chunk overlap set to %d tokens", overlap)
 }
 
+// GetSynthesisMode returns the current synthesis mode.
+func (cm *ContextManager) GetSynthesisMode() SynthesisMode {
+	return cm.synthesisMode
+}
+
+// SetSynthesisMode sets a new synthesis mode.
+func (cm *ContextManager) SetSynthesisMode(mode SynthesisMode) {
+	cm.synthesisMode = mode
+	log.Printf("ContextManager: Synthesis mode set to %s", mode)
+}
+
+// SetReduceInstruction sets the instruction used in map-reduce mode.
+func (cm *ContextManager) SetReduceInstruction(instruction string) {
+	cm.reduceInstruction = instruction
+}
+
+// SetReduceTokenLimit sets the maximum tokens per reduce call.
+func (cm *ContextManager) SetReduceTokenLimit(limit int) {
+	cm.reduceTokenLimit = limit
+	log.Printf("ContextManager: Reduce token limit set to %d tokens", limit)
+}
+
+// SetMaxParallelism sets the maximum number of concurrent chunk calls.
+func (cm *ContextManager) SetMaxParallelism(n int) {
+	cm.maxParallelism = n
+	log.Printf("ContextManager: Max parallelism set to %d", n)
+}
+
+// SetChunkRetries sets how many times a failed chunk is retried.
+func (cm *ContextManager) SetChunkRetries(retries int) {
+	cm.maxChunkRetries = retries
+	log.Printf("ContextManager: Chunk retries set to %d", retries)
+}
+
+// SetChunkFailurePolicy sets what happens when a chunk fails.
+func (cm *ContextManager) SetChunkFailurePolicy(policy ChunkFailurePolicy) {
+	cm.failurePolicy = policy
+	log.Printf("ContextManager: Chunk failure policy set to %s", policy)
+}
+
+// SetEmbedder sets the embedder used by ChunkBySemanticBoundary.
+func (cm *ContextManager) SetEmbedder(embedder database.Embedder) {
+	cm.embedder = embedder
+}
+
+// SetSemanticThreshold sets the similarity threshold for semantic chunking.
+func (cm *ContextManager) SetSemanticThreshold(threshold float64) {
+	cm.semanticThreshold = threshold
+	log.Printf("ContextManager: Semantic threshold set to %.2f", threshold)
+}
+
+// SetMinChunkSize sets the minimum size in tokens of a semantic chunk.
+func (cm *ContextManager) SetMinChunkSize(size int) {
+	cm.minChunkSize = size
+	log.Printf("ContextManager: Minimum semantic chunk size set to %d tokens", size)
+}
+
+// SetSequentialDelay sets the pause after each chunk call in sequential mode.
+func (cm *ContextManager) SetSequentialDelay(delay time.Duration) {
+	cm.sequentialDelay = delay
+	log.Printf("ContextManager: Sequential chunk delay set to %v", delay)
+}
+
 // Deprecated: LLM is now passed during processing.
 // func (cm *ContextManager) GetInferenceService() TextGenerator {
 // 	return cm.inferenceService
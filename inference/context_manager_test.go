@@ -3,9 +3,12 @@ package inference
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // MockTextGenerator implements the TextGenerator interface for testing
@@ -14,7 +17,7 @@ type MockTextGenerator struct {
 }
 
 // GenerateText implements the TextGenerator interface for testing
-func (m *MockTextGenerator) GenerateText(prompt string) (string, error) {
+func (m *MockTextGenerator) GenerateText(ctx context.Context, prompt string) (string, error) {
 	if m.generateFunc != nil {
 		return m.generateFunc(prompt)
 	}
@@ -234,3 +237,259 @@ func TestOverrideMethodsForStrategyAndMode(t *testing.T) {
 		t.Errorf("ProcessingMode was not restored after ProcessLargePromptWithMode, got %v", cm.processingMode)
 	}
 }
+
+func TestProcessLargePromptHonorsCancellation(t *testing.T) {
+	calls := 0
+	mockGenerator := &MockTextGenerator{
+		generateFunc: func(prompt string) (string, error) {
+			calls++
+			return "processed", nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, mode := range []ProcessingMode{SequentialProcessing, ParallelProcessing} {
+		calls = 0
+		cm := NewContextManager(ChunkByParagraph, WithProcessingMode(mode), WithMaxChunkSize(100))
+		_, err := cm.ProcessLargePrompt(ctx, mockGenerator, "First paragraph.\n\nSecond paragraph.", "Summarize")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Mode %v: expected context.Canceled, got %v", mode, err)
+		}
+		if calls != 0 {
+			t.Errorf("Mode %v: expected no generator calls after cancellation, got %d", mode, calls)
+		}
+	}
+}
+
+func TestProcessLargePromptSynthesisModes(t *testing.T) {
+	var mu sync.Mutex
+	var reduceCalls, refineCalls int
+	mockGenerator := &MockTextGenerator{
+		generateFunc: func(prompt string) (string, error) {
+			mu.Lock() // Reduce groups run in parallel
+			defer mu.Unlock()
+			switch {
+			case strings.HasPrefix(prompt, "Combine"):
+				reduceCalls++
+				return "combined", nil
+			case strings.HasPrefix(prompt, "Refine"):
+				refineCalls++
+				return "refined", nil
+			}
+			// Long enough that two outputs exceed the minimum reduce budget
+			return strings.Repeat("mapped ", 40), nil
+		},
+	}
+
+	ctx := context.Background()
+	text := "Chunk 1.\n\nChunk 2.\n\nChunk 3.\n\nChunk 4."
+
+	// Map-reduce with a tiny limit forces more than one reduce level
+	cm := NewContextManager(ChunkByParagraph, WithSynthesisMode(MapReduceSynthesis),
+		WithReduceInstruction("Combine these."), WithReduceTokenLimit(1))
+	result, err := cm.ProcessLargePrompt(ctx, mockGenerator, text, "Process this:")
+	if err != nil {
+		t.Fatalf("Map-reduce returned error: %v", err)
+	}
+	if result != "combined" {
+		t.Errorf("Expected a single combined result, got %q", result)
+	}
+	if reduceCalls != 3 {
+		t.Errorf("Expected 3 reduce calls (two pairs, then the final merge), got %d", reduceCalls)
+	}
+
+	// Refine calls the model once per chunk after the first
+	result, err = cm.ProcessLargePromptWithSynthesis(ctx, text, "Process this:", RefineSynthesis, "", mockGenerator)
+	if err != nil {
+		t.Fatalf("Refine returned error: %v", err)
+	}
+	if result != "refined" || refineCalls != 3 {
+		t.Errorf("Expected refined result after 3 refine calls, got %q after %d", result, refineCalls)
+	}
+
+	// The default mode keeps joining chunk outputs
+	cm = NewContextManager(ChunkByParagraph)
+	result, _ = cm.ProcessLargePrompt(ctx, mockGenerator, text, "Process this:")
+	if strings.Count(result, "---") != 3 {
+		t.Errorf("Expected map-only output to contain all 4 chunk outputs, got %q", result)
+	}
+}
+
+func TestParallelChunkFailurePolicies(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	attempts := map[string]int{}
+	mockGenerator := &MockTextGenerator{
+		generateFunc: func(prompt string) (string, error) {
+			chunk := strings.TrimSpace(strings.Split(prompt, "---")[1])
+			mu.Lock()
+			attempts[chunk]++
+			active++
+			if active > peak {
+				peak = active
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			if chunk == "Chunk 2." || chunk == "Chunk 4." {
+				return "", fmt.Errorf("simulated error for %s", chunk)
+			}
+			return "ok", nil
+		},
+	}
+	text := "Chunk 1.\n\nChunk 2.\n\nChunk 3.\n\nChunk 4.\n\nChunk 5.\n\nChunk 6."
+	ctx := context.Background()
+
+	// Best effort lists every failed chunk and respects the parallelism limit
+	cm := NewContextManager(ChunkByParagraph, WithMaxParallelism(2))
+	result, err := cm.ProcessLargePrompt(ctx, mockGenerator, text, "Process this:")
+	var chunkErrs ChunkErrors
+	if !errors.As(err, &chunkErrs) || len(chunkErrs) != 2 || chunkErrs[0].Chunk != 2 || chunkErrs[1].Chunk != 4 {
+		t.Fatalf("Expected ChunkErrors for chunks 2 and 4, got %v", err)
+	}
+	if strings.Count(result, "ok") != 4 || !strings.Contains(result, "[ERROR PROCESSING CHUNK 4]") {
+		t.Errorf("Expected partial results with placeholders, got: %s", result)
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent calls, got %d", peak)
+	}
+
+	// Fail fast never retries and reports only the chunk that failed
+	attempts = map[string]int{}
+	cm = NewContextManager(ChunkByParagraph, WithMaxParallelism(1), WithChunkRetries(3),
+		WithChunkFailurePolicy(FailFastPolicy))
+	_, err = cm.ProcessLargePrompt(ctx, mockGenerator, text, "Process this:")
+	if !errors.As(err, &chunkErrs) || len(chunkErrs) != 1 || chunkErrs[0].Chunk != 2 {
+		t.Fatalf("Expected a single ChunkError for chunk 2, got %v", err)
+	}
+	if attempts["Chunk 2."] != 1 {
+		t.Errorf("Expected 1 attempt on chunk 2 under fail-fast, got %d", attempts["Chunk 2."])
+	}
+}
+
+func TestStructureAwareChunking(t *testing.T) {
+	markdown := "# Guide\n\n## Install\n\nRun the installer.\n\n```sh\nmake build\n\nmake install\n```\n\n## Usage\n\n- one\n\n- two\n\n| a | b |\n|---|---|\n| 1 | 2 |"
+	cm := NewContextManager(ChunkByMarkdownHeading)
+	chunks := cm.splitIntoSections(context.Background(), markdown)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 Markdown chunks (one per section), got %d: %#v", len(chunks), chunks)
+	}
+	if got := strings.Join(chunks[0].HeadingPath, " > "); got != "Guide > Install" {
+		t.Errorf("Expected heading path 'Guide > Install', got %q", got)
+	}
+	if !strings.Contains(chunks[0].Text, "make build\n\nmake install") {
+		t.Errorf("Expected the code block to stay intact, got %q", chunks[0].Text)
+	}
+	if !strings.Contains(chunks[1].Text, "- one\n\n- two") || !strings.Contains(chunks[1].Text, "| 1 | 2 |") {
+		t.Errorf("Expected list and table in the Usage chunk, got %q", chunks[1].Text)
+	}
+
+	htmlDoc := `<!-- wp:heading --><h2>Pricing</h2><!-- /wp:heading --><p>Intro text.</p>` +
+		`<table><tr><td>Basic</td><td>$5</td></tr></table><div class="wp-block-group"><h3>Notes</h3><pre>a
+
+b</pre></div>`
+	cm = NewContextManager(ChunkByHTMLBlock)
+	chunks = cm.splitIntoSections(context.Background(), htmlDoc)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 HTML chunks, got %d: %#v", len(chunks), chunks)
+	}
+	if !strings.Contains(chunks[0].Text, "<table>") || strings.Contains(chunks[0].Text, "wp:heading") {
+		t.Errorf("Expected the table kept and block comments dropped, got %q", chunks[0].Text)
+	}
+	if got := strings.Join(chunks[1].HeadingPath, " > "); got != "Pricing > Notes" {
+		t.Errorf("Expected heading path 'Pricing > Notes', got %q", got)
+	}
+	if !strings.Contains(chunks[1].prompt("Summarize"), "Section: Pricing > Notes") {
+		t.Errorf("Expected the heading path in the chunk prompt, got %q", chunks[1].prompt("Summarize"))
+	}
+}
+
+// topicEmbedder maps sentences mentioning "cat" or "car" onto orthogonal vectors.
+type topicEmbedder struct{}
+
+func (topicEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if strings.Contains(strings.ToLower(text), "car") {
+		return []float32{0, 1}, nil
+	}
+	return []float32{1, 0}, nil
+}
+func (topicEmbedder) Dimensions() int { return 2 }
+func (topicEmbedder) Name() string    { return "topic" }
+
+func TestSemanticBoundaryChunking(t *testing.T) {
+	text := "Cats sleep a lot. A cat purrs when happy. Cars need fuel. A car has four wheels! Cats chase mice."
+	cm := NewContextManager(ChunkBySemanticBoundary, WithEmbedder(topicEmbedder{}), WithMinChunkSize(0))
+	chunks := cm.splitIntoChunks(text)
+	expected := []string{
+		"Cats sleep a lot. A cat purrs when happy.",
+		"Cars need fuel. A car has four wheels!",
+		"Cats chase mice.",
+	}
+	if len(chunks) != len(expected) {
+		t.Fatalf("Expected %d topic chunks, got %d: %q", len(expected), len(chunks), chunks)
+	}
+	for i := range expected {
+		if chunks[i] != expected[i] {
+			t.Errorf("Chunk %d: expected %q, got %q", i+1, expected[i], chunks[i])
+		}
+	}
+
+	// The size limit still applies within a single topic
+	cm = NewContextManager(ChunkBySemanticBoundary, WithEmbedder(topicEmbedder{}), WithMinChunkSize(0), WithMaxChunkSize(6))
+	if chunks = cm.splitIntoChunks(text); len(chunks) <= len(expected) {
+		t.Errorf("Expected maxChunkSize to split topics further, got %q", chunks)
+	}
+}
+
+// countingBatchEmbedder wraps topicEmbedder and counts the batch calls.
+type countingBatchEmbedder struct {
+	topicEmbedder
+	batches int
+}
+
+func (e *countingBatchEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	e.batches++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i], _ = e.Embed(ctx, text)
+	}
+	return vectors, nil
+}
+
+func TestSemanticChunkingDefaults(t *testing.T) {
+	// A topic change only ends a chunk once it holds the minimum size
+	embedder := &countingBatchEmbedder{}
+	text := "Cats sleep a lot. Cars need fuel. A car has four wheels! Cats chase mice. A cat purrs when happy."
+	minChunkSize := estimateTokens("Cats sleep a lot.", "gpt-4") + 1
+	cm := NewContextManager(ChunkBySemanticBoundary, WithEmbedder(embedder), WithMinChunkSize(minChunkSize))
+	chunks := cm.splitIntoChunks(text)
+	expected := []string{"Cats sleep a lot. Cars need fuel. A car has four wheels!", "Cats chase mice. A cat purrs when happy."}
+	if len(chunks) != len(expected) || chunks[0] != expected[0] || chunks[1] != expected[1] {
+		t.Errorf("Expected %q, got %q", expected, chunks)
+	}
+	if embedder.batches != 1 {
+		t.Errorf("Expected the sentences to be embedded in one batch, got %d calls", embedder.batches)
+	}
+
+	// With the hash embedder and the default settings, prose on two topics is
+	// not split into single sentences
+	var sb strings.Builder
+	for i := 0; i < 12; i++ {
+		sb.WriteString("The solar system has eight planets orbiting the Sun. Jupiter is the largest planet. ")
+		sb.WriteString("To bake bread, knead the dough for ten minutes. Let the dough rise for an hour. ")
+	}
+	cm = NewContextManager(ChunkBySemanticBoundary, WithMaxChunkSize(400))
+	chunks = cm.splitIntoChunks(sb.String())
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if tokens := estimateTokens(chunk, cm.modelName); tokens < defaultSemanticMinChunkSize {
+			t.Errorf("Chunk %d has %d tokens, expected at least %d: %q", i+1, tokens, defaultSemanticMinChunkSize, chunk)
+		}
+	}
+	if len(chunks) < 2 || len(chunks) > 6 {
+		t.Errorf("Expected a few chunks for 48 sentences, got %d", len(chunks))
+	}
+}
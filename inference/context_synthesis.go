@@ -0,0 +1,190 @@
+package inference
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// chunkSeparator joins chunk outputs in map-only mode.
+const chunkSeparator = "\n\n---\n\n"
+
+const defaultReduceInstruction = "Combine the following partial results into a single coherent result. " +
+	"Merge overlapping information, remove repetition and keep every relevant detail. " +
+	"Respond ONLY with the combined result."
+
+const defaultRefineInstruction = "Refine the existing answer using the new section of text below. " +
+	"Keep what is still correct, add any new relevant information and fix anything the new section contradicts. " +
+	"Respond ONLY with the refined answer."
+
+// reducePromptOverhead is a rough token allowance for the labels and task text
+// wrapped around the partial results in each reduce prompt.
+const reducePromptOverhead = 50
+
+// reduceOutputs combines chunk outputs level by level. Each level groups as many
+// outputs as fit within the reduce token limit into one call, so the number of
+// outputs shrinks until a single result remains.
+func (cm *ContextManager) reduceOutputs(ctx context.Context, llm TextGenerator, outputs []string, taskInstruction string, reduceInstruction string) (string, error) {
+	if len(outputs) == 0 {
+		return "", fmt.Errorf("no chunk outputs to reduce")
+	}
+	if reduceInstruction == "" {
+		reduceInstruction = cm.reduceInstruction
+	}
+
+	limit := cm.reduceTokenLimit
+	if limit <= 0 {
+		limit = cm.maxChunkSize
+	}
+	budget := limit - estimateTokens(reduceInstruction, cm.modelName) -
+		estimateTokens(taskInstruction, cm.modelName) - reducePromptOverhead
+	if budget < reducePromptOverhead {
+		budget = reducePromptOverhead
+	}
+
+	for level := 1; len(outputs) > 1; level++ {
+		groups := cm.groupForReduce(outputs, budget)
+		log.Printf("ContextManager: Reduce level %d combining %d outputs in %d calls...", level, len(outputs), len(groups))
+
+		reduced, err := cm.runReduceLevel(ctx, llm, groups, taskInstruction, reduceInstruction)
+		if err != nil {
+			// Hand back what the previous level produced so callers keep partial work
+			return strings.Join(outputs, chunkSeparator), fmt.Errorf("reduce level %d failed: %w", level, err)
+		}
+		outputs = reduced
+	}
+
+	log.Println("ContextManager: Finished reducing chunk outputs.")
+	return outputs[0], nil
+}
+
+// groupForReduce packs consecutive outputs into groups whose combined size stays
+// within budget. Every group holds at least two outputs so each level makes
+// progress even when single outputs exceed the budget.
+func (cm *ContextManager) groupForReduce(outputs []string, budget int) [][]string {
+	var groups [][]string
+	var current []string
+	currentTokens := 0
+
+	for _, output := range outputs {
+		tokens := estimateTokens(output, cm.modelName)
+		if len(current) >= 2 && currentTokens+tokens > budget {
+			groups = append(groups, current)
+			current, currentTokens = nil, 0
+		}
+		current = append(current, output)
+		currentTokens += tokens
+	}
+	if len(current) == 1 && len(groups) > 0 {
+		// A lone trailing output would pass through unchanged; fold it into the
+		// previous group instead.
+		last := len(groups) - 1
+		groups[last] = append(groups[last], current[0])
+	} else if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups
+}
+
+// runReduceLevel sends one reduce call per group and returns the results in order.
+// Calls run in parallel unless the manager is in sequential mode.
+func (cm *ContextManager) runReduceLevel(ctx context.Context, llm TextGenerator, groups [][]string, taskInstruction string, reduceInstruction string) ([]string, error) {
+	results := make([]string, len(groups))
+	reduceGroup := func(index int, group []string) error {
+		if len(group) == 1 {
+			results[index] = group[0]
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("group %d not reduced: %w", index+1, err)
+		}
+		result, err := llm.GenerateText(ctx, buildReducePrompt(reduceInstruction, taskInstruction, group))
+		if err != nil {
+			return fmt.Errorf("error reducing group %d: %w", index+1, err)
+		}
+		results[index] = result
+		return nil
+	}
+
+	if cm.processingMode == SequentialProcessing {
+		for i, group := range groups {
+			if err := reduceGroup(i, group); err != nil {
+				return nil, err
+			}
+		}
+		return results, nil
+	}
+
+	var wg sync.WaitGroup
+	var firstErr error
+	var errMutex sync.Mutex
+	for i, group := range groups {
+		wg.Add(1)
+		go func(index int, group []string) {
+			defer wg.Done()
+			if err := reduceGroup(index, group); err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMutex.Unlock()
+			}
+		}(i, group)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// buildReducePrompt labels each partial result so the model can tell them apart.
+func buildReducePrompt(reduceInstruction string, taskInstruction string, partials []string) string {
+	var sb strings.Builder
+	sb.WriteString(reduceInstruction)
+	if taskInstruction != "" {
+		sb.WriteString("\n\nOriginal task for each part: ")
+		sb.WriteString(taskInstruction)
+	}
+	for i, partial := range partials {
+		fmt.Fprintf(&sb, "\n\nPartial result %d:\n---\n%s\n---", i+1, partial)
+	}
+	return sb.String()
+}
+
+// processWithRefine answers the instruction for the first chunk, then feeds the
+// running answer together with each following chunk back to the model. On error
+// it returns the answer built so far.
+func (cm *ContextManager) processWithRefine(ctx context.Context, llm TextGenerator, chunks []textChunk, instructionPerChunk string, refineInstruction string) (string, error) {
+	if refineInstruction == "" {
+		refineInstruction = cm.refineInstruction
+	}
+
+	answer := ""
+	for i, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return answer, fmt.Errorf("refinement stopped before chunk %d: %w", i+1, err)
+		}
+		log.Printf("ContextManager: Refining with chunk %d/%d...", i+1, len(chunks))
+
+		var prompt string
+		if i == 0 {
+			prompt = chunk.prompt(instructionPerChunk)
+		} else {
+			prompt = fmt.Sprintf("%s\n\nOriginal task: %s\n\nExisting answer:\n%s\n\nNew section:\n%s",
+				refineInstruction, instructionPerChunk, answer, chunk.prompt(""))
+		}
+
+		result, err := llm.GenerateText(ctx, prompt)
+		if err != nil {
+			return answer, fmt.Errorf("error refining with chunk %d: %w", i+1, err)
+		}
+		answer = result
+	}
+
+	log.Println("ContextManager: Finished refining across all chunks.")
+	return answer, nil
+}
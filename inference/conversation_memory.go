@@ -20,6 +20,14 @@ type ConversationMemory interface {
 	GetHistory() []gollm_types.MemoryMessage
 }
 
+// PagedMemory is implemented by memories that can page through their history
+// without materializing all of it.
+type PagedMemory interface {
+	// GetHistoryPage returns up to limit messages starting at offset, in
+	// chronological order, together with the total number of messages.
+	GetHistoryPage(offset, limit int) ([]gollm_types.MemoryMessage, int, error)
+}
+
 // SimpleWindowMemory implements ConversationMemory using a simple sliding window
 // based on estimated token count.
 type SimpleWindowMemory struct {
@@ -93,5 +101,31 @@ func (m *SimpleWindowMemory) GetHistory() []gollm_types.MemoryMessage {
 	return historyCopy
 }
 
+// GetHistoryPage returns a page of the history in chronological order.
+func (m *SimpleWindowMemory) GetHistoryPage(offset, limit int) ([]gollm_types.MemoryMessage, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageMessages(m.messages, offset, limit), len(m.messages), nil
+}
+
+// pageMessages copies the requested page out of messages. A limit of 0 or
+// less returns everything from offset onwards.
+func pageMessages(messages []gollm_types.MemoryMessage, offset, limit int) []gollm_types.MemoryMessage {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(messages) {
+		return []gollm_types.MemoryMessage{}
+	}
+	end := len(messages)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	page := make([]gollm_types.MemoryMessage, end-offset)
+	copy(page, messages[offset:end])
+	return page
+}
+
 // Compile-time check to ensure SimpleWindowMemory implements ConversationMemory
 var _ ConversationMemory = (*SimpleWindowMemory)(nil)
+var _ PagedMemory = (*SimpleWindowMemory)(nil)
@@ -4,7 +4,6 @@ package inference
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"sync"
@@ -270,15 +269,9 @@ func (p *DeepseekProvider) PrepareRequestWithSchema(prompt string, options map[s
 		return nil, errors.New("internal error: PrepareRequestWithSchema called but SupportsJSONSchema is false")
 	}
 
-	var schemaPtr *jsonschema.Schema
-	if s, ok := schema.(*jsonschema.Schema); ok {
-		schemaPtr = s
-	} else {
-		reflector := jsonschema.Reflector{}
-		schemaPtr = reflector.Reflect(schema)
-		if schemaPtr == nil {
-			return nil, fmt.Errorf("failed to reflect jsonschema from provided schema type: %T", schema)
-		}
+	schemaPtr, err := toJSONSchema(schema)
+	if err != nil {
+		return nil, err
 	}
 
 	if options == nil {
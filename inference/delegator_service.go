@@ -3,10 +3,13 @@ package inference
 
 import (
 	"context"
+	"encoding/json"
 	"errors" // Import the errors package
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	gollm "github.com/guiperry/gollm_cerebras"
 	"github.com/pkoukk/tiktoken-go"
@@ -20,20 +23,36 @@ import (
 // DelegatorService handles request delegation between a primary (proxy)
 // and a secondary (base) LLM, including fallback logic and MOA orchestration.
 type DelegatorService struct {
-	primaryAttempts  []LLMAttempt       // Ordered list of primary LLMs to try
-	fallbackAttempts []LLMAttempt       // Ordered list of fallback LLMs to try
-	memory           ConversationMemory // Manages conversation history
-	contextManager   *ContextManager    // ADDED: Reference to context manager
+	primaryAttempts  []LLMAttempt    // Ordered list of primary LLMs to try
+	fallbackAttempts []LLMAttempt    // Ordered list of fallback LLMs to try
+	sessions         *SessionManager // Per-session conversation histories
+	contextManager   *ContextManager // ADDED: Reference to context manager
 
 	// Configuration for delegation logic
 	tokenLimitThreshold  int        // Token limit to decide initial routing
 	tokenLimitCheckModel string     // Model name used for token estimation against the limit
 	moa                  *gollm.MOA // MOA instance
+
+	semanticCache *SemanticCache // Optional near-duplicate response cache
+	pii           *PIIGuardrail  // Redacts prompts the attempts' LLMs do not see (MOA, multimodal)
+
+	structuredOutputRepairs int // Re-prompts allowed for structured output failing validation
+
+	hedgeDelay time.Duration // Delay before a hedged second attempt (0 = hedging off)
+
+	settingsMutex sync.RWMutex // Guards semanticCache, pii, structuredOutputRepairs and hedgeDelay, which change while requests run
+}
+
+// GenerationResult carries a generated response together with metadata
+// describing how it was produced (cache hits, similarity scores, etc.).
+type GenerationResult struct {
+	Content  string                 `json:"content"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // NewDelegatorService creates a new delegator instance.
 // It requires lists of initialized LLM attempts, an optional MOA instance, and a ContextManager.
-func NewDelegatorService(primaryAttempts []LLMAttempt, fallbackAttempts []LLMAttempt, tokenLimit int, tokenModel string, moaInstance *gollm.MOA, ctxManager *ContextManager) *DelegatorService {
+func NewDelegatorService(primaryAttempts []LLMAttempt, fallbackAttempts []LLMAttempt, tokenLimit int, tokenModel string, moaInstance *gollm.MOA, ctxManager *ContextManager, sessions *SessionManager) *DelegatorService {
 	if len(primaryAttempts) == 0 || len(fallbackAttempts) == 0 {
 		log.Println("CRITICAL: NewDelegatorService called with empty primary or fallback attempts")
 		return nil
@@ -44,14 +63,20 @@ func NewDelegatorService(primaryAttempts []LLMAttempt, fallbackAttempts []LLMAtt
 	if ctxManager == nil {
 		log.Println("[WARN] NewDelegatorService: ContextManager instance is nil. Chunking fallback will be disabled.")
 	}
+	if sessions == nil {
+		sessions = NewSessionManager(nil)
+	}
 	return &DelegatorService{
 		primaryAttempts:      primaryAttempts,
 		fallbackAttempts:     fallbackAttempts,
 		moa:                  moaInstance,
-		contextManager:       ctxManager,                        // Store context manager
-		memory:               NewSimpleWindowMemory(tokenModel), // Use tokenModel here
-		tokenLimitThreshold:  tokenLimit,                        // Use correct field name and passed value
-		tokenLimitCheckModel: tokenModel,                        // ADDED: Store the model name for token checking
+		contextManager:       ctxManager, // Store context manager
+		sessions:             sessions,   // Conversation memory is kept per session
+		tokenLimitThreshold:  tokenLimit, // Use correct field name and passed value
+		tokenLimitCheckModel: tokenModel, // ADDED: Store the model name for token checking
+
+		structuredOutputRepairs: structuredOutputRepairsFromEnv(),
+		hedgeDelay:              hedgeDelayFromEnv(),
 	}
 }
 
@@ -117,6 +142,16 @@ func formatMessagesToPrompt(messages []gollm_types.MemoryMessage) string {
 	return strings.TrimSuffix(builder.String(), "\n")
 }
 
+// isContextLengthError reports whether err says the prompt exceeded the
+// model's context window, which chunking can work around.
+func isContextLengthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := strings.ToLower(err.Error())
+	return strings.Contains(errStr, "context_length_exceeded") || strings.Contains(errStr, "token limit") || strings.Contains(errStr, "prompt is too long")
+}
+
 // shouldRetryWithError determines if the given error warrants a fallback attempt to the base LLM.
 // Customize this logic based on the errors observed from the primary LLM (Cerebras).
 func (d *DelegatorService) shouldFallbackOnError(err error) bool {
@@ -127,7 +162,7 @@ func (d *DelegatorService) shouldFallbackOnError(err error) bool {
 	log.Printf("DelegatorService: Evaluating error for fallback: %s", errStr)
 
 	// Allow Fallback on context length exceeded
-	if strings.Contains(errStr, "context_length_exceeded") || strings.Contains(errStr, "token limit") {
+	if isContextLengthError(err) {
 		log.Println("DelegatorService: Decision: Allowing Fallback (Context Length Exceeded)")
 		return true
 	}
@@ -138,8 +173,10 @@ func (d *DelegatorService) shouldFallbackOnError(err error) bool {
 	//     return true
 	// }
 
-	// Allow fallback for common transient errors (e.g., 5xx status codes, timeouts)
-	if strings.Contains(errStr, "status code 5") || strings.Contains(errStr, "timeout") || strings.Contains(errStr, "connection refused") {
+	// Allow fallback for common transient errors (e.g., 5xx status codes such as
+	// Anthropic's 529 overloaded, rate limits, timeouts)
+	if strings.Contains(errStr, "status code 5") || strings.Contains(errStr, "status code 429") || strings.Contains(errStr, "overloaded") ||
+		strings.Contains(errStr, "timeout") || strings.Contains(errStr, "connection refused") {
 		log.Println("DelegatorService: Decision: Allowing Fallback (Transient Error)")
 		return true
 	}
@@ -153,7 +190,7 @@ func (d *DelegatorService) shouldFallbackOnError(err error) bool {
 }
 
 // executeGenerationWithRetry attempts generation using a sequence of LLMs, handling retries and fallbacks.
-func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, modelName string, messages []gollm_types.MemoryMessage, instructionText string, operationName string) (string, error) {
+func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, memory ConversationMemory, modelName string, messages []gollm_types.MemoryMessage, instructionText string, operationName string) (string, error) {
 	if len(d.primaryAttempts) == 0 || len(d.fallbackAttempts) == 0 {
 		return "", fmt.Errorf("delegator service (%s): not properly configured", operationName)
 	}
@@ -182,7 +219,7 @@ func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, model
 		chunkedResponse, chunkErr := d.contextManager.ProcessLargePrompt(ctx, wrappedLLM, fullPromptForChunking, chunkInstruction)
 		if chunkErr == nil {
 			log.Printf("DelegatorService (%s): PROACTIVE ContextManager chunking successful.", operationName)
-			d.memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: chunkedResponse})
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: chunkedResponse})
 			return chunkedResponse, nil // Return successful chunked response
 		}
 		log.Printf("DelegatorService (%s): PROACTIVE ContextManager chunking failed: %v. Proceeding to standard attempt logic (will likely fail again or trigger reactive chunking).", operationName, chunkErr)
@@ -214,10 +251,32 @@ func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, model
 	promptString := formatMessagesToPrompt(messages)
 	_ = llm.NewPrompt(promptString) // gollm expects a Prompt object, assign to blank identifier if not used directly
 
+	// --- Incorporate Instruction Text ---
+	finalPromptStringForLLM := promptString
+	if instructionText != "" {
+		finalPromptStringForLLM = "Instructions:\n" + instructionText + "\n\n---\n\n" + promptString
+	}
+
 	var lastError error
 	currentAttemptList := attemptsToTry
 
-	for listNum := 0; listNum < 2; listNum++ { // Max 2 lists: primary then fallback (or just fallback)
+	// Hedged mode races the attempts instead of trying them one after another
+	hedgeDelay := d.hedgeDelayFor(ctx)
+	hedged := hedgeDelay > 0 && !specificModelRequested
+	if hedged {
+		hedgeAttempts := append(append([]LLMAttempt{}, d.primaryAttempts...), d.fallbackAttempts...)
+		responseContent, err := d.generateHedged(ctx, operationName, finalPromptStringForLLM, hedgeAttempts, hedgeDelay)
+		if err == nil {
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: responseContent})
+			return responseContent, nil
+		}
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s stopped: %w", operationName, ctx.Err())
+		}
+		lastError = err // Every attempt has been tried; go straight to the final fallback
+	}
+
+	for listNum := 0; listNum < 2 && !hedged; listNum++ { // Max 2 lists: primary then fallback (or just fallback)
 		if specificModelRequested && listNum > 0 { // If specific model was requested, only try that list (which is `attemptsToTry`)
 			break
 		}
@@ -240,31 +299,33 @@ func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, model
 		}
 
 		for i, attempt := range currentAttemptList {
+			if err := ctx.Err(); err != nil {
+				// Deadline exceeded or request cancelled: further attempts would fail too
+				log.Printf("DelegatorService (%s): Stopping before next attempt: %v", operationName, err)
+				return "", fmt.Errorf("%s stopped: %w", operationName, err)
+			}
 			targetName := fmt.Sprintf("%s Attempt %d/%d (Model: %s)", listName, i+1, len(currentAttemptList), attempt.Config.ModelName)
 			log.Printf("DelegatorService (%s): Trying %s", operationName, targetName)
 
-			// --- Incorporate Instruction Text ---
-			finalPromptStringForLLM := promptString
-			if instructionText != "" {
-				finalPromptStringForLLM = "Instructions:\n" + instructionText + "\n\n---\n\n" + promptString
-			}
 			finalPromptForLLM := llm.NewPrompt(finalPromptStringForLLM)
 			responseContent, err := attempt.Instance.Generate(ctx, finalPromptForLLM)
 
 			if err == nil {
 				log.Printf("DelegatorService (%s): Generation successful with %s.", operationName, targetName)
-				d.memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: responseContent})
+				memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: responseContent})
 				return responseContent, nil // Success!
 			}
 
 			// Attempt failed
 			log.Printf("DelegatorService (%s): Attempt with %s failed: %v", operationName, targetName, err)
 			lastError = err // Store the error
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("%s stopped: %w", operationName, ctx.Err())
+			}
 
 			// Decide if we should continue to the next attempt in *this* list
 			// --- ADDED: Reactive Chunking on Context Error ---
-			errStr := err.Error()
-			isContextError := strings.Contains(errStr, "context_length_exceeded") || strings.Contains(errStr, "token limit")
+			isContextError := isContextLengthError(err)
 
 			if isContextError && d.contextManager != nil {
 				log.Printf("DelegatorService (%s): Attempt with %s failed with context limit. Attempting REACTIVE chunking with ContextManager using the same LLM...", operationName, targetName)
@@ -281,7 +342,7 @@ func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, model
 					chunkedResponse, chunkErr := d.contextManager.ProcessLargePrompt(ctx, wrappedLLM, fullPromptForChunking, chunkInstruction)
 					if chunkErr == nil {
 						log.Printf("DelegatorService (%s): REACTIVE ContextManager chunking successful with %s.", operationName, targetName)
-						d.memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: chunkedResponse})
+						memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: chunkedResponse})
 						return chunkedResponse, nil // Return successful chunked response
 					}
 					log.Printf("DelegatorService (%s): REACTIVE ContextManager chunking with %s failed: %v. Proceeding to next attempt.", operationName, targetName, chunkErr)
@@ -313,8 +374,7 @@ func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, model
 	// Check if the last error suggests a context length issue and if context manager exists
 	// This block now acts as a fallback if the *immediate* chunking attempt (for Cerebras) failed,
 	// or if a fallback LLM (like Gemini) failed with a context error.
-	errStr := lastError.Error()
-	isContextError := strings.Contains(errStr, "context_length_exceeded") || strings.Contains(errStr, "token limit")
+	isContextError := isContextLengthError(lastError)
 
 	if isContextError && d.contextManager != nil {
 		log.Printf("DelegatorService (%s): All attempts failed, last error indicates context limit. Attempting FINAL chunking fallback with ContextManager...", operationName)
@@ -360,7 +420,7 @@ func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, model
 			if chunkErr == nil {
 				log.Printf("DelegatorService (%s): FINAL ContextManager chunking fallback successful.", operationName)
 				// Add the potentially long, combined response to memory
-				d.memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: chunkedResponse})
+				memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: chunkedResponse})
 				return chunkedResponse, nil // Return successful chunked response
 			}
 			log.Printf("DelegatorService (%s): FINAL ContextManager chunking fallback failed: %v", operationName, chunkErr)
@@ -378,12 +438,57 @@ func (d *DelegatorService) executeGenerationWithRetry(ctx context.Context, model
 // --- Generation Methods ---
 
 // GenerateSimple uses standard delegation/fallback ONLY.
-// It now uses the conversation memory.
-func (d *DelegatorService) GenerateSimple(ctx context.Context, modelName string, promptText string, instructionText string) (string, error) {
+// It uses the conversation memory of the given session.
+func (d *DelegatorService) GenerateSimple(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string) (string, error) {
+	result, err := d.GenerateSimpleWithMetadata(ctx, sessionID, modelName, promptText, instructionText)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// GenerateSimpleWithMetadata behaves like GenerateSimple but also reports how the
+// response was produced. When a semantic cache is configured, a sufficiently
+// similar earlier prompt short-circuits generation and its answer is returned
+// along with the similarity score. Requests in a session never use the cache,
+// since their answers depend on the conversation and must not reach other callers.
+func (d *DelegatorService) GenerateSimpleWithMetadata(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string) (*GenerationResult, error) {
+	memory, err := d.sessions.Memory(ctx, sessionID, d.tokenLimitCheckModel)
+	if err != nil {
+		return nil, err
+	}
+	if modelName == "" && sessionID != "" {
+		// Fall back to the model the session was created with
+		if session, err := d.sessions.GetSession(ctx, sessionID); err == nil {
+			modelName = session.Model
+		}
+	}
+
 	userMessage := gollm_types.MemoryMessage{Role: "user", Content: promptText} // Instruction is handled separately
 
 	// Add user prompt to memory
-	d.memory.AddMessage(userMessage)
+	memory.AddMessage(userMessage)
+
+	metadata := make(map[string]interface{})
+	cache := d.currentSemanticCache()
+	useCache := cache != nil && sessionID == "" && !semanticCacheBypassed(ctx)
+	if useCache {
+		hit, err := cache.Lookup(ctx, modelName, promptText, instructionText)
+		if err != nil {
+			log.Printf("DelegatorService (Simple): Semantic cache lookup failed: %v. Continuing without cache.", err)
+		} else if hit != nil {
+			log.Printf("DelegatorService (Simple): Serving response from semantic cache (similarity %.3f).", hit.Similarity)
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: hit.Response})
+			metadata["cache"] = "hit"
+			metadata["cache_similarity"] = hit.Similarity
+			metadata["cache_threshold"] = cache.Threshold()
+			if hit.SourcePrompt != "" {
+				metadata["cache_source_prompt"] = hit.SourcePrompt
+			}
+			return &GenerationResult{Content: hit.Response, Metadata: metadata}, nil
+		}
+		metadata["cache"] = "miss"
+	}
 
 	var messagesForContext []gollm_types.MemoryMessage
 
@@ -401,7 +506,7 @@ func (d *DelegatorService) GenerateSimple(ctx context.Context, modelName string,
 		messagesForContext = []gollm_types.MemoryMessage{userMessage}
 	} else {
 		// Otherwise, try to get history including the current message, respecting the limit
-		messagesForContext = d.memory.GetMessagesForContext(d.tokenLimitThreshold, tokenCheckModelForContext)
+		messagesForContext = memory.GetMessagesForContext(d.tokenLimitThreshold, tokenCheckModelForContext)
 		if len(messagesForContext) == 0 {
 			// This should ideally not happen if currentMessageTokens <= proxyTokenLimit, but handle defensively
 			log.Printf("DelegatorService (Simple): Warning - GetMessagesForContext returned empty despite current prompt fitting. Sending only current prompt.")
@@ -411,24 +516,39 @@ func (d *DelegatorService) GenerateSimple(ctx context.Context, modelName string,
 	}
 
 	// MOA is NOT used for simple generation in this design
-	return d.executeGenerationWithRetry(ctx, modelName, messagesForContext, instructionText, "Simple")
+	response, err := d.executeGenerationWithRetry(ctx, memory, modelName, messagesForContext, instructionText, "Simple")
+	if err != nil {
+		return nil, err
+	}
+
+	if useCache {
+		if err := cache.Store(ctx, modelName, promptText, instructionText, response); err != nil {
+			log.Printf("DelegatorService (Simple): Failed to store response in semantic cache: %v", err)
+		}
+	}
+	return &GenerationResult{Content: response, Metadata: metadata}, nil
 }
 
 // GenerateWithCoT uses MOA if available, otherwise standard fallback.
 // It now uses the conversation memory for the fallback path.
-func (d *DelegatorService) GenerateWithCoT(ctx context.Context, promptText string) (string, error) {
+func (d *DelegatorService) GenerateWithCoT(ctx context.Context, sessionID string, promptText string) (string, error) {
+	memory, err := d.sessions.Memory(ctx, sessionID, d.tokenLimitCheckModel)
+	if err != nil {
+		return "", err
+	}
+
 	// Construct CoT prompt
 	cotPromptText := fmt.Sprintf("Think step-by-step to answer the following question:\n%s\n\nReasoning steps:", promptText)
 
 	// --- Add user prompt to memory (even if MOA is used first) ---
 	// We add the *original* prompt, not the CoT-enhanced one, to keep history clean.
 	// The CoT enhancement is specific to this generation attempt.
-	d.memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
 
 	// --- Use MOA if available ---
 	if d.moa != nil {
 		log.Println("DelegatorService (CoT): Using MOA for generation...")
-		response, err := d.moa.Generate(ctx, cotPromptText)
+		response, err := d.generateWithMOA(ctx, cotPromptText)
 		if err != nil {
 			log.Printf("DelegatorService (CoT): MOA generation failed: %v", err)
 			// Optionally, could fall back AGAIN to executeGenerationWithFallback here?
@@ -437,7 +557,7 @@ func (d *DelegatorService) GenerateWithCoT(ctx context.Context, promptText strin
 			// Fall through to standard execution if MOA fails
 		} else {
 			// Add successful MOA response to memory
-			d.memory.AddMessage(gollm_types.MemoryMessage{
+			memory.AddMessage(gollm_types.MemoryMessage{
 				Role:    "assistant",
 				Content: response,
 			})
@@ -462,7 +582,7 @@ func (d *DelegatorService) GenerateWithCoT(ctx context.Context, promptText strin
 	cotMessage := gollm_types.MemoryMessage{Role: "user", Content: cotPromptText}
 	// We pass only this message for the CoT attempt, ignoring history for this specific fallback.
 	// This assumes CoT doesn't need prior context from memory for this step.
-	fullResponse, err := d.executeGenerationWithRetry(ctx, "", []gollm_types.MemoryMessage{cotMessage}, "", "CoT-Fallback") // No specific model, no instruction for this internal step
+	fullResponse, err := d.executeGenerationWithRetry(ctx, memory, "", []gollm_types.MemoryMessage{cotMessage}, "", "CoT-Fallback") // No specific model, no instruction for this internal step
 	if err != nil {
 		return "", err // Error already includes context from helper
 	}
@@ -473,19 +593,23 @@ func (d *DelegatorService) GenerateWithCoT(ctx context.Context, promptText strin
 
 // GenerateWithReflection uses MOA if available for each step, otherwise standard fallback.
 // It now uses the conversation memory for the fallback paths.
-func (d *DelegatorService) GenerateWithReflection(ctx context.Context, promptText string) (string, error) {
+func (d *DelegatorService) GenerateWithReflection(ctx context.Context, sessionID string, promptText string) (string, error) {
 	log.Println("DelegatorService: GenerateWithReflection - Starting initial generation step")
 
+	memory, err := d.sessions.Memory(ctx, sessionID, d.tokenLimitCheckModel)
+	if err != nil {
+		return "", err
+	}
+
 	// --- Step 1: Initial Response Generation (Use MOA if available) ---
 	var initialResponse string
-	var err error
 	if d.moa != nil {
 		// Add user prompt to memory before MOA attempt
 		// We add the original prompt here.
-		d.memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
 
 		log.Println("DelegatorService (Reflection-Initial): Using MOA...")
-		initialResponse, err = d.moa.Generate(ctx, promptText)
+		initialResponse, err = d.generateWithMOA(ctx, promptText)
 		if err != nil {
 			log.Printf("DelegatorService (Reflection-Initial): MOA failed: %v. Falling back...", err)
 			// Fall through to standard execution if MOA fails
@@ -496,23 +620,23 @@ func (d *DelegatorService) GenerateWithReflection(ctx context.Context, promptTex
 	if initialResponse == "" {
 		// If MOA wasn't used, add user prompt to memory now
 		if d.moa == nil {
-			d.memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
 		}
 
 		log.Println("DelegatorService (Reflection-Initial): Using standard generation...")
 		// Get messages for context
-		messagesForContext := d.memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel) // Use default check model
+		messagesForContext := memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel) // Use default check model
 		if len(messagesForContext) == 0 {
 			return "", fmt.Errorf("reflection initial generation: No messages fit context window")
 		}
-		initialResponse, err = d.executeGenerationWithRetry(ctx, "", messagesForContext, "", "Reflection-Initial") // No specific model, no instruction
+		initialResponse, err = d.executeGenerationWithRetry(ctx, memory, "", messagesForContext, "", "Reflection-Initial") // No specific model, no instruction
 	}
 
 	// Handle final error from Step 1
 	if err != nil {
 		return "", fmt.Errorf("reflection initial generation failed: %w", err)
 	} else if d.moa != nil && initialResponse != "" { // If MOA succeeded
-		d.memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: initialResponse})
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: initialResponse})
 	}
 	log.Println("DelegatorService: GenerateWithReflection - Initial generation successful")
 
@@ -525,10 +649,10 @@ func (d *DelegatorService) GenerateWithReflection(ctx context.Context, promptTex
 	if d.moa != nil {
 		// Add the reflection prompt "user" message to memory before MOA attempt
 		// This makes the reflection step part of the history.
-		d.memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: reflectionPromptText})
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: reflectionPromptText})
 
 		log.Println("DelegatorService (Reflection-Reflect): Using MOA...")
-		finalResponse, err = d.moa.Generate(ctx, reflectionPromptText)
+		finalResponse, err = d.generateWithMOA(ctx, reflectionPromptText)
 		if err != nil {
 			log.Printf("DelegatorService (Reflection-Reflect): MOA failed: %v. Falling back...", err)
 			// Fall through to standard execution if MOA fails
@@ -539,69 +663,82 @@ func (d *DelegatorService) GenerateWithReflection(ctx context.Context, promptTex
 	if finalResponse == "" {
 		// If MOA wasn't used, add reflection prompt to memory now
 		if d.moa == nil {
-			d.memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: reflectionPromptText})
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: reflectionPromptText})
 		}
 
 		log.Println("DelegatorService (Reflection-Reflect): Using standard generation...")
 		// Get messages for context (including the reflection prompt)
-		messagesForContext := d.memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel) // Use default check model
+		messagesForContext := memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel) // Use default check model
 		if len(messagesForContext) == 0 {
 			return "", fmt.Errorf("reflection refinement generation: No messages fit context window")
 		}
-		finalResponse, err = d.executeGenerationWithRetry(ctx, "", messagesForContext, "", "Reflection-Reflect") // No specific model, no instruction
+		finalResponse, err = d.executeGenerationWithRetry(ctx, memory, "", messagesForContext, "", "Reflection-Reflect") // No specific model, no instruction
 	}
 
 	// Handle final error from Step 3
 	if err != nil {
 		return "", fmt.Errorf("reflection refinement generation failed: %w", err)
 	} else if d.moa != nil && finalResponse != "" { // If MOA succeeded
-		d.memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: finalResponse})
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: finalResponse})
 	}
 	log.Println("DelegatorService: GenerateWithReflection - Reflection generation successful")
 
 	return finalResponse, nil
 }
 
-// GenerateStructuredOutput uses MOA if available, otherwise standard fallback.
+// GenerateStructuredOutput uses the native JSON schema mode of the configured
+// providers when available, then MOA if available, otherwise standard fallback.
 // It now uses the conversation memory for the fallback path.
-func (d *DelegatorService) GenerateStructuredOutput(ctx context.Context, content string, schema string) (string, error) {
+// The JSON is extracted from the response (dropping markdown fences and
+// surrounding text) and validated against the schema. Invalid responses are
+// sent back to the model with the validation errors, up to the configured
+// number of repairs; if the response is still invalid a *SchemaValidationError
+// is returned.
+func (d *DelegatorService) GenerateStructuredOutput(ctx context.Context, sessionID string, content string, schema string) (string, error) {
 	log.Println("DelegatorService: GenerateStructuredOutput - Starting generation")
 
+	schemaDoc, err := parseSchema(schema)
+	if err != nil {
+		return "", err
+	}
+
+	memory, err := d.sessions.Memory(ctx, sessionID, d.tokenLimitCheckModel)
+	if err != nil {
+		return "", err
+	}
+
 	// --- Step 1: Construct Structured Prompt ---
 	structuredPromptText := fmt.Sprintf("Analyze the following content:\n\n---\n%s\n---\n\nPlease extract the relevant information and respond ONLY with a valid JSON object strictly adhering to the following JSON schema:\n```json\n%s\n```", content, schema)
 
 	// --- Add user prompt to memory ---
 	// We add the structured prompt text itself as the user message.
 	// Alternatively, could store original content/schema and reconstruct if needed.
-	d.memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: structuredPromptText})
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: structuredPromptText})
 
-	// --- Step 2: Generate Structured Response (Use MOA if available) ---
+	// --- Step 2: Generate Structured Response (Native schema mode, then MOA if available) ---
 	var response string
-	var err error
+	if nativeResponse, ok := d.generateNativeStructured(ctx, memory, schemaDoc); ok {
+		response = nativeResponse
+	}
 
 	// --- Use MOA if available ---
-	if d.moa != nil {
+	if response == "" && d.moa != nil {
 		log.Println("DelegatorService (StructuredOutput): Using MOA...")
-		response, err = d.moa.Generate(ctx, structuredPromptText)
+		response, err = d.generateWithMOA(ctx, structuredPromptText)
 		if err != nil {
 			log.Printf("DelegatorService (StructuredOutput): MOA failed: %v. Falling back...", err)
 			// Fall through to standard execution if MOA fails
 		}
 		// If MOA succeeded, add response to memory
 		if err == nil {
-			d.memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: response})
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: response})
 		}
 	}
 
 	// If MOA not used or failed, use standard fallback
 	if response == "" {
 		log.Println("DelegatorService (StructuredOutput): Using standard generation...")
-		// Get messages for context (including the structured prompt)
-		messagesForContext := d.memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel) // Use default check model
-		if len(messagesForContext) == 0 {
-			return "", fmt.Errorf("structured output generation: No messages fit context window")
-		}
-		response, err = d.executeGenerationWithRetry(ctx, "", messagesForContext, "", "StructuredOutput") // No specific model, no instruction
+		response, err = d.generateFromMemory(ctx, memory, "StructuredOutput")
 		// Note: response is added to memory inside executeGenerationWithFallback on success
 	}
 
@@ -612,10 +749,53 @@ func (d *DelegatorService) GenerateStructuredOutput(ctx context.Context, content
 		return "", fmt.Errorf("structured output generation failed: %w", err)
 	}
 
-	log.Println("DelegatorService: GenerateStructuredOutput - Generation successful (validation may still be needed)")
-	// TODO: Add JSON validation logic here if needed
+	// --- Step 3: Validate, re-prompting with the errors if needed ---
+	maxRepairs := d.currentStructuredOutputRepairs()
+	for attempt := 1; ; attempt++ {
+		jsonText, validationErrors := validateStructuredResponse(response, schemaDoc)
+		if len(validationErrors) == 0 {
+			log.Printf("DelegatorService: GenerateStructuredOutput - Valid response after %d attempt(s)", attempt)
+			return jsonText, nil
+		}
+		log.Printf("DelegatorService (StructuredOutput): Attempt %d failed validation with %d error(s).", attempt, len(validationErrors))
+		if attempt > maxRepairs {
+			return "", &SchemaValidationError{Errors: validationErrors, Response: response, Attempts: attempt}
+		}
 
-	return response, nil
+		repairPromptText := fmt.Sprintf("Your previous response does not match the JSON schema:\n- %s\n\nRespond ONLY with the corrected JSON object strictly adhering to the schema.", strings.Join(validationErrors, "\n- "))
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: repairPromptText})
+		response, err = d.generateFromMemory(ctx, memory, "StructuredOutputRepair")
+		if err != nil {
+			return "", fmt.Errorf("structured output repair failed: %w", err)
+		}
+	}
+}
+
+// GenerateStructuredInto generates structured output for a Go value: the
+// schema is derived from target (a pointer, usually to a struct) and the
+// validated response is unmarshalled into it. The JSON is returned as well.
+func (d *DelegatorService) GenerateStructuredInto(ctx context.Context, sessionID string, content string, target interface{}) (string, error) {
+	schema, err := SchemaFor(target)
+	if err != nil {
+		return "", err
+	}
+	jsonText, err := d.GenerateStructuredOutput(ctx, sessionID, content, schema)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal([]byte(jsonText), target); err != nil {
+		return jsonText, fmt.Errorf("failed to unmarshal structured output into %T: %w", target, err)
+	}
+	return jsonText, nil
+}
+
+// generateFromMemory runs standard generation on the context held in memory.
+func (d *DelegatorService) generateFromMemory(ctx context.Context, memory ConversationMemory, operationName string) (string, error) {
+	messagesForContext := memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel) // Use default check model
+	if len(messagesForContext) == 0 {
+		return "", fmt.Errorf("%s: No messages fit context window", operationName)
+	}
+	return d.executeGenerationWithRetry(ctx, memory, "", messagesForContext, "", operationName) // No specific model, no instruction
 }
 
 // Add method to update MOA instance if needed by SetProxy/BaseModel in InferenceService
@@ -629,9 +809,61 @@ func (d *DelegatorService) UpdateMOA(moaInstance *gollm.MOA) {
 	log.Println("DelegatorService: Internal MOA instance updated.")
 }
 
-// ClearMemory clears the conversation history.
+// SetPIIGuardrail sets the guardrail applied to prompts sent through the MOA
+// and multimodal requests; attempt instances are guarded when created.
+func (d *DelegatorService) SetPIIGuardrail(guardrail *PIIGuardrail) {
+	d.settingsMutex.Lock()
+	d.pii = guardrail
+	d.settingsMutex.Unlock()
+}
+
+// currentPIIGuardrail returns the guardrail, or nil when none is set.
+func (d *DelegatorService) currentPIIGuardrail() *PIIGuardrail {
+	d.settingsMutex.RLock()
+	defer d.settingsMutex.RUnlock()
+	return d.pii
+}
+
+// SetSemanticCache enables (or, with nil, disables) the semantic response cache.
+func (d *DelegatorService) SetSemanticCache(cache *SemanticCache) {
+	d.settingsMutex.Lock()
+	d.semanticCache = cache
+	d.settingsMutex.Unlock()
+	if cache == nil {
+		log.Println("DelegatorService: Semantic cache disabled.")
+		return
+	}
+	log.Printf("DelegatorService: Semantic cache enabled (threshold %.3f).", cache.Threshold())
+}
+
+// currentSemanticCache returns the semantic cache, or nil when it is disabled.
+func (d *DelegatorService) currentSemanticCache() *SemanticCache {
+	d.settingsMutex.RLock()
+	defer d.settingsMutex.RUnlock()
+	return d.semanticCache
+}
+
+// SetStructuredOutputRepairs sets how many times a structured response that
+// fails schema validation is sent back to the model (0 disables repairs).
+func (d *DelegatorService) SetStructuredOutputRepairs(repairs int) {
+	if repairs < 0 {
+		repairs = 0
+	}
+	d.settingsMutex.Lock()
+	d.structuredOutputRepairs = repairs
+	d.settingsMutex.Unlock()
+}
+
+// currentStructuredOutputRepairs returns the number of repairs allowed per request.
+func (d *DelegatorService) currentStructuredOutputRepairs() int {
+	d.settingsMutex.RLock()
+	defer d.settingsMutex.RUnlock()
+	return d.structuredOutputRepairs
+}
+
+// ClearMemory clears the conversation history of every session.
 func (d *DelegatorService) ClearMemory() {
-	if d.memory != nil {
-		d.memory.Clear()
+	if d.sessions != nil {
+		d.sessions.ClearAll()
 	}
 }
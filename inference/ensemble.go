@@ -0,0 +1,352 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guiperry/gollm_cerebras/llm"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+// EnsembleMode selects how an ensemble picks its answer.
+type EnsembleMode string
+
+const (
+	// EnsembleVote returns the answer given by the most candidates after
+	// normalization (JSON is compared structurally, text case-insensitively).
+	EnsembleVote EnsembleMode = "vote"
+	// EnsembleJudge asks a judge model to pick, or merge, the best candidate.
+	EnsembleJudge EnsembleMode = "judge"
+)
+
+// ErrEnsembleNoCandidates is returned when every ensemble member failed.
+var ErrEnsembleNoCandidates = errors.New("no ensemble candidate produced a response")
+
+// ErrEnsembleModelNotFound is returned when a requested ensemble or judge model
+// is not among the configured attempts.
+var ErrEnsembleModelNotFound = errors.New("model not found in configured attempts")
+
+// ErrEnsembleTooSmall is returned when fewer than two distinct attempts would
+// take part in an ensemble.
+var ErrEnsembleTooSmall = errors.New("an ensemble needs at least 2 distinct attempts")
+
+// EnsembleOptions configures an ensemble generation.
+type EnsembleOptions struct {
+	Mode       EnsembleMode `json:"mode"`
+	Models     []string     `json:"models,omitempty"`      // Attempts to query by model name; empty uses all configured attempts
+	Size       int          `json:"size,omitempty"`        // Max number of attempts when Models is empty (0 = all)
+	JudgeModel string       `json:"judge_model,omitempty"` // Judge mode only; empty uses the first primary attempt
+	Merge      bool         `json:"merge,omitempty"`       // Judge mode only: let the judge merge candidates instead of picking one
+}
+
+// EnsembleCandidate is the response of one ensemble member.
+type EnsembleCandidate struct {
+	Model      string `json:"model"`
+	Provider   string `json:"provider"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Votes      int    `json:"votes,omitempty"` // Vote mode: candidates that agree with this one (including itself)
+}
+
+// EnsembleResult is the selected answer together with every candidate.
+type EnsembleResult struct {
+	Answer     string              `json:"answer"`
+	Mode       EnsembleMode        `json:"mode"`
+	Candidates []EnsembleCandidate `json:"candidates"`
+	Winner     int                 `json:"winner"`              // Index of the chosen candidate, -1 if the judge merged answers
+	Agreement  float64             `json:"agreement,omitempty"` // Vote mode: share of successful candidates agreeing with the winner
+	Rationale  string              `json:"rationale,omitempty"` // Judge mode: the judge's explanation
+	JudgeModel string              `json:"judge_model,omitempty"`
+}
+
+// GenerateEnsemble sends the prompt to several configured attempts in parallel
+// and selects an answer by majority vote or by asking a judge model. The chosen
+// answer is added to the session's conversation memory.
+func (d *DelegatorService) GenerateEnsemble(ctx context.Context, sessionID string, promptText string, instructionText string, opts EnsembleOptions) (*EnsembleResult, error) {
+	if opts.Mode == "" {
+		opts.Mode = EnsembleVote
+	}
+	if opts.Mode != EnsembleVote && opts.Mode != EnsembleJudge {
+		return nil, fmt.Errorf("unknown ensemble mode %q (expected %q or %q)", opts.Mode, EnsembleVote, EnsembleJudge)
+	}
+	members, err := d.ensembleMembers(opts)
+	if err != nil {
+		return nil, err
+	}
+	judge, err := d.ensembleJudge(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	memory, err := d.sessions.Memory(ctx, sessionID, d.tokenLimitCheckModel)
+	if err != nil {
+		return nil, err
+	}
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
+	messages := memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel)
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("Ensemble: No messages fit context window")
+	}
+	prompt := formatMessagesToPrompt(messages)
+	if instructionText != "" {
+		prompt = "Instructions:\n" + instructionText + "\n\n---\n\n" + prompt
+	}
+
+	log.Printf("DelegatorService (Ensemble): Querying %d attempts in %s mode...", len(members), opts.Mode)
+	candidates := d.runEnsemble(ctx, prompt, members)
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("Ensemble stopped: %w", err)
+	}
+
+	var result *EnsembleResult
+	if opts.Mode == EnsembleJudge {
+		result, err = d.judgeCandidates(ctx, judge, promptText, instructionText, candidates, opts.Merge)
+		if err != nil && !errors.Is(err, ErrEnsembleNoCandidates) && ctx.Err() == nil {
+			// The candidates are still usable; fall back to a vote rather than failing
+			log.Printf("DelegatorService (Ensemble): Judge failed: %v. Falling back to majority vote.", err)
+			judgeErr := err
+			result, err = voteCandidates(candidates)
+			if result != nil {
+				result.Rationale = fmt.Sprintf("judge failed (%v); answer chosen by majority vote", judgeErr)
+			}
+		}
+	} else {
+		result, err = voteCandidates(candidates)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: result.Answer})
+	return result, nil
+}
+
+// ensembleMembers resolves which attempts take part in the ensemble. A model
+// requested more than once is queried once.
+func (d *DelegatorService) ensembleMembers(opts EnsembleOptions) ([]LLMAttempt, error) {
+	all := append(append([]LLMAttempt{}, d.primaryAttempts...), d.fallbackAttempts...)
+	if len(opts.Models) == 0 {
+		if opts.Size > 0 && opts.Size < len(all) {
+			all = all[:opts.Size]
+		}
+		if len(all) < 2 {
+			return nil, fmt.Errorf("%w, %d configured", ErrEnsembleTooSmall, len(all))
+		}
+		return all, nil
+	}
+
+	members := make([]LLMAttempt, 0, len(opts.Models))
+	requested := make(map[string]bool, len(opts.Models))
+	for _, model := range opts.Models {
+		if requested[model] {
+			continue
+		}
+		requested[model] = true
+		found := false
+		for _, attempt := range all {
+			if attempt.Config.ModelName == model {
+				members = append(members, attempt)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("ensemble model '%s': %w", model, ErrEnsembleModelNotFound)
+		}
+	}
+	if len(members) < 2 {
+		return nil, fmt.Errorf("%w, %d requested", ErrEnsembleTooSmall, len(members))
+	}
+	return members, nil
+}
+
+// ensembleJudge resolves the judge attempt; only meaningful in judge mode.
+func (d *DelegatorService) ensembleJudge(opts EnsembleOptions) (LLMAttempt, error) {
+	judge := d.primaryAttempts[0]
+	if opts.Mode != EnsembleJudge || opts.JudgeModel == "" {
+		return judge, nil
+	}
+	for _, attempt := range append(append([]LLMAttempt{}, d.primaryAttempts...), d.fallbackAttempts...) {
+		if attempt.Config.ModelName == opts.JudgeModel {
+			return attempt, nil
+		}
+	}
+	return judge, fmt.Errorf("judge model '%s': %w", opts.JudgeModel, ErrEnsembleModelNotFound)
+}
+
+// runEnsemble queries every member in parallel and returns the candidates in
+// member order. Failures are recorded on the candidate rather than returned.
+func (d *DelegatorService) runEnsemble(ctx context.Context, prompt string, members []LLMAttempt) []EnsembleCandidate {
+	candidates := make([]EnsembleCandidate, len(members))
+	var wg sync.WaitGroup
+	for i, member := range members {
+		wg.Add(1)
+		go func(index int, attempt LLMAttempt) {
+			defer wg.Done()
+			start := time.Now()
+			response, err := attempt.Instance.Generate(ctx, llm.NewPrompt(prompt))
+			candidate := EnsembleCandidate{
+				Model:      attempt.Config.ModelName,
+				Provider:   attempt.Config.ProviderName,
+				Response:   response,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				log.Printf("DelegatorService (Ensemble): Attempt with %s failed: %v", attempt.Config.ModelName, err)
+				candidate.Error = err.Error()
+			}
+			candidates[index] = candidate
+		}(i, member)
+	}
+	wg.Wait()
+	return candidates
+}
+
+// voteCandidates picks the normalized answer given by the most candidates. Ties
+// go to the group whose first member comes earliest in the attempt order.
+func voteCandidates(candidates []EnsembleCandidate) (*EnsembleResult, error) {
+	counts := make(map[string]int)
+	keys := make([]string, len(candidates))
+	successful := 0
+	for i, candidate := range candidates {
+		if candidate.Error != "" {
+			continue
+		}
+		keys[i] = normalizeEnsembleAnswer(candidate.Response)
+		counts[keys[i]]++
+		successful++
+	}
+	if successful == 0 {
+		return nil, ErrEnsembleNoCandidates
+	}
+
+	winner := -1
+	for i, candidate := range candidates {
+		if candidate.Error != "" {
+			continue
+		}
+		candidates[i].Votes = counts[keys[i]]
+		if winner < 0 || counts[keys[i]] > counts[keys[winner]] {
+			winner = i
+		}
+	}
+	return &EnsembleResult{
+		Answer:     candidates[winner].Response,
+		Mode:       EnsembleVote,
+		Candidates: candidates,
+		Winner:     winner,
+		Agreement:  float64(counts[keys[winner]]) / float64(successful),
+	}, nil
+}
+
+// normalizeEnsembleAnswer maps equivalent answers to the same key. JSON answers
+// are compared structurally (key order and formatting ignored, strings
+// lowercased); text answers case- and whitespace-insensitively, ignoring
+// surrounding quotes and trailing punctuation.
+func normalizeEnsembleAnswer(response string) string {
+	trimmed := strings.TrimSpace(response)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "```") {
+		if jsonText, err := extractJSON(trimmed); err == nil {
+			var value interface{}
+			if err := json.Unmarshal([]byte(jsonText), &value); err == nil {
+				return "json:" + compactJSON(lowercaseJSONStrings(value))
+			}
+		}
+	}
+	text := strings.ToLower(strings.Join(strings.Fields(trimmed), " "))
+	return "text:" + strings.Trim(text, " \"'`.!")
+}
+
+func lowercaseJSONStrings(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		return strings.ToLower(strings.TrimSpace(typed))
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = lowercaseJSONStrings(item)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = lowercaseJSONStrings(item)
+		}
+	}
+	return value
+}
+
+// judgeVerdict is the JSON reply expected from the judge model.
+type judgeVerdict struct {
+	Choice    int    `json:"choice"`
+	Rationale string `json:"rationale"`
+	Answer    string `json:"answer"`
+}
+
+// judgeCandidates shows the successful candidates to the judge model and
+// returns the candidate it picked, or its merged answer when merge is set.
+func (d *DelegatorService) judgeCandidates(ctx context.Context, judge LLMAttempt, promptText string, instructionText string, candidates []EnsembleCandidate, merge bool) (*EnsembleResult, error) {
+	var shown []int // Candidate indexes in the order shown to the judge
+	for i, candidate := range candidates {
+		if candidate.Error == "" {
+			shown = append(shown, i)
+		}
+	}
+	if len(shown) == 0 {
+		return nil, ErrEnsembleNoCandidates
+	}
+
+	var sb strings.Builder
+	sb.WriteString("You are judging answers from several AI models to the same request.\n\nRequest:\n---\n")
+	if instructionText != "" {
+		sb.WriteString("Instructions: " + instructionText + "\n\n")
+	}
+	sb.WriteString(promptText)
+	sb.WriteString("\n---\n")
+	for n, index := range shown {
+		fmt.Fprintf(&sb, "\nCandidate %d:\n---\n%s\n---\n", n+1, candidates[index].Response)
+	}
+	if merge {
+		sb.WriteString("\nCombine the strengths of the candidates into the best possible answer, correcting any mistakes. " +
+			"Respond ONLY with a JSON object: {\"choice\": <number of the candidate closest to your answer>, \"rationale\": \"<why>\", \"answer\": \"<the merged answer>\"}")
+	} else {
+		sb.WriteString("\nPick the candidate that best fulfils the request (correctness first, then completeness and clarity). " +
+			"Respond ONLY with a JSON object: {\"choice\": <candidate number>, \"rationale\": \"<why>\"}")
+	}
+
+	log.Printf("DelegatorService (Ensemble): Asking judge %s to choose among %d candidates...", judge.Config.ModelName, len(shown))
+	reply, err := judge.Instance.Generate(ctx, llm.NewPrompt(sb.String()))
+	if err != nil {
+		return nil, fmt.Errorf("judge %s failed: %w", judge.Config.ModelName, err)
+	}
+	jsonText, err := extractJSON(reply)
+	if err != nil {
+		return nil, fmt.Errorf("judge %s returned no verdict: %w", judge.Config.ModelName, err)
+	}
+	var verdict judgeVerdict
+	if err := json.Unmarshal([]byte(jsonText), &verdict); err != nil {
+		return nil, fmt.Errorf("judge %s returned an invalid verdict: %w", judge.Config.ModelName, err)
+	}
+
+	result := &EnsembleResult{
+		Mode:       EnsembleJudge,
+		Candidates: candidates,
+		Rationale:  verdict.Rationale,
+		JudgeModel: judge.Config.ModelName,
+	}
+	if merge && strings.TrimSpace(verdict.Answer) != "" {
+		result.Answer = verdict.Answer
+		result.Winner = -1
+		return result, nil
+	}
+	if verdict.Choice < 1 || verdict.Choice > len(shown) {
+		return nil, fmt.Errorf("judge %s chose candidate %d, expected 1-%d", judge.Config.ModelName, verdict.Choice, len(shown))
+	}
+	result.Winner = shown[verdict.Choice-1]
+	result.Answer = candidates[result.Winner].Response
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEnsembleMembers(t *testing.T) {
+	attempt := func(model string) LLMAttempt { return LLMAttempt{Config: LLMAttemptConfig{ModelName: model}} }
+	delegator := &DelegatorService{
+		primaryAttempts:  []LLMAttempt{attempt("a"), attempt("b")},
+		fallbackAttempts: []LLMAttempt{attempt("c")},
+	}
+
+	members, err := delegator.ensembleMembers(EnsembleOptions{Size: 2})
+	if err != nil || len(members) != 2 || members[1].Config.ModelName != "b" {
+		t.Errorf("Expected the first two attempts, got %v (%v)", members, err)
+	}
+	members, err = delegator.ensembleMembers(EnsembleOptions{Models: []string{"c", "a", "c"}})
+	if err != nil || len(members) != 2 || members[0].Config.ModelName != "c" || members[1].Config.ModelName != "a" {
+		t.Errorf("Expected c and a once each, got %v (%v)", members, err)
+	}
+	if _, err := delegator.ensembleMembers(EnsembleOptions{Models: []string{"a", "a"}}); !errors.Is(err, ErrEnsembleTooSmall) {
+		t.Errorf("Expected a repeated single model to be too small, got %v", err)
+	}
+	if _, err := delegator.ensembleMembers(EnsembleOptions{Size: 1}); !errors.Is(err, ErrEnsembleTooSmall) {
+		t.Errorf("Expected size 1 to be too small, got %v", err)
+	}
+	if _, err := delegator.ensembleMembers(EnsembleOptions{Models: []string{"a", "z"}}); !errors.Is(err, ErrEnsembleModelNotFound) {
+		t.Errorf("Expected an unknown model to be reported, got %v", err)
+	}
+}
+
+func TestEnsembleVote(t *testing.T) {
+	result, err := voteCandidates([]EnsembleCandidate{
+		{Model: "a", Response: `{"answer": "Paris", "score": 1}`},
+		{Model: "b", Error: "status code 503"},
+		{Model: "c", Response: "```json\n{\"score\": 1, \"answer\": \"paris\"}\n```"},
+		{Model: "d", Response: `{"answer": "Lyon", "score": 1}`},
+	})
+	if err != nil {
+		t.Fatalf("voteCandidates failed: %v", err)
+	}
+	if result.Winner != 0 || result.Candidates[0].Votes != 2 || result.Candidates[3].Votes != 1 {
+		t.Errorf("Expected the two equivalent JSON answers to win, got %+v", result)
+	}
+	if result.Agreement < 0.66 || result.Agreement > 0.67 {
+		t.Errorf("Expected 2 of 3 successful candidates to agree, got %v", result.Agreement)
+	}
+
+	// Ties go to the earliest attempt
+	result, err = voteCandidates([]EnsembleCandidate{{Response: "Yes."}, {Response: "no"}})
+	if err != nil || result.Winner != 0 || result.Answer != "Yes." {
+		t.Errorf("Expected the tie to go to the first candidate, got %+v (%v)", result, err)
+	}
+	if _, err := voteCandidates([]EnsembleCandidate{{Error: "timeout"}}); !errors.Is(err, ErrEnsembleNoCandidates) {
+		t.Errorf("Expected ErrEnsembleNoCandidates, got %v", err)
+	}
+}
+
+func TestEnsembleJudge(t *testing.T) {
+	service := startFakeInferenceService(t, `{
+		"rules": [
+			{"match": "You are judging[\\s\\S]*Candidate 2:\\n---\\nfrom fallback", "response": "{\"choice\": 2, \"rationale\": \"more complete\"}"},
+			{"match": "capital", "model": "fake-primary", "response": "from primary"},
+			{"match": "capital", "model": "fake-fallback", "response": "from fallback"}
+		]
+	}`)
+	result, err := service.GenerateTextWithEnsemble(context.Background(), "", "What is the capital of France?", "", EnsembleOptions{Mode: EnsembleJudge})
+	if err != nil {
+		t.Fatalf("GenerateTextWithEnsemble failed: %v", err)
+	}
+	if result.Answer != "from fallback" || result.Winner != 1 || result.Rationale != "more complete" || result.JudgeModel != "fake-primary" {
+		t.Errorf("Expected the judge to pick the fallback candidate, got %+v", result)
+	}
+
+	// An unusable verdict falls back to a vote
+	service = startFakeInferenceService(t, `{
+		"default": "same answer",
+		"rules": [{"match": "You are judging", "response": "I like the first one"}]
+	}`)
+	result, err = service.GenerateTextWithEnsemble(context.Background(), "", "What is the capital of France?", "", EnsembleOptions{Mode: EnsembleJudge})
+	if err != nil {
+		t.Fatalf("GenerateTextWithEnsemble failed: %v", err)
+	}
+	if result.Answer != "same answer" || result.Mode != EnsembleVote || result.Agreement != 1 {
+		t.Errorf("Expected a unanimous vote after the judge failed, got %+v", result)
+	}
+}
@@ -0,0 +1,658 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"Agentic_Engine/database"
+
+	"github.com/google/uuid"
+	"github.com/philippgille/chromem-go"
+)
+
+// EvaluationCollection is the chromem collection holding evaluation datasets and run reports.
+const EvaluationCollection = "evaluations"
+
+const (
+	defaultEvalConcurrency   = 4
+	defaultEvalPassThreshold = 0.8
+)
+
+var (
+	// ErrEvalDatasetNotFound is returned when no dataset has the given ID.
+	ErrEvalDatasetNotFound = errors.New("evaluation dataset not found")
+	// ErrEvalRunNotFound is returned when no run report has the given ID.
+	ErrEvalRunNotFound = errors.New("evaluation run not found")
+	// ErrEvalInvalid is returned when a dataset or run configuration is rejected.
+	ErrEvalInvalid = errors.New("invalid evaluation")
+)
+
+// EvalCase is one input of a dataset with what a good answer looks like. Each
+// scorer uses the fields it needs and skips cases that leave them empty.
+type EvalCase struct {
+	ID        string                 `json:"id"`
+	Input     string                 `json:"input"`
+	Variables map[string]interface{} `json:"variables,omitempty"` // Template variables; Input is also available as {{.Input}}
+	Expected  string                 `json:"expected,omitempty"`  // exact_match, similarity and llm_judge
+	Pattern   string                 `json:"pattern,omitempty"`   // regex
+	Schema    string                 `json:"schema,omitempty"`    // json_schema, overrides the run schema
+}
+
+// EvalDataset is a named set of golden cases.
+type EvalDataset struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Cases       []EvalCase `json:"cases"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// EvalMode selects the generation method a run exercises.
+type EvalMode string
+
+const (
+	EvalModeSimple     EvalMode = "simple"
+	EvalModeCoT        EvalMode = "cot"
+	EvalModeReflection EvalMode = "reflection"
+	EvalModeStructured EvalMode = "structured"
+)
+
+// EvalRunConfig describes what a run evaluates and how it is scored.
+type EvalRunConfig struct {
+	DatasetID      string             `json:"dataset_id"`
+	Label          string             `json:"label,omitempty"` // e.g. "llama-4 baseline"
+	Model          string             `json:"model,omitempty"` // Empty uses the default model order
+	PromptTemplate *PromptTemplateRef `json:"prompt_template,omitempty"`
+	Mode           EvalMode           `json:"mode,omitempty"`
+	Instruction    string             `json:"instruction,omitempty"`
+	Schema         string             `json:"schema,omitempty"` // Structured mode and the json_schema scorer
+	Scorers        []EvalScorer       `json:"scorers"`
+	JudgeModel     string             `json:"judge_model,omitempty"`
+	PassThreshold  float64            `json:"pass_threshold,omitempty"` // Every score must reach it for a case to pass
+	Concurrency    int                `json:"concurrency,omitempty"`
+	AgentID        string             `json:"agent_id,omitempty"` // Applies the agent's PII redaction flag
+}
+
+// EvalCaseResult is the output and scores of one case.
+type EvalCaseResult struct {
+	CaseID    string             `json:"case_id"`
+	Output    string             `json:"output"`
+	Error     string             `json:"error,omitempty"`
+	LatencyMs int64              `json:"latency_ms"`
+	Scores    map[string]float64 `json:"scores"`
+	Notes     map[string]string  `json:"notes,omitempty"` // Why a scorer gave its score
+	Passed    bool               `json:"passed"`
+}
+
+// EvalSummary aggregates the case results of a run.
+type EvalSummary struct {
+	Cases            int                `json:"cases"`
+	Passed           int                `json:"passed"`
+	Errors           int                `json:"errors"`
+	PassRate         float64            `json:"pass_rate"`
+	MeanScores       map[string]float64 `json:"mean_scores"`
+	AverageLatencyMs float64            `json:"average_latency_ms"`
+}
+
+// EvalRunStatus is the lifecycle state of a run.
+type EvalRunStatus string
+
+const (
+	EvalRunRunning   EvalRunStatus = "running"
+	EvalRunCompleted EvalRunStatus = "completed"
+	EvalRunFailed    EvalRunStatus = "failed"
+)
+
+// EvalRunReport is the stored result of running a dataset. Summary and Results
+// are filled in once the run is completed.
+type EvalRunReport struct {
+	ID          string           `json:"id"`
+	Config      EvalRunConfig    `json:"config"`
+	DatasetName string           `json:"dataset_name"`
+	Status      EvalRunStatus    `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	StartedAt   time.Time        `json:"started_at"`
+	FinishedAt  time.Time        `json:"finished_at"`
+	Summary     EvalSummary      `json:"summary"`
+	Results     []EvalCaseResult `json:"results"`
+}
+
+// EvalComparison shows how a candidate run differs from a baseline run of the same dataset.
+type EvalComparison struct {
+	BaselineID       string             `json:"baseline_id"`
+	CandidateID      string             `json:"candidate_id"`
+	DatasetID        string             `json:"dataset_id"`
+	PassRateDelta    float64            `json:"pass_rate_delta"`
+	ScoreDeltas      map[string]float64 `json:"score_deltas"`
+	LatencyDeltaMs   float64            `json:"latency_delta_ms"`
+	Regressions      []string           `json:"regressions"`  // Cases that passed in the baseline and fail now
+	Improvements     []string           `json:"improvements"` // Cases that failed in the baseline and pass now
+	CandidateSummary EvalSummary        `json:"candidate_summary"`
+	BaselineSummary  EvalSummary        `json:"baseline_summary"`
+}
+
+// Evaluator runs datasets through the inference service and keeps the reports,
+// in the domain database when one is available and in memory otherwise.
+type Evaluator struct {
+	service    *InferenceService
+	embedder   database.Embedder
+	collection *chromem.Collection
+	datasets   map[string]*EvalDataset
+	runs       map[string]*EvalRunReport
+	mutex      sync.RWMutex
+}
+
+// NewEvaluator creates an evaluator for service and loads stored datasets and runs from db.
+func NewEvaluator(ctx context.Context, service *InferenceService, db *database.SimpleDomainDB) (*Evaluator, error) {
+	e := &Evaluator{
+		service:  service,
+		embedder: database.NewHashEmbedder(database.DefaultEmbeddingDimensions),
+		datasets: make(map[string]*EvalDataset),
+		runs:     make(map[string]*EvalRunReport),
+	}
+	if db == nil {
+		return e, nil
+	}
+
+	e.embedder = guardEmbedder(db.Embedder(), service.pii) // Scored outputs may repeat PII from the prompt
+	collection, err := db.GetOrCreateCollection(EvaluationCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open evaluation collection: %w", err)
+	}
+	e.collection = collection
+	if err := e.load(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Evaluator: Initialized (collection: %s, datasets: %d, runs: %d)", EvaluationCollection, len(e.datasets), len(e.runs))
+	return e, nil
+}
+
+// load reads the stored datasets and run reports into memory.
+func (e *Evaluator) load(ctx context.Context) error {
+	count := e.collection.Count()
+	if count == 0 {
+		return nil
+	}
+	// chromem-go has no list call, so query with a generic text for every document
+	results, err := e.collection.Query(ctx, "evaluation", count, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load evaluations: %w", err)
+	}
+	for _, result := range results {
+		switch result.Metadata["kind"] {
+		case "dataset":
+			var dataset EvalDataset
+			if err := json.Unmarshal([]byte(result.Content), &dataset); err != nil {
+				log.Printf("[WARN] Evaluator: Skipping stored dataset %s: %v", result.ID, err)
+				continue
+			}
+			e.datasets[dataset.ID] = &dataset
+		case "run":
+			var run EvalRunReport
+			if err := json.Unmarshal([]byte(result.Content), &run); err != nil {
+				log.Printf("[WARN] Evaluator: Skipping stored run %s: %v", result.ID, err)
+				continue
+			}
+			e.runs[run.ID] = &run
+		}
+	}
+	return nil
+}
+
+// persist stores value as JSON under kind:id. Callers hold the lock.
+func (e *Evaluator) persist(ctx context.Context, kind, id string, value interface{}, metadata map[string]string) error {
+	if e.collection == nil {
+		return nil
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %s: %w", kind, id, err)
+	}
+	metadata["kind"] = kind
+	// Datasets and reports hold prompts and outputs, so they are embedded with PII redacted
+	embedding, err := e.embedder.Embed(ctx, string(content))
+	if err != nil {
+		return fmt.Errorf("failed to embed %s %s: %w", kind, id, err)
+	}
+	doc := chromem.Document{ID: kind + ":" + id, Content: string(content), Embedding: embedding, Metadata: metadata}
+	if err := e.collection.AddDocument(ctx, doc); err != nil {
+		return fmt.Errorf("failed to store %s %s: %w", kind, id, err)
+	}
+	return nil
+}
+
+// CreateDataset validates and stores a dataset. Cases without an ID are numbered.
+func (e *Evaluator) CreateDataset(ctx context.Context, dataset EvalDataset) (*EvalDataset, error) {
+	if dataset.Name == "" {
+		return nil, fmt.Errorf("%w: dataset name is required", ErrEvalInvalid)
+	}
+	if len(dataset.Cases) == 0 {
+		return nil, fmt.Errorf("%w: dataset %s has no cases", ErrEvalInvalid, dataset.Name)
+	}
+	seen := make(map[string]bool)
+	cases := make([]EvalCase, len(dataset.Cases))
+	for i, evalCase := range dataset.Cases {
+		if evalCase.ID == "" {
+			evalCase.ID = fmt.Sprintf("case-%d", i+1)
+		}
+		if seen[evalCase.ID] {
+			return nil, fmt.Errorf("%w: duplicate case ID %s", ErrEvalInvalid, evalCase.ID)
+		}
+		seen[evalCase.ID] = true
+		if evalCase.Input == "" && len(evalCase.Variables) == 0 {
+			return nil, fmt.Errorf("%w: case %s has no input", ErrEvalInvalid, evalCase.ID)
+		}
+		cases[i] = evalCase
+	}
+	dataset.Cases = cases
+	dataset.ID = uuid.New().String()
+	dataset.CreatedAt = time.Now()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	metadata := map[string]string{"name": dataset.Name, "created_at": dataset.CreatedAt.Format(time.RFC3339)}
+	if err := e.persist(ctx, "dataset", dataset.ID, dataset, metadata); err != nil {
+		return nil, err
+	}
+	e.datasets[dataset.ID] = &dataset
+	log.Printf("Evaluator: Stored dataset '%s' (%s) with %d cases", dataset.Name, dataset.ID, len(dataset.Cases))
+	created := dataset
+	return &created, nil
+}
+
+// GetDataset returns a dataset by ID.
+func (e *Evaluator) GetDataset(id string) (*EvalDataset, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	dataset, ok := e.datasets[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEvalDatasetNotFound, id)
+	}
+	copied := *dataset
+	return &copied, nil
+}
+
+// ListDatasets returns all datasets without their cases, newest first.
+func (e *Evaluator) ListDatasets() []EvalDataset {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	datasets := make([]EvalDataset, 0, len(e.datasets))
+	for _, dataset := range e.datasets {
+		summary := *dataset
+		summary.Cases = nil
+		datasets = append(datasets, summary)
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].CreatedAt.After(datasets[j].CreatedAt) })
+	return datasets
+}
+
+// DeleteDataset removes a dataset. Its run reports are kept for comparison.
+func (e *Evaluator) DeleteDataset(ctx context.Context, id string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if _, ok := e.datasets[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrEvalDatasetNotFound, id)
+	}
+	if e.collection != nil {
+		if err := e.collection.Delete(ctx, nil, nil, "dataset:"+id); err != nil {
+			return fmt.Errorf("failed to delete dataset %s: %w", id, err)
+		}
+	}
+	delete(e.datasets, id)
+	log.Printf("Evaluator: Deleted dataset %s", id)
+	return nil
+}
+
+// GetRun returns a run report by ID.
+func (e *Evaluator) GetRun(id string) (*EvalRunReport, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	run, ok := e.runs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEvalRunNotFound, id)
+	}
+	copied := *run
+	return &copied, nil
+}
+
+// ListRuns returns the run reports without case results, newest first. A
+// non-empty datasetID limits them to that dataset.
+func (e *Evaluator) ListRuns(datasetID string) []EvalRunReport {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	runs := make([]EvalRunReport, 0, len(e.runs))
+	for _, run := range e.runs {
+		if datasetID != "" && run.Config.DatasetID != datasetID {
+			continue
+		}
+		summary := *run
+		summary.Results = nil
+		runs = append(runs, summary)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	return runs
+}
+
+// evalRun is a validated run waiting to be executed.
+type evalRun struct {
+	ctx     context.Context
+	report  *EvalRunReport
+	dataset *EvalDataset
+	tmpl    *PromptTemplate
+}
+
+// Start validates config, stores a running report and evaluates the dataset in
+// the background. Poll GetRun until the report is completed or failed.
+func (e *Evaluator) Start(ctx context.Context, config EvalRunConfig) (*EvalRunReport, error) {
+	run, err := e.prepare(context.WithoutCancel(ctx), config)
+	if err != nil {
+		return nil, err
+	}
+	started := *run.report
+	go e.execute(run)
+	return &started, nil
+}
+
+// Run evaluates the dataset and waits for the report. Generation errors are
+// recorded per case and fail that case.
+func (e *Evaluator) Run(ctx context.Context, config EvalRunConfig) (*EvalRunReport, error) {
+	run, err := e.prepare(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.execute(run); err != nil {
+		return nil, err
+	}
+	return e.GetRun(run.report.ID)
+}
+
+// prepare validates config, resolves the prompt template and registers the
+// report as running.
+func (e *Evaluator) prepare(ctx context.Context, config EvalRunConfig) (*evalRun, error) {
+	dataset, err := e.GetDataset(config.DatasetID)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.normalizeConfig(&config); err != nil {
+		return nil, err
+	}
+	ctx, _, err = e.service.WithAgent(ctx, config.AgentID)
+	if err != nil {
+		return nil, err
+	}
+	// Cached answers would measure an earlier generation instead of this configuration
+	ctx = WithoutSemanticCache(ctx)
+	var tmpl *PromptTemplate
+	if config.PromptTemplate != nil {
+		if tmpl, err = e.service.PromptTemplates().Resolve(*config.PromptTemplate); err != nil {
+			return nil, err
+		}
+		// Pin the version so runs stay comparable after the template changes
+		config.PromptTemplate = &PromptTemplateRef{Name: tmpl.Name, Version: tmpl.Version}
+	}
+
+	report := &EvalRunReport{
+		ID:          uuid.New().String(),
+		Config:      config,
+		DatasetName: dataset.Name,
+		Status:      EvalRunRunning,
+		StartedAt:   time.Now(),
+		Results:     []EvalCaseResult{},
+	}
+	e.mutex.Lock()
+	e.runs[report.ID] = report
+	e.mutex.Unlock()
+	return &evalRun{ctx: ctx, report: report, dataset: dataset, tmpl: tmpl}, nil
+}
+
+// execute generates and scores every case, then completes and stores the report.
+// A stopped or unstorable run is marked failed.
+func (e *Evaluator) execute(run *evalRun) error {
+	ctx, config, dataset := run.ctx, run.report.Config, run.dataset
+	log.Printf("Evaluator: Running dataset '%s' (%d cases, mode %s, model '%s')", dataset.Name, len(dataset.Cases), config.Mode, config.Model)
+
+	results := make([]EvalCaseResult, len(dataset.Cases))
+	slots := make(chan struct{}, config.Concurrency)
+	var wg sync.WaitGroup
+	for i, evalCase := range dataset.Cases {
+		wg.Add(1)
+		go func(index int, evalCase EvalCase) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[index] = e.runCase(ctx, config, run.tmpl, evalCase)
+		}(i, evalCase)
+	}
+	wg.Wait()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	report := run.report
+	report.FinishedAt = time.Now()
+	if err := ctx.Err(); err != nil {
+		report.Status = EvalRunFailed
+		report.Error = fmt.Sprintf("evaluation of dataset %s stopped: %v", dataset.Name, err)
+		log.Printf("[WARN] Evaluator: Run %s failed: %s", report.ID, report.Error)
+		return fmt.Errorf("evaluation of dataset %s stopped: %w", dataset.Name, err)
+	}
+	report.Status = EvalRunCompleted
+	report.Results = results
+	report.Summary = summarizeEvalResults(results)
+	metadata := map[string]string{"dataset_id": config.DatasetID, "created_at": report.StartedAt.Format(time.RFC3339)}
+	if err := e.persist(context.WithoutCancel(ctx), "run", report.ID, report, metadata); err != nil {
+		report.Status = EvalRunFailed
+		report.Error = err.Error()
+		log.Printf("[ERROR] Evaluator: Run %s could not be stored: %v", report.ID, err)
+		return err
+	}
+	log.Printf("Evaluator: Run %s finished, %d/%d cases passed", report.ID, report.Summary.Passed, report.Summary.Cases)
+	return nil
+}
+
+// normalizeConfig applies defaults and rejects unknown modes and scorers.
+func (e *Evaluator) normalizeConfig(config *EvalRunConfig) error {
+	switch config.Mode {
+	case "":
+		config.Mode = EvalModeSimple
+	case EvalModeSimple, EvalModeCoT, EvalModeReflection:
+	case EvalModeStructured:
+		if config.Schema == "" {
+			return fmt.Errorf("%w: structured mode requires a schema", ErrEvalInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrEvalInvalid, config.Mode)
+	}
+	if config.Mode != EvalModeSimple && (config.Model != "" || config.Instruction != "") {
+		return fmt.Errorf("%w: model and instruction are only supported in simple mode", ErrEvalInvalid)
+	}
+	if len(config.Scorers) == 0 {
+		return fmt.Errorf("%w: at least one scorer is required", ErrEvalInvalid)
+	}
+	for _, scorer := range config.Scorers {
+		if !scorer.valid() {
+			return fmt.Errorf("%w: unknown scorer %q", ErrEvalInvalid, scorer)
+		}
+	}
+	if config.PassThreshold <= 0 || config.PassThreshold > 1 {
+		config.PassThreshold = defaultEvalPassThreshold
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaultEvalConcurrency
+	}
+	return nil
+}
+
+// runCase generates and scores one case.
+func (e *Evaluator) runCase(ctx context.Context, config EvalRunConfig, tmpl *PromptTemplate, evalCase EvalCase) EvalCaseResult {
+	result := EvalCaseResult{CaseID: evalCase.ID, Scores: make(map[string]float64), Notes: make(map[string]string)}
+
+	prompt := evalCase.Input
+	if tmpl != nil {
+		vars := make(map[string]interface{}, len(evalCase.Variables)+1)
+		for name, value := range evalCase.Variables {
+			vars[name] = value
+		}
+		if _, ok := vars["Input"]; !ok {
+			vars["Input"] = evalCase.Input
+		}
+		rendered, err := tmpl.Render(vars)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		prompt = rendered
+	}
+
+	start := time.Now()
+	output, err := e.generate(ctx, config, prompt)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Output = output
+
+	result.Passed = true
+	for _, scorer := range config.Scorers {
+		score, note, applicable := e.score(ctx, scorer, config, evalCase, output)
+		if !applicable {
+			continue
+		}
+		result.Scores[string(scorer)] = score
+		if note != "" {
+			result.Notes[string(scorer)] = note
+		}
+		if score < config.PassThreshold {
+			result.Passed = false
+		}
+	}
+	return result
+}
+
+// generate produces the output of one case with the run's mode. Each case uses
+// a fresh conversation so cases do not see each other.
+func (e *Evaluator) generate(ctx context.Context, config EvalRunConfig, prompt string) (string, error) {
+	switch config.Mode {
+	case EvalModeCoT:
+		return e.service.GenerateTextWithCoT(ctx, "", prompt)
+	case EvalModeReflection:
+		return e.service.GenerateTextWithReflection(ctx, "", prompt)
+	case EvalModeStructured:
+		return e.service.GenerateStructuredOutput(ctx, "", prompt, config.Schema)
+	default:
+		result, err := e.service.GenerateTextWithMetadata(ctx, "", config.Model, prompt, config.Instruction)
+		if err != nil {
+			return "", err
+		}
+		return result.Content, nil
+	}
+}
+
+// summarizeEvalResults computes the pass rate, mean score per scorer and the
+// average latency of the cases that produced output.
+func summarizeEvalResults(results []EvalCaseResult) EvalSummary {
+	summary := EvalSummary{Cases: len(results), MeanScores: make(map[string]float64)}
+	counts := make(map[string]int)
+	var latencySum int64
+	for _, result := range results {
+		if result.Passed {
+			summary.Passed++
+		}
+		if result.Error != "" {
+			summary.Errors++
+			continue
+		}
+		latencySum += result.LatencyMs
+		for scorer, score := range result.Scores {
+			summary.MeanScores[scorer] += score
+			counts[scorer]++
+		}
+	}
+	for scorer, count := range counts {
+		summary.MeanScores[scorer] /= float64(count)
+	}
+	if summary.Cases > 0 {
+		summary.PassRate = float64(summary.Passed) / float64(summary.Cases)
+	}
+	if succeeded := summary.Cases - summary.Errors; succeeded > 0 {
+		summary.AverageLatencyMs = float64(latencySum) / float64(succeeded)
+	}
+	return summary
+}
+
+// CompareRuns compares a candidate run with a baseline run of the same dataset.
+func (e *Evaluator) CompareRuns(baselineID, candidateID string) (*EvalComparison, error) {
+	baseline, err := e.GetRun(baselineID)
+	if err != nil {
+		return nil, err
+	}
+	candidate, err := e.GetRun(candidateID)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range []*EvalRunReport{baseline, candidate} {
+		if run.Status != EvalRunCompleted {
+			return nil, fmt.Errorf("%w: run %s is %s", ErrEvalInvalid, run.ID, run.Status)
+		}
+	}
+	if baseline.Config.DatasetID != candidate.Config.DatasetID {
+		return nil, fmt.Errorf("%w: runs %s and %s use different datasets", ErrEvalInvalid, baselineID, candidateID)
+	}
+
+	comparison := &EvalComparison{
+		BaselineID:       baselineID,
+		CandidateID:      candidateID,
+		DatasetID:        candidate.Config.DatasetID,
+		PassRateDelta:    candidate.Summary.PassRate - baseline.Summary.PassRate,
+		ScoreDeltas:      make(map[string]float64),
+		LatencyDeltaMs:   candidate.Summary.AverageLatencyMs - baseline.Summary.AverageLatencyMs,
+		Regressions:      []string{},
+		Improvements:     []string{},
+		BaselineSummary:  baseline.Summary,
+		CandidateSummary: candidate.Summary,
+	}
+	for scorer, score := range candidate.Summary.MeanScores {
+		if baseScore, ok := baseline.Summary.MeanScores[scorer]; ok {
+			comparison.ScoreDeltas[scorer] = score - baseScore
+		}
+	}
+
+	basePassed := make(map[string]bool, len(baseline.Results))
+	for _, result := range baseline.Results {
+		basePassed[result.CaseID] = result.Passed
+	}
+	for _, result := range candidate.Results {
+		passedBefore, ok := basePassed[result.CaseID]
+		switch {
+		case !ok:
+		case passedBefore && !result.Passed:
+			comparison.Regressions = append(comparison.Regressions, result.CaseID)
+		case !passedBefore && result.Passed:
+			comparison.Improvements = append(comparison.Improvements, result.CaseID)
+		}
+	}
+	return comparison, nil
+}
+
+// evalJudgeInstruction asks the judge model for a graded verdict.
+const evalJudgeInstruction = "You are grading the answer of an AI model. Score how well the answer responds to the input" +
+	" and, when a reference answer is given, how closely it agrees with it. Respond ONLY with JSON of the form" +
+	` {"score": <number between 0 and 1>, "reason": "<one sentence>"}.`
+
+// buildEvalJudgePrompt lays out the case for the judge model.
+func buildEvalJudgePrompt(evalCase EvalCase, output string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Input:\n---\n%s\n---\n\n", evalCase.Input)
+	if evalCase.Expected != "" {
+		fmt.Fprintf(&sb, "Reference answer:\n---\n%s\n---\n\n", evalCase.Expected)
+	}
+	fmt.Fprintf(&sb, "Answer to grade:\n---\n%s\n---", output)
+	return sb.String()
+}
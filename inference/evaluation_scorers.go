@@ -0,0 +1,145 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EvalScorer names a way of scoring a case output between 0 and 1.
+type EvalScorer string
+
+const (
+	// EvalExactMatch scores 1 when the output equals Expected after trimming;
+	// JSON is compared structurally.
+	EvalExactMatch EvalScorer = "exact_match"
+	// EvalRegex scores 1 when the output matches Pattern.
+	EvalRegex EvalScorer = "regex"
+	// EvalJSONSchema scores 1 when the output contains JSON valid against the case or run schema.
+	EvalJSONSchema EvalScorer = "json_schema"
+	// EvalSimilarity scores the cosine similarity of the output and Expected embeddings.
+	EvalSimilarity EvalScorer = "similarity"
+	// EvalLLMJudge lets the judge model grade the output.
+	EvalLLMJudge EvalScorer = "llm_judge"
+)
+
+func (s EvalScorer) valid() bool {
+	switch s {
+	case EvalExactMatch, EvalRegex, EvalJSONSchema, EvalSimilarity, EvalLLMJudge:
+		return true
+	}
+	return false
+}
+
+// score applies one scorer. It reports false when the case lacks what the
+// scorer needs, e.g. a regex scorer on a case without a pattern.
+func (e *Evaluator) score(ctx context.Context, scorer EvalScorer, config EvalRunConfig, evalCase EvalCase, output string) (float64, string, bool) {
+	switch scorer {
+	case EvalExactMatch:
+		if evalCase.Expected == "" {
+			return 0, "", false
+		}
+		if exactMatch(output, evalCase.Expected) {
+			return 1, "", true
+		}
+		return 0, "", true
+
+	case EvalRegex:
+		if evalCase.Pattern == "" {
+			return 0, "", false
+		}
+		pattern, err := regexp.Compile(evalCase.Pattern)
+		if err != nil {
+			return 0, fmt.Sprintf("invalid pattern: %v", err), true
+		}
+		if pattern.MatchString(output) {
+			return 1, "", true
+		}
+		return 0, "", true
+
+	case EvalJSONSchema:
+		schemaText := evalCase.Schema
+		if schemaText == "" {
+			schemaText = config.Schema
+		}
+		if schemaText == "" {
+			return 0, "", false
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal([]byte(schemaText), &schema); err != nil {
+			return 0, fmt.Sprintf("invalid schema: %v", err), true
+		}
+		if _, violations := validateStructuredResponse(output, schema); len(violations) > 0 {
+			return 0, strings.Join(violations, "; "), true
+		}
+		return 1, "", true
+
+	case EvalSimilarity:
+		if evalCase.Expected == "" {
+			return 0, "", false
+		}
+		outputEmbedding, err := e.embedder.Embed(ctx, output)
+		if err != nil {
+			return 0, fmt.Sprintf("embedding failed: %v", err), true
+		}
+		expectedEmbedding, err := e.embedder.Embed(ctx, evalCase.Expected)
+		if err != nil {
+			return 0, fmt.Sprintf("embedding failed: %v", err), true
+		}
+		similarity := cosineSimilarity(outputEmbedding, expectedEmbedding)
+		if similarity < 0 {
+			similarity = 0
+		}
+		return similarity, "", true
+
+	case EvalLLMJudge:
+		return e.judge(ctx, config, evalCase, output)
+	}
+	return 0, "", false
+}
+
+// exactMatch reports whether output equals expected after trimming. When both
+// are JSON they are compared structurally, so key order and spacing do not matter.
+func exactMatch(output, expected string) bool {
+	output, expected = strings.TrimSpace(output), strings.TrimSpace(expected)
+	if output == expected {
+		return true
+	}
+	var outputValue, expectedValue interface{}
+	if json.Unmarshal([]byte(output), &outputValue) != nil || json.Unmarshal([]byte(expected), &expectedValue) != nil {
+		return false
+	}
+	return compactJSON(outputValue) == compactJSON(expectedValue)
+}
+
+// evalJudgeVerdict is the JSON a judge model answers with.
+type evalJudgeVerdict struct {
+	Score  *float64 `json:"score"`
+	Reason string   `json:"reason"`
+}
+
+// judge asks the judge model (or the default model order) to grade output. A
+// failed or unparseable verdict scores 0 with the reason in the note.
+func (e *Evaluator) judge(ctx context.Context, config EvalRunConfig, evalCase EvalCase, output string) (float64, string, bool) {
+	response, err := e.service.GenerateText(ctx, "", config.JudgeModel, buildEvalJudgePrompt(evalCase, output), evalJudgeInstruction)
+	if err != nil {
+		return 0, fmt.Sprintf("judge failed: %v", err), true
+	}
+	jsonText, err := extractJSON(response)
+	if err != nil {
+		return 0, fmt.Sprintf("judge verdict unreadable: %v", err), true
+	}
+	var verdict evalJudgeVerdict
+	if err := json.Unmarshal([]byte(jsonText), &verdict); err != nil || verdict.Score == nil {
+		return 0, "judge verdict has no score", true
+	}
+	score := *verdict.Score
+	if score < 0 {
+		score = 0
+	} else if score > 1 {
+		score = 1
+	}
+	return score, verdict.Reason, true
+}
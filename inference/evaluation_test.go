@@ -0,0 +1,126 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Agentic_Engine/database"
+)
+
+func TestEvaluationRunAndCompare(t *testing.T) {
+	t.Setenv("SEMANTIC_CACHE_THRESHOLD", "0.9")
+	db, err := database.NewSimpleDomainDBWithEmbedder(filepath.Join(t.TempDir(), "domain.db"), database.NewHashEmbedder(0))
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	defer db.Close()
+	service := startFakeInferenceServiceWithDB(t, `{
+		"default": "I don't know",
+		"rules": [
+			{"match": "Answer to grade:\\n---\\nParis", "response": "{\"score\": 0.9, \"reason\": \"correct\"}"},
+			{"match": "Answer to grade:", "response": "{\"score\": 0.1, \"reason\": \"wrong\"}"},
+			{"match": "capital of Peru", "response": "Lima", "times": 1},
+			{"match": "capital of Peru", "response": "Cusco"},
+			{"match": "capital of France", "response": "Paris"},
+			{"match": "2\\+2", "response": "The answer is 4."},
+			{"match": "user as JSON", "response": "Here you go: {\"name\": \"Ada\", \"age\": 36}"}
+		]
+	}`, db)
+	evaluator := service.Evaluations()
+	ctx := context.Background()
+
+	dataset, err := evaluator.CreateDataset(ctx, EvalDataset{
+		Name: "golden",
+		Cases: []EvalCase{
+			{Input: "What is the capital of France?", Expected: " Paris\n"},
+			{Input: "What is 2+2?", Pattern: `\b4\b`},
+			{Input: "Describe the user as JSON", Schema: `{"type": "object", "required": ["name", "age"]}`},
+			{Input: "What is the capital of Peru?", Expected: "Lima"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateDataset failed: %v", err)
+	}
+	if dataset.Cases[3].ID != "case-4" {
+		t.Errorf("Expected generated case ID 'case-4', got %q", dataset.Cases[3].ID)
+	}
+
+	if _, err := evaluator.Run(ctx, EvalRunConfig{DatasetID: dataset.ID, Mode: EvalModeCoT, Model: "fake-fallback", Scorers: []EvalScorer{EvalRegex}}); !errors.Is(err, ErrEvalInvalid) {
+		t.Errorf("Expected a model outside simple mode to be rejected, got %v", err)
+	}
+
+	// The cached "Lima" must not leak into the run, which gets "Cusco" and fails case-4
+	if _, err := service.GenerateTextWithMetadata(ctx, "", "", "What is the capital of Peru?", ""); err != nil {
+		t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+	}
+	baseline, err := evaluator.Run(ctx, EvalRunConfig{
+		DatasetID: dataset.ID,
+		Scorers:   []EvalScorer{EvalExactMatch, EvalRegex, EvalJSONSchema},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	passed := map[string]bool{}
+	for _, result := range baseline.Results {
+		passed[result.CaseID] = result.Passed
+	}
+	expected := map[string]bool{"case-1": true, "case-2": true, "case-3": true, "case-4": false}
+	for id, want := range expected {
+		if passed[id] != want {
+			t.Errorf("Expected %s passed=%v, got %v", id, want, passed[id])
+		}
+	}
+	if baseline.Status != EvalRunCompleted || baseline.Summary.PassRate != 0.75 {
+		t.Errorf("Expected a completed run with pass rate 0.75, got %s and %v", baseline.Status, baseline.Summary.PassRate)
+	}
+	if baseline.Summary.MeanScores[string(EvalExactMatch)] != 0.5 {
+		t.Errorf("Expected exact_match mean 0.5, got %v", baseline.Summary.MeanScores[string(EvalExactMatch)])
+	}
+	if !exactMatch(`{"age": 36, "name": "Ada"}`, `{"name":"Ada","age":36}`) || exactMatch("paris", "Paris") {
+		t.Errorf("Expected exact_match to compare JSON structurally and text exactly")
+	}
+
+	// A judge run in the background that only passes the Paris case, compared with the baseline
+	started, err := evaluator.Start(ctx, EvalRunConfig{
+		DatasetID: dataset.ID,
+		Scorers:   []EvalScorer{EvalLLMJudge},
+	})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if started.Status != EvalRunRunning {
+		t.Errorf("Expected a running report, got %s", started.Status)
+	}
+	candidate := started
+	for deadline := time.Now().Add(30 * time.Second); candidate.Status == EvalRunRunning; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Judge run did not finish")
+		}
+		if candidate, err = evaluator.GetRun(started.ID); err != nil {
+			t.Fatalf("GetRun failed: %v", err)
+		}
+	}
+	if candidate.Status != EvalRunCompleted {
+		t.Fatalf("Expected the judge run to complete, got %s (%s)", candidate.Status, candidate.Error)
+	}
+	if note := candidate.Results[0].Notes[string(EvalLLMJudge)]; note != "correct" {
+		t.Errorf("Expected judge reason 'correct', got %q", note)
+	}
+	comparison, err := evaluator.CompareRuns(baseline.ID, candidate.ID)
+	if err != nil {
+		t.Fatalf("CompareRuns failed: %v", err)
+	}
+	if strings.Join(comparison.Regressions, ",") != "case-2,case-3" {
+		t.Errorf("Expected regressions case-2,case-3, got %v", comparison.Regressions)
+	}
+	if comparison.PassRateDelta != -0.5 {
+		t.Errorf("Expected pass rate delta -0.5, got %v", comparison.PassRateDelta)
+	}
+	if runs := evaluator.ListRuns(dataset.ID); len(runs) != 2 {
+		t.Errorf("Expected 2 stored runs, got %d", len(runs))
+	}
+}
@@ -0,0 +1,447 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"Agentic_Engine/database"
+
+	"github.com/google/uuid"
+	"github.com/philippgille/chromem-go"
+)
+
+// ExperimentCollection is the chromem collection holding experiments and their generations.
+const ExperimentCollection = "experiments"
+
+var (
+	// ErrExperimentNotFound is returned when no experiment has the given ID.
+	ErrExperimentNotFound = errors.New("experiment not found")
+	// ErrExperimentInvalid is returned when an experiment definition is rejected.
+	ErrExperimentInvalid = errors.New("invalid experiment")
+	// ErrExperimentInactive is returned when generating with a stopped experiment.
+	ErrExperimentInactive = errors.New("experiment is not active")
+	// ErrExperimentGenerationNotFound is returned when feedback names an unknown generation.
+	ErrExperimentGenerationNotFound = errors.New("experiment generation not found")
+)
+
+// ExperimentVariant is one arm of an experiment. A variant sends its share of
+// traffic to a prompt template version, a model, or both.
+type ExperimentVariant struct {
+	Name            string             `json:"name"`
+	Weight          int                `json:"weight"` // Percentage of traffic, all variants add up to 100
+	PromptTemplate  *PromptTemplateRef `json:"prompt_template,omitempty"`
+	Model           string             `json:"model,omitempty"`              // Empty uses the default model order
+	CostPer1KTokens float64            `json:"cost_per_1k_tokens,omitempty"` // Used to estimate the cost of each generation
+}
+
+// Experiment splits generation traffic between variants and tracks how each performs.
+type Experiment struct {
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Variants    []ExperimentVariant `json:"variants"`
+	Active      bool                `json:"active"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+// ExperimentGeneration records one generation tagged with the variant that served it.
+type ExperimentGeneration struct {
+	ID               string             `json:"id"`
+	ExperimentID     string             `json:"experiment_id"`
+	Variant          string             `json:"variant"`
+	Model            string             `json:"model,omitempty"`
+	PromptTemplate   *PromptTemplateRef `json:"prompt_template,omitempty"`
+	Response         string             `json:"response,omitempty"`
+	Error            string             `json:"error,omitempty"`
+	LatencyMs        int64              `json:"latency_ms"`
+	PromptTokens     int                `json:"prompt_tokens"`
+	CompletionTokens int                `json:"completion_tokens"`
+	Cost             float64            `json:"cost"`
+	Score            *float64           `json:"score,omitempty"` // User feedback, set later
+	Feedback         string             `json:"feedback,omitempty"`
+	CreatedAt        time.Time          `json:"created_at"`
+}
+
+// ExperimentVariantStats summarizes the generations of one variant.
+type ExperimentVariantStats struct {
+	Variant          string  `json:"variant"`
+	Weight           int     `json:"weight"`
+	Generations      int     `json:"generations"`
+	Errors           int     `json:"errors"`
+	ErrorRate        float64 `json:"error_rate"`
+	FeedbackCount    int     `json:"feedback_count"`
+	AverageScore     float64 `json:"average_score"`
+	AverageLatencyMs float64 `json:"average_latency_ms"`
+	P95LatencyMs     int64   `json:"p95_latency_ms"`
+	AverageTokens    float64 `json:"average_tokens"`
+	TotalCost        float64 `json:"total_cost"`
+	AverageCost      float64 `json:"average_cost"`
+}
+
+// ExperimentReport holds the per-variant statistics of an experiment.
+type ExperimentReport struct {
+	Experiment  Experiment               `json:"experiment"`
+	Variants    []ExperimentVariantStats `json:"variants"`
+	GeneratedAt time.Time                `json:"generated_at"`
+}
+
+// ExperimentManager holds experiments and the generations tagged with their
+// variants, in the domain database when one is available and in memory otherwise.
+type ExperimentManager struct {
+	prompts     *PromptTemplateRegistry
+	collection  *chromem.Collection // nil when running without a domain database
+	embedder    database.Embedder
+	experiments map[string]*Experiment
+	generations map[string]*ExperimentGeneration
+	byVariant   map[string]map[string][]*ExperimentGeneration // experiment ID -> variant -> generations
+	rng         *rand.Rand
+	mutex       sync.RWMutex
+}
+
+// NewExperimentManager creates an experiment manager and loads the experiments
+// stored in db. Template references in variants are checked against prompts.
+// Stored generations hold responses, so they are embedded through pii.
+func NewExperimentManager(ctx context.Context, prompts *PromptTemplateRegistry, db *database.SimpleDomainDB, pii *PIIGuardrail) (*ExperimentManager, error) {
+	m := &ExperimentManager{
+		prompts:     prompts,
+		experiments: make(map[string]*Experiment),
+		generations: make(map[string]*ExperimentGeneration),
+		byVariant:   make(map[string]map[string][]*ExperimentGeneration),
+		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if db == nil {
+		return m, nil
+	}
+
+	m.embedder = guardEmbedder(db.Embedder(), pii)
+	collection, err := db.GetOrCreateCollection(ExperimentCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open experiment collection: %w", err)
+	}
+	m.collection = collection
+	if err := m.load(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("ExperimentManager: Initialized (collection: %s, experiments: %d, generations: %d)", ExperimentCollection, len(m.experiments), len(m.generations))
+	return m, nil
+}
+
+// load reads the stored experiments and generations into memory.
+func (m *ExperimentManager) load(ctx context.Context) error {
+	count := m.collection.Count()
+	if count == 0 {
+		return nil
+	}
+	// chromem-go has no list call, so query with a generic text for every document
+	results, err := m.collection.Query(ctx, "experiment", count, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load experiments: %w", err)
+	}
+	var generations []*ExperimentGeneration
+	for _, result := range results {
+		switch result.Metadata["kind"] {
+		case "experiment":
+			var exp Experiment
+			if err := json.Unmarshal([]byte(result.Content), &exp); err != nil {
+				log.Printf("[WARN] ExperimentManager: Skipping stored experiment %s: %v", result.ID, err)
+				continue
+			}
+			m.experiments[exp.ID] = &exp
+			m.byVariant[exp.ID] = make(map[string][]*ExperimentGeneration)
+		case "generation":
+			var generation ExperimentGeneration
+			if err := json.Unmarshal([]byte(result.Content), &generation); err != nil {
+				log.Printf("[WARN] ExperimentManager: Skipping stored generation %s: %v", result.ID, err)
+				continue
+			}
+			generations = append(generations, &generation)
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].CreatedAt.Before(generations[j].CreatedAt) })
+	for _, generation := range generations {
+		variants, ok := m.byVariant[generation.ExperimentID]
+		if !ok {
+			continue // The experiment was deleted
+		}
+		m.generations[generation.ID] = generation
+		variants[generation.Variant] = append(variants[generation.Variant], generation)
+	}
+	return nil
+}
+
+// persist stores value as JSON under kind:id, tagged with its experiment.
+// Callers hold the lock.
+func (m *ExperimentManager) persist(ctx context.Context, kind, id, experimentID string, value interface{}) error {
+	if m.collection == nil {
+		return nil
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %s: %w", kind, id, err)
+	}
+	embedding, err := m.embedder.Embed(ctx, string(content))
+	if err != nil {
+		return fmt.Errorf("failed to embed %s %s: %w", kind, id, err)
+	}
+	doc := chromem.Document{
+		ID:        kind + ":" + id,
+		Content:   string(content),
+		Embedding: embedding,
+		Metadata:  map[string]string{"kind": kind, "experiment_id": experimentID},
+	}
+	if err := m.collection.AddDocument(ctx, doc); err != nil {
+		return fmt.Errorf("failed to store %s %s: %w", kind, id, err)
+	}
+	return nil
+}
+
+// Create validates and stores a new, active experiment.
+func (m *ExperimentManager) Create(ctx context.Context, exp Experiment) (*Experiment, error) {
+	if exp.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrExperimentInvalid)
+	}
+	if len(exp.Variants) < 2 {
+		return nil, fmt.Errorf("%w: at least two variants are required", ErrExperimentInvalid)
+	}
+
+	names := make(map[string]bool)
+	total := 0
+	for i, variant := range exp.Variants {
+		if variant.Name == "" {
+			return nil, fmt.Errorf("%w: variant %d has no name", ErrExperimentInvalid, i+1)
+		}
+		if names[variant.Name] {
+			return nil, fmt.Errorf("%w: duplicate variant %s", ErrExperimentInvalid, variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.Weight <= 0 {
+			return nil, fmt.Errorf("%w: variant %s needs a positive weight", ErrExperimentInvalid, variant.Name)
+		}
+		total += variant.Weight
+		if variant.PromptTemplate == nil && variant.Model == "" {
+			return nil, fmt.Errorf("%w: variant %s must set a prompt template or a model", ErrExperimentInvalid, variant.Name)
+		}
+		if variant.PromptTemplate != nil {
+			if _, err := m.prompts.Resolve(*variant.PromptTemplate); err != nil {
+				return nil, fmt.Errorf("%w: variant %s: %v", ErrExperimentInvalid, variant.Name, err)
+			}
+		}
+		if variant.CostPer1KTokens < 0 {
+			return nil, fmt.Errorf("%w: variant %s has a negative cost", ErrExperimentInvalid, variant.Name)
+		}
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("%w: variant weights add up to %d, expected 100", ErrExperimentInvalid, total)
+	}
+
+	exp.ID = uuid.New().String()
+	exp.Active = true
+	exp.CreatedAt = time.Now()
+	exp.Variants = append([]ExperimentVariant(nil), exp.Variants...)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.persist(ctx, "experiment", exp.ID, exp.ID, exp); err != nil {
+		return nil, err
+	}
+	m.experiments[exp.ID] = &exp
+	m.byVariant[exp.ID] = make(map[string][]*ExperimentGeneration)
+	log.Printf("ExperimentManager: Created experiment '%s' (%s) with %d variants", exp.Name, exp.ID, len(exp.Variants))
+	created := exp
+	return &created, nil
+}
+
+// Get returns a copy of an experiment.
+func (m *ExperimentManager) Get(id string) (*Experiment, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	exp, ok := m.experiments[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExperimentNotFound, id)
+	}
+	copied := *exp
+	return &copied, nil
+}
+
+// List returns all experiments, newest first.
+func (m *ExperimentManager) List() []Experiment {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	experiments := make([]Experiment, 0, len(m.experiments))
+	for _, exp := range m.experiments {
+		experiments = append(experiments, *exp)
+	}
+	sort.Slice(experiments, func(i, j int) bool { return experiments[i].CreatedAt.After(experiments[j].CreatedAt) })
+	return experiments
+}
+
+// SetActive starts or stops an experiment. Stopped experiments keep their results.
+func (m *ExperimentManager) SetActive(ctx context.Context, id string, active bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	exp, ok := m.experiments[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExperimentNotFound, id)
+	}
+	updated := *exp
+	updated.Active = active
+	if err := m.persist(ctx, "experiment", id, id, updated); err != nil {
+		return err
+	}
+	exp.Active = active
+	log.Printf("ExperimentManager: Experiment '%s' active: %v", exp.Name, active)
+	return nil
+}
+
+// Delete removes an experiment and its recorded generations.
+func (m *ExperimentManager) Delete(ctx context.Context, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.experiments[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrExperimentNotFound, id)
+	}
+	if m.collection != nil {
+		if err := m.collection.Delete(ctx, map[string]string{"experiment_id": id}, nil); err != nil {
+			return fmt.Errorf("failed to delete experiment %s: %w", id, err)
+		}
+	}
+	for _, generations := range m.byVariant[id] {
+		for _, generation := range generations {
+			delete(m.generations, generation.ID)
+		}
+	}
+	delete(m.byVariant, id)
+	delete(m.experiments, id)
+	log.Printf("ExperimentManager: Deleted experiment %s", id)
+	return nil
+}
+
+// ChooseVariant picks a variant by weight. A non-empty assignment key (a user
+// or session ID) always maps to the same variant; otherwise the pick is random.
+func (m *ExperimentManager) ChooseVariant(exp *Experiment, assignmentKey string) ExperimentVariant {
+	var bucket int
+	if assignmentKey != "" {
+		h := fnv.New32a()
+		h.Write([]byte(exp.ID + ":" + assignmentKey))
+		bucket = int(h.Sum32() % 100)
+	} else {
+		m.mutex.Lock()
+		bucket = m.rng.Intn(100)
+		m.mutex.Unlock()
+	}
+
+	for _, variant := range exp.Variants {
+		if bucket < variant.Weight {
+			return variant
+		}
+		bucket -= variant.Weight
+	}
+	return exp.Variants[len(exp.Variants)-1]
+}
+
+// Record stores a copy of a generation served by an experiment variant and
+// assigns its ID.
+func (m *ExperimentManager) Record(ctx context.Context, generation *ExperimentGeneration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	variants, ok := m.byVariant[generation.ExperimentID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExperimentNotFound, generation.ExperimentID)
+	}
+	generation.ID = uuid.New().String()
+	if generation.CreatedAt.IsZero() {
+		generation.CreatedAt = time.Now()
+	}
+	stored := *generation
+	if err := m.persist(ctx, "generation", stored.ID, stored.ExperimentID, stored); err != nil {
+		return err
+	}
+	m.generations[stored.ID] = &stored
+	variants[stored.Variant] = append(variants[stored.Variant], &stored)
+	return nil
+}
+
+// RecordFeedback attaches a user score (and optional comment) to a generation,
+// replacing any earlier feedback.
+func (m *ExperimentManager) RecordFeedback(ctx context.Context, generationID string, score float64, comment string) (*ExperimentGeneration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	generation, ok := m.generations[generationID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExperimentGenerationNotFound, generationID)
+	}
+	updated := *generation
+	updated.Score = &score
+	updated.Feedback = comment
+	if err := m.persist(ctx, "generation", updated.ID, updated.ExperimentID, updated); err != nil {
+		return nil, err
+	}
+	*generation = updated
+	copied := updated
+	return &copied, nil
+}
+
+// Report computes per-variant statistics for an experiment.
+func (m *ExperimentManager) Report(id string) (*ExperimentReport, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	exp, ok := m.experiments[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExperimentNotFound, id)
+	}
+
+	report := &ExperimentReport{Experiment: *exp, GeneratedAt: time.Now()}
+	for _, variant := range exp.Variants {
+		report.Variants = append(report.Variants, variantStats(variant, m.byVariant[id][variant.Name]))
+	}
+	return report, nil
+}
+
+// variantStats aggregates the generations of one variant. Latency, tokens and
+// cost are averaged over successful generations only.
+func variantStats(variant ExperimentVariant, generations []*ExperimentGeneration) ExperimentVariantStats {
+	stats := ExperimentVariantStats{Variant: variant.Name, Weight: variant.Weight, Generations: len(generations)}
+	var latencies []int64
+	var scoreSum float64
+	var tokenSum int
+	for _, generation := range generations {
+		if generation.Score != nil {
+			stats.FeedbackCount++
+			scoreSum += *generation.Score
+		}
+		if generation.Error != "" {
+			stats.Errors++
+			continue
+		}
+		latencies = append(latencies, generation.LatencyMs)
+		tokenSum += generation.PromptTokens + generation.CompletionTokens
+		stats.TotalCost += generation.Cost
+	}
+
+	if stats.Generations > 0 {
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Generations)
+	}
+	if stats.FeedbackCount > 0 {
+		stats.AverageScore = scoreSum / float64(stats.FeedbackCount)
+	}
+	if succeeded := len(latencies); succeeded > 0 {
+		var latencySum int64
+		for _, latency := range latencies {
+			latencySum += latency
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		stats.AverageLatencyMs = float64(latencySum) / float64(succeeded)
+		stats.P95LatencyMs = latencies[(succeeded*95+99)/100-1]
+		stats.AverageTokens = float64(tokenSum) / float64(succeeded)
+		stats.AverageCost = stats.TotalCost / float64(succeeded)
+	}
+	return stats
+}
@@ -0,0 +1,175 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"Agentic_Engine/database"
+)
+
+func newTestExperiment(t *testing.T, manager *ExperimentManager) *Experiment {
+	t.Helper()
+	exp, err := manager.Create(context.Background(), Experiment{
+		Name: "models",
+		Variants: []ExperimentVariant{
+			{Name: "control", Weight: 80, Model: "fake-primary"},
+			{Name: "treatment", Weight: 20, Model: "fake-fallback", CostPer1KTokens: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return exp
+}
+
+func TestExperimentVariantAssignment(t *testing.T) {
+	prompts, _ := NewPromptTemplateRegistry(context.Background(), nil)
+	manager, err := NewExperimentManager(context.Background(), prompts, nil, nil)
+	if err != nil {
+		t.Fatalf("NewExperimentManager failed: %v", err)
+	}
+	exp := newTestExperiment(t, manager)
+
+	// Random picks follow the weights
+	manager.rng = rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		counts[manager.ChooseVariant(exp, "").Name]++
+	}
+	if counts["treatment"] < 320 || counts["treatment"] > 480 {
+		t.Errorf("Expected about 400 of 2000 picks for the 20%% variant, got %v", counts)
+	}
+
+	// An assignment key always gets the same variant, and keys follow the weights
+	keyed := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		variant := manager.ChooseVariant(exp, key)
+		for j := 0; j < 3; j++ {
+			if again := manager.ChooseVariant(exp, key); again.Name != variant.Name {
+				t.Fatalf("Expected %s to stay on %s, got %s", key, variant.Name, again.Name)
+			}
+		}
+		keyed[variant.Name]++
+	}
+	if keyed["treatment"] < 150 || keyed["treatment"] > 250 {
+		t.Errorf("Expected about 200 of 1000 keys on the 20%% variant, got %v", keyed)
+	}
+
+	if _, err := manager.Create(context.Background(), Experiment{Name: "bad", Variants: []ExperimentVariant{
+		{Name: "a", Weight: 50, Model: "x"}, {Name: "b", Weight: 40, Model: "y"},
+	}}); !errors.Is(err, ErrExperimentInvalid) {
+		t.Errorf("Expected weights not adding up to 100 to be rejected, got %v", err)
+	}
+}
+
+func TestExperimentVariantStats(t *testing.T) {
+	var generations []*ExperimentGeneration
+	for i := 1; i <= 20; i++ {
+		generations = append(generations, &ExperimentGeneration{LatencyMs: int64(i * 10), PromptTokens: 10, CompletionTokens: 20, Cost: 0.5})
+	}
+	score := 4.0
+	generations[0].Score = &score
+	// Failed generations count as errors but not towards latency, tokens or cost
+	generations = append(generations, &ExperimentGeneration{Error: "timeout", LatencyMs: 60000, Score: &score})
+
+	stats := variantStats(ExperimentVariant{Name: "control", Weight: 80}, generations)
+	if stats.Generations != 21 || stats.Errors != 1 || stats.FeedbackCount != 2 || stats.AverageScore != 4 {
+		t.Errorf("Unexpected counts: %+v", stats)
+	}
+	if stats.P95LatencyMs != 190 || stats.AverageLatencyMs != 105 {
+		t.Errorf("Expected a p95 of 190ms and an average of 105ms, got %+v", stats)
+	}
+	if stats.AverageTokens != 30 || stats.TotalCost != 10 || stats.AverageCost != 0.5 {
+		t.Errorf("Unexpected token and cost averages: %+v", stats)
+	}
+
+	single := variantStats(ExperimentVariant{Name: "treatment"}, generations[:1])
+	if single.P95LatencyMs != 10 {
+		t.Errorf("Expected the only latency as p95, got %d", single.P95LatencyMs)
+	}
+	if empty := variantStats(ExperimentVariant{Name: "treatment"}, nil); empty.P95LatencyMs != 0 || empty.ErrorRate != 0 {
+		t.Errorf("Expected zero stats without generations, got %+v", empty)
+	}
+}
+
+func TestExperimentsBypassCacheAndPersist(t *testing.T) {
+	t.Setenv("SEMANTIC_CACHE_THRESHOLD", "0.9")
+	path := filepath.Join(t.TempDir(), "domain.db")
+	db, err := database.NewSimpleDomainDBWithEmbedder(path, database.NewHashEmbedder(0))
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	service := startFakeInferenceServiceWithDB(t, `{
+		"rules": [
+			{"match": "capital", "model": "fake-primary", "response": "cached answer", "times": 1},
+			{"match": "capital", "model": "fake-fallback", "response": "cached answer", "times": 1},
+			{"match": "capital", "response": "fresh answer"}
+		]
+	}`, db)
+	ctx := context.Background()
+	exp := newTestExperiment(t, service.Experiments())
+
+	// Prime the cache for both variants' models
+	for _, model := range []string{"fake-primary", "fake-fallback"} {
+		if _, err := service.GenerateTextWithMetadata(ctx, "", model, "What is the capital of France?", ""); err != nil {
+			t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+		}
+	}
+	generation, err := service.GenerateWithExperiment(ctx, ExperimentRequest{ExperimentID: exp.ID, AssignmentKey: "user-1", Prompt: "What is the capital of France?"})
+	if err != nil {
+		t.Fatalf("GenerateWithExperiment failed: %v", err)
+	}
+	if generation.Response != "fresh answer" {
+		t.Errorf("Expected the experiment to bypass the semantic cache, got %q", generation.Response)
+	}
+	if _, err := service.Experiments().RecordFeedback(ctx, generation.ID, 5, "great"); err != nil {
+		t.Fatalf("RecordFeedback failed: %v", err)
+	}
+	if err := service.Experiments().SetActive(ctx, exp.ID, false); err != nil {
+		t.Fatalf("SetActive failed: %v", err)
+	}
+	db.Close()
+
+	// After a restart the experiment and its scored generation are still there
+	db, err = database.NewSimpleDomainDBWithEmbedder(path, database.NewHashEmbedder(0))
+	if err != nil {
+		t.Fatalf("Reopening the domain database failed: %v", err)
+	}
+	defer db.Close()
+	prompts, _ := NewPromptTemplateRegistry(ctx, nil)
+	restarted, err := NewExperimentManager(ctx, prompts, db, nil)
+	if err != nil {
+		t.Fatalf("NewExperimentManager after reopening failed: %v", err)
+	}
+	report, err := restarted.Report(exp.ID)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if report.Experiment.Active {
+		t.Errorf("Expected the experiment to stay stopped")
+	}
+	total, scored := 0, 0
+	for _, stats := range report.Variants {
+		total += stats.Generations
+		scored += stats.FeedbackCount
+	}
+	if total != 1 || scored != 1 {
+		t.Errorf("Expected one scored generation after the restart, got %+v", report.Variants)
+	}
+
+	if err := restarted.Delete(ctx, exp.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	again, err := NewExperimentManager(ctx, prompts, db, nil)
+	if err != nil {
+		t.Fatalf("NewExperimentManager failed: %v", err)
+	}
+	if _, err := again.Get(exp.ID); !errors.Is(err, ErrExperimentNotFound) {
+		t.Errorf("Expected a deleted experiment to stay deleted, got %v", err)
+	}
+}
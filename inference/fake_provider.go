@@ -0,0 +1,384 @@
+package inference
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/guiperry/gollm_cerebras/config"
+	"github.com/guiperry/gollm_cerebras/providers"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+	"github.com/guiperry/gollm_cerebras/utils"
+)
+
+// FakeProviderName is the provider name of scripted, offline LLM attempts.
+const FakeProviderName = "fake"
+
+// FakeRule scripts one kind of answer. A rule applies when Match (a regular
+// expression, empty for any prompt) matches the prompt and Model, when set,
+// names the model being called.
+type FakeRule struct {
+	Match string `json:"match"`
+	Model string `json:"model,omitempty"`
+	// Response is expanded with the Match submatches, so "$1" echoes the first group.
+	Response  string `json:"response"`
+	LatencyMs int    `json:"latency_ms,omitempty"`
+	// Error injects a failure instead of a response: "context_length_exceeded",
+	// "timeout", an HTTP status code such as "503", or any other message.
+	Error string `json:"error,omitempty"`
+	// Times limits how often the rule applies; 0 means always.
+	Times int `json:"times,omitempty"`
+	// ToolCalls answers with native calls to tools offered with the request.
+	ToolCalls []FakeToolCall `json:"tool_calls,omitempty"`
+
+	pattern *regexp.Regexp
+	used    int
+}
+
+// FakeToolCall is a scripted call to a tool.
+type FakeToolCall struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// FakeScript is the rule set shared by all fake attempts of a service.
+type FakeScript struct {
+	Rules []*FakeRule `json:"rules"`
+	// Default answers prompts that no rule matches.
+	Default string `json:"default"`
+
+	mutex sync.Mutex
+}
+
+// LoadFakeScript reads a JSON script from path.
+func LoadFakeScript(path string) (*FakeScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fake script '%s': %w", path, err)
+	}
+	var script FakeScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse fake script '%s': %w", path, err)
+	}
+	if err := script.compile(); err != nil {
+		return nil, fmt.Errorf("invalid fake script '%s': %w", path, err)
+	}
+	return &script, nil
+}
+
+func (s *FakeScript) compile() error {
+	for i, rule := range s.Rules {
+		pattern, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return fmt.Errorf("rule %d: invalid match pattern: %w", i+1, err)
+		}
+		rule.pattern = pattern
+	}
+	return nil
+}
+
+// next picks the rule for a call and consumes one of its uses. It returns nil
+// when no rule applies.
+func (s *FakeScript) next(model, input string) (*FakeRule, []int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, rule := range s.Rules {
+		if rule.Model != "" && rule.Model != model {
+			continue
+		}
+		if rule.Times > 0 && rule.used >= rule.Times {
+			continue
+		}
+		match := rule.pattern.FindStringSubmatchIndex(input)
+		if match == nil {
+			continue
+		}
+		rule.used++
+		return rule, match
+	}
+	return nil, nil
+}
+
+// fakeScriptFromEnv loads the script named by FAKE_LLM_SCRIPT and serves it
+// to the fake provider, replacing what an earlier Start loaded from the same
+// file. It reports false when the variable is unset.
+func fakeScriptFromEnv() (bool, error) {
+	path := os.Getenv("FAKE_LLM_SCRIPT")
+	if path == "" {
+		return false, nil
+	}
+	script, err := LoadFakeScript(path)
+	if err != nil {
+		return true, err
+	}
+	if _, err := serveFakeScript(path, script); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// fakeAttemptConfigs replaces the real providers when a fake script is loaded:
+// one primary and one fallback, so fallback paths can be scripted by model.
+func fakeAttemptConfigs() []LLMAttemptConfig {
+	return []LLMAttemptConfig{
+		{ProviderName: FakeProviderName, ModelName: "fake-primary", DefaultAPIKey: fakeAPIKey, MaxTokens: 4000, IsPrimary: true, NoRetries: true},
+		{ProviderName: FakeProviderName, ModelName: "fake-fallback", DefaultAPIKey: fakeAPIKey, MaxTokens: 8000, IsPrimary: false, NoRetries: true},
+	}
+}
+
+// fakeAPIKey stands in for an API key; the fake provider ignores it.
+const fakeAPIKey = "fake-provider-placeholder-key"
+
+// fakeServer answers fake provider requests from a script over loopback HTTP.
+// The fake provider is registered with gollm like the real providers and
+// reaches its script this way, so attempts, MOA, multimodal requests and
+// cassettes all handle it like any other provider.
+type fakeServer struct {
+	url string
+
+	mutex  sync.RWMutex
+	script *FakeScript
+}
+
+// fakeServers holds one server per script file for the life of the process.
+var (
+	fakeServers      = make(map[string]*fakeServer)
+	fakeServersMutex sync.Mutex
+)
+
+// serveFakeScript serves script for the script file at path, starting the
+// file's server on first use.
+func serveFakeScript(path string, script *FakeScript) (*fakeServer, error) {
+	fakeServersMutex.Lock()
+	defer fakeServersMutex.Unlock()
+	server, ok := fakeServers[path]
+	if !ok {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to start the fake provider: %w", err)
+		}
+		server = &fakeServer{url: "http://" + listener.Addr().String()}
+		go http.Serve(listener, server)
+		fakeServers[path] = server
+	}
+	server.mutex.Lock()
+	server.script = script
+	server.mutex.Unlock()
+	return server, nil
+}
+
+// fakeServerFor returns the server of the script file at path, loading the
+// script if no Start has served it yet.
+func fakeServerFor(path string) (*fakeServer, error) {
+	if path == "" {
+		return nil, errors.New("FAKE_LLM_SCRIPT is not set")
+	}
+	fakeServersMutex.Lock()
+	server, ok := fakeServers[path]
+	fakeServersMutex.Unlock()
+	if ok {
+		return server, nil
+	}
+	script, err := LoadFakeScript(path)
+	if err != nil {
+		return nil, err
+	}
+	return serveFakeScript(path, script)
+}
+
+type fakeRequest struct {
+	Model  string   `json:"model"`
+	Prompt string   `json:"prompt"`
+	Tools  []string `json:"tools,omitempty"` // Names of the offered tools
+}
+
+type fakeResponse struct {
+	Response  string         `json:"response,omitempty"`
+	ToolCalls []FakeToolCall `json:"tool_calls,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// ServeHTTP answers with the first applicable rule, after its latency.
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request fakeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid fake request: %v", err), http.StatusBadRequest)
+		return
+	}
+	s.mutex.RLock()
+	script := s.script
+	s.mutex.RUnlock()
+
+	status, response := http.StatusOK, fakeResponse{Response: script.Default}
+	if rule, match := script.next(request.Model, request.Prompt); rule != nil {
+		if rule.LatencyMs > 0 {
+			timer := time.NewTimer(time.Duration(rule.LatencyMs) * time.Millisecond)
+			defer timer.Stop()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+			}
+		}
+		if rule.Error != "" {
+			status, response = fakeError(request.Model, rule.Error)
+		} else if len(rule.ToolCalls) > 0 {
+			status, response = fakeToolCalls(request, rule.ToolCalls)
+		} else {
+			response.Response = string(rule.pattern.ExpandString(nil, rule.Response, request.Prompt, match))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// fakeError phrases an injected error the way provider errors read, so
+// shouldFallbackOnError treats it like the real thing. Status codes, context
+// length errors and timeouts are answered with their HTTP status; any other
+// message comes back in a 200 response and fails when it is parsed.
+func fakeError(model, injected string) (int, fakeResponse) {
+	switch injected {
+	case "context_length_exceeded":
+		return http.StatusBadRequest, fakeResponse{Error: fmt.Sprintf("fake provider (%s): context_length_exceeded: prompt exceeds the model's token limit", model)}
+	case "timeout":
+		return http.StatusGatewayTimeout, fakeResponse{Error: fmt.Sprintf("fake provider (%s): request timeout", model)}
+	}
+	if code, err := strconv.Atoi(injected); err == nil && http.StatusText(code) != "" {
+		return code, fakeResponse{Error: fmt.Sprintf("fake provider (%s): %s", model, http.StatusText(code))}
+	}
+	return http.StatusOK, fakeResponse{Error: fmt.Sprintf("fake provider (%s): %s", model, injected)}
+}
+
+// fakeToolCalls answers with calls to tools, which must have been offered.
+func fakeToolCalls(request fakeRequest, calls []FakeToolCall) (int, fakeResponse) {
+	for _, call := range calls {
+		offered := false
+		for _, name := range request.Tools {
+			offered = offered || name == call.Name
+		}
+		if !offered {
+			return http.StatusBadRequest, fakeResponse{Error: fmt.Sprintf("fake provider (%s): tool %s was not offered", request.Model, call.Name)}
+		}
+	}
+	return http.StatusOK, fakeResponse{ToolCalls: calls}
+}
+
+// --- Registration ---
+func init() {
+	providers.GetDefaultRegistry().Register(FakeProviderName, NewFakeProvider)
+}
+
+// FakeProvider implements providers.Provider for the scripted fake models. It
+// sends the flattened prompt and model name to the server of the script named
+// by FAKE_LLM_SCRIPT.
+type FakeProvider struct {
+	model  string
+	server *fakeServer
+	err    error // Why no script is available, reported by every request
+	logger utils.Logger
+}
+
+// NewFakeProvider creates a fake provider for model. The API key is ignored.
+func NewFakeProvider(apiKey, model string, extraHeaders map[string]string) providers.Provider {
+	server, err := fakeServerFor(os.Getenv("FAKE_LLM_SCRIPT"))
+	return &FakeProvider{model: model, server: server, err: err, logger: utils.NewLogger(utils.LogLevelWarn)}
+}
+
+func (p *FakeProvider) Name() string { return FakeProviderName }
+
+func (p *FakeProvider) Endpoint() string {
+	if p.server == nil {
+		return ""
+	}
+	return p.server.url + "/generate"
+}
+
+func (p *FakeProvider) Headers() map[string]string {
+	return map[string]string{"Content-Type": "application/json"}
+}
+
+func (p *FakeProvider) PrepareRequest(prompt string, options map[string]interface{}) ([]byte, error) {
+	if p.err != nil {
+		return nil, fmt.Errorf("fake provider (%s): %w", p.model, p.err)
+	}
+	request := fakeRequest{Model: p.model, Prompt: prompt}
+	if tools, ok := options["tools"].([]utils.Tool); ok {
+		for _, tool := range tools {
+			request.Tools = append(request.Tools, tool.Function.Name)
+		}
+	}
+	return json.Marshal(request)
+}
+
+// PrepareRequestWithSchema appends the schema to the prompt; the script is
+// expected to return schema-conforming JSON.
+func (p *FakeProvider) PrepareRequestWithSchema(prompt string, options map[string]interface{}, schema interface{}) ([]byte, error) {
+	return p.PrepareRequest(promptWithSchema(prompt, schema), options)
+}
+
+func (p *FakeProvider) PrepareRequestWithMessages(messages []gollm_types.MemoryMessage, options map[string]interface{}) ([]byte, error) {
+	return p.PrepareRequest(formatMultimodalMessages(messages), options)
+}
+
+// SupportsContentPart accepts any attachment; scripts see attachments
+// described in the prompt text (see formatMultimodalMessages).
+func (p *FakeProvider) SupportsContentPart(part ContentPart) bool { return true }
+
+func (p *FakeProvider) PrepareMultimodalRequest(messages []gollm_types.MemoryMessage, options map[string]interface{}) ([]byte, error) {
+	return p.PrepareRequestWithMessages(messages, options)
+}
+
+func (p *FakeProvider) ParseResponse(body []byte) (string, error) {
+	var response fakeResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal fake response: %w", err)
+	}
+	if response.Error != "" {
+		return "", errors.New(response.Error)
+	}
+	return response.Response, nil
+}
+
+func (p *FakeProvider) SetDefaultOptions(cfg *config.Config) {
+	if cfg != nil && cfg.Model != "" {
+		p.model = cfg.Model
+	}
+}
+
+// HandleFunctionCalls returns scripted tool calls as gollm tools.
+func (p *FakeProvider) HandleFunctionCalls(body []byte) ([]byte, error) {
+	var response fakeResponse
+	if err := json.Unmarshal(body, &response); err != nil || len(response.ToolCalls) == 0 {
+		return body, nil // Not a response with tool calls
+	}
+	calls := make([]utils.Tool, 0, len(response.ToolCalls))
+	for _, call := range response.ToolCalls {
+		calls = append(calls, utils.Tool{Type: "function", Function: utils.Function{Name: call.Name, Parameters: call.Arguments}})
+	}
+	return json.Marshal(calls)
+}
+
+func (p *FakeProvider) SetExtraHeaders(extraHeaders map[string]string) {}
+func (p *FakeProvider) SupportsJSONSchema() bool                       { return false }
+func (p *FakeProvider) SetOption(key string, value interface{})        {}
+func (p *FakeProvider) SetLogger(logger utils.Logger)                  { p.logger = logger }
+func (p *FakeProvider) SupportsStreaming() bool                        { return false }
+
+func (p *FakeProvider) PrepareStreamRequest(prompt string, options map[string]interface{}) ([]byte, error) {
+	return nil, fmt.Errorf("fake provider (%s): streaming is not supported", p.model)
+}
+
+func (p *FakeProvider) ParseStreamResponse(chunk []byte) (string, error) {
+	return "", fmt.Errorf("fake provider (%s): streaming is not supported", p.model)
+}
+
+// --- Compile-time Interface Check ---
+var _ providers.Provider = (*FakeProvider)(nil)
@@ -0,0 +1,102 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Agentic_Engine/database"
+)
+
+// startFakeInferenceService starts a service on the fake provider with script.
+func startFakeInferenceService(t *testing.T, script string) *InferenceService {
+	t.Helper()
+	return startFakeInferenceServiceWithDB(t, script, nil)
+}
+
+// startFakeInferenceServiceWithDB is startFakeInferenceService with a domain
+// database, which enables the features that need vector collections.
+func startFakeInferenceServiceWithDB(t *testing.T, script string, db *database.SimpleDomainDB) *InferenceService {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	t.Setenv("FAKE_LLM_SCRIPT", path)
+	t.Setenv("CHUNK_SEQUENTIAL_DELAY", "0") // Scripted models have no rate limit
+	service, err := NewInferenceService(db)
+	if err != nil {
+		t.Fatalf("NewInferenceService failed: %v", err)
+	}
+	if err := service.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { service.Stop() })
+	return service
+}
+
+func TestFakeProviderFallsBackOnInjectedErrors(t *testing.T) {
+	service := startFakeInferenceService(t, `{
+		"default": "default answer",
+		"rules": [
+			{"match": "flaky", "model": "fake-primary", "error": "503"},
+			{"match": "flaky", "model": "fake-fallback", "response": "from fallback"},
+			{"match": "echo (\\w+)", "response": "you said $1"},
+			{"match": "once", "error": "context_length_exceeded", "times": 1},
+			{"match": "once", "response": "second try"}
+		]
+	}`)
+	ctx := context.Background()
+
+	result, err := service.GenerateTextWithMetadata(ctx, "", "", "a flaky request", "")
+	if err != nil {
+		t.Fatalf("Expected fallback to succeed, got %v", err)
+	}
+	if result.Content != "from fallback" {
+		t.Errorf("Expected 'from fallback', got %q", result.Content)
+	}
+
+	if text, err := service.GenerateText(ctx, "", "", "please echo hello", ""); err != nil || text != "you said hello" {
+		t.Errorf("Expected 'you said hello', got %q (%v)", text, err)
+	}
+	if text, err := service.GenerateText(ctx, "", "", "try once", ""); err != nil || text != "second try" {
+		t.Errorf("Expected the exhausted rule to fall through to 'second try', got %q (%v)", text, err)
+	}
+	if text, err := service.GenerateText(ctx, "", "", "anything else", ""); err != nil || text != "default answer" {
+		t.Errorf("Expected 'default answer', got %q (%v)", text, err)
+	}
+}
+
+func TestFakeProviderLatencyHonorsContext(t *testing.T) {
+	service := startFakeInferenceService(t, `{"rules": [{"response": "slow", "latency_ms": 1000}]}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := service.GenerateText(ctx, "", "fake-primary", "hi", ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if status, response := fakeError("m", "context_length_exceeded"); status != http.StatusBadRequest || !strings.Contains(response.Error, "context_length_exceeded") {
+		t.Errorf("Expected a 400 context length error, got %d %q", status, response.Error)
+	}
+}
+
+func TestFakeProviderRunsMOA(t *testing.T) {
+	service := startFakeInferenceService(t, `{
+		"default": "plain answer",
+		"rules": [
+			{"match": "^Synthesise these responses", "model": "fake-fallback", "response": "synthesised answer"},
+			{"match": "step-by-step", "response": "a layer's reasoning"}
+		]
+	}`)
+	response, err := service.GenerateTextWithCoT(context.Background(), "", "Why is the sky blue?")
+	if err != nil {
+		t.Fatalf("GenerateTextWithCoT failed: %v", err)
+	}
+	if response != "synthesised answer" {
+		t.Errorf("Expected the MOA aggregator's answer, got %q", response)
+	}
+}
@@ -42,8 +42,9 @@ type GeminiProvider struct {
 
 // --- Gemini API Request/Response Structs (Manual HTTP) ---
 type GeminiRequest struct {
-	Contents         []GeminiContent         `json:"contents"`
-	GenerationConfig *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
 	// SafetySettings, Tools, etc. can be added here if needed
 }
 
@@ -53,8 +54,15 @@ type GeminiContent struct {
 }
 
 type GeminiPart struct {
-	Text string `json:"text"`
-	// InlineData, FunctionCall, etc. can be added here
+	Text       string            `json:"text,omitempty"`
+	InlineData *GeminiInlineData `json:"inlineData,omitempty"`
+	// FunctionCall, etc. can be added here
+}
+
+// GeminiInlineData is a base64-encoded image or file sent with the prompt.
+type GeminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
 }
 
 // init registers the Gemini provider with the gollm registry.
@@ -70,6 +78,8 @@ type GeminiGenerationConfig struct {
 	TopK            *int32   `json:"topK,omitempty"`
 	MaxOutputTokens *int32   `json:"maxOutputTokens,omitempty"`
 	// StopSequences []string `json:"stopSequences,omitempty"`
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"` // "application/json" for structured output
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`   // OpenAPI subset, see toGeminiSchema
 }
 
 type GeminiResponse struct {
@@ -179,6 +189,12 @@ func (p *GeminiProvider) Headers() map[string]string {
 
 // PrepareRequest creates the request body for a standard API call.
 func (p *GeminiProvider) PrepareRequest(prompt string, options map[string]interface{}) ([]byte, error) {
+	return p.prepareRequest(prompt, options, nil)
+}
+
+// prepareRequest builds the request body. A non-nil responseSchema (already
+// converted by toGeminiSchema) asks for JSON output matching it.
+func (p *GeminiProvider) prepareRequest(prompt string, options map[string]interface{}, responseSchema map[string]interface{}) ([]byte, error) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
@@ -203,16 +219,134 @@ func (p *GeminiProvider) PrepareRequest(prompt string, options map[string]interf
 		},
 	}
 	// TODO: Apply options from the 'options' map to reqBody.GenerationConfig
+	if responseSchema != nil {
+		reqBody.GenerationConfig.ResponseMimeType = "application/json"
+		reqBody.GenerationConfig.ResponseSchema = responseSchema
+	}
 
 	// Marshal the request body
 	jsonBytes, err := json.Marshal(reqBody)
 	return jsonBytes, err
 }
 
-// PrepareRequestWithSchema creates a request with JSON schema validation.
+// PrepareRequestWithSchema creates a request that constrains the response to
+// JSON matching the schema via generationConfig.responseSchema.
 func (p *GeminiProvider) PrepareRequestWithSchema(prompt string, options map[string]interface{}, schema interface{}) ([]byte, error) {
-	// Gemini supports structured output, but we'll implement this in a basic way for now
-	return p.PrepareRequest(prompt, options)
+	schemaDoc, err := geminiSchemaDocument(schema)
+	if err != nil {
+		return nil, err
+	}
+	return p.prepareRequest(prompt, options, toGeminiSchema(schemaDoc, schemaDoc, 0))
+}
+
+// geminiSchemaDocument decodes schema into a generic JSON document. Raw JSON
+// is decoded directly, since jsonschema.Schema cannot hold type lists such as
+// ["string", "null"]; Go values are reflected.
+func geminiSchemaDocument(schema interface{}) (map[string]interface{}, error) {
+	var schemaJSON []byte
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		return s, nil
+	case string:
+		schemaJSON = []byte(s)
+	case []byte:
+		schemaJSON = s
+	case json.RawMessage:
+		schemaJSON = s
+	default:
+		schemaPtr, err := toJSONSchema(schema)
+		if err != nil {
+			return nil, err
+		}
+		if schemaJSON, err = json.Marshal(schemaPtr); err != nil {
+			return nil, fmt.Errorf("failed to marshal schema: %w", err)
+		}
+	}
+	var schemaDoc map[string]interface{}
+	if err := json.Unmarshal(schemaJSON, &schemaDoc); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON schema: %w", err)
+	}
+	return schemaDoc, nil
+}
+
+// geminiSchemaKeywords are the JSON schema keywords accepted by Gemini's
+// responseSchema (an OpenAPI 3.0 subset). Other keywords are dropped.
+var geminiSchemaKeywords = map[string]bool{
+	"type": true, "format": true, "description": true, "nullable": true, "enum": true,
+	"properties": true, "required": true, "items": true, "minItems": true, "maxItems": true,
+	"minimum": true, "maximum": true, "anyOf": true, "propertyOrdering": true,
+}
+
+// toGeminiSchema converts a JSON schema into Gemini's responseSchema format:
+// local $ref are inlined, type lists become a single type plus nullable,
+// types are upper-cased and unsupported keywords are removed.
+func toGeminiSchema(node map[string]interface{}, root map[string]interface{}, depth int) map[string]interface{} {
+	if depth > 32 {
+		return map[string]interface{}{"type": "OBJECT"} // Recursive schemas are truncated
+	}
+	if ref, ok := node["$ref"].(string); ok && (ref == "#" || strings.HasPrefix(ref, "#/")) {
+		var target interface{} = root
+		if ref != "#" {
+			for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				if object, ok := target.(map[string]interface{}); ok {
+					target = object[part]
+				}
+			}
+		}
+		if resolved, ok := target.(map[string]interface{}); ok {
+			return toGeminiSchema(resolved, root, depth+1)
+		}
+	}
+
+	result := make(map[string]interface{})
+	for key, value := range node {
+		if !geminiSchemaKeywords[key] {
+			continue
+		}
+		switch key {
+		case "type":
+			switch t := value.(type) {
+			case string:
+				result["type"] = strings.ToUpper(t)
+			case []interface{}:
+				for _, item := range t {
+					name, _ := item.(string)
+					if name == "null" {
+						result["nullable"] = true
+					} else if name != "" && result["type"] == nil {
+						result["type"] = strings.ToUpper(name)
+					}
+				}
+			}
+		case "properties":
+			if properties, ok := value.(map[string]interface{}); ok {
+				converted := make(map[string]interface{}, len(properties))
+				for name, property := range properties {
+					if propertySchema, ok := property.(map[string]interface{}); ok {
+						converted[name] = toGeminiSchema(propertySchema, root, depth+1)
+					}
+				}
+				result["properties"] = converted
+			}
+		case "items":
+			if items, ok := value.(map[string]interface{}); ok {
+				result["items"] = toGeminiSchema(items, root, depth+1)
+			}
+		case "anyOf":
+			if variants, ok := value.([]interface{}); ok {
+				converted := make([]interface{}, 0, len(variants))
+				for _, variant := range variants {
+					if variantSchema, ok := variant.(map[string]interface{}); ok {
+						converted = append(converted, toGeminiSchema(variantSchema, root, depth+1))
+					}
+				}
+				result["anyOf"] = converted
+			}
+		default:
+			result[key] = value
+		}
+	}
+	return result
 }
 
 // PrepareRequestWithMessages handles messages for conversation.
@@ -243,6 +377,56 @@ func (p *GeminiProvider) PrepareRequestWithMessages(messages []types.MemoryMessa
 	return jsonBytes, err
 }
 
+// SupportsContentPart reports whether Gemini accepts the part inline.
+func (p *GeminiProvider) SupportsContentPart(part ContentPart) bool {
+	for _, prefix := range []string{"image/", "audio/", "video/", "text/", "application/pdf"} {
+		if strings.HasPrefix(part.MIMEType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrepareMultimodalRequest is PrepareRequestWithMessages with each message's
+// attachments sent as inlineData parts and system messages as the system
+// instruction.
+func (p *GeminiProvider) PrepareMultimodalRequest(messages []types.MemoryMessage, options map[string]interface{}) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	log.Printf("GeminiProvider: Preparing multimodal request for model %s", p.model)
+
+	reqBody := GeminiRequest{Contents: make([]GeminiContent, 0, len(messages))}
+	for _, msg := range messages {
+		parts := make([]GeminiPart, 0, 1)
+		if msg.Content != "" {
+			parts = append(parts, GeminiPart{Text: msg.Content})
+		}
+		for _, part := range contentParts(msg) {
+			parts = append(parts, GeminiPart{InlineData: &GeminiInlineData{MimeType: part.MIMEType, Data: part.Data}})
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		switch strings.ToLower(msg.Role) {
+		case "system":
+			if reqBody.SystemInstruction == nil {
+				reqBody.SystemInstruction = &GeminiContent{}
+			}
+			reqBody.SystemInstruction.Parts = append(reqBody.SystemInstruction.Parts, parts...)
+		case "assistant", "ai", "model":
+			reqBody.Contents = append(reqBody.Contents, GeminiContent{Role: "model", Parts: parts})
+		default:
+			reqBody.Contents = append(reqBody.Contents, GeminiContent{Role: "user", Parts: parts})
+		}
+	}
+	if p.maxTokens > 0 {
+		maxTokens := int32(p.maxTokens)
+		reqBody.GenerationConfig = &GeminiGenerationConfig{MaxOutputTokens: &maxTokens, Temperature: p.temperature}
+	}
+	return json.Marshal(reqBody)
+}
+
 // ParseResponse extracts the generated text from the API response.
 func (p *GeminiProvider) ParseResponse(body []byte) (string, error) {
 	// This method won't be used directly since we're using the client library
@@ -289,8 +473,8 @@ func (p *GeminiProvider) HandleFunctionCalls(body []byte) ([]byte, error) {
 
 // SupportsJSONSchema indicates whether the provider supports native JSON schema validation.
 func (p *GeminiProvider) SupportsJSONSchema() bool {
-	// Gemini's function calling is the way to get structured output, not direct JSON schema in response_format yet.
-	return false // Set to false for now, can be true if function calling is implemented
+	// Structured output is requested through generationConfig.responseSchema
+	return true
 }
 
 // SetDefaultOptions configures provider-specific defaults.
@@ -0,0 +1,143 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/guiperry/gollm_cerebras"
+	"github.com/guiperry/gollm_cerebras/config"
+)
+
+const geminiTestSchema = `{
+	"$defs": {"tag": {"type": "string", "pattern": "^[a-z]+$"}},
+	"type": "object",
+	"additionalProperties": false,
+	"properties": {
+		"title": {"type": "string", "description": "Post title"},
+		"rating": {"type": ["integer", "null"], "minimum": 1, "maximum": 5},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 3}
+	},
+	"required": ["title"]
+}`
+
+func TestToGeminiSchema(t *testing.T) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(geminiTestSchema), &schema); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	converted := toGeminiSchema(schema, schema, 0)
+	encoded, _ := json.Marshal(converted)
+	expected := `{"properties":{"rating":{"maximum":5,"minimum":1,"nullable":true,"type":"INTEGER"},` +
+		`"tags":{"items":{"type":"STRING"},"maxItems":3,"type":"ARRAY"},` +
+		`"title":{"description":"Post title","type":"STRING"}},"required":["title"],"type":"OBJECT"}`
+	if string(encoded) != expected {
+		t.Errorf("Unexpected Gemini schema:\n got  %s\n want %s", encoded, expected)
+	}
+
+	// Recursive references are cut off instead of looping
+	recursive := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"next": map[string]interface{}{"$ref": "#"}},
+	}
+	node := toGeminiSchema(recursive, recursive, 0)
+	levels := 0
+	for {
+		properties, ok := node["properties"].(map[string]interface{})
+		if !ok {
+			break
+		}
+		node = properties["next"].(map[string]interface{})
+		levels++
+	}
+	if node["type"] != "OBJECT" || levels > 33 {
+		t.Errorf("Expected a recursive schema to be truncated to an object, got %v after %d levels", node, levels)
+	}
+}
+
+func TestGeminiPrepareRequestWithSchema(t *testing.T) {
+	provider := NewGeminiProvider("test-gemini-key", "gemini-test", nil)
+	options := map[string]interface{}{"max_tokens": 100}
+	body, err := provider.PrepareRequestWithSchema("Describe the post", options, geminiTestSchema)
+	if err != nil {
+		t.Fatalf("PrepareRequestWithSchema failed: %v", err)
+	}
+	if len(options) != 1 {
+		t.Errorf("Expected the caller's options to be left alone, got %v", options)
+	}
+	var request GeminiRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	generation := request.GenerationConfig
+	if generation == nil || generation.ResponseMimeType != "application/json" {
+		t.Fatalf("Expected a JSON response MIME type, got %+v", generation)
+	}
+	rating := generation.ResponseSchema["properties"].(map[string]interface{})["rating"].(map[string]interface{})
+	if rating["type"] != "INTEGER" || rating["nullable"] != true {
+		t.Errorf("Expected the nullable rating in responseSchema, got %v", generation.ResponseSchema)
+	}
+
+	// A plain request asks for no schema
+	body, err = provider.PrepareRequest("Describe the post", nil)
+	if err != nil {
+		t.Fatalf("PrepareRequest failed: %v", err)
+	}
+	request = GeminiRequest{}
+	json.Unmarshal(body, &request)
+	if request.GenerationConfig != nil && (request.GenerationConfig.ResponseMimeType != "" || request.GenerationConfig.ResponseSchema != nil) {
+		t.Errorf("Expected no structured output settings, got %+v", request.GenerationConfig)
+	}
+}
+
+func TestGeminiStructuredOutputRequest(t *testing.T) {
+	var request GeminiRequest
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "{\"title\": \"Hello\"}"}]}}]}`))
+	}))
+	defer server.Close()
+	t.Setenv("GEMINI_API_ENDPOINT", server.URL+"/v1beta/")
+
+	instance, err := gollm.NewLLM(
+		config.SetProvider("gemini"),
+		config.SetAPIKey("test-gemini-key-0123456789abcdef"),
+		config.SetModel("gemini-test"),
+		config.SetMaxRetries(0),
+	)
+	if err != nil {
+		t.Fatalf("NewLLM failed: %v", err)
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"title": map[string]interface{}{"type": "string"}},
+		"required":   []interface{}{"title"},
+	}
+	response, err := instance.GenerateWithSchema(context.Background(), instance.NewPrompt("Describe the post"), schema)
+	if err != nil {
+		t.Fatalf("GenerateWithSchema failed: %v", err)
+	}
+	if response != `{"title": "Hello"}` {
+		t.Errorf("Expected the JSON response, got %q", response)
+	}
+
+	if gotPath != "/v1beta/models/gemini-test:generateContent" {
+		t.Errorf("Expected the generateContent endpoint, got %q", gotPath)
+	}
+	generation := request.GenerationConfig
+	if generation == nil || generation.ResponseMimeType != "application/json" {
+		t.Fatalf("Expected a JSON response MIME type, got %+v", generation)
+	}
+	title, _ := generation.ResponseSchema["properties"].(map[string]interface{})["title"].(map[string]interface{})
+	if generation.ResponseSchema["type"] != "OBJECT" || title["type"] != "STRING" {
+		t.Errorf("Expected the converted schema in responseSchema, got %v", generation.ResponseSchema)
+	}
+	if len(request.Contents) != 1 || len(request.Contents[0].Parts) != 1 || request.Contents[0].Parts[0].Text == "" {
+		t.Errorf("Expected the prompt as a single user part, got %+v", request.Contents)
+	}
+}
@@ -0,0 +1,133 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/guiperry/gollm_cerebras/llm"
+)
+
+// hedgeDelayKey is the context key for a per-request hedge delay.
+type hedgeDelayKey struct{}
+
+// WithHedgeDelay returns a context that enables hedged generation for calls made
+// with it: if the first attempt has not answered within delay, a second attempt
+// is started and the first response wins. A zero delay disables hedging for the
+// request even when the delegator has a default.
+func WithHedgeDelay(ctx context.Context, delay time.Duration) context.Context {
+	return context.WithValue(ctx, hedgeDelayKey{}, delay)
+}
+
+// hedgeDelayFromEnv reads HEDGE_DELAY_MS; hedging is off when unset.
+func hedgeDelayFromEnv() time.Duration {
+	raw := os.Getenv("HEDGE_DELAY_MS")
+	if raw == "" {
+		return 0
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		log.Printf("[WARN] DelegatorService: Invalid HEDGE_DELAY_MS '%s'. Hedging disabled.", raw)
+		return 0
+	}
+	return time.Duration(value) * time.Millisecond
+}
+
+// hedgeDelayFor returns the hedge delay for a request, preferring the delay set
+// on the context over the delegator default.
+func (d *DelegatorService) hedgeDelayFor(ctx context.Context) time.Duration {
+	if delay, ok := ctx.Value(hedgeDelayKey{}).(time.Duration); ok {
+		return delay
+	}
+	d.settingsMutex.RLock()
+	defer d.settingsMutex.RUnlock()
+	return d.hedgeDelay
+}
+
+// hedgeOutcome is the result of one attempt in a hedged race.
+type hedgeOutcome struct {
+	index    int
+	response string
+	err      error
+}
+
+// generateHedged races the attempts in order. The first attempt starts
+// immediately; if it has not answered within delay, the next one is started
+// alongside it. A failed attempt is replaced by the next one right away. The
+// first successful response is returned and the attempts still running are
+// cancelled.
+func (d *DelegatorService) generateHedged(ctx context.Context, operationName string, prompt string, attempts []LLMAttempt, delay time.Duration) (string, error) {
+	if len(attempts) == 0 {
+		return "", fmt.Errorf("%s: no attempts configured for hedging", operationName)
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel() // Cancels the losing attempts
+
+	outcomes := make(chan hedgeOutcome, len(attempts)) // Buffered so losers never block
+	started, running := 0, 0
+	launch := func() {
+		index := started
+		attempt := attempts[index]
+		started++
+		running++
+		log.Printf("DelegatorService (%s): Hedged attempt %d/%d (Model: %s) started.", operationName, index+1, len(attempts), attempt.Config.ModelName)
+		go func() {
+			response, err := attempt.Instance.Generate(raceCtx, llm.NewPrompt(prompt))
+			outcomes <- hedgeOutcome{index: index, response: response, err: err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	hedgeTimer := timer.C // Set to nil once the hedge has been sent
+
+	var lastError error
+	for running > 0 {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%s stopped: %w", operationName, ctx.Err())
+
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			if started < len(attempts) {
+				log.Printf("DelegatorService (%s): No response after %v. Sending hedged request.", operationName, delay)
+				launch()
+			}
+
+		case outcome := <-outcomes:
+			running--
+			model := attempts[outcome.index].Config.ModelName
+			if outcome.err == nil {
+				log.Printf("DelegatorService (%s): Hedged attempt %d (Model: %s) won the race.", operationName, outcome.index+1, model)
+				return outcome.response, nil
+			}
+			log.Printf("DelegatorService (%s): Hedged attempt %d (Model: %s) failed: %v", operationName, outcome.index+1, model, outcome.err)
+			lastError = outcome.err
+			if started < len(attempts) && !errors.Is(outcome.err, context.Canceled) {
+				launch() // Replace the failed attempt
+			}
+		}
+	}
+	return "", fmt.Errorf("%s failed after %d hedged attempts, last error: %w", operationName, started, lastError)
+}
+
+// SetHedgeDelay sets the default hedge delay for generation (0 disables hedging).
+func (d *DelegatorService) SetHedgeDelay(delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+	d.settingsMutex.Lock()
+	d.hedgeDelay = delay
+	d.settingsMutex.Unlock()
+	if delay == 0 {
+		log.Println("DelegatorService: Hedged requests disabled.")
+		return
+	}
+	log.Printf("DelegatorService: Hedged requests enabled (delay %v).", delay)
+}
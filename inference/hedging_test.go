@@ -0,0 +1,107 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiperry/gollm_cerebras/llm"
+)
+
+// raceLLM answers after a delay and reports whether its request was cancelled.
+// Only Generate is implemented.
+type raceLLM struct {
+	llm.LLM
+	delay     time.Duration
+	response  string
+	err       error
+	cancelled chan struct{}
+}
+
+func newRaceLLM(delay time.Duration, response string, err error) *raceLLM {
+	return &raceLLM{delay: delay, response: response, err: err, cancelled: make(chan struct{})}
+}
+
+func (r *raceLLM) Generate(ctx context.Context, prompt *llm.Prompt, opts ...llm.GenerateOption) (string, error) {
+	select {
+	case <-time.After(r.delay):
+		return r.response, r.err
+	case <-ctx.Done():
+		close(r.cancelled)
+		return "", ctx.Err()
+	}
+}
+
+func raceAttempts(instances ...*raceLLM) []LLMAttempt {
+	attempts := make([]LLMAttempt, len(instances))
+	for i, instance := range instances {
+		attempts[i] = LLMAttempt{Instance: instance, Config: LLMAttemptConfig{ModelName: instance.response}}
+	}
+	return attempts
+}
+
+func TestHedgedRequestWinsAndCancelsTheLoser(t *testing.T) {
+	slow := newRaceLLM(5*time.Second, "slow", nil)
+	fast := newRaceLLM(10*time.Millisecond, "fast", nil)
+	start := time.Now()
+	response, err := (&DelegatorService{}).generateHedged(context.Background(), "Test", "hi", raceAttempts(slow, fast), 20*time.Millisecond)
+	if err != nil || response != "fast" {
+		t.Fatalf("Expected the hedged attempt to win, got %q (%v)", response, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the hedge to answer long before the slow attempt, took %v", elapsed)
+	}
+	select {
+	case <-slow.cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the losing attempt to be cancelled")
+	}
+}
+
+func TestHedgedRequestReplacesFailedAttempt(t *testing.T) {
+	failing := newRaceLLM(0, "failing", errors.New("status code 503"))
+	fallback := newRaceLLM(0, "fallback", nil)
+	start := time.Now()
+	response, err := (&DelegatorService{}).generateHedged(context.Background(), "Test", "hi", raceAttempts(failing, fallback), 5*time.Second)
+	if err != nil || response != "fallback" {
+		t.Fatalf("Expected the next attempt to answer, got %q (%v)", response, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the failed attempt to be replaced without waiting for the hedge delay, took %v", elapsed)
+	}
+
+	failing = newRaceLLM(0, "failing", errors.New("status code 503"))
+	if _, err := (&DelegatorService{}).generateHedged(context.Background(), "Test", "hi", raceAttempts(failing), time.Millisecond); err == nil {
+		t.Errorf("Expected an error when every attempt fails")
+	}
+}
+
+func TestDelegatorSettingsChangeWhileRequestsRun(t *testing.T) {
+	delegator := &DelegatorService{}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			delegator.SetHedgeDelay(time.Duration(i%2) * time.Millisecond)
+			delegator.SetSemanticCache(nil)
+			delegator.SetPIIGuardrail(nil)
+			delegator.SetStructuredOutputRepairs(i % 3)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			delegator.hedgeDelayFor(context.Background())
+			delegator.currentSemanticCache()
+			delegator.currentPIIGuardrail()
+			delegator.currentStructuredOutputRepairs()
+		}
+	}()
+	wg.Wait()
+	if delay := delegator.hedgeDelayFor(WithHedgeDelay(context.Background(), 0)); delay != 0 {
+		t.Errorf("Expected the request's delay to override the default, got %v", delay)
+	}
+}
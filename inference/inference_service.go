@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -17,15 +18,23 @@ import (
 	gollm "github.com/guiperry/gollm_cerebras"
 	"github.com/guiperry/gollm_cerebras/config"
 	"github.com/guiperry/gollm_cerebras/llm"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
 )
 
+// ErrAgentNotFound is returned when a request runs under an agent that does not exist.
+var ErrAgentNotFound = errors.New("agent not found")
+
 // LLMAttemptConfig defines the configuration for a single LLM attempt.
 type LLMAttemptConfig struct {
 	ProviderName string
 	ModelName    string
 	APIKeyEnvVar string // Environment variable name for the API key
-	MaxTokens    int
-	IsPrimary    bool // True if part of initial attempts, false for fallback
+	// DefaultAPIKey is used when the environment variable is unset, for
+	// providers that need no key (local servers, the fake provider)
+	DefaultAPIKey string
+	MaxTokens     int
+	IsPrimary     bool // True if part of initial attempts, false for fallback
+	NoRetries     bool // Errors go straight to the next attempt instead of being retried
 	// Add EndpointOverride string if needed
 }
 
@@ -43,6 +52,20 @@ type InferenceService struct {
 	fallbackAttempts []LLMAttempt
 	delegator        *DelegatorService
 	contextManager   *ContextManager // ADDED: Context Manager instance
+	sessions         *SessionManager // Conversation sessions, kept across restarts of the service
+	domainDB         *database.SimpleDomainDB
+	agents           *database.SimpleAgentRepository
+	semanticCache    *SemanticCache          // Optional near-duplicate response cache
+	tools            *ToolRegistry           // Tools available to tool-calling generation
+	prompts          *PromptTemplateRegistry // Named, versioned prompt templates
+	experiments      *ExperimentManager      // A/B experiments over templates and models
+	evaluator        *Evaluator              // Offline evaluation of datasets
+	batches          *BatchManager           // Offline batch jobs
+	pii              *PIIGuardrail           // Redacts PII from prompts sent to providers and embedders
+	hedgeDelay       time.Duration           // Default hedge delay, kept across restarts (0 = off)
+	transport        http.RoundTripper       // Transport for provider requests (nil = http.DefaultTransport)
+	cassette         *CassetteTransport      // Cassette from LLM_CASSETTE, kept across restarts
+	httpClient       *http.Client            // Sends every provider request, built on Start
 	isRunning        bool
 	mutex            sync.Mutex
 	moa              *gollm.MOA
@@ -55,16 +78,64 @@ type InferenceService struct {
 
 // NewInferenceService creates a new instance of InferenceService.
 func NewInferenceService(db *database.SimpleDomainDB) (*InferenceService, error) {
-	return &InferenceService{
+	pii, err := NewPIIGuardrail(piiGuardrailConfigFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize PII guardrail: %w", err)
+	}
+	sessions := NewSessionManager(nil)
+	sessions.SetIdleTimeout(sessionIdleTimeoutFromEnv())
+	sessions.SetPIIGuardrail(pii)
+	if db != nil {
+		sessions.SetRetrievalStore(db)
+	}
+	tools := NewToolRegistry()
+	tools.SetAllowedHosts(toolAllowedHostsFromEnv())
+	registerBuiltinTools(tools)
+	contextOptions := []ContextManagerOption{
+		WithProcessingMode(SequentialProcessing), // Default to sequential
+		WithSequentialDelay(sequentialDelayFromEnv()),
+	}
+	if db != nil {
+		// Semantic chunking shares the domain database's embedder
+		contextOptions = append(contextOptions, WithEmbedder(guardEmbedder(db.Embedder(), pii)))
+	}
+	prompts, err := NewPromptTemplateRegistry(context.Background(), db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize prompt templates: %w", err)
+	}
+	service := &InferenceService{
+		domainDB:   db,
+		tools:      tools,
+		prompts:    prompts,
+		pii:        pii,
+		hedgeDelay: hedgeDelayFromEnv(),
 		// Initialize slices
 		primaryAttempts:  make([]LLMAttempt, 0),
 		fallbackAttempts: make([]LLMAttempt, 0),
+		sessions:         sessions,
 		// Initialize ContextManager with default strategy
 		contextManager: NewContextManager(
-			ChunkByTokenCount,                        // Use token count for better splitting
-			WithProcessingMode(SequentialProcessing), // Default to sequential
+			ChunkByTokenCount, // Use token count for better splitting
+			contextOptions...,
 		),
-	}, nil
+	}
+	service.experiments, err = NewExperimentManager(context.Background(), prompts, db, pii)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize experiments: %w", err)
+	}
+	service.evaluator, err = NewEvaluator(context.Background(), service, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize evaluator: %w", err)
+	}
+	service.batches = NewBatchManager(service, batchOutputDirFromEnv(), batchRequestsPerMinuteFromEnv())
+	if db != nil {
+		agents, err := db.GetOrCreateCollection("agents")
+		if err != nil {
+			return nil, fmt.Errorf("failed to open agents collection: %w", err)
+		}
+		service.agents = database.NewSimpleAgentRepository(agents)
+	}
+	return service, nil
 }
 
 // Start configures the service with both proxy and base providers and the delegator.
@@ -80,8 +151,30 @@ func (s *InferenceService) Start() error {
 		// {ProviderName: "cerebras", ModelName: "some-other-cerebras-model", APIKeyEnvVar: "CEREBRAS_API_KEY", MaxTokens: 8000, IsPrimary: true}, // Example: another primary
 		// {ProviderName: "cerebras", ModelName: "llama-4-scout-17b-16e-instruct", APIKeyEnvVar: "CEREBRAS_API_KEY_2", MaxTokens: 4000, IsPrimary: true}, // Example: different key
 		{ProviderName: "gemini", ModelName: "gemini-1.5-flash-latest", APIKeyEnvVar: "GEMINI_API_KEY", MaxTokens: 100000, IsPrimary: false}, // Fallback 1 (Use working model name)
-		{ProviderName: "deepseek", ModelName: "deepseek-chat", APIKeyEnvVar: "DEEPSEEK_API_KEY", MaxTokens: 8000, IsPrimary: false},         // Fallback 2 (Target for final chunking)
-		// {ProviderName: "gemini", ModelName: "gemini-1.5-pro-latest", APIKeyEnvVar: "GEMINI_API_KEY", MaxTokens: 1000000, IsPrimary: false}, // Fallback 3 (Example: Use Pro if needed)
+		{ProviderName: "anthropic", ModelName: DefaultAnthropicModel, APIKeyEnvVar: "ANTHROPIC_API_KEY", MaxTokens: 8000, IsPrimary: false}, // Fallback 2
+		{ProviderName: "deepseek", ModelName: "deepseek-chat", APIKeyEnvVar: "DEEPSEEK_API_KEY", MaxTokens: 8000, IsPrimary: false},         // Fallback 3 (Target for final chunking)
+		// {ProviderName: "gemini", ModelName: "gemini-1.5-pro-latest", APIKeyEnvVar: "GEMINI_API_KEY", MaxTokens: 1000000, IsPrimary: false}, // Fallback 4 (Example: Use Pro if needed)
+	}
+
+	// A local OpenAI-compatible server (Ollama, llama.cpp, vLLM) joins the chain when configured
+	if local, ok := localAttemptConfigFromEnv(); ok {
+		attemptConfigs = withLocalAttempt(attemptConfigs, local)
+	}
+
+	// A fake script replaces the network providers with scripted, offline ones
+	useFake, err := fakeScriptFromEnv()
+	if err != nil {
+		return fmt.Errorf("inference service configuration error: %w", err)
+	}
+	if useFake {
+		log.Println("InferenceService: FAKE_LLM_SCRIPT is set. Using the scripted fake provider instead of network providers.")
+		attemptConfigs = fakeAttemptConfigs()
+	}
+
+	// A cassette records provider traffic or replays it without network
+	cassetteMode, err := s.configureHTTPClient()
+	if err != nil {
+		return fmt.Errorf("inference service configuration error: %w", err)
 	}
 
 	s.primaryAttempts = make([]LLMAttempt, 0)
@@ -93,6 +186,12 @@ func (s *InferenceService) Start() error {
 	for _, attemptConf := range attemptConfigs {
 		log.Printf("InferenceService: Configuring LLM attempt: Provider=%s, Model=%s, Primary=%t", attemptConf.ProviderName, attemptConf.ModelName, attemptConf.IsPrimary)
 		apiKey := os.Getenv(attemptConf.APIKeyEnvVar)
+		if apiKey == "" {
+			apiKey = attemptConf.DefaultAPIKey
+		}
+		if apiKey == "" && cassetteMode == CassetteReplay {
+			apiKey = cassetteReplayAPIKey // Replayed requests never reach the provider
+		}
 		if apiKey == "" {
 			log.Printf("[WARN] InferenceService: API Key from env var '%s' not found for model '%s'. Skipping this attempt.", attemptConf.APIKeyEnvVar, attemptConf.ModelName)
 			continue // Skip this attempt if key is missing
@@ -105,8 +204,12 @@ func (s *InferenceService) Start() error {
 			config.SetMaxTokens(attemptConf.MaxTokens),
 			// Add config.SetEndpoint(attemptConf.EndpointOverride) if needed
 		}
+		if attemptConf.NoRetries {
+			opts = append(opts, config.SetMaxRetries(0))
+		}
 
-		llmInstance, err := gollm.NewLLM(opts...)
+		var llmInstance interface{}
+		llmInstance, err = NewProviderLLM(s.httpClient, opts...)
 		if err != nil {
 			log.Printf("[ERROR] InferenceService: Failed to create LLM instance for model '%s': %v. Skipping this attempt.", attemptConf.ModelName, err)
 			continue // Skip this attempt on error
@@ -114,7 +217,7 @@ func (s *InferenceService) Start() error {
 
 		if initializedLLM, ok := llmInstance.(llm.LLM); ok {
 			attempt := LLMAttempt{
-				Instance: initializedLLM,
+				Instance: guardLLM(initializedLLM, s.pii, attemptConf.ModelName), // Every provider call passes the PII guardrail
 				Config:   attemptConf,
 				Opts:     opts, // STORE THE OPTS
 			}
@@ -158,7 +261,7 @@ func (s *InferenceService) Start() error {
 	delegatorTokenLimit := s.primaryAttempts[0].Config.MaxTokens
 	delegatorTokenModel := s.primaryAttempts[0].Config.ModelName // Model used for token estimation
 	// Pass contextManager to DelegatorService
-	s.delegator = NewDelegatorService(s.primaryAttempts, s.fallbackAttempts, delegatorTokenLimit, delegatorTokenModel, s.moa, s.contextManager)
+	s.delegator = NewDelegatorService(s.primaryAttempts, s.fallbackAttempts, delegatorTokenLimit, delegatorTokenModel, s.moa, s.contextManager, s.sessions)
 	if s.delegator == nil {
 		log.Println("[ERROR] InferenceService: Failed to create DelegatorService.") // Corrected log message
 		s.isRunning = false
@@ -167,12 +270,71 @@ func (s *InferenceService) Start() error {
 		return fmt.Errorf("failed to create delegator service")
 	}
 	log.Println("InferenceService: DelegatorService created.")
+	if s.hedgeDelay > 0 {
+		s.delegator.SetHedgeDelay(s.hedgeDelay)
+	}
+	s.delegator.SetPIIGuardrail(s.pii)
+
+	// Sessions with the summary memory strategy summarize with the first primary model
+	s.sessions.SetSummarizer(&LLMAdapter{LLM: s.primaryAttempts[0].Instance, ProviderName: s.primaryAttempts[0].Config.ProviderName})
+
+	// --- Semantic Cache ---
+	if threshold, enabled := semanticCacheThresholdFromEnv(); enabled && s.domainDB != nil {
+		if s.semanticCache == nil {
+			cache, err := NewSemanticCache(s.domainDB, threshold)
+			if err != nil {
+				log.Printf("[WARN] InferenceService: Semantic cache unavailable: %v", err)
+			} else {
+				s.semanticCache = cache
+			}
+		}
+		if s.semanticCache != nil {
+			s.semanticCache.SetPIIGuardrail(s.pii)
+			s.semanticCache.SetExposeSourcePrompt(semanticCacheExposePromptFromEnv())
+			s.delegator.SetSemanticCache(s.semanticCache)
+		}
+	}
 
 	s.isRunning = true
 	log.Println("InferenceService: Started successfully.")
 	return nil
 }
 
+// SetHTTPTransport sets the transport provider requests are sent through,
+// for example a CassetteTransport. It applies from the next Start; nil
+// restores http.DefaultTransport.
+func (s *InferenceService) SetHTTPTransport(transport http.RoundTripper) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.transport = transport
+}
+
+// configureHTTPClient builds the client provider requests are sent through:
+// the transport set with SetHTTPTransport, wrapped in the LLM_CASSETTE
+// cassette when one is configured. It returns the cassette mode in use, or ""
+// without a cassette.
+func (s *InferenceService) configureHTTPClient() (CassetteMode, error) {
+	transport := s.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if s.cassette == nil {
+		cassette, err := cassetteFromEnv(transport)
+		if err != nil {
+			return "", err
+		}
+		s.cassette = cassette
+	}
+	if s.cassette != nil {
+		transport = s.cassette
+	}
+	s.httpClient = &http.Client{Transport: transport}
+	if cassette, ok := transport.(*CassetteTransport); ok {
+		return cassette.Mode(), nil
+	}
+	return "", nil
+}
+
 // Stop cleans up the clients and delegator
 func (s *InferenceService) Stop() error {
 	s.mutex.Lock()
@@ -192,8 +354,9 @@ func (s *InferenceService) Stop() error {
 	return nil
 }
 
-// GenerateText delegates to the DelegatorService.
-func (s *InferenceService) GenerateText(modelName string, promptText string, instructionText string) (string, error) {
+// GenerateText delegates to the DelegatorService using the history of the
+// given session. An empty sessionID runs a one-off request without history.
+func (s *InferenceService) GenerateText(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string) (string, error) {
 	s.mutex.Lock() // Lock at the beginning
 	if !s.isRunning || s.delegator == nil {
 		s.mutex.Unlock()
@@ -202,12 +365,11 @@ func (s *InferenceService) GenerateText(modelName string, promptText string, ins
 	delegatorInstance := s.delegator // Capture instance under lock
 	s.mutex.Unlock()
 
-	ctx := context.Background()
 	log.Printf("InferenceService: Delegating generation request to DelegatorService. Model: '%s', Instruction: '%s'", modelName, instructionText)
 	// --- Adapt GenerateText to potentially use ContextManager ---
 	// The delegator will now handle the potential call to ContextManager internally
 	// Pass modelName and instructionText to the delegator
-	response, err := delegatorInstance.GenerateSimple(ctx, modelName, promptText, instructionText)
+	response, err := delegatorInstance.GenerateSimple(ctx, sessionID, modelName, promptText, instructionText)
 	// --- End Adapt ---
 	if err != nil {
 		return "", err
@@ -216,9 +378,59 @@ func (s *InferenceService) GenerateText(modelName string, promptText string, ins
 	return response, nil
 }
 
+// GenerateTextWithMetadata delegates to the DelegatorService and returns the
+// response together with generation metadata (e.g. semantic cache details).
+func (s *InferenceService) GenerateTextWithMetadata(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string) (*GenerationResult, error) {
+	s.mutex.Lock()
+	if !s.isRunning || s.delegator == nil {
+		s.mutex.Unlock()
+		return nil, errors.New("inference service is not running or delegator not configured")
+	}
+	delegatorInstance := s.delegator
+	s.mutex.Unlock()
+
+	log.Printf("InferenceService: Delegating generation request (with metadata) to DelegatorService. Model: '%s'", modelName)
+	return delegatorInstance.GenerateSimpleWithMetadata(ctx, sessionID, modelName, promptText, instructionText)
+}
+
+// SetSemanticCacheThreshold updates the similarity threshold of the semantic cache.
+func (s *InferenceService) SetSemanticCacheThreshold(threshold float32) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.semanticCache == nil {
+		return errors.New("semantic cache is not configured")
+	}
+	return s.semanticCache.SetThreshold(threshold)
+}
+
+// SetHedgeDelay sets how long generation waits for the first attempt before
+// sending a hedged request to the next one (0 disables hedging).
+func (s *InferenceService) SetHedgeDelay(delay time.Duration) error {
+	if delay < 0 {
+		return fmt.Errorf("hedge delay must not be negative, got %v", delay)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hedgeDelay = delay
+	if s.delegator != nil {
+		s.delegator.SetHedgeDelay(delay)
+	}
+	return nil
+}
+
+// ClearSemanticCache removes all cached responses.
+func (s *InferenceService) ClearSemanticCache() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.semanticCache == nil {
+		return errors.New("semantic cache is not configured")
+	}
+	return s.semanticCache.Clear()
+}
+
 // --- ADDED: GenerateTextWithProvider ---
 // GenerateTextWithProvider sends a prompt directly to the first configured instance of a specific provider.
-func (s *InferenceService) GenerateTextWithProvider(providerName string, promptText string) (string, error) {
+func (s *InferenceService) GenerateTextWithProvider(ctx context.Context, providerName string, promptText string) (string, error) {
 	s.mutex.Lock()
 	if !s.isRunning {
 		s.mutex.Unlock()
@@ -232,7 +444,6 @@ func (s *InferenceService) GenerateTextWithProvider(providerName string, promptT
 	}
 	s.mutex.Unlock() // Unlock before making the potentially long call
 
-	ctx := context.Background() // Consider allowing context passing
 	log.Printf("InferenceService: Delegating direct generation request to provider '%s'...", providerName)
 
 	// Use the llm.NewPrompt helper from the gollm library
@@ -243,7 +454,7 @@ func (s *InferenceService) GenerateTextWithProvider(providerName string, promptT
 
 // --- ADDED: GenerateTextWithMOA ---
 // GenerateTextWithMOA directly delegates to the MOA instance.
-func (s *InferenceService) GenerateTextWithMOA(promptText string, instructionText string) (string, error) {
+func (s *InferenceService) GenerateTextWithMOA(ctx context.Context, promptText string, instructionText string) (string, error) {
 	s.mutex.Lock()
 	if !s.isRunning {
 		s.mutex.Unlock()
@@ -256,7 +467,6 @@ func (s *InferenceService) GenerateTextWithMOA(promptText string, instructionTex
 	moaInstance := s.moa // Capture instance under lock
 	s.mutex.Unlock()
 
-	ctx := context.Background() // Consider allowing context passing
 	log.Printf("InferenceService: Delegating generation request to MOA. Instruction: '%s'", instructionText)
 
 	combinedPrompt := promptText
@@ -265,7 +475,7 @@ func (s *InferenceService) GenerateTextWithMOA(promptText string, instructionTex
 	}
 
 	// Note: MOA's Generate might have its own internal timeouts based on AgentTimeout
-	response, err := moaInstance.Generate(ctx, combinedPrompt)
+	response, err := s.pii.generateText(ctx, "MOA", combinedPrompt, moaInstance.Generate)
 	if err != nil {
 		log.Printf("InferenceService: Direct MOA generation failed: %v", err)
 		return "", fmt.Errorf("MOA generation failed: %w", err)
@@ -276,7 +486,7 @@ func (s *InferenceService) GenerateTextWithMOA(promptText string, instructionTex
 
 // --- ADDED: GenerateTextWithContextManager ---
 // Explicitly trigger context manager processing (useful for testing or specific UI actions)
-func (s *InferenceService) GenerateTextWithContextManager(promptText, instruction string, llmProviderName string) (string, error) {
+func (s *InferenceService) GenerateTextWithContextManager(ctx context.Context, promptText, instruction string, llmProviderName string) (string, error) {
 	s.mutex.Lock()
 	if !s.isRunning || s.contextManager == nil {
 		s.mutex.Unlock()
@@ -291,7 +501,6 @@ func (s *InferenceService) GenerateTextWithContextManager(promptText, instructio
 	ctxMgr := s.contextManager
 	s.mutex.Unlock()
 
-	ctx := context.Background()
 	log.Printf("InferenceService: Explicitly calling ContextManager with provider %s", llmProviderName)
 	// Adapt llmInstance to TextGenerator interface if needed
 	// Wrap the LLM in our adapter to implement TextGenerator
@@ -299,9 +508,31 @@ func (s *InferenceService) GenerateTextWithContextManager(promptText, instructio
 	return ctxMgr.ProcessLargePrompt(ctx, wrappedLLM, promptText, instruction)
 }
 
+// GenerateTextWithSynthesis is GenerateTextWithContextManager with an explicit
+// synthesis mode for combining chunk outputs. An empty synthesisInstruction uses
+// the context manager's configured reduce or refine instruction.
+func (s *InferenceService) GenerateTextWithSynthesis(ctx context.Context, promptText, instruction string, llmProviderName string, mode SynthesisMode, synthesisInstruction string) (string, error) {
+	s.mutex.Lock()
+	if !s.isRunning || s.contextManager == nil {
+		s.mutex.Unlock()
+		return "", errors.New("service not running or context manager not configured")
+	}
+	llmInstance := s.findLLMInstance(llmProviderName)
+	if llmInstance == nil {
+		s.mutex.Unlock()
+		return "", fmt.Errorf("LLM provider '%s' not found or configured", llmProviderName)
+	}
+	ctxMgr := s.contextManager
+	s.mutex.Unlock()
+
+	log.Printf("InferenceService: Calling ContextManager with provider %s and %s synthesis", llmProviderName, mode)
+	wrappedLLM := &LLMAdapter{LLM: llmInstance, ProviderName: llmProviderName}
+	return ctxMgr.ProcessLargePromptWithSynthesis(ctx, promptText, instruction, mode, synthesisInstruction, wrappedLLM)
+}
+
 // --- Update other generation methods to use DelegatorService ---
 
-func (s *InferenceService) GenerateTextWithCoT(promptText string) (string, error) {
+func (s *InferenceService) GenerateTextWithCoT(ctx context.Context, sessionID string, promptText string) (string, error) {
 	s.mutex.Lock()
 	if !s.isRunning || s.delegator == nil {
 		s.mutex.Unlock()
@@ -309,12 +540,11 @@ func (s *InferenceService) GenerateTextWithCoT(promptText string) (string, error
 	}
 	delegatorInstance := s.delegator
 	s.mutex.Unlock()
-	ctx := context.Background()
 	log.Println("InferenceService: Delegating CoT generation to DelegatorService...")
-	return delegatorInstance.GenerateWithCoT(ctx, promptText) // Call delegator
+	return delegatorInstance.GenerateWithCoT(ctx, sessionID, promptText) // Call delegator
 }
 
-func (s *InferenceService) GenerateTextWithReflection(promptText string) (string, error) {
+func (s *InferenceService) GenerateTextWithReflection(ctx context.Context, sessionID string, promptText string) (string, error) {
 	s.mutex.Lock()
 	if !s.isRunning || s.delegator == nil {
 		s.mutex.Unlock()
@@ -322,12 +552,11 @@ func (s *InferenceService) GenerateTextWithReflection(promptText string) (string
 	}
 	delegatorInstance := s.delegator
 	s.mutex.Unlock()
-	ctx := context.Background()
 	log.Println("InferenceService: Delegating Reflection generation to DelegatorService...")
-	return delegatorInstance.GenerateWithReflection(ctx, promptText) // Call delegator
+	return delegatorInstance.GenerateWithReflection(ctx, sessionID, promptText) // Call delegator
 }
 
-func (s *InferenceService) GenerateStructuredOutput(content string, schema string) (string, error) {
+func (s *InferenceService) GenerateStructuredOutput(ctx context.Context, sessionID string, content string, schema string) (string, error) {
 	s.mutex.Lock()
 	if !s.isRunning || s.delegator == nil {
 		s.mutex.Unlock()
@@ -335,9 +564,190 @@ func (s *InferenceService) GenerateStructuredOutput(content string, schema strin
 	}
 	delegatorInstance := s.delegator
 	s.mutex.Unlock()
-	ctx := context.Background()
 	log.Println("InferenceService: Delegating structured output generation to DelegatorService...")
-	return delegatorInstance.GenerateStructuredOutput(ctx, content, schema) // Call delegator
+	return delegatorInstance.GenerateStructuredOutput(ctx, sessionID, content, schema) // Call delegator
+}
+
+// GenerateStructuredInto generates structured output matching the schema of
+// target (a pointer, usually to a struct) and unmarshals the result into it.
+func (s *InferenceService) GenerateStructuredInto(ctx context.Context, sessionID string, content string, target interface{}) (string, error) {
+	s.mutex.Lock()
+	if !s.isRunning || s.delegator == nil {
+		s.mutex.Unlock()
+		return "", errors.New("service not running")
+	}
+	delegatorInstance := s.delegator
+	s.mutex.Unlock()
+	log.Printf("InferenceService: Delegating structured output generation for %T to DelegatorService...", target)
+	return delegatorInstance.GenerateStructuredInto(ctx, sessionID, content, target)
+}
+
+// GenerateTextWithTools runs a tool-calling generation with the selected tools
+// (all registered tools when toolNames is empty) and returns the full trace.
+func (s *InferenceService) GenerateTextWithTools(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string, toolNames []string, maxIterations int) (*ToolTrace, error) {
+	s.mutex.Lock()
+	if !s.isRunning || s.delegator == nil {
+		s.mutex.Unlock()
+		return nil, errors.New("inference service is not running or delegator not configured")
+	}
+	delegatorInstance := s.delegator
+	s.mutex.Unlock()
+
+	log.Printf("InferenceService: Delegating tool-calling generation to DelegatorService. Model: '%s', Tools: %v", modelName, toolNames)
+	return delegatorInstance.GenerateWithTools(ctx, sessionID, modelName, promptText, instructionText, s.tools, toolNames, maxIterations)
+}
+
+// GenerateTextWithEnsemble sends the prompt to several configured models in
+// parallel and selects the answer by majority vote or with a judge model.
+func (s *InferenceService) GenerateTextWithEnsemble(ctx context.Context, sessionID string, promptText string, instructionText string, opts EnsembleOptions) (*EnsembleResult, error) {
+	s.mutex.Lock()
+	if !s.isRunning || s.delegator == nil {
+		s.mutex.Unlock()
+		return nil, errors.New("inference service is not running or delegator not configured")
+	}
+	delegatorInstance := s.delegator
+	s.mutex.Unlock()
+
+	log.Printf("InferenceService: Delegating ensemble generation to DelegatorService. Mode: '%s', Models: %v", opts.Mode, opts.Models)
+	return delegatorInstance.GenerateEnsemble(ctx, sessionID, promptText, instructionText, opts)
+}
+
+// ExperimentRequest is a generation routed through an A/B experiment.
+type ExperimentRequest struct {
+	ExperimentID  string                 `json:"experiment_id"`
+	SessionID     string                 `json:"session_id,omitempty"`
+	AssignmentKey string                 `json:"assignment_key,omitempty"` // Keeps a user or session on one variant
+	Prompt        string                 `json:"prompt,omitempty"`         // Used as is by variants without a template, or as {{.Prompt}}
+	Instruction   string                 `json:"instruction,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"` // Template variables
+	AgentID       string                 `json:"agent_id,omitempty"`  // Applies the agent's PII redaction flag
+}
+
+// GenerateWithExperiment picks a variant of the experiment, renders its prompt
+// template and generates with its model. The generation is tagged with the
+// variant and its latency and estimated cost are recorded, even when it fails.
+func (s *InferenceService) GenerateWithExperiment(ctx context.Context, request ExperimentRequest) (*ExperimentGeneration, error) {
+	s.mutex.Lock()
+	if !s.isRunning || s.delegator == nil {
+		s.mutex.Unlock()
+		return nil, errors.New("inference service is not running or delegator not configured")
+	}
+	delegatorInstance := s.delegator
+	tokenModel := s.primaryAttempts[0].Config.ModelName
+	s.mutex.Unlock()
+
+	ctx, _, err := s.WithAgent(ctx, request.AgentID)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := s.experiments.Get(request.ExperimentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exp.Active {
+		return nil, fmt.Errorf("%w: %s", ErrExperimentInactive, exp.Name)
+	}
+	variant := s.experiments.ChooseVariant(exp, request.AssignmentKey)
+
+	generation := &ExperimentGeneration{
+		ExperimentID: exp.ID,
+		Variant:      variant.Name,
+		Model:        variant.Model,
+	}
+	promptText := request.Prompt
+	if variant.PromptTemplate != nil {
+		tmpl, err := s.prompts.Resolve(*variant.PromptTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("experiment %s variant %s: %w", exp.Name, variant.Name, err)
+		}
+		vars := make(map[string]interface{}, len(request.Variables)+1)
+		for name, value := range request.Variables {
+			vars[name] = value
+		}
+		if _, ok := vars["Prompt"]; !ok && request.Prompt != "" {
+			vars["Prompt"] = request.Prompt
+		}
+		if promptText, err = tmpl.Render(vars); err != nil {
+			return nil, err
+		}
+		generation.PromptTemplate = &PromptTemplateRef{Name: tmpl.Name, Version: tmpl.Version}
+	}
+	if promptText == "" {
+		return nil, errors.New("prompt is required for variants without a prompt template")
+	}
+
+	log.Printf("InferenceService: Experiment '%s' serving variant '%s' (Model: '%s', Template: %v)", exp.Name, variant.Name, variant.Model, generation.PromptTemplate)
+	if variant.Model != "" {
+		tokenModel = variant.Model
+	}
+	start := time.Now()
+	// Cached answers would hide the variant's own latency and output
+	result, genErr := delegatorInstance.GenerateSimpleWithMetadata(WithoutSemanticCache(ctx), request.SessionID, variant.Model, promptText, request.Instruction)
+	generation.LatencyMs = time.Since(start).Milliseconds()
+	generation.PromptTokens = estimateTokens(request.Instruction+promptText, tokenModel)
+	if genErr != nil {
+		generation.Error = genErr.Error()
+	} else {
+		generation.Response = result.Content
+		generation.CompletionTokens = estimateTokens(result.Content, tokenModel)
+		generation.Cost = float64(generation.PromptTokens+generation.CompletionTokens) / 1000 * variant.CostPer1KTokens
+	}
+
+	if err := s.experiments.Record(context.WithoutCancel(ctx), generation); err != nil {
+		// The experiment was deleted while generating, or storing it failed
+		log.Printf("[WARN] InferenceService: Could not record experiment generation: %v", err)
+	}
+	if genErr != nil {
+		return generation, genErr
+	}
+	return generation, nil
+}
+
+// Evaluations returns the evaluator for offline dataset runs.
+func (s *InferenceService) Evaluations() *Evaluator {
+	return s.evaluator
+}
+
+// PIIGuardrail returns the guardrail that redacts PII from prompts.
+func (s *InferenceService) PIIGuardrail() *PIIGuardrail {
+	return s.pii
+}
+
+// WithAgent scopes ctx to the agent with agentID, so that the agent's PII
+// redaction flag applies to every provider and embedder call made with it.
+// An empty agentID returns ctx unchanged and no agent.
+func (s *InferenceService) WithAgent(ctx context.Context, agentID string) (context.Context, *database.SimpleAgent, error) {
+	if agentID == "" {
+		return ctx, nil, nil
+	}
+	if s.agents == nil {
+		return ctx, nil, fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+	agent, err := s.agents.GetAgentByID(ctx, agentID)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+	return WithPIIRedaction(ctx, agent.ID, agent.RedactPII), agent, nil
+}
+
+// Batches returns the manager of offline batch jobs.
+func (s *InferenceService) Batches() *BatchManager {
+	return s.batches
+}
+
+// Experiments returns the manager of A/B experiments.
+func (s *InferenceService) Experiments() *ExperimentManager {
+	return s.experiments
+}
+
+// Tools returns the registry of tools available to tool-calling generation.
+func (s *InferenceService) Tools() *ToolRegistry {
+	return s.tools
+}
+
+// PromptTemplates returns the registry of named, versioned prompt templates.
+func (s *InferenceService) PromptTemplates() *PromptTemplateRegistry {
+	return s.prompts
 }
 
 // --- Model Setting Methods ---
@@ -465,17 +875,62 @@ func (s *InferenceService) GetName() string {
 	return "InferenceService(Delegator+MOA)" // Updated name
 }
 
-// ClearConversationHistory clears the memory in the delegator.
+// ClearConversationHistory clears the history of every session.
 func (s *InferenceService) ClearConversationHistory() error {
+	s.sessions.ClearAll()
+	return nil
+}
+
+// --- Session Methods ---
+
+// CreateSession starts a new conversation session with its own history.
+// strategy selects how history becomes context ("window", "summary" or "retrieval").
+// The session's Token must be attached with WithSessionToken to use it.
+func (s *InferenceService) CreateSession(ownerID int64, model string, strategy MemoryStrategy) (*Session, error) {
+	return s.sessions.CreateSession(ownerID, model, strategy)
+}
+
+// GetSession returns the details of a session.
+func (s *InferenceService) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	return s.sessions.GetSession(ctx, sessionID)
+}
+
+// ListSessions returns the sessions of an owner (0 lists all sessions).
+func (s *InferenceService) ListSessions(ownerID int64) []*Session {
+	return s.sessions.ListSessions(ownerID)
+}
+
+// DeleteSession removes a session and its history.
+func (s *InferenceService) DeleteSession(ctx context.Context, sessionID string) error {
+	return s.sessions.DeleteSession(ctx, sessionID)
+}
+
+// GetSessionHistory returns the messages recorded for a session.
+func (s *InferenceService) GetSessionHistory(ctx context.Context, sessionID string) ([]gollm_types.MemoryMessage, error) {
+	return s.sessions.History(ctx, sessionID)
+}
+
+// GetSessionHistoryPage returns up to limit messages of a session starting at
+// offset, together with the total number of messages.
+func (s *InferenceService) GetSessionHistoryPage(ctx context.Context, sessionID string, offset, limit int) ([]gollm_types.MemoryMessage, int, error) {
+	return s.sessions.HistoryPage(ctx, sessionID, offset, limit)
+}
+
+// EnableConversationPersistence stores sessions and their messages in the
+// conversation database so that they survive restarts. Call before Start.
+func (s *InferenceService) EnableConversationPersistence(repo *database.ConversationRepository) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-
-	if !s.isRunning || s.delegator == nil {
-		return errors.New("inference service is not running or delegator not configured")
+	s.sessions = NewPersistentSessionManager(repo)
+	s.sessions.SetIdleTimeout(sessionIdleTimeoutFromEnv())
+	s.sessions.SetPIIGuardrail(s.pii)
+	if s.domainDB != nil {
+		s.sessions.SetRetrievalStore(s.domainDB)
 	}
-
-	s.delegator.memory.Clear()
-	return nil
+	if s.delegator != nil {
+		s.delegator.sessions = s.sessions
+	}
+	log.Println("InferenceService: Conversation persistence enabled.")
 }
 
 // reconfigureMOAInternal handles the creation or recreation of the MOA instance.
@@ -519,6 +974,23 @@ func (s *InferenceService) reconfigureMOAInternal() error {
 		return moaErr
 	}
 
+	// gollm builds the layers with its own HTTP clients; replace them so MOA
+	// requests go through the service's client (and cassette) as well
+	for i, opts := range [][]config.ConfigOption{s.moaPrimaryOpts, s.moaFallbackOpts} {
+		layerLLM, err := NewProviderLLM(s.httpClient, opts...)
+		if err != nil {
+			s.moa = nil
+			return fmt.Errorf("failed to create MOA layer %d: %w", i, err)
+		}
+		moaInstance.Layers[i].Models = []llm.LLM{layerLLM}
+	}
+	aggregator, err := NewProviderLLM(s.httpClient, aggregatorOpts...)
+	if err != nil {
+		s.moa = nil
+		return fmt.Errorf("failed to create MOA aggregator: %w", err)
+	}
+	moaInstance.Aggregator = aggregator
+
 	s.moa = moaInstance // Store the new MOA instance
 	log.Printf("InferenceService: MOA instance created/recreated successfully (Primary: %s, Fallback: %s).", s.moaPrimaryModelName, s.moaFallbackModelName)
 
@@ -0,0 +1,510 @@
+package inference
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Agentic_Engine/database"
+
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+	"github.com/philippgille/chromem-go"
+)
+
+// summaryTimeout bounds a single call to the summarizer.
+const summaryTimeout = 2 * time.Minute
+
+// MemoryStrategy selects how a session's history is turned into context.
+type MemoryStrategy string
+
+const (
+	// MemoryStrategyWindow keeps the most recent messages that fit the token window.
+	MemoryStrategyWindow MemoryStrategy = "window"
+	// MemoryStrategySummary rolls messages that no longer fit into a running summary.
+	MemoryStrategySummary MemoryStrategy = "summary"
+	// MemoryStrategyRetrieval embeds every message and retrieves the older
+	// turns most relevant to the current prompt.
+	MemoryStrategyRetrieval MemoryStrategy = "retrieval"
+)
+
+// ConversationMemoryCollection is the chromem collection used by RetrievalMemory.
+const ConversationMemoryCollection = "conversation_memory"
+
+// ParseMemoryStrategy validates a strategy name. An empty name selects the window strategy.
+func ParseMemoryStrategy(name string) (MemoryStrategy, error) {
+	switch MemoryStrategy(strings.ToLower(strings.TrimSpace(name))) {
+	case "", MemoryStrategyWindow:
+		return MemoryStrategyWindow, nil
+	case MemoryStrategySummary:
+		return MemoryStrategySummary, nil
+	case MemoryStrategyRetrieval:
+		return MemoryStrategyRetrieval, nil
+	default:
+		return "", fmt.Errorf("unknown memory strategy '%s' (expected 'window', 'summary' or 'retrieval')", name)
+	}
+}
+
+// --- Summarizing Memory ---
+
+// summaryBudgetRatio is the share of the token window reserved for the running summary.
+const summaryBudgetRatio = 0.25
+
+// SummarizingMemory wraps another ConversationMemory. When the history no
+// longer fits the token window, the messages that fall out of it are folded
+// into an LLM-generated running summary, which is sent ahead of the recent
+// messages instead of being dropped.
+type SummarizingMemory struct {
+	base             ConversationMemory
+	summarizer       func() TextGenerator // Resolved on use; nil result falls back to the plain window
+	defaultModelName string
+	summary          string
+	summarizedCount  int                              // Number of leading history messages already folded into summary
+	store            *database.ConversationRepository // Optional; keeps the summary across restarts
+	sessionID        string
+	mu               sync.Mutex
+}
+
+// NewSummarizingMemory creates a summarizing memory on top of base.
+func NewSummarizingMemory(base ConversationMemory, summarizer func() TextGenerator, defaultModelName string) *SummarizingMemory {
+	return &SummarizingMemory{
+		base:             base,
+		summarizer:       summarizer,
+		defaultModelName: defaultModelName,
+	}
+}
+
+// PersistTo loads the session's stored summary and saves every later update,
+// so that a restart does not summarize the history again.
+func (m *SummarizingMemory) PersistTo(store *database.ConversationRepository, sessionID string) error {
+	summary, count, err := store.GetSummary(context.Background(), sessionID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) { // New sessions are stored after their memory is built
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+	m.sessionID = sessionID
+	m.summary = summary
+	m.summarizedCount = count
+	return nil
+}
+
+// save stores the summary when persistence is enabled. Assumes the lock is held.
+func (m *SummarizingMemory) save() {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.UpdateSummary(context.Background(), m.sessionID, m.summary, m.summarizedCount); err != nil {
+		log.Printf("[ERROR] SummarizingMemory: Failed to store summary of session %s: %v", m.sessionID, err)
+	}
+}
+
+// AddMessage adds a message to the underlying history.
+func (m *SummarizingMemory) AddMessage(message gollm_types.MemoryMessage) {
+	m.base.AddMessage(message)
+}
+
+// GetMessagesForContext returns the running summary followed by the most recent
+// messages. The summary is only extended when messages are evicted.
+func (m *SummarizingMemory) GetMessagesForContext(maxTokens int, modelName string) []gollm_types.MemoryMessage {
+	return m.messagesForContext(context.Background(), maxTokens, modelName)
+}
+
+// messagesForContext is GetMessagesForContext for a request. The summarizer is
+// called with ctx and without the lock, so the session stays usable meanwhile.
+func (m *SummarizingMemory) messagesForContext(ctx context.Context, maxTokens int, modelName string) []gollm_types.MemoryMessage {
+	estimationModel := m.defaultModelName
+	if modelName != "" {
+		estimationModel = modelName
+	}
+	history := m.base.GetHistory()
+	if estimateTotalTokens(history, estimationModel) <= maxTokens {
+		return history
+	}
+
+	var generator TextGenerator
+	if m.summarizer != nil {
+		generator = m.summarizer()
+	}
+	if generator == nil {
+		log.Println("[WARN] SummarizingMemory: No summarizer available. Falling back to sliding window.")
+		return m.base.GetMessagesForContext(maxTokens, modelName)
+	}
+
+	// Keep as many recent messages as fit next to the summary budget
+	recentBudget := maxTokens - int(float64(maxTokens)*summaryBudgetRatio)
+	recentStart := len(history)
+	recentTokens := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		msgTokens := estimateTokens(history[i].Content, estimationModel)
+		if recentTokens+msgTokens > recentBudget {
+			break
+		}
+		recentTokens += msgTokens
+		recentStart = i
+	}
+
+	m.mu.Lock()
+	if m.summarizedCount > len(history) {
+		// The history was cleared underneath us
+		m.summary = ""
+		m.summarizedCount = 0
+		m.save()
+	}
+	summary, summarizedCount := m.summary, m.summarizedCount
+	m.mu.Unlock()
+
+	if recentStart > summarizedCount {
+		evicted := history[summarizedCount:recentStart]
+		updated, err := extendSummary(ctx, generator, summary, evicted)
+		if err != nil {
+			log.Printf("[WARN] SummarizingMemory: Failed to update summary: %v. Falling back to sliding window.", err)
+			return m.base.GetMessagesForContext(maxTokens, modelName)
+		}
+		m.mu.Lock()
+		if m.summarizedCount == summarizedCount {
+			m.summary = updated
+			m.summarizedCount = recentStart
+			m.save()
+			log.Printf("SummarizingMemory: Folded %d messages into the running summary.", len(evicted))
+		} else {
+			// Another request extended the summary meanwhile; this one is
+			// still right for the messages returned below
+			log.Println("SummarizingMemory: Summary was extended concurrently, keeping the stored one.")
+		}
+		m.mu.Unlock()
+		summary = updated
+	}
+
+	contextMessages := make([]gollm_types.MemoryMessage, 0, len(history)-recentStart+1)
+	if summary != "" {
+		contextMessages = append(contextMessages, gollm_types.MemoryMessage{
+			Role:    "system",
+			Content: "Summary of the earlier conversation:\n" + summary,
+		})
+	}
+	// If the window grew since the summary was last extended, the oldest recent
+	// messages may also be covered by the summary, which is harmless.
+	return append(contextMessages, history[recentStart:]...)
+}
+
+// extendSummary asks the summarizer to merge evicted messages into summary.
+func extendSummary(ctx context.Context, generator TextGenerator, summary string, evicted []gollm_types.MemoryMessage) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString("You maintain a concise running summary of a conversation. Keep facts, decisions, names and open questions; drop pleasantries.\n\n")
+	if summary != "" {
+		prompt.WriteString("Current summary:\n")
+		prompt.WriteString(summary)
+		prompt.WriteString("\n\n")
+	}
+	prompt.WriteString("New messages to incorporate:\n")
+	prompt.WriteString(formatMessagesToPrompt(evicted))
+	prompt.WriteString("\n\nRespond ONLY with the updated summary.")
+
+	// The request's deadline applies when it is shorter
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+	updated, err := generator.GenerateText(ctx, prompt.String())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(updated), nil
+}
+
+// Summary returns the current running summary.
+func (m *SummarizingMemory) Summary() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.summary
+}
+
+// Clear removes all messages and the summary.
+func (m *SummarizingMemory) Clear() {
+	m.mu.Lock()
+	m.summary = ""
+	m.summarizedCount = 0
+	m.save()
+	m.mu.Unlock()
+	m.base.Clear()
+}
+
+// GetHistory returns all messages of the underlying history.
+func (m *SummarizingMemory) GetHistory() []gollm_types.MemoryMessage {
+	return m.base.GetHistory()
+}
+
+// GetHistoryPage pages through the underlying history.
+func (m *SummarizingMemory) GetHistoryPage(offset, limit int) ([]gollm_types.MemoryMessage, int, error) {
+	return historyPageOf(m.base, offset, limit)
+}
+
+// --- Retrieval-Augmented Memory ---
+
+const (
+	// retrievalRecentRatio is the share of the token window reserved for the most recent messages.
+	retrievalRecentRatio = 0.5
+	// retrievalMaxResults caps the number of older messages retrieved per request.
+	retrievalMaxResults = 8
+)
+
+// RetrievalMemory wraps another ConversationMemory and embeds every message
+// into a chromem collection. Context consists of the most recent messages plus
+// the older messages that are most similar to the latest user prompt.
+type RetrievalMemory struct {
+	base             ConversationMemory
+	collection       *chromem.Collection
+	embedder         database.Embedder
+	sessionID        string
+	defaultModelName string
+	mu               sync.Mutex
+}
+
+// NewRetrievalMemory creates a retrieval-augmented memory on top of base,
+// storing embeddings in the conversation memory collection of db.
+func NewRetrievalMemory(base ConversationMemory, db *database.SimpleDomainDB, sessionID string, defaultModelName string) (*RetrievalMemory, error) {
+	if db == nil {
+		return nil, fmt.Errorf("retrieval memory requires a domain database")
+	}
+	collection, err := db.GetOrCreateCollection(ConversationMemoryCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open conversation memory collection: %w", err)
+	}
+	return &RetrievalMemory{
+		base:             base,
+		collection:       collection,
+		embedder:         db.Embedder(),
+		sessionID:        sessionID,
+		defaultModelName: defaultModelName,
+	}, nil
+}
+
+// AddMessage adds a message to the underlying history and embeds it.
+func (m *RetrievalMemory) AddMessage(message gollm_types.MemoryMessage) {
+	m.addMessage(context.Background(), message)
+}
+
+// addMessage adds a message and embeds it for a request made with ctx.
+func (m *RetrievalMemory) addMessage(ctx context.Context, message gollm_types.MemoryMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	index := len(m.base.GetHistory())
+	m.base.AddMessage(message)
+	if strings.TrimSpace(message.Content) == "" {
+		return
+	}
+
+	embedding, err := m.embedder.Embed(ctx, message.Content)
+	if err != nil {
+		log.Printf("[ERROR] RetrievalMemory: Failed to embed message %d of session %s: %v", index, m.sessionID, err)
+		return
+	}
+	doc := chromem.Document{
+		ID:        fmt.Sprintf("%s:%d", m.sessionID, index),
+		Content:   message.Content,
+		Embedding: embedding,
+		Metadata: map[string]string{
+			"session_id": m.sessionID,
+			"role":       message.Role,
+			"index":      strconv.Itoa(index),
+		},
+	}
+	if err := m.collection.AddDocument(ctx, doc); err != nil {
+		log.Printf("[ERROR] RetrievalMemory: Failed to embed message %d of session %s: %v", index, m.sessionID, err)
+	}
+}
+
+// GetMessagesForContext returns the retrieved older messages (as a single
+// system message) followed by the most recent messages.
+func (m *RetrievalMemory) GetMessagesForContext(maxTokens int, modelName string) []gollm_types.MemoryMessage {
+	return m.messagesForContext(context.Background(), maxTokens, modelName)
+}
+
+// messagesForContext builds the context for a request made with ctx.
+func (m *RetrievalMemory) messagesForContext(ctx context.Context, maxTokens int, modelName string) []gollm_types.MemoryMessage {
+	estimationModel := m.defaultModelName
+	if modelName != "" {
+		estimationModel = modelName
+	}
+	history := m.base.GetHistory()
+	if estimateTotalTokens(history, estimationModel) <= maxTokens {
+		return history
+	}
+
+	// Most recent messages first
+	recentBudget := int(float64(maxTokens) * retrievalRecentRatio)
+	recentStart := len(history)
+	recentTokens := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		msgTokens := estimateTokens(history[i].Content, estimationModel)
+		if recentTokens+msgTokens > recentBudget {
+			break
+		}
+		recentTokens += msgTokens
+		recentStart = i
+	}
+	recent := history[recentStart:]
+
+	query := latestUserPrompt(history)
+	if query == "" || recentStart == 0 {
+		return recent
+	}
+
+	retrieved := m.retrieve(ctx, query, recentStart)
+	if len(retrieved) == 0 {
+		return recent
+	}
+
+	// Fill the remaining budget with the most relevant older messages, then
+	// present them in chronological order.
+	remaining := maxTokens - recentTokens
+	var selected []retrievedMessage
+	for _, r := range retrieved {
+		msgTokens := estimateTokens(r.content, estimationModel) + 3
+		if msgTokens > remaining {
+			continue
+		}
+		remaining -= msgTokens
+		selected = append(selected, r)
+	}
+	if len(selected) == 0 {
+		return recent
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].index < selected[j].index })
+
+	var builder strings.Builder
+	builder.WriteString("Relevant earlier messages from this conversation:\n")
+	for _, r := range selected {
+		builder.WriteString(fmt.Sprintf("[%s]: %s\n", r.role, r.content))
+	}
+	log.Printf("RetrievalMemory: Added %d relevant earlier messages to context for session %s.", len(selected), m.sessionID)
+
+	contextMessages := []gollm_types.MemoryMessage{{Role: "system", Content: strings.TrimSuffix(builder.String(), "\n")}}
+	return append(contextMessages, recent...)
+}
+
+// retrievedMessage is an older message returned by a similarity query.
+type retrievedMessage struct {
+	index   int
+	role    string
+	content string
+}
+
+// retrieve queries the collection for messages of this session older than
+// before, ordered by decreasing similarity to query.
+func (m *RetrievalMemory) retrieve(ctx context.Context, query string, before int) []retrievedMessage {
+	count := m.collection.Count()
+	if count == 0 {
+		return nil
+	}
+	n := retrievalMaxResults + (len(m.base.GetHistory()) - before) // Recent messages may also match
+	if n > count {
+		n = count
+	}
+	embedding, err := m.embedder.Embed(ctx, query)
+	if err != nil {
+		log.Printf("[WARN] RetrievalMemory: Failed to embed the query of session %s: %v", m.sessionID, err)
+		return nil
+	}
+	results, err := m.collection.QueryEmbedding(ctx, embedding, n, map[string]string{"session_id": m.sessionID}, nil)
+	if err != nil {
+		log.Printf("[WARN] RetrievalMemory: Query failed for session %s: %v", m.sessionID, err)
+		return nil
+	}
+
+	retrieved := make([]retrievedMessage, 0, len(results))
+	for _, result := range results {
+		index, err := strconv.Atoi(result.Metadata["index"])
+		if err != nil || index >= before {
+			continue
+		}
+		retrieved = append(retrieved, retrievedMessage{index: index, role: result.Metadata["role"], content: result.Content})
+		if len(retrieved) == retrievalMaxResults {
+			break
+		}
+	}
+	return retrieved
+}
+
+// latestUserPrompt returns the content of the most recent user message.
+func latestUserPrompt(history []gollm_types.MemoryMessage) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" {
+			return history[i].Content
+		}
+	}
+	return ""
+}
+
+// Clear removes all messages and their embeddings.
+func (m *RetrievalMemory) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.base.Clear()
+	if err := m.collection.Delete(context.Background(), map[string]string{"session_id": m.sessionID}, nil); err != nil {
+		log.Printf("[ERROR] RetrievalMemory: Failed to delete embeddings of session %s: %v", m.sessionID, err)
+	}
+}
+
+// GetHistory returns all messages of the underlying history.
+func (m *RetrievalMemory) GetHistory() []gollm_types.MemoryMessage {
+	return m.base.GetHistory()
+}
+
+// GetHistoryPage pages through the underlying history.
+func (m *RetrievalMemory) GetHistoryPage(offset, limit int) ([]gollm_types.MemoryMessage, int, error) {
+	return historyPageOf(m.base, offset, limit)
+}
+
+// retrievalRequest is the view of a RetrievalMemory for one request. Messages
+// and queries are embedded with the request's context, so its PII redaction
+// scope applies to them.
+type retrievalRequest struct {
+	*RetrievalMemory
+	ctx context.Context
+}
+
+// AddMessage adds a message and embeds it for the request.
+func (r retrievalRequest) AddMessage(message gollm_types.MemoryMessage) {
+	r.addMessage(r.ctx, message)
+}
+
+// GetMessagesForContext builds the context for the request.
+func (r retrievalRequest) GetMessagesForContext(maxTokens int, modelName string) []gollm_types.MemoryMessage {
+	return r.messagesForContext(r.ctx, maxTokens, modelName)
+}
+
+// summaryRequest is the view of a SummarizingMemory for one request, whose
+// context bounds the summarizer call.
+type summaryRequest struct {
+	*SummarizingMemory
+	ctx context.Context
+}
+
+// GetMessagesForContext builds the context for the request.
+func (r summaryRequest) GetMessagesForContext(maxTokens int, modelName string) []gollm_types.MemoryMessage {
+	return r.messagesForContext(r.ctx, maxTokens, modelName)
+}
+
+// historyPageOf pages through memory, using its own paging when available.
+func historyPageOf(memory ConversationMemory, offset, limit int) ([]gollm_types.MemoryMessage, int, error) {
+	if paged, ok := memory.(PagedMemory); ok {
+		return paged.GetHistoryPage(offset, limit)
+	}
+	history := memory.GetHistory()
+	return pageMessages(history, offset, limit), len(history), nil
+}
+
+// Compile-time checks for the memory strategies
+var _ ConversationMemory = (*SummarizingMemory)(nil)
+var _ ConversationMemory = (*RetrievalMemory)(nil)
+var _ PagedMemory = (*SummarizingMemory)(nil)
+var _ PagedMemory = (*RetrievalMemory)(nil)
+var _ PagedMemory = retrievalRequest{}
+var _ PagedMemory = summaryRequest{}
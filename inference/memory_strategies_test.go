@@ -0,0 +1,154 @@
+package inference
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+// countingSummarizer returns a fixed summary and counts the calls.
+type countingSummarizer struct {
+	calls atomic.Int32
+}
+
+func (s *countingSummarizer) GenerateText(ctx context.Context, prompt string) (string, error) {
+	s.calls.Add(1)
+	return "the user counted messages", nil
+}
+
+// blockingSummarizer blocks until the call's context ends.
+type blockingSummarizer struct {
+	started chan struct{}
+}
+
+func (s *blockingSummarizer) GenerateText(ctx context.Context, prompt string) (string, error) {
+	close(s.started)
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestSummaryHonorsRequestDeadlineWithoutLocking(t *testing.T) {
+	summarizer := &blockingSummarizer{started: make(chan struct{})}
+	manager := NewSessionManager(nil)
+	manager.SetSummarizer(summarizer)
+	session, err := manager.CreateSession(1, "m", MemoryStrategySummary)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(WithSessionToken(context.Background(), session.Token), 200*time.Millisecond)
+	defer cancel()
+	memory, err := manager.Memory(ctx, session.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory failed: %v", err)
+	}
+	for i := 1; i <= 20; i++ {
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: fmt.Sprintf("message number %d with a few more words", i)})
+	}
+
+	done := make(chan []gollm_types.MemoryMessage, 1)
+	go func() { done <- memory.GetMessagesForContext(60, "m") }()
+	<-summarizer.started
+
+	// The session stays usable while the summarizer runs
+	summaryRead := make(chan struct{})
+	go func() {
+		memory.(summaryRequest).Summary()
+		close(summaryRead)
+	}()
+	select {
+	case <-summaryRead:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the summary to be readable during the summarizer call")
+	}
+
+	select {
+	case messages := <-done:
+		if len(messages) == 0 || messages[0].Role == "system" {
+			t.Errorf("Expected the sliding window after the summarizer timed out, got %v", messages)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the summarizer call to stop at the request deadline")
+	}
+}
+
+func TestDeletingRetrievalSessionRemovesEmbeddings(t *testing.T) {
+	repo := newTestConversationRepo(t)
+	domainDB := newTestDomainDB(t)
+	manager := NewPersistentSessionManager(repo)
+	manager.SetRetrievalStore(domainDB)
+	session, err := manager.CreateSession(1, "m", MemoryStrategyRetrieval)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	ctx := WithSessionToken(context.Background(), session.Token)
+	memory, err := manager.Memory(ctx, session.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory failed: %v", err)
+	}
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: "my favourite colour is teal"})
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: "noted"})
+
+	collection, err := domainDB.GetOrCreateCollection(ConversationMemoryCollection)
+	if err != nil {
+		t.Fatalf("GetOrCreateCollection failed: %v", err)
+	}
+	if count := collection.Count(); count != 2 {
+		t.Fatalf("Expected 2 embedded messages, got %d", count)
+	}
+	if err := manager.DeleteSession(ctx, session.ID); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if count := collection.Count(); count != 0 {
+		t.Errorf("Expected the embeddings to be deleted with the session, got %d", count)
+	}
+}
+
+func TestSummaryPersistsAcrossRestart(t *testing.T) {
+	repo := newTestConversationRepo(t)
+	summarizer := &countingSummarizer{}
+	manager := NewPersistentSessionManager(repo)
+	manager.SetSummarizer(summarizer)
+	session, err := manager.CreateSession(1, "m", MemoryStrategySummary)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	ctx := WithSessionToken(context.Background(), session.Token)
+	memory, err := manager.Memory(ctx, session.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory failed: %v", err)
+	}
+	for i := 1; i <= 20; i++ {
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: fmt.Sprintf("message number %d with a few more words", i)})
+	}
+	const maxTokens = 60
+	memory.GetMessagesForContext(maxTokens, "m")
+	if calls := summarizer.calls.Load(); calls != 1 {
+		t.Fatalf("Expected one summarizer call, got %d", calls)
+	}
+
+	// After a restart the stored summary is used without summarizing again
+	restarted := NewPersistentSessionManager(repo)
+	restarted.SetSummarizer(summarizer)
+	memory, err = restarted.Memory(ctx, session.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory after restart failed: %v", err)
+	}
+	messages := memory.GetMessagesForContext(maxTokens, "m")
+	if calls := summarizer.calls.Load(); calls != 1 {
+		t.Errorf("Expected no summarizer call after the restart, got %d", calls-1)
+	}
+	if len(messages) == 0 || messages[0].Role != "system" || !strings.Contains(messages[0].Content, "the user counted messages") {
+		t.Errorf("Expected the stored summary ahead of the recent messages, got %v", messages)
+	}
+
+	// Clearing the session clears the stored summary
+	memory.Clear()
+	if summary, count, err := repo.GetSummary(context.Background(), session.ID); err != nil || summary != "" || count != 0 {
+		t.Errorf("Expected the stored summary to be cleared, got %q/%d (%v)", summary, count, err)
+	}
+}
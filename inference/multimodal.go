@@ -0,0 +1,270 @@
+package inference
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+var (
+	// ErrMultimodalUnsupported is returned when no usable model accepts the attached content.
+	ErrMultimodalUnsupported = errors.New("image or file input is not supported")
+	// ErrInvalidContentPart is returned for attachments without a MIME type or valid base64 data.
+	ErrInvalidContentPart = errors.New("invalid attachment")
+)
+
+// MaxContentPartBytes limits the decoded size of one inline attachment.
+const MaxContentPartBytes = 20 << 20
+
+// MaxContentParts limits the number of attachments of one request.
+const MaxContentParts = 8
+
+// contentPartsKey is the MemoryMessage metadata key holding a message's attachments.
+const contentPartsKey = "parts"
+
+// ContentPart is an image or file sent inline with a prompt.
+type ContentPart struct {
+	Name     string `json:"name,omitempty"`
+	MIMEType string `json:"mime_type"`
+	Data     string `json:"data"` // Base64 (standard encoding)
+}
+
+// NewContentPart encodes raw file content as a ContentPart.
+func NewContentPart(name, mimeType string, data []byte) ContentPart {
+	return ContentPart{Name: name, MIMEType: mimeType, Data: base64.StdEncoding.EncodeToString(data)}
+}
+
+// Validate checks the MIME type, the base64 data and the size limit.
+func (p ContentPart) Validate() error {
+	if p.MIMEType == "" || !strings.Contains(p.MIMEType, "/") {
+		return fmt.Errorf("%w '%s': mime_type is required (e.g. image/png)", ErrInvalidContentPart, p.Name)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		return fmt.Errorf("%w '%s': data is not valid base64: %v", ErrInvalidContentPart, p.Name, err)
+	}
+	if len(decoded) == 0 {
+		return fmt.Errorf("%w '%s': data is empty", ErrInvalidContentPart, p.Name)
+	}
+	if len(decoded) > MaxContentPartBytes {
+		return fmt.Errorf("%w '%s': %d bytes exceeds the %d byte limit", ErrInvalidContentPart, p.Name, len(decoded), MaxContentPartBytes)
+	}
+	return nil
+}
+
+// IsImage reports whether the part is an image.
+func (p ContentPart) IsImage() bool {
+	return strings.HasPrefix(p.MIMEType, "image/")
+}
+
+// describe names the part in text, for history and providers that only see text.
+func (p ContentPart) describe() string {
+	if p.Name != "" {
+		return fmt.Sprintf("[attached %s: %s]", p.MIMEType, p.Name)
+	}
+	return fmt.Sprintf("[attached %s]", p.MIMEType)
+}
+
+// withContentParts returns msg carrying parts in its metadata.
+func withContentParts(msg gollm_types.MemoryMessage, parts []ContentPart) gollm_types.MemoryMessage {
+	if len(parts) == 0 {
+		return msg
+	}
+	metadata := make(map[string]interface{}, len(msg.Metadata)+1)
+	for k, v := range msg.Metadata {
+		metadata[k] = v
+	}
+	metadata[contentPartsKey] = parts
+	msg.Metadata = metadata
+	return msg
+}
+
+// contentParts returns the attachments carried by msg.
+func contentParts(msg gollm_types.MemoryMessage) []ContentPart {
+	parts, _ := msg.Metadata[contentPartsKey].([]ContentPart)
+	return parts
+}
+
+// MultimodalRequestBuilder is implemented by providers whose request builders
+// can carry inline parts. Messages carry their parts in metadata (see
+// contentParts).
+type MultimodalRequestBuilder interface {
+	SupportsContentPart(part ContentPart) bool
+	PrepareMultimodalRequest(messages []gollm_types.MemoryMessage, options map[string]interface{}) ([]byte, error)
+}
+
+// multimodalTimeout bounds one multimodal request; attachments make them
+// slower than text requests.
+const multimodalTimeout = 120 * time.Second
+
+// GenerateWithParts generates a response to a prompt with attached images or
+// files. Models are tried like GenerateSimple (primary, then fallback, or only
+// modelName); models whose provider cannot take the attachments are skipped,
+// and ErrMultimodalUnsupported is returned when none can. Attachments are
+// recorded in the session history by name only.
+func (d *DelegatorService) GenerateWithParts(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string, parts []ContentPart) (*GenerationResult, error) {
+	if len(parts) > MaxContentParts {
+		return nil, fmt.Errorf("%w: %d attachments exceed the limit of %d", ErrInvalidContentPart, len(parts), MaxContentParts)
+	}
+	for _, part := range parts {
+		if err := part.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	memory, err := d.sessions.Memory(ctx, sessionID, d.tokenLimitCheckModel)
+	if err != nil {
+		return nil, err
+	}
+	if modelName == "" && sessionID != "" {
+		if session, err := d.sessions.GetSession(ctx, sessionID); err == nil {
+			modelName = session.Model
+		}
+	}
+
+	var attempts []LLMAttempt
+	if modelName != "" {
+		for _, attempt := range append(append([]LLMAttempt{}, d.primaryAttempts...), d.fallbackAttempts...) {
+			if attempt.Config.ModelName == modelName {
+				attempts = []LLMAttempt{attempt}
+				break
+			}
+		}
+		if len(attempts) == 0 {
+			return nil, fmt.Errorf("delegator service (Multimodal): requested model '%s' not found in configured attempts", modelName)
+		}
+	} else {
+		attempts = append(append([]LLMAttempt{}, d.primaryAttempts...), d.fallbackAttempts...)
+	}
+
+	// History is sent as text; only the current message carries the attachments
+	descriptions := make([]string, 0, len(parts))
+	for _, part := range parts {
+		descriptions = append(descriptions, part.describe())
+	}
+	historyMessage := gollm_types.MemoryMessage{Role: "user", Content: strings.TrimSpace(promptText + "\n" + strings.Join(descriptions, "\n"))}
+	messages := memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel)
+	if instructionText != "" {
+		messages = append([]gollm_types.MemoryMessage{{Role: "system", Content: instructionText}}, messages...)
+	}
+	messages = append(messages, withContentParts(gollm_types.MemoryMessage{Role: "user", Content: promptText}, parts))
+	memory.AddMessage(historyMessage)
+
+	var unsupported []string
+	var lastError error
+	for i, attempt := range attempts {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("Multimodal stopped: %w", err)
+		}
+		targetName := fmt.Sprintf("Attempt %d/%d (Model: %s)", i+1, len(attempts), attempt.Config.ModelName)
+		response, err := d.sendMultimodal(ctx, attempt, messages)
+		if errors.Is(err, ErrMultimodalUnsupported) {
+			log.Printf("DelegatorService (Multimodal): Skipping %s: %v", targetName, err)
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", attempt.Config.ModelName, attempt.Config.ProviderName))
+			continue
+		}
+		if err != nil {
+			log.Printf("DelegatorService (Multimodal): %s failed: %v", targetName, err)
+			lastError = err
+			if !d.shouldFallbackOnError(err) {
+				break
+			}
+			continue
+		}
+		log.Printf("DelegatorService (Multimodal): Generation successful with %s.", targetName)
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: response})
+		return &GenerationResult{Content: response, Metadata: map[string]interface{}{
+			"model":       attempt.Config.ModelName,
+			"attachments": len(parts),
+		}}, nil
+	}
+	if lastError == nil {
+		mimeTypes := make([]string, 0, len(parts))
+		for _, part := range parts {
+			mimeTypes = append(mimeTypes, part.MIMEType)
+		}
+		return nil, fmt.Errorf("no configured model accepts %s input (tried %s): %w", strings.Join(mimeTypes, ", "), strings.Join(unsupported, ", "), ErrMultimodalUnsupported)
+	}
+	return nil, fmt.Errorf("delegator service (Multimodal): all attempts failed: %w", lastError)
+}
+
+// sendMultimodal sends messages with attachments to one attempt's provider.
+func (d *DelegatorService) sendMultimodal(ctx context.Context, attempt LLMAttempt, messages []gollm_types.MemoryMessage) (string, error) {
+	// gollm only passes a flattened prompt string to providers, so requests
+	// with attachments are built here and sent through the attempt's client
+	providerLLM, ok := unguardedLLM(attempt.Instance).(*ProviderLLM)
+	if !ok {
+		return "", fmt.Errorf("model '%s': %w", attempt.Config.ModelName, ErrMultimodalUnsupported)
+	}
+	provider := providerLLM.Provider()
+	builder, ok := provider.(MultimodalRequestBuilder)
+	if !ok {
+		return "", fmt.Errorf("provider '%s': %w", provider.Name(), ErrMultimodalUnsupported)
+	}
+	for _, msg := range messages {
+		for _, part := range contentParts(msg) {
+			if !builder.SupportsContentPart(part) {
+				return "", fmt.Errorf("provider '%s' does not accept %s: %w", provider.Name(), part.MIMEType, ErrMultimodalUnsupported)
+			}
+		}
+	}
+
+	// Attachments are sent as they are; only the text passes the PII guardrail
+	redaction := d.currentPIIGuardrail().begin(ctx)
+	if redaction != nil {
+		redacted := make([]gollm_types.MemoryMessage, len(messages))
+		for i, msg := range messages {
+			msg.Content = redaction.redact(msg.Content)
+			redacted[i] = msg
+		}
+		messages = redacted
+		redaction.record(attempt.Config.ModelName)
+	}
+
+	body, err := builder.PrepareMultimodalRequest(messages, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare %s request: %w", provider.Name(), err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, multimodalTimeout)
+	defer cancel()
+	respBody, err := providerLLM.send(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	response, err := provider.ParseResponse(respBody)
+	return redaction.restore(response), err
+}
+
+// formatMultimodalMessages flattens messages like formatMessagesToPrompt and
+// lists each message's attachments after its text.
+func formatMultimodalMessages(messages []gollm_types.MemoryMessage) string {
+	var builder strings.Builder
+	for _, msg := range messages {
+		builder.WriteString(fmt.Sprintf("[%s]: %s\n", msg.Role, msg.Content))
+		for _, part := range contentParts(msg) {
+			builder.WriteString(part.describe())
+			builder.WriteString("\n")
+		}
+	}
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// GenerateTextWithParts delegates a prompt with attached images or files to
+// the DelegatorService.
+func (s *InferenceService) GenerateTextWithParts(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string, parts []ContentPart) (*GenerationResult, error) {
+	s.mutex.Lock()
+	if !s.isRunning || s.delegator == nil {
+		s.mutex.Unlock()
+		return nil, errors.New("inference service is not running or delegator not configured")
+	}
+	delegatorInstance := s.delegator
+	s.mutex.Unlock()
+
+	log.Printf("InferenceService: Delegating multimodal request (%d attachments) to DelegatorService. Model: '%s'", len(parts), modelName)
+	return delegatorInstance.GenerateWithParts(ctx, sessionID, modelName, promptText, instructionText, parts)
+}
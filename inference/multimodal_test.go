@@ -0,0 +1,125 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/guiperry/gollm_cerebras/config"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+func TestProvidersBuildMultimodalRequests(t *testing.T) {
+	image := NewContentPart("chart.png", "image/png", []byte("png bytes"))
+	pdf := NewContentPart("report.pdf", "application/pdf", []byte("%PDF-1.4"))
+	messages := []gollm_types.MemoryMessage{
+		{Role: "system", Content: "Describe what you see."},
+		withContentParts(gollm_types.MemoryMessage{Role: "user", Content: "What is in these?"}, []ContentPart{image, pdf}),
+	}
+
+	gemini := NewGeminiProvider("gemini-test-key", "", nil).(*GeminiProvider)
+	body, err := gemini.PrepareMultimodalRequest(messages, nil)
+	if err != nil {
+		t.Fatalf("Gemini PrepareMultimodalRequest failed: %v", err)
+	}
+	var geminiReq GeminiRequest
+	json.Unmarshal(body, &geminiReq)
+	if geminiReq.SystemInstruction == nil || len(geminiReq.Contents) != 1 || len(geminiReq.Contents[0].Parts) != 3 {
+		t.Fatalf("Expected a system instruction and one user turn with text and two files, got %s", body)
+	}
+	if inline := geminiReq.Contents[0].Parts[1].InlineData; inline == nil || inline.MimeType != "image/png" || inline.Data != image.Data {
+		t.Errorf("Expected the image as inlineData, got %+v", geminiReq.Contents[0].Parts[1])
+	}
+
+	anthropic := NewAnthropicProvider("sk-ant-test", "", nil).(*AnthropicProvider)
+	body, err = anthropic.PrepareMultimodalRequest(messages, nil)
+	if err != nil {
+		t.Fatalf("Anthropic PrepareMultimodalRequest failed: %v", err)
+	}
+	var anthropicReq AnthropicMessagesRequest
+	json.Unmarshal(body, &anthropicReq)
+	blocks := anthropicReq.Messages[0].Content
+	if len(blocks) != 3 || blocks[0].Type != "image" || blocks[1].Type != "document" || blocks[1].Source.MediaType != "application/pdf" || blocks[2].Type != "text" {
+		t.Errorf("Expected image, document and text blocks, got %s", body)
+	}
+
+	local := NewOpenAICompatibleProvider(openAICompatibleNoKey, "llava", nil).(*OpenAICompatibleProvider)
+	if !local.SupportsContentPart(image) || local.SupportsContentPart(pdf) {
+		t.Errorf("Expected the OpenAI-compatible provider to take images only")
+	}
+	body, err = local.PrepareMultimodalRequest(messages[1:], nil)
+	if err != nil {
+		t.Fatalf("OpenAI-compatible PrepareMultimodalRequest failed: %v", err)
+	}
+	if !strings.Contains(string(body), `"url":"data:image/png;base64,`+image.Data+`"`) {
+		t.Errorf("Expected the image as a data URL, got %s", body)
+	}
+}
+
+// providerAttempt returns an attempt sending to a real provider's endpoint.
+func providerAttempt(t *testing.T, provider, model, apiKey string) LLMAttempt {
+	t.Helper()
+	opts := []config.ConfigOption{config.SetProvider(provider), config.SetModel(model), config.SetAPIKey(apiKey)}
+	instance, err := NewProviderLLM(nil, opts...)
+	if err != nil {
+		t.Fatalf("NewProviderLLM failed: %v", err)
+	}
+	return LLMAttempt{Instance: instance, Config: LLMAttemptConfig{ProviderName: provider, ModelName: model}, Opts: opts}
+}
+
+func TestMultimodalInputRejectedByTextOnlyProviders(t *testing.T) {
+	image := NewContentPart("photo.jpg", "image/jpeg", []byte("jpeg bytes"))
+	messages := []gollm_types.MemoryMessage{withContentParts(gollm_types.MemoryMessage{Role: "user", Content: "hi"}, []ContentPart{image})}
+	attempt := providerAttempt(t, "cerebras", "llama-4-scout-17b-16e-instruct", "csk-test-key-long-enough-for-gollm")
+	if _, err := (&DelegatorService{}).sendMultimodal(context.Background(), attempt, messages); !errors.Is(err, ErrMultimodalUnsupported) {
+		t.Errorf("Expected ErrMultimodalUnsupported from cerebras, got %v", err)
+	}
+
+	if err := (ContentPart{Name: "x", Data: image.Data}).Validate(); !errors.Is(err, ErrInvalidContentPart) {
+		t.Errorf("Expected a missing mime_type to be invalid, got %v", err)
+	}
+	if err := (ContentPart{MIMEType: "image/png", Data: "not base64!"}).Validate(); !errors.Is(err, ErrInvalidContentPart) {
+		t.Errorf("Expected bad base64 to be invalid, got %v", err)
+	}
+}
+
+func TestGenerateWithPartsUsesFakeProvider(t *testing.T) {
+	service := startFakeInferenceService(t, `{
+		"default": "no attachment seen",
+		"rules": [{"match": "\\[attached image/png: chart.png\\]", "response": "a bar chart"}]
+	}`)
+	result, err := service.GenerateTextWithParts(context.Background(), "", "", "What is this?", "",
+		[]ContentPart{NewContentPart("chart.png", "image/png", []byte("png bytes"))})
+	if err != nil {
+		t.Fatalf("GenerateTextWithParts failed: %v", err)
+	}
+	if result.Content != "a bar chart" || result.Metadata["attachments"] != 1 {
+		t.Errorf("Expected 'a bar chart' with one attachment, got %q / %v", result.Content, result.Metadata)
+	}
+}
+
+func TestMultimodalErrorsOmitTheRequestURL(t *testing.T) {
+	messages := []gollm_types.MemoryMessage{withContentParts(gollm_types.MemoryMessage{Role: "user", Content: "hi"},
+		[]ContentPart{NewContentPart("photo.png", "image/png", []byte("png bytes"))})}
+	attempt := providerAttempt(t, "gemini", "gemini-1.5-flash-latest", "gemini-secret-key-1234567890")
+	// Gemini sends its API key in the URL; a canceled request fails before any network use
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := (&DelegatorService{}).sendMultimodal(ctx, attempt, messages)
+	if err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the canceled request to fail, got %v", err)
+	}
+	if strings.Contains(err.Error(), "gemini-secret-key") {
+		t.Errorf("Expected the API key to be left out of the error, got %q", err)
+	}
+
+	parts := make([]ContentPart, MaxContentParts+1)
+	for i := range parts {
+		parts[i] = NewContentPart("photo.png", "image/png", []byte("png bytes"))
+	}
+	if _, err := (&DelegatorService{}).GenerateWithParts(context.Background(), "", "", "hi", "", parts); !errors.Is(err, ErrInvalidContentPart) {
+		t.Errorf("Expected too many attachments to be rejected, got %v", err)
+	}
+}
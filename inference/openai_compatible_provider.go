@@ -0,0 +1,242 @@
+package inference
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/guiperry/gollm_cerebras/config"
+	"github.com/guiperry/gollm_cerebras/providers"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+// OpenAICompatibleProviderName is the gollm provider name of local,
+// OpenAI-compatible model servers (Ollama, llama.cpp, vLLM, LM Studio).
+const OpenAICompatibleProviderName = "openai_compatible"
+
+// DefaultOpenAICompatibleBaseURL is Ollama's OpenAI-compatible API.
+const DefaultOpenAICompatibleBaseURL = "http://localhost:11434/v1"
+
+// openAICompatibleNoKey stands in for the API key of servers without auth;
+// gollm refuses to create an LLM without one of more than 20 characters.
+const openAICompatibleNoKey = "no-key-openai-compatible-server"
+
+// OpenAICompatibleProvider implements providers.Provider for any server that
+// speaks the OpenAI chat completions API. Request building and response
+// parsing are the DeepSeek (and so Cerebras) ones; only the endpoint and
+// authentication differ.
+type OpenAICompatibleProvider struct {
+	*DeepseekProvider
+
+	baseURL string
+	urlLock sync.Mutex
+}
+
+// --- Registration ---
+func init() {
+	registry := providers.GetDefaultRegistry()
+	registry.Register(OpenAICompatibleProviderName, NewOpenAICompatibleProvider)
+	log.Println("Registered OpenAI-compatible provider constructor with gollm registry")
+}
+
+// NewOpenAICompatibleProvider creates a provider for the server at
+// LOCAL_LLM_BASE_URL (default DefaultOpenAICompatibleBaseURL).
+func NewOpenAICompatibleProvider(apiKey, model string, extraHeaders map[string]string) providers.Provider {
+	baseURL := os.Getenv("LOCAL_LLM_BASE_URL")
+	if baseURL == "" {
+		baseURL = DefaultOpenAICompatibleBaseURL
+	}
+	provider := &OpenAICompatibleProvider{
+		DeepseekProvider: NewDeepseekProvider(apiKey, model, extraHeaders).(*DeepseekProvider),
+		baseURL:          baseURL,
+	}
+	log.Printf("NewOpenAICompatibleProvider created: model=%s, baseURL=%s", provider.model, baseURL)
+	return provider
+}
+
+// Name returns the provider's identifier.
+func (p *OpenAICompatibleProvider) Name() string {
+	return OpenAICompatibleProviderName
+}
+
+// Endpoint returns the chat completions URL under the configured base URL.
+func (p *OpenAICompatibleProvider) Endpoint() string {
+	p.urlLock.Lock()
+	defer p.urlLock.Unlock()
+	return openAICompatibleEndpoint(p.baseURL)
+}
+
+// openAICompatibleEndpoint accepts a base URL with or without the
+// /chat/completions suffix.
+func openAICompatibleEndpoint(baseURL string) string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if strings.HasSuffix(baseURL, "/chat/completions") {
+		return baseURL
+	}
+	return baseURL + "/chat/completions"
+}
+
+// Headers returns the HTTP headers, with a bearer token only when a real API key is set.
+func (p *OpenAICompatibleProvider) Headers() map[string]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       "application/json",
+		"User-Agent":   "Wordpress-Inference-Engine/1.0 (via Gollm Provider)",
+	}
+	if p.apiKey != "" && p.apiKey != openAICompatibleNoKey {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	for k, v := range p.extraHeaders {
+		headers[k] = v
+	}
+	return headers
+}
+
+// SetDefaultOptions applies global configuration defaults.
+func (p *OpenAICompatibleProvider) SetDefaultOptions(cfg *config.Config) {
+	if cfg != nil && cfg.APIKeys != nil {
+		if apiKey, ok := cfg.APIKeys[p.Name()]; ok && apiKey != "" {
+			p.DeepseekProvider.SetOption("api_key", apiKey)
+		}
+	}
+	p.DeepseekProvider.SetDefaultOptions(cfg)
+}
+
+// SetOption sets a configuration option; "base_url" points the provider at another server.
+func (p *OpenAICompatibleProvider) SetOption(key string, value interface{}) {
+	if key == "base_url" {
+		if v, ok := value.(string); ok && v != "" {
+			p.urlLock.Lock()
+			p.baseURL = v
+			p.urlLock.Unlock()
+		}
+		return
+	}
+	p.DeepseekProvider.SetOption(key, value)
+}
+
+// openAIContentPart is an entry of an OpenAI message content array.
+type openAIContentPart struct {
+	Type     string          `json:"type"` // "text" or "image_url"
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"` // data:<mime>;base64,<data>
+}
+
+// openAIMultimodalMessage has string content, or a content array when the
+// message carries images.
+type openAIMultimodalMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type openAIMultimodalRequest struct {
+	Model       string                    `json:"model"`
+	Messages    []openAIMultimodalMessage `json:"messages"`
+	MaxTokens   int                       `json:"max_tokens,omitempty"`
+	Temperature *float64                  `json:"temperature,omitempty"`
+}
+
+// SupportsContentPart reports whether the part can be sent. The chat
+// completions API only takes images inline; whether the served model can see
+// them is up to the server.
+func (p *OpenAICompatibleProvider) SupportsContentPart(part ContentPart) bool {
+	return part.IsImage()
+}
+
+// PrepareMultimodalRequest builds a chat completions request with images as
+// image_url data URLs.
+func (p *OpenAICompatibleProvider) PrepareMultimodalRequest(messages []gollm_types.MemoryMessage, options map[string]interface{}) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	reqBody := openAIMultimodalRequest{Model: p.model, MaxTokens: p.maxTokens, Temperature: p.temperature}
+	for _, msg := range messages {
+		role := strings.ToLower(msg.Role)
+		switch role {
+		case "system", "assistant", "user":
+		case "ai":
+			role = "assistant"
+		default:
+			role = "user"
+		}
+		parts := contentParts(msg)
+		if len(parts) == 0 {
+			reqBody.Messages = append(reqBody.Messages, openAIMultimodalMessage{Role: role, Content: msg.Content})
+			continue
+		}
+		content := make([]openAIContentPart, 0, len(parts)+1)
+		if msg.Content != "" {
+			content = append(content, openAIContentPart{Type: "text", Text: msg.Content})
+		}
+		for _, part := range parts {
+			content = append(content, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: fmt.Sprintf("data:%s;base64,%s", part.MIMEType, part.Data)}})
+		}
+		reqBody.Messages = append(reqBody.Messages, openAIMultimodalMessage{Role: role, Content: content})
+	}
+	return json.Marshal(reqBody)
+}
+
+// localAttemptConfigFromEnv adds a local model server to the attempt chain
+// when LOCAL_LLM_MODEL is set. LOCAL_LLM_ROLE chooses "primary" or
+// "fallback" (default), LOCAL_LLM_MAX_TOKENS its token limit and
+// LOCAL_LLM_API_KEY optional authentication.
+func localAttemptConfigFromEnv() (LLMAttemptConfig, bool) {
+	model := os.Getenv("LOCAL_LLM_MODEL")
+	if model == "" {
+		return LLMAttemptConfig{}, false
+	}
+	attempt := LLMAttemptConfig{
+		ProviderName:  OpenAICompatibleProviderName,
+		ModelName:     model,
+		APIKeyEnvVar:  "LOCAL_LLM_API_KEY",
+		DefaultAPIKey: openAICompatibleNoKey, // Local servers usually run without auth
+		MaxTokens:     4096,
+	}
+	switch role := strings.ToLower(os.Getenv("LOCAL_LLM_ROLE")); role {
+	case "primary":
+		attempt.IsPrimary = true
+	case "", "fallback":
+	default:
+		log.Printf("[WARN] InferenceService: Invalid LOCAL_LLM_ROLE '%s'. Using the local model as a fallback.", role)
+	}
+	if raw := os.Getenv("LOCAL_LLM_MAX_TOKENS"); raw != "" {
+		if value, err := strconv.Atoi(raw); err == nil && value > 0 {
+			attempt.MaxTokens = value
+		} else {
+			log.Printf("[WARN] InferenceService: Invalid LOCAL_LLM_MAX_TOKENS '%s'. Using default %d.", raw, attempt.MaxTokens)
+		}
+	}
+	return attempt, true
+}
+
+// withLocalAttempt places the local attempt first among the primary or
+// fallback attempts, so the last fallback stays the final chunking target.
+func withLocalAttempt(attemptConfigs []LLMAttemptConfig, local LLMAttemptConfig) []LLMAttemptConfig {
+	result := make([]LLMAttemptConfig, 0, len(attemptConfigs)+1)
+	inserted := false
+	for _, attempt := range attemptConfigs {
+		if !inserted && attempt.IsPrimary == local.IsPrimary {
+			result = append(result, local)
+			inserted = true
+		}
+		result = append(result, attempt)
+	}
+	if !inserted {
+		result = append(result, local)
+	}
+	return result
+}
+
+// --- Compile-time Interface Check ---
+var _ providers.Provider = (*OpenAICompatibleProvider)(nil)
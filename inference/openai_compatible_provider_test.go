@@ -0,0 +1,65 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/guiperry/gollm_cerebras"
+	"github.com/guiperry/gollm_cerebras/config"
+)
+
+func TestOpenAICompatibleProviderTalksToLocalServer(t *testing.T) {
+	var gotPath, gotAuth, gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotModel = req.Model
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1", "object": "chat.completion", "model": "llama3", "choices": [{"index": 0, "message": {"role": "assistant", "content": "hello from local"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+	t.Setenv("LOCAL_LLM_BASE_URL", server.URL+"/v1/")
+
+	instance, err := gollm.NewLLM(
+		config.SetProvider(OpenAICompatibleProviderName),
+		config.SetAPIKey(openAICompatibleNoKey),
+		config.SetModel("llama3"),
+		config.SetMaxTokens(256),
+	)
+	if err != nil {
+		t.Fatalf("NewLLM failed: %v", err)
+	}
+	text, err := instance.Generate(context.Background(), instance.NewPrompt("hi"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if text != "hello from local" {
+		t.Errorf("Expected 'hello from local', got %q", text)
+	}
+	if gotPath != "/v1/chat/completions" || gotModel != "llama3" {
+		t.Errorf("Expected llama3 at /v1/chat/completions, got %q at %q", gotModel, gotPath)
+	}
+	if gotAuth != "" {
+		t.Errorf("Expected no Authorization header without an API key, got %q", gotAuth)
+	}
+}
+
+func TestLocalAttemptConfigFromEnv(t *testing.T) {
+	t.Setenv("LOCAL_LLM_MODEL", "qwen2.5")
+	t.Setenv("LOCAL_LLM_ROLE", "primary")
+	local, ok := localAttemptConfigFromEnv()
+	if !ok || !local.IsPrimary || local.ProviderName != OpenAICompatibleProviderName {
+		t.Fatalf("Expected a primary local attempt, got %+v (%v)", local, ok)
+	}
+	chain := withLocalAttempt([]LLMAttemptConfig{
+		{ModelName: "hosted-primary", IsPrimary: true},
+		{ModelName: "hosted-fallback"},
+	}, local)
+	if chain[0].ModelName != "qwen2.5" || chain[2].ModelName != "hosted-fallback" {
+		t.Errorf("Expected the local model first and the hosted fallback last, got %+v", chain)
+	}
+}
@@ -0,0 +1,566 @@
+package inference
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"Agentic_Engine/database"
+
+	"github.com/guiperry/gollm_cerebras/llm"
+)
+
+// ErrPIIRuleInvalid is returned when a PII guardrail configuration is rejected.
+var ErrPIIRuleInvalid = errors.New("invalid PII rule")
+
+// Built-in PII detectors, by the name used in placeholders and audit counts.
+const (
+	PIITypeEmail = "EMAIL"
+	PIITypePhone = "PHONE"
+	PIITypeCard  = "CARD"
+	PIITypeIP    = "IP"
+)
+
+// PIIRule is a custom detector; matches are replaced with [NAME_n] placeholders.
+type PIIRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// PIIGuardrailConfig configures the PII guardrail.
+type PIIGuardrailConfig struct {
+	Enabled     bool      `json:"enabled"`                // Default for requests without an agent flag
+	Types       []string  `json:"types,omitempty"`        // Built-in detectors to use, empty means all
+	CustomRules []PIIRule `json:"custom_rules,omitempty"` // Applied after the built-in detectors
+}
+
+// PIIAudit counts the values redacted from prompts sent to providers. A
+// request that is retried or falls back is counted once per provider call.
+type PIIAudit struct {
+	ProviderCalls int            `json:"provider_calls"` // Calls with at least one redaction
+	Redactions    int            `json:"redactions"`
+	ByType        map[string]int `json:"by_type"`
+	ByAgent       map[string]int `json:"by_agent"` // Requests without an agent are counted under ""
+}
+
+// piiDetector finds one kind of PII. valid, when set, rejects false positives;
+// numeric matches must also not be part of a longer number or word.
+type piiDetector struct {
+	name    string
+	pattern *regexp.Regexp
+	valid   func(match string) bool
+	numeric bool
+}
+
+var (
+	piiRuleNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	piiDatePattern     = regexp.MustCompile(`^(?:\d{4}[-./]\d{1,2}[-./]\d{1,2}|\d{1,2}[-./]\d{1,2}[-./]\d{4})$`)
+
+	builtinPIIDetectors = []piiDetector{
+		{name: PIITypeEmail, pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+		// Cards before phones, so card numbers are not taken for phone numbers
+		{name: PIITypeCard, pattern: regexp.MustCompile(`\d(?:[ -]?\d){12,18}`), valid: validCardNumber, numeric: true},
+		{name: PIITypeIP, pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?i)\b[0-9a-f]{1,4}(?::[0-9a-f]{0,4}){2,7}`), valid: validIP},
+		{name: PIITypePhone, pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,4}`), valid: validPhoneNumber, numeric: true},
+	}
+)
+
+// validCardNumber accepts 13 to 19 digits passing the Luhn check.
+func validCardNumber(match string) bool {
+	digits := onlyDigits(match)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 0 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validIP accepts IPv4 and IPv6 addresses.
+func validIP(match string) bool {
+	return net.ParseIP(match) != nil
+}
+
+// validPhoneNumber accepts 7 to 15 digits that are not a date.
+func validPhoneNumber(match string) bool {
+	digits := onlyDigits(match)
+	return len(digits) >= 7 && len(digits) <= 15 && !piiDatePattern.MatchString(strings.TrimSpace(match))
+}
+
+// isolatedMatch reports whether text[start:end] is not part of a longer
+// number, word or decimal.
+func isolatedMatch(text string, start, end int) bool {
+	isWordByte := func(b byte) bool {
+		return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_'
+	}
+	if start > 0 && (isWordByte(text[start-1]) || text[start-1] == '.') {
+		return false
+	}
+	if end < len(text) && (isWordByte(text[end]) || text[end] == '.' && end+1 < len(text) && isWordByte(text[end+1])) {
+		return false
+	}
+	return true
+}
+
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// PIIGuardrail replaces PII in prompts with placeholders before they are sent
+// to a provider and puts the original values back into the response.
+type PIIGuardrail struct {
+	config    PIIGuardrailConfig
+	detectors []piiDetector
+	audit     PIIAudit
+	mutex     sync.RWMutex
+}
+
+// NewPIIGuardrail creates a guardrail with config.
+func NewPIIGuardrail(config PIIGuardrailConfig) (*PIIGuardrail, error) {
+	g := &PIIGuardrail{audit: PIIAudit{ByType: make(map[string]int), ByAgent: make(map[string]int)}}
+	if err := g.Configure(config); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// piiGuardrailConfigFromEnv reads PII_REDACTION (default off) and
+// PII_REDACTION_TYPES, a comma-separated list of built-in detectors.
+func piiGuardrailConfigFromEnv() PIIGuardrailConfig {
+	var config PIIGuardrailConfig
+	if raw := os.Getenv("PII_REDACTION"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Printf("[WARN] PIIGuardrail: Invalid PII_REDACTION '%s'. Redaction disabled.", raw)
+		}
+		config.Enabled = enabled
+	}
+	if raw := os.Getenv("PII_REDACTION_TYPES"); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.Types = append(config.Types, name)
+			}
+		}
+	}
+	return config
+}
+
+// Configure validates and applies config. The audit counts are kept.
+func (g *PIIGuardrail) Configure(config PIIGuardrailConfig) error {
+	var detectors []piiDetector
+	types := make(map[string]bool)
+	for _, name := range config.Types {
+		types[strings.ToUpper(name)] = true
+	}
+	all := len(types) == 0
+	for _, detector := range builtinPIIDetectors {
+		if all || types[detector.name] {
+			detectors = append(detectors, detector)
+			delete(types, detector.name)
+		}
+	}
+	if len(types) > 0 {
+		unknown := make([]string, 0, len(types))
+		for name := range types {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: unknown type %s (use %s, %s, %s or %s)", ErrPIIRuleInvalid, strings.Join(unknown, ", "), PIITypeEmail, PIITypePhone, PIITypeCard, PIITypeIP)
+	}
+	names := map[string]bool{PIITypeEmail: true, PIITypePhone: true, PIITypeCard: true, PIITypeIP: true}
+	config.CustomRules = append([]PIIRule(nil), config.CustomRules...)
+	for i, rule := range config.CustomRules {
+		if !piiRuleNamePattern.MatchString(rule.Name) {
+			return fmt.Errorf("%w: name %q must start with a letter and contain only letters, digits and underscores", ErrPIIRuleInvalid, rule.Name)
+		}
+		name := strings.ToUpper(rule.Name)
+		if names[name] {
+			return fmt.Errorf("%w: name %s is already used", ErrPIIRuleInvalid, name)
+		}
+		names[name] = true
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("%w: pattern of %s: %v", ErrPIIRuleInvalid, name, err)
+		}
+		config.CustomRules[i].Name = name
+		detectors = append(detectors, piiDetector{name: name, pattern: pattern})
+	}
+	if len(config.Types) == 0 {
+		config.Types = nil
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.config = config
+	g.detectors = detectors
+	log.Printf("PIIGuardrail: Configured (enabled by default: %t, %d detectors)", config.Enabled, len(detectors))
+	return nil
+}
+
+// Config returns the current configuration.
+func (g *PIIGuardrail) Config() PIIGuardrailConfig {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	config := g.config
+	config.Types = append([]string(nil), g.config.Types...)
+	config.CustomRules = append([]PIIRule(nil), g.config.CustomRules...)
+	return config
+}
+
+// Audit returns a copy of the redaction counts.
+func (g *PIIGuardrail) Audit() PIIAudit {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	audit := g.audit
+	audit.ByType = make(map[string]int, len(g.audit.ByType))
+	for k, v := range g.audit.ByType {
+		audit.ByType[k] = v
+	}
+	audit.ByAgent = make(map[string]int, len(g.audit.ByAgent))
+	for k, v := range g.audit.ByAgent {
+		audit.ByAgent[k] = v
+	}
+	return audit
+}
+
+type piiScopeKey struct{}
+
+// piiScope is the agent a request runs for and its redaction flag.
+type piiScope struct {
+	agentID string
+	enabled *bool
+}
+
+// WithPIIRedaction marks ctx as a request for agentID. enabled is the agent's
+// redaction flag; nil uses the guardrail default.
+func WithPIIRedaction(ctx context.Context, agentID string, enabled *bool) context.Context {
+	return context.WithValue(ctx, piiScopeKey{}, piiScope{agentID: agentID, enabled: enabled})
+}
+
+// piiRedaction holds the placeholders of one provider call.
+type piiRedaction struct {
+	guardrail    *PIIGuardrail
+	agentID      string
+	detectors    []piiDetector
+	placeholders map[string]string // "TYPE\x00value" -> placeholder
+	originals    map[string]string // placeholder -> value
+	counts       map[string]int    // Placeholders created per type
+}
+
+// begin starts a redaction for a call made with ctx, or returns nil when
+// redaction is off for the request.
+func (g *PIIGuardrail) begin(ctx context.Context) *piiRedaction {
+	if g == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(piiScopeKey{}).(piiScope)
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	enabled := g.config.Enabled
+	if scope.enabled != nil {
+		enabled = *scope.enabled
+	}
+	if !enabled || len(g.detectors) == 0 {
+		return nil
+	}
+	return &piiRedaction{
+		guardrail:    g,
+		agentID:      scope.agentID,
+		detectors:    g.detectors,
+		placeholders: make(map[string]string),
+		originals:    make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// redact replaces PII in text; the same value always gets the same placeholder.
+func (r *piiRedaction) redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	for _, detector := range r.detectors {
+		var b strings.Builder
+		last := 0
+		for _, loc := range detector.pattern.FindAllStringIndex(text, -1) {
+			match := text[loc[0]:loc[1]]
+			if match == "" {
+				continue
+			}
+			if _, isPlaceholder := r.originals[match]; isPlaceholder ||
+				(detector.valid != nil && !detector.valid(match)) ||
+				(detector.numeric && !isolatedMatch(text, loc[0], loc[1])) {
+				continue
+			}
+			b.WriteString(text[last:loc[0]])
+			b.WriteString(r.placeholder(detector.name, match))
+			last = loc[1]
+		}
+		if last > 0 {
+			b.WriteString(text[last:])
+			text = b.String()
+		}
+	}
+	return text
+}
+
+// placeholder returns the placeholder of value, creating it on first use.
+func (r *piiRedaction) placeholder(name, value string) string {
+	key := name + "\x00" + value
+	if placeholder, ok := r.placeholders[key]; ok {
+		return placeholder
+	}
+	r.counts[name]++
+	placeholder := fmt.Sprintf("[%s_%d]", name, r.counts[name])
+	r.placeholders[key] = placeholder
+	r.originals[placeholder] = value
+	return placeholder
+}
+
+// restore puts the original values back in place of their placeholders.
+func (r *piiRedaction) restore(text string) string {
+	if r == nil || len(r.originals) == 0 {
+		return text
+	}
+	// Longest first, so [EMAIL_1] does not replace the start of [EMAIL_10]
+	placeholders := make([]string, 0, len(r.originals))
+	for placeholder := range r.originals {
+		placeholders = append(placeholders, placeholder)
+	}
+	sort.Slice(placeholders, func(i, j int) bool { return len(placeholders[i]) > len(placeholders[j]) })
+	pairs := make([]string, 0, 2*len(placeholders))
+	for _, placeholder := range placeholders {
+		pairs = append(pairs, placeholder, r.originals[placeholder])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// restoreValue restores the placeholders in the strings of a decoded JSON value.
+func (r *piiRedaction) restoreValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		return r.restore(typed)
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = r.restoreValue(item)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = r.restoreValue(item)
+		}
+	}
+	return value
+}
+
+type piiRedactionKey struct{}
+
+// withRedaction passes the redaction of a provider call down to ProviderLLM,
+// which restores it in the tool calls it collects from the response.
+func withRedaction(ctx context.Context, redaction *piiRedaction) context.Context {
+	return context.WithValue(ctx, piiRedactionKey{}, redaction)
+}
+
+// redactionFrom returns the redaction of the provider call made with ctx, or nil.
+func redactionFrom(ctx context.Context) *piiRedaction {
+	redaction, _ := ctx.Value(piiRedactionKey{}).(*piiRedaction)
+	return redaction
+}
+
+// record adds the call's redactions to the audit counts. Values are never logged.
+func (r *piiRedaction) record(target string) {
+	if r == nil || len(r.originals) == 0 {
+		return
+	}
+	g := r.guardrail
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.audit.ProviderCalls++
+	g.audit.Redactions += len(r.originals)
+	g.audit.ByAgent[r.agentID] += len(r.originals)
+	for name, count := range r.counts {
+		g.audit.ByType[name] += count
+	}
+	log.Printf("PIIGuardrail: Redacted %d values %v before calling %s", len(r.originals), r.counts, target)
+}
+
+// redactPrompt returns a copy of prompt with PII redacted from its text fields.
+func (r *piiRedaction) redactPrompt(prompt *llm.Prompt) *llm.Prompt {
+	if r == nil || prompt == nil {
+		return prompt
+	}
+	redacted := *prompt
+	redacted.Input = r.redact(prompt.Input)
+	redacted.Context = r.redact(prompt.Context)
+	redacted.SystemPrompt = r.redact(prompt.SystemPrompt)
+	redacted.Directives = make([]string, len(prompt.Directives))
+	for i, directive := range prompt.Directives {
+		redacted.Directives[i] = r.redact(directive)
+	}
+	redacted.Examples = make([]string, len(prompt.Examples))
+	for i, example := range prompt.Examples {
+		redacted.Examples[i] = r.redact(example)
+	}
+	redacted.Messages = make([]llm.PromptMessage, len(prompt.Messages))
+	for i, msg := range prompt.Messages {
+		msg.Content = r.redact(msg.Content)
+		redacted.Messages[i] = msg
+	}
+	return &redacted
+}
+
+// fingerprint identifies the values redacted so far, or returns "" when
+// nothing was redacted. Texts that only differ in their PII redact to the same
+// text but not to the same fingerprint.
+func (r *piiRedaction) fingerprint() string {
+	if r == nil || len(r.placeholders) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(r.placeholders))
+	for key := range r.placeholders {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.Sum256([]byte(strings.Join(keys, "\x01")))
+	return hex.EncodeToString(hash[:])
+}
+
+// fingerprint returns the fingerprint of the PII in text for a request made
+// with ctx, or "" when redaction is off or text holds no PII.
+func (g *PIIGuardrail) fingerprint(ctx context.Context, text string) string {
+	redaction := g.begin(ctx)
+	redaction.redact(text)
+	return redaction.fingerprint()
+}
+
+// piiGuardedLLM applies the guardrail to every call of an attempt's LLM.
+type piiGuardedLLM struct {
+	llm.LLM
+	guardrail *PIIGuardrail
+	name      string
+}
+
+// guardLLM wraps instance so prompts are redacted according to guardrail.
+func guardLLM(instance llm.LLM, guardrail *PIIGuardrail, name string) llm.LLM {
+	return &piiGuardedLLM{LLM: instance, guardrail: guardrail, name: name}
+}
+
+// unguardedLLM returns the LLM wrapped by guardLLM, or instance itself.
+func unguardedLLM(instance llm.LLM) llm.LLM {
+	if guarded, ok := instance.(*piiGuardedLLM); ok {
+		return guarded.LLM
+	}
+	return instance
+}
+
+// Generate redacts the prompt and restores the response and its tool calls.
+func (g *piiGuardedLLM) Generate(ctx context.Context, prompt *llm.Prompt, opts ...llm.GenerateOption) (string, error) {
+	redaction := g.guardrail.begin(ctx)
+	if redaction == nil {
+		return g.LLM.Generate(ctx, prompt, opts...)
+	}
+	prompt = redaction.redactPrompt(prompt)
+	redaction.record(g.name)
+	response, err := g.LLM.Generate(withRedaction(ctx, redaction), prompt, opts...)
+	return redaction.restore(response), err
+}
+
+// GenerateWithSchema redacts the prompt and restores the response.
+func (g *piiGuardedLLM) GenerateWithSchema(ctx context.Context, prompt *llm.Prompt, schema interface{}, opts ...llm.GenerateOption) (string, error) {
+	redaction := g.guardrail.begin(ctx)
+	if redaction == nil {
+		return g.LLM.GenerateWithSchema(ctx, prompt, schema, opts...)
+	}
+	prompt = redaction.redactPrompt(prompt)
+	redaction.record(g.name)
+	response, err := g.LLM.GenerateWithSchema(withRedaction(ctx, redaction), prompt, schema, opts...)
+	return redaction.restore(response), err
+}
+
+// Stream is refused while redaction is on: placeholders can be split across
+// tokens, so they could not be restored reliably.
+func (g *piiGuardedLLM) Stream(ctx context.Context, prompt *llm.Prompt, opts ...llm.StreamOption) (llm.TokenStream, error) {
+	if g.guardrail.begin(ctx) != nil {
+		return nil, errors.New("streaming is not available while PII redaction is enabled")
+	}
+	return g.LLM.Stream(ctx, prompt, opts...)
+}
+
+// generateText sends a text prompt through generate with PII redacted, for
+// calls that do not go through a guarded LLM (the MOA).
+func (g *PIIGuardrail) generateText(ctx context.Context, target string, prompt string, generate func(context.Context, string) (string, error)) (string, error) {
+	redaction := g.begin(ctx)
+	prompt = redaction.redact(prompt)
+	redaction.record(target)
+	response, err := generate(ctx, prompt)
+	return redaction.restore(response), err
+}
+
+// generateWithMOA sends prompt through the MOA with PII redacted.
+func (d *DelegatorService) generateWithMOA(ctx context.Context, prompt string) (string, error) {
+	return d.currentPIIGuardrail().generateText(ctx, "MOA", prompt, d.moa.Generate)
+}
+
+// piiGuardedEmbedder applies the guardrail to texts sent to a remote embedder.
+type piiGuardedEmbedder struct {
+	database.Embedder
+	guardrail *PIIGuardrail
+}
+
+// guardEmbedder wraps embedder so texts are redacted according to guardrail
+// before they are embedded. The local hash embedder is returned as is, since
+// its texts never leave the process.
+func guardEmbedder(embedder database.Embedder, guardrail *PIIGuardrail) database.Embedder {
+	if embedder == nil || guardrail == nil {
+		return embedder
+	}
+	if _, local := embedder.(*database.HashEmbedder); local {
+		return embedder
+	}
+	return &piiGuardedEmbedder{Embedder: embedder, guardrail: guardrail}
+}
+
+// Embed embeds text with PII redacted.
+func (e *piiGuardedEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	redaction := e.guardrail.begin(ctx)
+	text = redaction.redact(text)
+	redaction.record("embedder " + e.Name())
+	return e.Embedder.Embed(ctx, text)
+}
+
+// EmbedBatch embeds texts with PII redacted, in one call when the wrapped
+// embedder supports it.
+func (e *piiGuardedEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	redaction := e.guardrail.begin(ctx)
+	if redaction != nil {
+		redacted := make([]string, len(texts))
+		for i, text := range texts {
+			redacted[i] = redaction.redact(text)
+		}
+		texts = redacted
+		redaction.record("embedder " + e.Name())
+	}
+	return database.EmbedTexts(ctx, e.Embedder, texts)
+}
@@ -0,0 +1,226 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"Agentic_Engine/database"
+
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+func TestPIIRedactionRoundTrip(t *testing.T) {
+	guardrail, err := NewPIIGuardrail(PIIGuardrailConfig{
+		Enabled:     true,
+		CustomRules: []PIIRule{{Name: "employee_id", Pattern: `EMP-\d{5}`}},
+	})
+	if err != nil {
+		t.Fatalf("NewPIIGuardrail failed: %v", err)
+	}
+	redaction := guardrail.begin(context.Background())
+	text := "Mail jane.doe@example.com or call +1 (555) 123-4567 about card 4111 1111 1111 1111 " +
+		"from 192.168.1.20 and EMP-00042. jane.doe@example.com again."
+	redacted := redaction.redact(text)
+	for _, value := range []string{"jane.doe@example.com", "555) 123-4567", "4111 1111 1111 1111", "192.168.1.20", "EMP-00042"} {
+		if strings.Contains(redacted, value) {
+			t.Errorf("Expected %q to be redacted, got %q", value, redacted)
+		}
+	}
+	for _, placeholder := range []string{"[EMAIL_1]", "[PHONE_1]", "[CARD_1]", "[IP_1]", "[EMPLOYEE_ID_1]"} {
+		if !strings.Contains(redacted, placeholder) {
+			t.Errorf("Expected placeholder %s in %q", placeholder, redacted)
+		}
+	}
+	if strings.Contains(redacted, "[EMAIL_2]") {
+		t.Errorf("Expected a repeated email to reuse its placeholder, got %q", redacted)
+	}
+	if restored := redaction.restore(redacted); restored != text {
+		t.Errorf("Expected restore to return the original text, got %q", restored)
+	}
+
+	// Numbers that only look like PII are left alone
+	for _, text := range []string{"pi is 3.14159265", "released on 2024-05-17", "due 17.05.2024", "use std::vector", "order 4111111111111112"} {
+		if redacted := guardrail.begin(context.Background()).redact(text); redacted != text {
+			t.Errorf("Expected %q to be unchanged, got %q", text, redacted)
+		}
+	}
+}
+
+func TestPIIGuardrailRejectsInvalidRules(t *testing.T) {
+	for _, config := range []PIIGuardrailConfig{
+		{Types: []string{"email", "passport"}},
+		{CustomRules: []PIIRule{{Name: "1st", Pattern: "x"}}},
+		{CustomRules: []PIIRule{{Name: "email", Pattern: "x"}}},
+		{CustomRules: []PIIRule{{Name: "ssn", Pattern: "("}}},
+	} {
+		if _, err := NewPIIGuardrail(config); !errors.Is(err, ErrPIIRuleInvalid) {
+			t.Errorf("Expected %+v to be rejected with ErrPIIRuleInvalid, got %v", config, err)
+		}
+	}
+
+	guardrail, err := NewPIIGuardrail(PIIGuardrailConfig{Enabled: true, Types: []string{"email"}})
+	if err != nil {
+		t.Fatalf("NewPIIGuardrail failed: %v", err)
+	}
+	text := "a@b.io at 10.0.0.1"
+	if redacted := guardrail.begin(context.Background()).redact(text); redacted != "[EMAIL_1] at 10.0.0.1" {
+		t.Errorf("Expected only the email to be redacted, got %q", redacted)
+	}
+	disabled := false
+	if redaction := guardrail.begin(WithPIIRedaction(context.Background(), "agent-1", &disabled)); redaction != nil {
+		t.Errorf("Expected an agent flag to turn redaction off")
+	}
+}
+
+func TestPIIGuardrailRedactsProviderCalls(t *testing.T) {
+	service := startFakeInferenceService(t, `{
+		"default": "the prompt was not redacted",
+		"rules": [{"match": "contact (\\[EMAIL_1\\])", "response": "I will write to $1"}]
+	}`)
+	enabled := true
+	ctx := WithPIIRedaction(context.Background(), "agent-7", &enabled)
+	result, err := service.GenerateTextWithMetadata(ctx, "", "", "Please contact jane@example.com today", "")
+	if err != nil {
+		t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+	}
+	if result.Content != "I will write to jane@example.com" {
+		t.Errorf("Expected the placeholder to be sent and restored, got %q", result.Content)
+	}
+	audit := service.PIIGuardrail().Audit()
+	if audit.ProviderCalls < 1 || audit.ByType[PIITypeEmail] < 1 || audit.ByAgent["agent-7"] < 1 {
+		t.Errorf("Expected the redaction to be audited for agent-7, got %+v", audit)
+	}
+
+	// Off by default: the prompt reaches the provider unchanged
+	result, err = service.GenerateTextWithMetadata(context.Background(), "", "", "Please contact jane@example.com again", "")
+	if err != nil {
+		t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+	}
+	if result.Content != "the prompt was not redacted" {
+		t.Errorf("Expected no redaction without an agent flag, got %q", result.Content)
+	}
+}
+
+// recordingEmbedder stands in for a remote embedder and keeps the texts it was sent.
+type recordingEmbedder struct {
+	*database.HashEmbedder
+	texts []string
+	mutex sync.Mutex
+}
+
+func (e *recordingEmbedder) Name() string { return "remote-test" }
+
+func (e *recordingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	e.mutex.Lock()
+	e.texts = append(e.texts, text)
+	e.mutex.Unlock()
+	return e.HashEmbedder.Embed(ctx, text)
+}
+
+func TestPIIGuardrailRedactsRemoteEmbedding(t *testing.T) {
+	embedder := &recordingEmbedder{HashEmbedder: database.NewHashEmbedder(0)}
+	db, err := database.NewSimpleDomainDBWithEmbedder(filepath.Join(t.TempDir(), "domain.db"), embedder)
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	defer db.Close()
+	service, err := NewInferenceService(db)
+	if err != nil {
+		t.Fatalf("NewInferenceService failed: %v", err)
+	}
+	agents, _ := db.GetOrCreateCollection("agents")
+	redact := true
+	agent := &database.SimpleAgent{Name: "support", RedactPII: &redact}
+	if err := database.NewSimpleAgentRepository(agents).CreateAgent(context.Background(), agent); err != nil {
+		t.Fatalf("CreateAgent failed: %v", err)
+	}
+	if _, _, err := service.WithAgent(context.Background(), "missing"); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("Expected ErrAgentNotFound for an unknown agent, got %v", err)
+	}
+	ctx, _, err := service.WithAgent(context.Background(), agent.ID)
+	if err != nil {
+		t.Fatalf("WithAgent failed: %v", err)
+	}
+
+	// Cached entries are embedded redacted and only reused for the same PII
+	cache, err := NewSemanticCache(db, 0.9)
+	if err != nil {
+		t.Fatalf("NewSemanticCache failed: %v", err)
+	}
+	cache.SetPIIGuardrail(service.PIIGuardrail())
+	if err := cache.Store(ctx, "", "Write to jane@example.com about the invoice", "", "Dear jane@example.com, ..."); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if hit, err := cache.Lookup(ctx, "", "Write to jane@example.com about the invoice", ""); err != nil || hit == nil {
+		t.Errorf("Expected a hit for the same prompt, got %+v (%v)", hit, err)
+	}
+	if hit, err := cache.Lookup(ctx, "", "Write to john@example.com about the invoice", ""); err != nil || hit != nil {
+		t.Errorf("Expected no hit for a prompt with other PII, got %+v (%v)", hit, err)
+	}
+
+	// Retrieval memory embeds messages within the request's scope
+	session, err := service.CreateSession(1, "m", MemoryStrategyRetrieval)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	memory, err := service.sessions.Memory(WithSessionToken(ctx, session.Token), session.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory failed: %v", err)
+	}
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: "My address is jane@example.com"})
+
+	redacted := 0
+	for _, text := range embedder.texts {
+		if strings.Contains(text, "@example.com") {
+			t.Errorf("Expected no email address to reach the embedder, got %q", text)
+		}
+		if strings.Contains(text, "[EMAIL_1]") {
+			redacted++
+		}
+	}
+	if redacted < 4 {
+		t.Errorf("Expected the cache and memory texts to be embedded redacted, got %q", embedder.texts)
+	}
+	if guardEmbedder(embedder.HashEmbedder, service.PIIGuardrail()) != database.Embedder(embedder.HashEmbedder) {
+		t.Errorf("Expected the local hash embedder to be used as is")
+	}
+}
+
+func TestConversationPersistenceRedactsRetrievalEmbedding(t *testing.T) {
+	t.Setenv("PII_REDACTION", "true")
+	embedder := &recordingEmbedder{HashEmbedder: database.NewHashEmbedder(0)}
+	db, err := database.NewSimpleDomainDBWithEmbedder(filepath.Join(t.TempDir(), "domain.db"), embedder)
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	defer db.Close()
+	service, err := NewInferenceService(db)
+	if err != nil {
+		t.Fatalf("NewInferenceService failed: %v", err)
+	}
+	service.EnableConversationPersistence(newTestConversationRepo(t))
+
+	session, err := service.CreateSession(1, "m", MemoryStrategyRetrieval)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	ctx := WithSessionToken(context.Background(), session.Token)
+	memory, err := service.sessions.Memory(ctx, session.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory failed: %v", err)
+	}
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: "Reach me at jane@example.com or +1 (555) 123-4567"})
+
+	if len(embedder.texts) == 0 {
+		t.Fatalf("Expected the message to be embedded")
+	}
+	for _, text := range embedder.texts {
+		if strings.Contains(text, "jane@example.com") || strings.Contains(text, "123-4567") {
+			t.Errorf("Expected no raw email or phone number to reach the embedder, got %q", text)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"Agentic_Engine/database"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// PromptTemplateCollection is the chromem collection holding user-defined prompt templates.
+const PromptTemplateCollection = "prompt_templates"
+
+var (
+	// ErrPromptTemplateNotFound is returned when no template matches a name and version.
+	ErrPromptTemplateNotFound = errors.New("prompt template not found")
+	// ErrPromptTemplateInvalid is returned when a template name or body is rejected.
+	ErrPromptTemplateInvalid = errors.New("invalid prompt template")
+)
+
+// promptTemplateNameRegex limits template names to characters that are safe in URLs.
+var promptTemplateNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// PromptTemplate is one version of a named prompt. The body uses text/template
+// syntax, e.g. "Summarize {{.Content}}". Versions are immutable: editing a
+// template stores a new version.
+type PromptTemplate struct {
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	Description string    `json:"description,omitempty"`
+	Body        string    `json:"body"`
+	Variables   []string  `json:"variables"` // Top-level variables the body references
+	Builtin     bool      `json:"builtin"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	parsed *template.Template
+	ranged map[string]bool // Variables used as range pipelines
+}
+
+// PromptTemplateRef points workflows and agents at a template. A zero Version
+// means the latest version.
+type PromptTemplateRef struct {
+	Name    string `json:"name"`
+	Version int    `json:"version,omitempty"`
+}
+
+// String returns the reference as name@vN, or just the name for the latest version.
+func (ref PromptTemplateRef) String() string {
+	if ref.Version == 0 {
+		return ref.Name
+	}
+	return fmt.Sprintf("%s@v%d", ref.Name, ref.Version)
+}
+
+// MissingVariables returns the variables the template references that vars does not set.
+func (t *PromptTemplate) MissingVariables(vars map[string]interface{}) []string {
+	var missing []string
+	for _, name := range t.Variables {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Render executes the template with vars. Variables the template references but
+// vars leaves out render as empty strings (or empty lists for range), so
+// templates can branch on them with {{if .Name}}.
+func (t *PromptTemplate) Render(vars map[string]interface{}) (string, error) {
+	data := make(map[string]interface{}, len(vars)+len(t.Variables))
+	for _, name := range t.Variables {
+		if t.ranged[name] {
+			data[name] = []interface{}{}
+		} else {
+			data[name] = ""
+		}
+	}
+	for name, value := range vars {
+		data[name] = value
+	}
+
+	var sb strings.Builder
+	if err := t.parsed.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %s@v%d: %w", t.Name, t.Version, err)
+	}
+	return sb.String(), nil
+}
+
+// NewPromptTemplate parses body and returns an unsaved template. It is used for
+// previews and by the registry before a template is stored.
+func NewPromptTemplate(name, description, body string) (*PromptTemplate, error) {
+	if name != "" && !promptTemplateNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("%w: name %q may only contain letters, digits, '.', '_' and '-'", ErrPromptTemplateInvalid, name)
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil, fmt.Errorf("%w: body is required", ErrPromptTemplateInvalid)
+	}
+	parsed, err := template.New(name).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPromptTemplateInvalid, err)
+	}
+	variables, ranged := templateVariables(parsed)
+	return &PromptTemplate{
+		Name:        name,
+		Description: description,
+		Body:        body,
+		Variables:   variables,
+		parsed:      parsed,
+		ranged:      ranged,
+	}, nil
+}
+
+// templateVariables lists the top-level fields (.Name) a template references, in
+// order of first use, and the ones used as range pipelines. Fields inside range
+// and with blocks are relative to a different dot and are skipped.
+func templateVariables(tmpl *template.Template) ([]string, map[string]bool) {
+	seen := make(map[string]bool)
+	ranged := make(map[string]bool)
+	var names []string
+	inRange := false
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			name := n.Ident[0]
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			if inRange && len(n.Ident) == 1 {
+				ranged[name] = true
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			inRange = true
+			walk(n.Pipe)
+			inRange = false
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		}
+	}
+	if tmpl.Tree != nil {
+		walk(tmpl.Tree.Root)
+	}
+	return names, ranged
+}
+
+// PromptTemplateRegistry stores named, versioned prompt templates. Built-in
+// templates live in code; user templates are kept in the domain database when
+// one is available and in memory otherwise.
+type PromptTemplateRegistry struct {
+	collection *chromem.Collection // nil when running without a domain database
+	templates  map[string][]*PromptTemplate
+	mutex      sync.RWMutex
+}
+
+// NewPromptTemplateRegistry creates a registry seeded with the built-in
+// templates and loads any templates previously stored in db.
+func NewPromptTemplateRegistry(ctx context.Context, db *database.SimpleDomainDB) (*PromptTemplateRegistry, error) {
+	r := &PromptTemplateRegistry{templates: make(map[string][]*PromptTemplate)}
+	for _, builtin := range builtinPromptTemplates() {
+		r.templates[builtin.Name] = append(r.templates[builtin.Name], builtin)
+	}
+	if db == nil {
+		log.Println("PromptTemplateRegistry: No domain database, user templates will not be persisted.")
+		return r, nil
+	}
+
+	collection, err := db.GetOrCreateCollection(PromptTemplateCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open prompt template collection: %w", err)
+	}
+	r.collection = collection
+	if err := r.load(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("PromptTemplateRegistry: Initialized (collection: %s, stored templates: %d)", PromptTemplateCollection, collection.Count())
+	return r, nil
+}
+
+// load reads every stored template into memory.
+func (r *PromptTemplateRegistry) load(ctx context.Context) error {
+	count := r.collection.Count()
+	if count == 0 {
+		return nil
+	}
+	// chromem-go has no list call, so query with a generic text for every document
+	results, err := r.collection.Query(ctx, "prompt template", count, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load prompt templates: %w", err)
+	}
+	for _, result := range results {
+		tmpl, err := documentToPromptTemplate(result.Metadata, result.Content)
+		if err != nil {
+			log.Printf("[WARN] PromptTemplateRegistry: Skipping stored template %s: %v", result.ID, err)
+			continue
+		}
+		if r.find(tmpl.Name, tmpl.Version) != nil {
+			log.Printf("[WARN] PromptTemplateRegistry: Skipping stored template %s, it clashes with a built-in.", result.ID)
+			continue
+		}
+		r.insert(tmpl)
+	}
+	return nil
+}
+
+// insert adds tmpl keeping the versions of its name sorted. Callers hold the lock.
+func (r *PromptTemplateRegistry) insert(tmpl *PromptTemplate) {
+	versions := append(r.templates[tmpl.Name], tmpl)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	r.templates[tmpl.Name] = versions
+}
+
+// find returns the template with name and version (0 for the latest), or nil.
+// Callers hold the lock.
+func (r *PromptTemplateRegistry) find(name string, version int) *PromptTemplate {
+	versions := r.templates[name]
+	if len(versions) == 0 {
+		return nil
+	}
+	if version == 0 {
+		return versions[len(versions)-1]
+	}
+	for _, tmpl := range versions {
+		if tmpl.Version == version {
+			return tmpl
+		}
+	}
+	return nil
+}
+
+// Create stores body as the next version of name and returns it.
+func (r *PromptTemplateRegistry) Create(ctx context.Context, name, description, body string) (*PromptTemplate, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrPromptTemplateInvalid)
+	}
+	tmpl, err := NewPromptTemplate(name, description, body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	tmpl.Version = 1
+	if latest := r.find(name, 0); latest != nil {
+		tmpl.Version = latest.Version + 1
+	}
+	tmpl.CreatedAt = time.Now()
+
+	if r.collection != nil {
+		doc := chromem.Document{
+			ID:      promptTemplateDocumentID(tmpl.Name, tmpl.Version),
+			Content: tmpl.Body,
+			Metadata: map[string]string{
+				"name":        tmpl.Name,
+				"version":     strconv.Itoa(tmpl.Version),
+				"description": tmpl.Description,
+				"created_at":  tmpl.CreatedAt.Format(time.RFC3339),
+			},
+		}
+		if err := r.collection.AddDocument(ctx, doc); err != nil {
+			return nil, fmt.Errorf("failed to store prompt template %s: %w", doc.ID, err)
+		}
+	}
+	r.insert(tmpl)
+	log.Printf("PromptTemplateRegistry: Stored template '%s' version %d", tmpl.Name, tmpl.Version)
+	return tmpl, nil
+}
+
+// Get returns a template version; version 0 returns the latest.
+func (r *PromptTemplateRegistry) Get(name string, version int) (*PromptTemplate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	tmpl := r.find(name, version)
+	if tmpl == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPromptTemplateNotFound, PromptTemplateRef{Name: name, Version: version})
+	}
+	return tmpl, nil
+}
+
+// Resolve returns the template a reference points at.
+func (r *PromptTemplateRegistry) Resolve(ref PromptTemplateRef) (*PromptTemplate, error) {
+	return r.Get(ref.Name, ref.Version)
+}
+
+// List returns the latest version of every template, sorted by name.
+func (r *PromptTemplateRegistry) List() []PromptTemplate {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	templates := make([]PromptTemplate, 0, len(r.templates))
+	for name := range r.templates {
+		templates = append(templates, *r.find(name, 0))
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Versions returns every version of a template, oldest first.
+func (r *PromptTemplateRegistry) Versions(name string) ([]PromptTemplate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	versions := r.templates[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPromptTemplateNotFound, name)
+	}
+	result := make([]PromptTemplate, len(versions))
+	for i, tmpl := range versions {
+		result[i] = *tmpl
+	}
+	return result, nil
+}
+
+// Delete removes one version of a template, or every user version when version
+// is 0. Built-in versions cannot be deleted.
+func (r *PromptTemplateRegistry) Delete(ctx context.Context, name string, version int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	versions := r.templates[name]
+	if len(versions) == 0 || (version != 0 && r.find(name, version) == nil) {
+		return fmt.Errorf("%w: %s", ErrPromptTemplateNotFound, PromptTemplateRef{Name: name, Version: version})
+	}
+
+	var kept []*PromptTemplate
+	var ids []string
+	for _, tmpl := range versions {
+		if tmpl.Builtin || (version != 0 && tmpl.Version != version) {
+			kept = append(kept, tmpl)
+			continue
+		}
+		ids = append(ids, promptTemplateDocumentID(tmpl.Name, tmpl.Version))
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("%w: %s is built in and cannot be deleted", ErrPromptTemplateInvalid, PromptTemplateRef{Name: name, Version: version})
+	}
+
+	if r.collection != nil {
+		if err := r.collection.Delete(ctx, nil, nil, ids...); err != nil {
+			return fmt.Errorf("failed to delete prompt template %s: %w", name, err)
+		}
+	}
+	if len(kept) == 0 {
+		delete(r.templates, name)
+	} else {
+		r.templates[name] = kept
+	}
+	log.Printf("PromptTemplateRegistry: Deleted %v", ids)
+	return nil
+}
+
+// Render resolves ref and renders it with vars.
+func (r *PromptTemplateRegistry) Render(ref PromptTemplateRef, vars map[string]interface{}) (string, error) {
+	tmpl, err := r.Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.Render(vars)
+}
+
+// promptTemplateDocumentID is the chromem document ID of a template version.
+func promptTemplateDocumentID(name string, version int) string {
+	return fmt.Sprintf("%s@v%d", name, version)
+}
+
+// documentToPromptTemplate rebuilds a stored template from its document.
+func documentToPromptTemplate(metadata map[string]string, body string) (*PromptTemplate, error) {
+	tmpl, err := NewPromptTemplate(metadata["name"], metadata["description"], body)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Version, err = strconv.Atoi(metadata["version"]); err != nil {
+		return nil, fmt.Errorf("invalid version: %w", err)
+	}
+	if tmpl.CreatedAt, err = time.Parse(time.RFC3339, metadata["created_at"]); err != nil {
+		return nil, fmt.Errorf("invalid created_at timestamp: %w", err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,126 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"Agentic_Engine/database"
+)
+
+func TestPromptTemplateVersions(t *testing.T) {
+	registry, err := NewPromptTemplateRegistry(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("NewPromptTemplateRegistry failed: %v", err)
+	}
+	first, err := registry.Create(context.Background(), "greet", "", "Hello {{.Name}}")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	second, err := registry.Create(context.Background(), "greet", "", "Hi {{.Name}}!")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if first.Version != 1 || second.Version != 2 {
+		t.Fatalf("Expected versions 1 and 2, got %d and %d", first.Version, second.Version)
+	}
+
+	// Version 0 resolves to the latest
+	latest, err := registry.Resolve(PromptTemplateRef{Name: "greet"})
+	if err != nil || latest.Version != 2 {
+		t.Errorf("Expected version 0 to resolve to version 2, got %+v (%v)", latest, err)
+	}
+
+	// A pinned reference, as a workflow stores it, keeps rendering its version
+	pinned := PromptTemplateRef{Name: "greet", Version: first.Version}
+	if _, err := registry.Create(context.Background(), "greet", "", "Hey {{.Name}}"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	rendered, err := registry.Render(pinned, map[string]interface{}{"Name": "Ada"})
+	if err != nil || rendered != "Hello Ada" {
+		t.Errorf("Expected the pinned version to render, got %q (%v)", rendered, err)
+	}
+	if rendered, err := registry.Render(PromptTemplateRef{Name: "greet"}, map[string]interface{}{"Name": "Ada"}); err != nil || rendered != "Hey Ada" {
+		t.Errorf("Expected the latest version to render, got %q (%v)", rendered, err)
+	}
+
+	if _, err := registry.Resolve(PromptTemplateRef{Name: "greet", Version: 9}); !errors.Is(err, ErrPromptTemplateNotFound) {
+		t.Errorf("Expected ErrPromptTemplateNotFound for a missing version, got %v", err)
+	}
+	if err := registry.Delete(context.Background(), "greet", 0); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := registry.Render(pinned, nil); !errors.Is(err, ErrPromptTemplateNotFound) {
+		t.Errorf("Expected a deleted template to be reported as not found, got %v", err)
+	}
+}
+
+func TestPromptTemplatesReloadFromDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domain.db")
+	db, err := database.NewSimpleDomainDBWithEmbedder(path, database.NewHashEmbedder(0))
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	registry, err := NewPromptTemplateRegistry(context.Background(), db)
+	if err != nil {
+		t.Fatalf("NewPromptTemplateRegistry failed: %v", err)
+	}
+	for _, body := range []string{"Summarize {{.Content}}", "Summarize {{.Content}} in {{.Words}} words"} {
+		if _, err := registry.Create(context.Background(), "summary", "short summaries", body); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	db.Close()
+
+	db, err = database.NewSimpleDomainDBWithEmbedder(path, database.NewHashEmbedder(0))
+	if err != nil {
+		t.Fatalf("Reopening the domain database failed: %v", err)
+	}
+	defer db.Close()
+	reloaded, err := NewPromptTemplateRegistry(context.Background(), db)
+	if err != nil {
+		t.Fatalf("NewPromptTemplateRegistry after reopening failed: %v", err)
+	}
+	versions, err := reloaded.Versions("summary")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("Expected both stored versions after a reload, got %+v (%v)", versions, err)
+	}
+	latest := versions[1]
+	if latest.Version != 2 || latest.Description != "short summaries" || len(latest.Variables) != 2 || latest.CreatedAt.IsZero() {
+		t.Errorf("Expected version 2 with its metadata, got %+v", latest)
+	}
+	if next, err := reloaded.Create(context.Background(), "summary", "", "TL;DR {{.Content}}"); err != nil || next.Version != 3 {
+		t.Errorf("Expected the next version after a reload to be 3, got %+v (%v)", next, err)
+	}
+}
+
+func TestPromptTemplateRenderErrors(t *testing.T) {
+	for _, body := range []string{"", "Hello {{.Name", "{{template \"missing\"}}x{{end}}"} {
+		if _, err := NewPromptTemplate("bad", "", body); !errors.Is(err, ErrPromptTemplateInvalid) {
+			t.Errorf("Expected %q to be rejected with ErrPromptTemplateInvalid, got %v", body, err)
+		}
+	}
+	if _, err := NewPromptTemplate("no spaces", "", "x"); !errors.Is(err, ErrPromptTemplateInvalid) {
+		t.Errorf("Expected an invalid name to be rejected, got %v", err)
+	}
+
+	tmpl, err := NewPromptTemplate("items", "", "{{if .Title}}{{.Title}}: {{end}}{{range .Items}}{{.Label}} {{end}}")
+	if err != nil {
+		t.Fatalf("NewPromptTemplate failed: %v", err)
+	}
+	if missing := tmpl.MissingVariables(map[string]interface{}{"Items": nil}); len(missing) != 1 || missing[0] != "Title" {
+		t.Errorf("Expected Title to be missing, got %v", missing)
+	}
+	// Missing variables render empty
+	if rendered, err := tmpl.Render(nil); err != nil || rendered != "" {
+		t.Errorf("Expected missing variables to render empty, got %q (%v)", rendered, err)
+	}
+	// Values of the wrong shape fail to render
+	if _, err := tmpl.Render(map[string]interface{}{"Items": []interface{}{map[string]interface{}{"Name": "x"}}}); err == nil {
+		t.Errorf("Expected a missing key inside a range to fail")
+	}
+	if _, err := tmpl.Render(map[string]interface{}{"Items": 42}); err == nil {
+		t.Errorf("Expected ranging over a number to fail")
+	}
+}
@@ -1,10 +1,21 @@
 package inference
 
-// Prompts for WordPress Content Management
+import "log"
+
+// Names of the built-in WordPress prompt templates.
+const (
+	WordPressContentImproveTemplate             = "wordpress_content_improve"
+	WordPressContentRewriteTemplate             = "wordpress_content_rewrite"
+	WordPressContentExpandTemplate              = "wordpress_content_expand"
+	WordPressContentGenerateWithSourcesTemplate = "wordpress_content_generate_with_sources"
+)
+
+// Prompts for WordPress Content Management, in text/template syntax. They are
+// registered as version 1 of the built-in templates above.
 const (
 	WordPressContentImprovePrompt = `Improve the following WordPress page content to make it more engaging, professional, and SEO-friendly:
 
-%s
+{{.Content}}
 
 Please enhance the content while maintaining its core message and purpose. Consider:
 1. Improving readability with better paragraph structure and transitions
@@ -17,7 +28,7 @@ Return the improved content in HTML format suitable for WordPress.`
 
 	WordPressContentRewritePrompt = `Rewrite the following WordPress page content with a fresh perspective while maintaining the same information and purpose:
 
-%s
+{{.Content}}
 
 Please create an entirely new version that:
 1. Presents the same information in a different way
@@ -30,7 +41,7 @@ Return the rewritten content in HTML format suitable for WordPress.`
 
 	WordPressContentExpandPrompt = `Expand the following WordPress page content with additional relevant information:
 
-%s
+{{.Content}}
 
 Please enhance this content by:
 1. Adding more depth and detail to existing points
@@ -48,18 +59,18 @@ Return the expanded content in HTML format suitable for WordPress.`
 **Sample Sources:** These provide examples of the desired writing style, tone, structure, or formatting. Use these as a guide for *how* to present the information derived from the True Sources, but do not treat their content as factual unless it overlaps with a True Source.
 
 --- TRUE SOURCES ---
-%s
+{{if .TrueSources}}{{.TrueSources}}{{else}}(No True Sources Provided){{end}}
 --- END TRUE SOURCES ---
 
 --- SAMPLE SOURCES ---
-%s
+{{if .SampleSources}}{{.SampleSources}}{{else}}(No Sample Sources Provided){{end}}
 --- END SAMPLE SOURCES ---
 
 **Your Task:**
 
 Based *only* on the information provided in the **True Sources**, generate new content that addresses the following specific request:
 
-**Request:** %s
+**Request:** {{.Request}}
 
 **Instructions:**
 1.  Strictly adhere to the facts and information presented in the **True Sources**.
@@ -71,64 +82,69 @@ Based *only* on the information provided in the **True Sources**, generate new c
 `
 )
 
-// WordPress Content Prompts
-func GetWordPressContentImprovePrompt(content string) string {
-	return formatPrompt(WordPressContentImprovePrompt, content)
-}
-
-func GetWordPressContentRewritePrompt(content string) string {
-	return formatPrompt(WordPressContentRewritePrompt, content)
-}
-
-func GetWordPressContentExpandPrompt(content string) string {
-	return formatPrompt(WordPressContentExpandPrompt, content)
+// builtinPromptTemplates parses the built-in templates. They are compiled into
+// the binary, so a parse error is a programming error.
+func builtinPromptTemplates() []*PromptTemplate {
+	definitions := []struct {
+		name, description, body string
+	}{
+		{WordPressContentImproveTemplate, "Improve WordPress page content for engagement and SEO", WordPressContentImprovePrompt},
+		{WordPressContentRewriteTemplate, "Rewrite WordPress page content with a fresh perspective", WordPressContentRewritePrompt},
+		{WordPressContentExpandTemplate, "Expand WordPress page content with more detail", WordPressContentExpandPrompt},
+		{WordPressContentGenerateWithSourcesTemplate, "Generate content from true and sample sources", WordPressContentGenerateWithSourcesPrompt},
+	}
+	templates := make([]*PromptTemplate, len(definitions))
+	for i, def := range definitions {
+		tmpl, err := NewPromptTemplate(def.name, def.description, def.body)
+		if err != nil {
+			panic(err)
+		}
+		tmpl.Version = 1
+		tmpl.Builtin = true
+		templates[i] = tmpl
+	}
+	return templates
 }
 
-// formatPrompt formats a prompt with the given arguments
-func formatPrompt(format string, args ...interface{}) string {
-	return sprintf(format, args...)
+// builtinPrompts holds the built-in templates for the helpers below, which do
+// not need a registry.
+var builtinPrompts = func() map[string]*PromptTemplate {
+	byName := make(map[string]*PromptTemplate)
+	for _, tmpl := range builtinPromptTemplates() {
+		byName[tmpl.Name] = tmpl
+	}
+	return byName
+}()
+
+// renderBuiltinPrompt renders a built-in template, logging and returning an
+// empty prompt if it fails.
+func renderBuiltinPrompt(name string, vars map[string]interface{}) string {
+	prompt, err := builtinPrompts[name].Render(vars)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return ""
+	}
+	return prompt
 }
 
-// sprintf is a simple implementation of fmt.Sprintf to avoid importing fmt
-func sprintf(format string, args ...interface{}) string {
-	result := format
-	for _, arg := range args {
-		// Replace the first occurrence of %s with the string representation of arg
-		// This is a simplified version and doesn't handle all format specifiers
-		if s, ok := arg.(string); ok {
-			result = replaceFirst(result, "%s", s)
-		}
-	}
-	return result
+// WordPress Content Prompts
+func GetWordPressContentImprovePrompt(content string) string {
+	return renderBuiltinPrompt(WordPressContentImproveTemplate, map[string]interface{}{"Content": content})
 }
 
-// replaceFirst replaces the first occurrence of old with new in s
-func replaceFirst(s, old, new string) string {
-	i := indexOf(s, old)
-	if i < 0 {
-		return s
-	}
-	return s[:i] + new + s[i+len(old):]
+func GetWordPressContentRewritePrompt(content string) string {
+	return renderBuiltinPrompt(WordPressContentRewriteTemplate, map[string]interface{}{"Content": content})
 }
 
-// indexOf returns the index of the first occurrence of substr in s, or -1 if substr is not present
-func indexOf(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
-		}
-	}
-	return -1
+func GetWordPressContentExpandPrompt(content string) string {
+	return renderBuiltinPrompt(WordPressContentExpandTemplate, map[string]interface{}{"Content": content})
 }
 
-// Function to format the new prompt
+// Function to format the new prompt. Empty sources are reported as not provided.
 func GetWordPressContentGenerateWithSourcesPrompt(trueSourcesContent, sampleSourcesContent, userRequest string) string {
-	// Handle cases where one type of source might be empty
-	if trueSourcesContent == "" {
-		trueSourcesContent = "(No True Sources Provided)"
-	}
-	if sampleSourcesContent == "" {
-		sampleSourcesContent = "(No Sample Sources Provided)"
-	}
-	return formatPrompt(WordPressContentGenerateWithSourcesPrompt, trueSourcesContent, sampleSourcesContent, userRequest)
+	return renderBuiltinPrompt(WordPressContentGenerateWithSourcesTemplate, map[string]interface{}{
+		"TrueSources":   trueSourcesContent,
+		"SampleSources": sampleSourcesContent,
+		"Request":       userRequest,
+	})
 }
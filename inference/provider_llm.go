@@ -0,0 +1,354 @@
+package inference
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guiperry/gollm_cerebras/config"
+	"github.com/guiperry/gollm_cerebras/llm"
+	"github.com/guiperry/gollm_cerebras/providers"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+	"github.com/guiperry/gollm_cerebras/utils"
+)
+
+// ProviderError is a provider call answered with an error status, or an
+// error reported inside a response or stream.
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	Body       string // Start of the response body
+	Err        error  // The provider's classification, if any (see providerErrorClassifier)
+}
+
+func (e *ProviderError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s API error: status code %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports whether the same request may succeed later.
+func (e *ProviderError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// providerErrorClassifier is implemented by providers whose error bodies tell
+// more than the status code, such as Anthropic's context-length errors. A nil
+// result keeps the plain *ProviderError.
+type providerErrorClassifier interface {
+	ClassifyError(statusCode int, body []byte) error
+}
+
+// ProviderLLM is an llm.LLM that sends a gollm provider's requests through an
+// http.Client owned by the InferenceService. gollm's own LLM keeps its client
+// private, so its traffic could only be redirected by replacing
+// http.DefaultTransport, and it drops the status and body of failed calls.
+type ProviderLLM struct {
+	provider   providers.Provider
+	client     *http.Client
+	logger     utils.Logger
+	timeout    time.Duration
+	maxRetries int
+	retryDelay time.Duration
+
+	options      map[string]interface{}
+	optionsMutex sync.RWMutex
+}
+
+// NewProviderLLM creates the provider configured by opts from the gollm
+// registry, like gollm.NewLLM, and sends its requests through client.
+func NewProviderLLM(client *http.Client, opts ...config.ConfigOption) (*ProviderLLM, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := llm.Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	provider, err := providers.GetDefaultRegistry().Get(cfg.Provider, cfg.APIKeys[cfg.Provider], cfg.Model, cfg.ExtraHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider: %w", err)
+	}
+	provider.SetDefaultOptions(cfg)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ProviderLLM{
+		provider:   provider,
+		client:     client,
+		logger:     utils.NewLogger(cfg.LogLevel),
+		timeout:    cfg.Timeout,
+		maxRetries: cfg.MaxRetries,
+		retryDelay: cfg.RetryDelay,
+		options:    make(map[string]interface{}),
+	}, nil
+}
+
+// Provider returns the provider building the requests.
+func (p *ProviderLLM) Provider() providers.Provider {
+	return p.provider
+}
+
+// Generate sends the prompt and returns the parsed response. Rate limits,
+// server errors and network failures are retried; other errors are returned
+// at once as a *ProviderError.
+func (p *ProviderLLM) Generate(ctx context.Context, prompt *llm.Prompt, opts ...llm.GenerateOption) (string, error) {
+	if prompt.SystemPrompt != "" {
+		p.SetOption("system_prompt", prompt.SystemPrompt)
+	}
+	options := p.requestOptions()
+	if len(prompt.Tools) > 0 {
+		options["tools"] = prompt.Tools
+	} else if collector := toolCallCollectorFrom(ctx); collector != nil {
+		options["tools"] = collector.tools
+	}
+	if len(prompt.ToolChoice) > 0 {
+		options["tool_choice"] = prompt.ToolChoice
+	}
+
+	var body []byte
+	var err error
+	if messages, ok := options["structured_messages"].([]gollm_types.MemoryMessage); ok {
+		body, err = p.provider.PrepareRequestWithMessages(messages, options)
+	} else {
+		body, err = p.provider.PrepareRequest(prompt.String(), options)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare %s request: %w", p.provider.Name(), err)
+	}
+	return p.generate(ctx, body)
+}
+
+// GenerateWithSchema asks for a response matching schema, natively when the
+// provider supports JSON schemas and through the prompt otherwise, and
+// validates the result against it.
+func (p *ProviderLLM) GenerateWithSchema(ctx context.Context, prompt *llm.Prompt, schema interface{}, opts ...llm.GenerateOption) (string, error) {
+	options := p.requestOptions()
+	var body []byte
+	var err error
+	if p.provider.SupportsJSONSchema() {
+		body, err = p.provider.PrepareRequestWithSchema(prompt.String(), options, schema)
+	} else {
+		body, err = p.provider.PrepareRequest(promptWithSchema(prompt.String(), schema), options)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare %s request: %w", p.provider.Name(), err)
+	}
+	result, err := p.generate(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	if err := llm.ValidateAgainstSchema(result, schema); err != nil {
+		return "", fmt.Errorf("%s response does not match schema: %w", p.provider.Name(), err)
+	}
+	return result, nil
+}
+
+// promptWithSchema appends schema to prompt the way gollm does for providers
+// without native JSON schema support.
+func promptWithSchema(prompt string, schema interface{}) string {
+	document, err := geminiSchemaDocument(schema)
+	if err != nil {
+		return prompt
+	}
+	encoded, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return prompt
+	}
+	return fmt.Sprintf("%s\n\nPlease provide your response in JSON format according to this schema:\n%s", prompt, encoded)
+}
+
+// generate sends body, retrying as Generate describes, and parses the response.
+func (p *ProviderLLM) generate(ctx context.Context, body []byte) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(p.retryDelay):
+			}
+		}
+		respBody, err := p.sendWithTimeout(ctx, body)
+		if err == nil {
+			if collector := toolCallCollectorFrom(ctx); collector != nil {
+				collector.collect(ctx, p.provider, respBody)
+			}
+			return p.provider.ParseResponse(respBody)
+		}
+		lastErr = err
+		var providerErr *ProviderError
+		if ctx.Err() != nil || (errors.As(err, &providerErr) && !providerErr.retryable()) {
+			break
+		}
+		p.logger.Warn("Provider request failed", "provider", p.provider.Name(), "attempt", attempt+1, "error", err)
+	}
+	return "", lastErr
+}
+
+func (p *ProviderLLM) sendWithTimeout(ctx context.Context, body []byte) ([]byte, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	return p.send(ctx, body)
+}
+
+// send posts body to the provider's endpoint and returns the response body.
+// A non-200 status is returned as a *ProviderError.
+func (p *ProviderLLM) send(ctx context.Context, body []byte) ([]byte, error) {
+	resp, err := p.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", p.provider.Name(), err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, p.statusError(resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
+
+func (p *ProviderLLM) post(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.provider.Endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", p.provider.Name(), err)
+	}
+	for k, v := range p.provider.Headers() {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// The URL may carry the API key (Gemini), so only the cause is reported
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%s request failed: %w", p.provider.Name(), err)
+	}
+	return resp, nil
+}
+
+func (p *ProviderLLM) statusError(statusCode int, body []byte) error {
+	if classifier, ok := p.provider.(providerErrorClassifier); ok {
+		if err := classifier.ClassifyError(statusCode, body); err != nil {
+			return err
+		}
+	}
+	detail := strings.TrimSpace(string(body))
+	if len(detail) > 500 {
+		detail = detail[:500] + "..."
+	}
+	return &ProviderError{Provider: p.provider.Name(), StatusCode: statusCode, Body: detail}
+}
+
+// Stream sends a streaming request and returns its tokens. The request is not
+// retried and is bounded only by ctx.
+func (p *ProviderLLM) Stream(ctx context.Context, prompt *llm.Prompt, opts ...llm.StreamOption) (llm.TokenStream, error) {
+	if !p.provider.SupportsStreaming() {
+		return nil, fmt.Errorf("%s: streaming is not supported", p.provider.Name())
+	}
+	options := p.requestOptions()
+	options["stream"] = true
+	body, err := p.provider.PrepareStreamRequest(prompt.String(), options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare %s stream request: %w", p.provider.Name(), err)
+	}
+	resp, err := p.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, p.statusError(resp.StatusCode, respBody)
+	}
+	return &providerTokenStream{body: resp.Body, decoder: llm.NewSSEDecoder(resp.Body), provider: p.provider}, nil
+}
+
+// providerTokenStream reads server-sent events and parses them with the provider.
+type providerTokenStream struct {
+	body     io.ReadCloser
+	decoder  *llm.SSEDecoder
+	provider providers.Provider
+	index    int
+}
+
+func (s *providerTokenStream) Next(ctx context.Context) (*llm.StreamToken, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !s.decoder.Next() {
+			if err := s.decoder.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		event := s.decoder.Event()
+		if len(event.Data) == 0 {
+			continue
+		}
+		text, err := s.provider.ParseStreamResponse(event.Data)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) {
+			return nil, err // An error event, such as Anthropic's overloaded_error
+		}
+		if err != nil {
+			continue // Skipped tokens and events without text
+		}
+		s.index++
+		return &llm.StreamToken{Text: text, Type: event.Type, Index: s.index - 1}, nil
+	}
+}
+
+func (s *providerTokenStream) Close() error {
+	return s.body.Close()
+}
+
+// requestOptions copies the options set with SetOption.
+func (p *ProviderLLM) requestOptions() map[string]interface{} {
+	p.optionsMutex.RLock()
+	defer p.optionsMutex.RUnlock()
+	options := make(map[string]interface{}, len(p.options))
+	for k, v := range p.options {
+		options[k] = v
+	}
+	return options
+}
+
+func (p *ProviderLLM) SetOption(key string, value interface{}) {
+	p.optionsMutex.Lock()
+	defer p.optionsMutex.Unlock()
+	p.options[key] = value
+}
+
+func (p *ProviderLLM) SupportsStreaming() bool            { return p.provider.SupportsStreaming() }
+func (p *ProviderLLM) SupportsJSONSchema() bool           { return p.provider.SupportsJSONSchema() }
+func (p *ProviderLLM) SetLogLevel(level utils.LogLevel)   { p.logger.SetLevel(level) }
+func (p *ProviderLLM) SetEndpoint(endpoint string)        {}
+func (p *ProviderLLM) NewPrompt(input string) *llm.Prompt { return &llm.Prompt{Input: input} }
+func (p *ProviderLLM) GetLogger() utils.Logger            { return p.logger }
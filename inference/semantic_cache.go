@@ -0,0 +1,265 @@
+package inference
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"Agentic_Engine/database"
+
+	"github.com/philippgille/chromem-go"
+)
+
+const (
+	// SemanticCacheCollection is the chromem collection holding cached prompt/response pairs.
+	SemanticCacheCollection = "semantic_cache"
+	// DefaultSemanticCacheThreshold is the cosine similarity a prompt must reach to reuse a cached answer.
+	DefaultSemanticCacheThreshold float32 = 0.95
+)
+
+// SemanticCacheHit describes a cached response that matched an incoming prompt.
+// SourcePrompt is the cached prompt, which may come from another caller, so it
+// is only filled in when SEMANTIC_CACHE_EXPOSE_PROMPT is set.
+type SemanticCacheHit struct {
+	Response     string    `json:"response"`
+	Similarity   float32   `json:"similarity"`
+	CachedAt     time.Time `json:"cached_at"`
+	SourcePrompt string    `json:"source_prompt,omitempty"`
+}
+
+type semanticCacheBypassKey struct{}
+
+// WithoutSemanticCache marks ctx so that generation neither reads nor writes
+// the semantic cache, e.g. for evaluations and experiments that must measure
+// the model rather than earlier answers.
+func WithoutSemanticCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, semanticCacheBypassKey{}, true)
+}
+
+// semanticCacheBypassed reports whether ctx was marked by WithoutSemanticCache.
+func semanticCacheBypassed(ctx context.Context) bool {
+	bypassed, _ := ctx.Value(semanticCacheBypassKey{}).(bool)
+	return bypassed
+}
+
+// SemanticCache reuses answers for near-duplicate prompts. Prompts are embedded
+// into a dedicated chromem collection and the stored response is returned when
+// a new prompt's cosine similarity exceeds the configured threshold.
+type SemanticCache struct {
+	db           *database.SimpleDomainDB
+	collection   *chromem.Collection
+	embedder     database.Embedder
+	pii          *PIIGuardrail // Optional; scopes entries by the PII they were stored for
+	threshold    float32
+	exposePrompt bool // Report the cached prompt of a hit
+	mutex        sync.RWMutex
+}
+
+// NewSemanticCache creates a semantic cache backed by the domain database.
+func NewSemanticCache(db *database.SimpleDomainDB, threshold float32) (*SemanticCache, error) {
+	if db == nil {
+		return nil, fmt.Errorf("semantic cache requires a domain database")
+	}
+	collection, err := db.GetOrCreateCollection(SemanticCacheCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open semantic cache collection: %w", err)
+	}
+	if threshold <= 0 || threshold > 1 {
+		threshold = DefaultSemanticCacheThreshold
+	}
+	log.Printf("SemanticCache: Initialized (collection: %s, threshold: %.2f, entries: %d)", SemanticCacheCollection, threshold, collection.Count())
+	return &SemanticCache{
+		db:         db,
+		collection: collection,
+		embedder:   db.Embedder(),
+		threshold:  threshold,
+	}, nil
+}
+
+// SetPIIGuardrail redacts prompts before they are embedded when redaction is
+// on for a request. Such entries are only reused for prompts with the same
+// PII, since their responses may contain it.
+func (c *SemanticCache) SetPIIGuardrail(guardrail *PIIGuardrail) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pii = guardrail
+	c.embedder = guardEmbedder(c.db.Embedder(), guardrail)
+	log.Println("SemanticCache: PII guardrail configured.")
+}
+
+// scope returns the embedder and the PII fingerprint for a prompt.
+func (c *SemanticCache) scope(ctx context.Context, promptText string) (database.Embedder, string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.embedder, c.pii.fingerprint(ctx, promptText)
+}
+
+// SetExposeSourcePrompt controls whether hits report the prompt they were cached for.
+func (c *SemanticCache) SetExposeSourcePrompt(expose bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.exposePrompt = expose
+}
+
+// semanticCacheExposePromptFromEnv reads SEMANTIC_CACHE_EXPOSE_PROMPT. Cached
+// prompts are not reported unless it is "true".
+func semanticCacheExposePromptFromEnv() bool {
+	return os.Getenv("SEMANTIC_CACHE_EXPOSE_PROMPT") == "true"
+}
+
+// semanticCacheThresholdFromEnv reads SEMANTIC_CACHE_THRESHOLD. The cache is
+// off unless it is set to a threshold in (0, 1].
+func semanticCacheThresholdFromEnv() (float32, bool) {
+	raw := os.Getenv("SEMANTIC_CACHE_THRESHOLD")
+	if raw == "" || raw == "0" {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(raw, 32)
+	if err != nil || value <= 0 || value > 1 {
+		log.Printf("[WARN] SemanticCache: Invalid SEMANTIC_CACHE_THRESHOLD '%s'. The cache stays off.", raw)
+		return 0, false
+	}
+	return float32(value), true
+}
+
+// currentCollection returns the active collection, which Clear may replace.
+func (c *SemanticCache) currentCollection() *chromem.Collection {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.collection
+}
+
+// Threshold returns the similarity threshold currently in use.
+func (c *SemanticCache) Threshold() float32 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.threshold
+}
+
+// SetThreshold updates the similarity threshold. Values must be in (0, 1].
+func (c *SemanticCache) SetThreshold(threshold float32) error {
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("semantic cache threshold must be in (0, 1], got %.3f", threshold)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.threshold = threshold
+	log.Printf("SemanticCache: Threshold set to %.3f", threshold)
+	return nil
+}
+
+// Lookup returns the closest cached response for the prompt if its similarity
+// exceeds the threshold. Entries are scoped by model and instruction so that an
+// answer is never reused for a different task or target model.
+func (c *SemanticCache) Lookup(ctx context.Context, modelName, promptText, instructionText string) (*SemanticCacheHit, error) {
+	collection := c.currentCollection()
+	if promptText == "" || collection.Count() == 0 {
+		return nil, nil
+	}
+
+	embedder, fingerprint := c.scope(ctx, promptText)
+	where := map[string]string{
+		"model":       semanticCacheModelKey(modelName),
+		"instruction": instructionText,
+		"pii":         fingerprint, // Entries without PII have no "pii" key, which matches ""
+	}
+	embedding, err := embedder.Embed(ctx, promptText)
+	if err != nil {
+		return nil, fmt.Errorf("semantic cache embedding failed: %w", err)
+	}
+	results, err := collection.QueryEmbedding(ctx, embedding, 1, where, nil)
+	if err != nil {
+		return nil, fmt.Errorf("semantic cache query failed: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	best := results[0]
+	threshold := c.Threshold()
+	if !(best.Similarity >= threshold) { // Also rejects NaN similarities from degenerate embeddings
+		log.Printf("SemanticCache: Closest entry below threshold (similarity %.3f < %.3f)", best.Similarity, threshold)
+		return nil, nil
+	}
+
+	cachedAt, _ := time.Parse(time.RFC3339, best.Metadata["created_at"])
+	log.Printf("SemanticCache: Hit (similarity %.3f, entry %s)", best.Similarity, best.ID)
+	hit := &SemanticCacheHit{
+		Response:   best.Metadata["response"],
+		Similarity: best.Similarity,
+		CachedAt:   cachedAt,
+	}
+	c.mutex.RLock()
+	if c.exposePrompt {
+		hit.SourcePrompt = best.Content
+	}
+	c.mutex.RUnlock()
+	return hit, nil
+}
+
+// Store records a prompt/response pair. Storing the same prompt again for the
+// same model and instruction replaces the previous entry.
+func (c *SemanticCache) Store(ctx context.Context, modelName, promptText, instructionText, response string) error {
+	if promptText == "" || response == "" {
+		return nil
+	}
+	embedder, fingerprint := c.scope(ctx, promptText)
+	embedding, err := embedder.Embed(ctx, promptText)
+	if err != nil {
+		return fmt.Errorf("semantic cache embedding failed: %w", err)
+	}
+	modelKey := semanticCacheModelKey(modelName)
+	key := modelKey + "\x00" + instructionText + "\x00" + promptText
+	if fingerprint != "" {
+		key += "\x00" + fingerprint
+	}
+	hash := sha256.Sum256([]byte(key))
+	doc := chromem.Document{
+		ID:        hex.EncodeToString(hash[:]),
+		Content:   promptText,
+		Embedding: embedding,
+		Metadata: map[string]string{
+			"model":       modelKey,
+			"instruction": instructionText,
+			"response":    response,
+			"created_at":  time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	if fingerprint != "" {
+		doc.Metadata["pii"] = fingerprint
+	}
+	if err := c.currentCollection().AddDocument(ctx, doc); err != nil {
+		return fmt.Errorf("failed to store semantic cache entry: %w", err)
+	}
+	return nil
+}
+
+// Clear removes all cached entries by recreating the collection.
+func (c *SemanticCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.db.GetDB().DeleteCollection(SemanticCacheCollection); err != nil {
+		return fmt.Errorf("failed to delete semantic cache collection: %w", err)
+	}
+	collection, err := c.db.GetOrCreateCollection(SemanticCacheCollection)
+	if err != nil {
+		return fmt.Errorf("failed to recreate semantic cache collection: %w", err)
+	}
+	c.collection = collection
+	log.Println("SemanticCache: Cleared.")
+	return nil
+}
+
+// semanticCacheModelKey normalizes the model name used to scope cache entries.
+func semanticCacheModelKey(modelName string) string {
+	if modelName == "" {
+		return "default"
+	}
+	return modelName
+}
@@ -0,0 +1,94 @@
+package inference
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"Agentic_Engine/database"
+)
+
+// newTestDomainDB opens a domain database with the offline hash embedder.
+func newTestDomainDB(t *testing.T) *database.SimpleDomainDB {
+	t.Helper()
+	db, err := database.NewSimpleDomainDBWithEmbedder(filepath.Join(t.TempDir(), "domain.db"), database.NewHashEmbedder(0))
+	if err != nil {
+		t.Fatalf("NewSimpleDomainDBWithEmbedder failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSemanticCacheIsOffByDefault(t *testing.T) {
+	t.Setenv("SEMANTIC_CACHE_THRESHOLD", "")
+	if _, enabled := semanticCacheThresholdFromEnv(); enabled {
+		t.Errorf("Expected the cache to be off without SEMANTIC_CACHE_THRESHOLD")
+	}
+	t.Setenv("SEMANTIC_CACHE_THRESHOLD", "1.5")
+	if _, enabled := semanticCacheThresholdFromEnv(); enabled {
+		t.Errorf("Expected an invalid threshold to leave the cache off")
+	}
+	t.Setenv("SEMANTIC_CACHE_THRESHOLD", "0.9")
+	if threshold, enabled := semanticCacheThresholdFromEnv(); !enabled || threshold != 0.9 {
+		t.Errorf("Expected threshold 0.9, got %v (enabled %t)", threshold, enabled)
+	}
+}
+
+func TestSemanticCacheHitMissAndScoping(t *testing.T) {
+	t.Setenv("SEMANTIC_CACHE_THRESHOLD", "0.9")
+	// The scripted answer is given once; any later generation gets the default
+	service := startFakeInferenceServiceWithDB(t, `{
+		"default": "fresh answer",
+		"rules": [{"match": "capital of France", "response": "Paris", "times": 1}]
+	}`, newTestDomainDB(t))
+	ctx := context.Background()
+
+	result, err := service.GenerateTextWithMetadata(ctx, "", "", "What is the capital of France?", "")
+	if err != nil {
+		t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+	}
+	if result.Content != "Paris" || result.Metadata["cache"] != "miss" {
+		t.Fatalf("Expected a generated 'Paris' on a miss, got %q / %v", result.Content, result.Metadata)
+	}
+
+	result, err = service.GenerateTextWithMetadata(ctx, "", "", "What is the capital of France", "")
+	if err != nil {
+		t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+	}
+	if result.Content != "Paris" || result.Metadata["cache"] != "hit" {
+		t.Errorf("Expected a near-duplicate to hit the cache, got %q / %v", result.Content, result.Metadata)
+	}
+	if _, leaked := result.Metadata["cache_source_prompt"]; leaked {
+		t.Errorf("Expected the cached prompt not to be reported by default, got %v", result.Metadata)
+	}
+	service.semanticCache.SetExposeSourcePrompt(true)
+	result, err = service.GenerateTextWithMetadata(ctx, "", "", "What is the capital of France", "")
+	if err != nil {
+		t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+	}
+	if result.Metadata["cache_source_prompt"] != "What is the capital of France?" {
+		t.Errorf("Expected the cached prompt once exposing it is enabled, got %v", result.Metadata)
+	}
+	service.semanticCache.SetExposeSourcePrompt(false)
+
+	// Entries are scoped by instruction
+	if text, err := service.GenerateText(ctx, "", "", "What is the capital of France?", "Answer in French"); err != nil || text != "fresh answer" {
+		t.Errorf("Expected a different instruction to miss, got %q (%v)", text, err)
+	}
+
+	// Sessions and bypassed requests never read the cache
+	session, err := service.CreateSession(1, "", MemoryStrategyWindow)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	result, err = service.GenerateTextWithMetadata(WithSessionToken(ctx, session.Token), session.ID, "", "What is the capital of France?", "")
+	if err != nil {
+		t.Fatalf("GenerateTextWithMetadata failed: %v", err)
+	}
+	if result.Content != "fresh answer" || result.Metadata["cache"] != nil {
+		t.Errorf("Expected a session request to skip the cache, got %q / %v", result.Content, result.Metadata)
+	}
+	if text, err := service.GenerateText(WithoutSemanticCache(ctx), "", "", "What is the capital of France?", ""); err != nil || text != "fresh answer" {
+		t.Errorf("Expected WithoutSemanticCache to skip the cache, got %q (%v)", text, err)
+	}
+}
@@ -0,0 +1,123 @@
+package inference
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+
+	"Agentic_Engine/database"
+)
+
+const (
+	// defaultSemanticThreshold is the adjacent-sentence cosine similarity below
+	// which ChunkBySemanticBoundary may start a new chunk. It is calibrated for
+	// the default hash embedder, which only sees shared words: neighbouring
+	// sentences on one topic typically score 0.03-0.2 and sentences on
+	// different topics about as much, so only sentences with almost nothing in
+	// common mark a boundary. Model embedders separate topics better and
+	// usually need a higher threshold.
+	defaultSemanticThreshold = 0.05
+
+	// defaultSemanticMinChunkSize is the number of tokens a semantic chunk holds
+	// before a similarity drop may end it, so that a single unrelated sentence
+	// does not become a chunk of its own.
+	defaultSemanticMinChunkSize = 200
+)
+
+// sentenceEndRegex matches the whitespace after sentence-ending punctuation.
+var sentenceEndRegex = regexp.MustCompile(`[.!?]["')\]]*\s+`)
+
+// splitSentences splits text into sentences, keeping their punctuation.
+// Paragraph breaks always end a sentence.
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		start := 0
+		for _, loc := range sentenceEndRegex.FindAllStringIndex(paragraph, -1) {
+			if sentence := strings.TrimSpace(paragraph[start:loc[1]]); sentence != "" {
+				sentences = append(sentences, sentence)
+			}
+			start = loc[1]
+		}
+		if sentence := strings.TrimSpace(paragraph[start:]); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+	return sentences
+}
+
+// splitBySemanticBoundary embeds every sentence and starts a new chunk wherever
+// the similarity between neighbouring sentences drops below the semantic
+// threshold once the chunk holds minChunkSize tokens, or when the chunk would
+// exceed maxChunkSize. If embedding fails it falls back to grouping sentences
+// by size.
+func (cm *ContextManager) splitBySemanticBoundary(ctx context.Context, text string) []string {
+	sentences := splitSentences(text)
+	if len(sentences) <= 1 {
+		return sentences
+	}
+
+	embeddings, err := cm.embedSentences(ctx, sentences)
+	if err != nil {
+		log.Printf("[WARN] ContextManager: Semantic chunking failed, grouping sentences by size instead: %v", err)
+		return cm.groupSentencesIntoChunks(sentences)
+	}
+
+	threshold := cm.semanticThreshold
+	var chunks []string
+	current := []string{sentences[0]}
+	currentTokens := estimateTokens(sentences[0], cm.modelName)
+	for i := 1; i < len(sentences); i++ {
+		tokens := estimateTokens(sentences[i], cm.modelName)
+		similarity := cosineSimilarity(embeddings[i-1], embeddings[i])
+		boundary := similarity < threshold && currentTokens >= cm.minChunkSize
+		if boundary || currentTokens+tokens > cm.maxChunkSize {
+			chunks = append(chunks, strings.Join(current, " "))
+			current, currentTokens = nil, 0
+		}
+		current = append(current, sentences[i])
+		currentTokens += tokens
+	}
+	chunks = append(chunks, strings.Join(current, " "))
+
+	log.Printf("ContextManager: Split %d sentences into %d semantic chunks (threshold %.2f).", len(sentences), len(chunks), threshold)
+	return chunks
+}
+
+// embedSentences embeds sentences, in a single call when the embedder
+// supports batches.
+func (cm *ContextManager) embedSentences(ctx context.Context, sentences []string) ([][]float32, error) {
+	embedder := cm.embedder
+	if embedder == nil {
+		embedder = database.NewHashEmbedder(database.DefaultEmbeddingDimensions)
+	}
+	embeddings, err := database.EmbedTexts(ctx, embedder, sentences)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed %d sentences with %s: %w", len(sentences), embedder.Name(), err)
+	}
+	if len(embeddings) != len(sentences) {
+		return nil, fmt.Errorf("%s returned %d embeddings for %d sentences", embedder.Name(), len(embeddings), len(sentences))
+	}
+	return embeddings, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0 if
+// either is empty, zero or the lengths differ.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
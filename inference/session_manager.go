@@ -0,0 +1,484 @@
+package inference
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"Agentic_Engine/database"
+
+	"github.com/google/uuid"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+// ErrSessionNotFound is returned when a session ID does not exist or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrSessionAccessDenied is returned when a request does not carry the
+// session's access token.
+var ErrSessionAccessDenied = errors.New("session access denied")
+
+// Session describes a conversation with its own isolated history.
+type Session struct {
+	ID             string         `json:"id"`
+	OwnerID        int64          `json:"owner_id"`
+	Model          string         `json:"model"`
+	MemoryStrategy MemoryStrategy `json:"memory_strategy"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	// Token grants access to the session. It is only returned on creation;
+	// the manager keeps a hash of it.
+	Token string `json:"token,omitempty"`
+}
+
+type sessionTokenKey struct{}
+
+// WithSessionToken attaches the access token of the caller's session to ctx.
+// Every access to a session by ID must carry its token.
+func WithSessionToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, sessionTokenKey{}, token)
+}
+
+// sessionTokenHash hashes an access token for storage and comparison.
+func sessionTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSessionToken returns a random access token.
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// sessionIdleTimeoutFromEnv reads SESSION_IDLE_TIMEOUT, a duration such as
+// "24h" after which inactive sessions expire. Unset or invalid means never.
+func sessionIdleTimeoutFromEnv() time.Duration {
+	raw := os.Getenv("SESSION_IDLE_TIMEOUT")
+	if raw == "" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout < 0 {
+		log.Printf("[WARN] SessionManager: Invalid SESSION_IDLE_TIMEOUT '%s'. Sessions do not expire.", raw)
+		return 0
+	}
+	return timeout
+}
+
+// MemoryFactory builds the ConversationMemory that stores a session's messages.
+// The session's MemoryStrategy is applied on top of it by the SessionManager.
+type MemoryFactory func(session *Session) (ConversationMemory, error)
+
+// conversationSession pairs session details with its memory.
+type conversationSession struct {
+	info      Session
+	tokenHash string
+	memory    ConversationMemory
+}
+
+// SessionManager keeps one ConversationMemory per session so that prompts
+// from different users or conversations never share a context window.
+// When backed by a ConversationRepository, sessions and their messages are
+// persisted and loaded on demand after a restart.
+type SessionManager struct {
+	sessions      map[string]*conversationSession
+	memoryFactory MemoryFactory
+	store         *database.ConversationRepository // Optional persistence
+	summarizer    TextGenerator                    // Used by the summary strategy
+	retrievalDB   *database.SimpleDomainDB         // Used by the retrieval strategy
+	pii           *PIIGuardrail                    // Redacts messages before a remote embedder sees them
+	idleTimeout   time.Duration                    // Inactive sessions expire after this (0 = never)
+	mutex         sync.RWMutex
+}
+
+// NewSessionManager creates a session manager. If factory is nil, sessions use
+// an in-memory SimpleWindowMemory keyed to the session's model.
+func NewSessionManager(factory MemoryFactory) *SessionManager {
+	if factory == nil {
+		factory = func(session *Session) (ConversationMemory, error) {
+			return NewSimpleWindowMemory(session.Model), nil
+		}
+	}
+	return &SessionManager{
+		sessions:      make(map[string]*conversationSession),
+		memoryFactory: factory,
+	}
+}
+
+// NewPersistentSessionManager creates a session manager that stores sessions
+// and their messages in the conversation database.
+func NewPersistentSessionManager(repo *database.ConversationRepository) *SessionManager {
+	manager := NewSessionManager(func(session *Session) (ConversationMemory, error) {
+		return NewSQLiteMemory(repo, session.ID, session.Model), nil
+	})
+	manager.store = repo
+	return manager
+}
+
+// SetSummarizer sets the generator used by sessions with the summary strategy.
+func (m *SessionManager) SetSummarizer(generator TextGenerator) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.summarizer = generator
+}
+
+// currentSummarizer returns the summarizer, which may change after sessions were loaded.
+func (m *SessionManager) currentSummarizer() TextGenerator {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.summarizer
+}
+
+// SetIdleTimeout sets how long a session may be inactive before it expires
+// and is deleted (0 disables expiry).
+func (m *SessionManager) SetIdleTimeout(timeout time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.idleTimeout = timeout
+}
+
+// SetRetrievalStore sets the vector database used by sessions with the retrieval strategy.
+func (m *SessionManager) SetRetrievalStore(db *database.SimpleDomainDB) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.retrievalDB = db
+}
+
+// SetPIIGuardrail sets the guardrail applied to messages embedded by sessions
+// with the retrieval strategy.
+func (m *SessionManager) SetPIIGuardrail(guardrail *PIIGuardrail) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pii = guardrail
+}
+
+// newMemory builds the memory of a session and applies its strategy.
+// Assumes the lock is held.
+func (m *SessionManager) newMemory(info *Session) (ConversationMemory, error) {
+	base, err := m.memoryFactory(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create memory for session: %w", err)
+	}
+
+	switch info.MemoryStrategy {
+	case MemoryStrategySummary:
+		memory := NewSummarizingMemory(base, m.currentSummarizer, info.Model)
+		if m.store != nil {
+			if err := memory.PersistTo(m.store, info.ID); err != nil {
+				return nil, fmt.Errorf("failed to load summary of session: %w", err)
+			}
+		}
+		return memory, nil
+	case MemoryStrategyRetrieval:
+		if m.retrievalDB == nil {
+			return nil, fmt.Errorf("retrieval memory is not available: no vector database configured")
+		}
+		memory, err := NewRetrievalMemory(base, m.retrievalDB, info.ID, info.Model)
+		if err != nil {
+			return nil, err
+		}
+		memory.embedder = guardEmbedder(m.retrievalDB.Embedder(), m.pii)
+		return memory, nil
+	default:
+		return base, nil
+	}
+}
+
+// CreateSession starts a new conversation for the given owner and model using
+// the given memory strategy (empty selects the sliding window). The returned
+// session carries the access token required by every later access.
+func (m *SessionManager) CreateSession(ownerID int64, model string, strategy MemoryStrategy) (*Session, error) {
+	strategy, err := ParseMemoryStrategy(string(strategy))
+	if err != nil {
+		return nil, err
+	}
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	tokenHash := sessionTokenHash(token)
+	now := time.Now().UTC()
+	info := Session{
+		ID:             uuid.New().String(),
+		OwnerID:        ownerID,
+		Model:          model,
+		MemoryStrategy: strategy,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	m.mutex.Lock()
+	memory, err := m.newMemory(&info)
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.store != nil {
+		stored := sessionToStored(&info)
+		stored.TokenHash = tokenHash
+		if err := m.store.CreateSession(context.Background(), stored); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mutex.Lock()
+	m.sessions[info.ID] = &conversationSession{info: info, tokenHash: tokenHash, memory: memory}
+	m.mutex.Unlock()
+
+	log.Printf("SessionManager: Created session %s (Owner: %d, Model: %s, Memory: %s)", info.ID, ownerID, model, strategy)
+	result := info
+	result.Token = token
+	return &result, nil
+}
+
+// lookup returns a loaded session, loading it from the store if needed.
+// Assumes the write lock is held.
+func (m *SessionManager) lookup(sessionID string) (*conversationSession, error) {
+	if session, ok := m.sessions[sessionID]; ok {
+		return session, nil
+	}
+	if m.store == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	stored, err := m.store.GetSession(context.Background(), sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	info := storedToSession(stored)
+	memory, err := m.newMemory(&info)
+	if err != nil {
+		return nil, err
+	}
+	session := &conversationSession{info: info, tokenHash: stored.TokenHash, memory: memory}
+	m.sessions[sessionID] = session
+	log.Printf("SessionManager: Loaded session %s from store", sessionID)
+	return session, nil
+}
+
+// expired reports whether a session has been inactive for longer than the idle timeout.
+// Assumes the lock is held.
+func (m *SessionManager) expired(info *Session) bool {
+	return m.idleTimeout > 0 && time.Since(info.UpdatedAt) > m.idleTimeout
+}
+
+// access returns a session for a caller, checking that ctx carries its access
+// token. Expired sessions are deleted and reported as not found.
+// Assumes the write lock is held.
+func (m *SessionManager) access(ctx context.Context, sessionID string) (*conversationSession, error) {
+	session, err := m.lookup(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if m.expired(&session.info) {
+		log.Printf("SessionManager: Session %s expired after %v of inactivity", sessionID, m.idleTimeout)
+		if err := m.remove(session); err != nil {
+			log.Printf("[WARN] SessionManager: Failed to delete expired session %s: %v", sessionID, err)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	token, _ := ctx.Value(sessionTokenKey{}).(string)
+	// Sessions stored before access tokens existed have no hash and stay closed
+	if token == "" || session.tokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(sessionTokenHash(token)), []byte(session.tokenHash)) != 1 {
+		return nil, fmt.Errorf("%w: %s", ErrSessionAccessDenied, sessionID)
+	}
+	return session, nil
+}
+
+// remove deletes a loaded session and its history.
+// Assumes the write lock is held.
+func (m *SessionManager) remove(session *conversationSession) error {
+	delete(m.sessions, session.info.ID)
+	// Strategies keep data outside the message store, such as retrieval embeddings
+	session.memory.Clear()
+	if m.store != nil {
+		return m.store.DeleteSession(context.Background(), session.info.ID)
+	}
+	return nil
+}
+
+// GetSession returns the details of a session.
+func (m *SessionManager) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session, err := m.access(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	info := session.info
+	return &info, nil
+}
+
+// ListSessions returns the sessions of an owner, most recently active first.
+// An ownerID of 0 lists every session. Expired sessions are left out.
+func (m *SessionManager) ListSessions(ownerID int64) []*Session {
+	if m.store != nil {
+		stored, err := m.store.ListSessions(context.Background(), ownerID)
+		if err != nil {
+			log.Printf("[ERROR] SessionManager: Failed to list sessions: %v", err)
+			return []*Session{}
+		}
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+		sessions := make([]*Session, 0, len(stored))
+		for _, s := range stored {
+			info := storedToSession(s)
+			if m.expired(&info) {
+				continue
+			}
+			sessions = append(sessions, &info)
+		}
+		return sessions
+	}
+
+	m.mutex.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if (ownerID != 0 && session.info.OwnerID != ownerID) || m.expired(&session.info) {
+			continue
+		}
+		info := session.info
+		sessions = append(sessions, &info)
+	}
+	m.mutex.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions
+}
+
+// DeleteSession removes a session and clears its history.
+func (m *SessionManager) DeleteSession(ctx context.Context, sessionID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session, err := m.access(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if err := m.remove(session); err != nil {
+		return err
+	}
+	log.Printf("SessionManager: Deleted session %s", sessionID)
+	return nil
+}
+
+// Memory returns the conversation memory of a session and marks it active.
+// An empty sessionID yields a fresh, unshared memory for a one-off request.
+// A retrieval memory is returned as a view that embeds with ctx, for use
+// within the request only.
+func (m *SessionManager) Memory(ctx context.Context, sessionID string, defaultModel string) (ConversationMemory, error) {
+	if sessionID == "" {
+		return NewSimpleWindowMemory(defaultModel), nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session, err := m.access(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	session.info.UpdatedAt = time.Now().UTC()
+	if m.store != nil {
+		if err := m.store.TouchSession(context.Background(), sessionID, session.info.UpdatedAt); err != nil {
+			log.Printf("[WARN] SessionManager: Failed to update activity of session %s: %v", sessionID, err)
+		}
+	}
+	if retrieval, ok := session.memory.(*RetrievalMemory); ok {
+		// A cancelled request still embeds its messages, within its PII scope
+		return retrievalRequest{RetrievalMemory: retrieval, ctx: context.WithoutCancel(ctx)}, nil
+	}
+	if summarizing, ok := session.memory.(*SummarizingMemory); ok {
+		return summaryRequest{SummarizingMemory: summarizing, ctx: ctx}, nil
+	}
+	return session.memory, nil
+}
+
+// History returns all messages recorded for a session.
+func (m *SessionManager) History(ctx context.Context, sessionID string) ([]gollm_types.MemoryMessage, error) {
+	m.mutex.Lock()
+	session, err := m.access(ctx, sessionID)
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return session.memory.GetHistory(), nil
+}
+
+// HistoryPage returns up to limit messages of a session starting at offset,
+// together with the total number of messages.
+func (m *SessionManager) HistoryPage(ctx context.Context, sessionID string, offset, limit int) ([]gollm_types.MemoryMessage, int, error) {
+	m.mutex.Lock()
+	session, err := m.access(ctx, sessionID)
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	return historyPageOf(session.memory, offset, limit)
+}
+
+// ClearAll clears the history of every session without deleting the sessions.
+func (m *SessionManager) ClearAll() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.store != nil {
+		// Make sure sessions that were not loaded since startup are cleared too
+		stored, err := m.store.ListSessions(context.Background(), 0)
+		if err != nil {
+			log.Printf("[ERROR] SessionManager: Failed to list sessions for clearing: %v", err)
+		}
+		for _, s := range stored {
+			if _, err := m.lookup(s.ID); err != nil {
+				log.Printf("[WARN] SessionManager: Failed to load session %s for clearing: %v", s.ID, err)
+			}
+		}
+	}
+	for _, session := range m.sessions {
+		session.memory.Clear()
+	}
+	log.Printf("SessionManager: Cleared history of %d sessions.", len(m.sessions))
+}
+
+// sessionToStored converts a session to its database representation.
+func sessionToStored(info *Session) *database.ConversationSession {
+	return &database.ConversationSession{
+		ID:             info.ID,
+		OwnerID:        info.OwnerID,
+		Model:          info.Model,
+		MemoryStrategy: string(info.MemoryStrategy),
+		CreatedAt:      info.CreatedAt,
+		UpdatedAt:      info.UpdatedAt,
+	}
+}
+
+// storedToSession converts a database session to a Session.
+func storedToSession(stored *database.ConversationSession) Session {
+	strategy, err := ParseMemoryStrategy(stored.MemoryStrategy)
+	if err != nil {
+		log.Printf("[WARN] SessionManager: Session %s has %v. Using the window strategy.", stored.ID, err)
+		strategy = MemoryStrategyWindow
+	}
+	return Session{
+		ID:             stored.ID,
+		OwnerID:        stored.OwnerID,
+		Model:          stored.Model,
+		MemoryStrategy: strategy,
+		CreatedAt:      stored.CreatedAt,
+		UpdatedAt:      stored.UpdatedAt,
+	}
+}
@@ -0,0 +1,95 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+func TestSessionsAreIsolatedByAccessToken(t *testing.T) {
+	manager := NewSessionManager(nil)
+	alice, err := manager.CreateSession(1, "m", "")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	bob, _ := manager.CreateSession(2, "m", "")
+	if alice.Token == "" || alice.Token == bob.Token {
+		t.Fatalf("Expected distinct access tokens, got %q and %q", alice.Token, bob.Token)
+	}
+	aliceCtx := WithSessionToken(context.Background(), alice.Token)
+	bobCtx := WithSessionToken(context.Background(), bob.Token)
+
+	memory, err := manager.Memory(aliceCtx, alice.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory failed: %v", err)
+	}
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: "alice's secret"})
+	if history, _ := manager.History(bobCtx, bob.ID); len(history) != 0 {
+		t.Errorf("Expected bob's history to be empty, got %v", history)
+	}
+
+	// Without the right token a session can be neither read, written nor deleted
+	for name, ctx := range map[string]context.Context{"no token": context.Background(), "other token": bobCtx} {
+		if _, err := manager.History(ctx, alice.ID); !errors.Is(err, ErrSessionAccessDenied) {
+			t.Errorf("%s: expected History to be denied, got %v", name, err)
+		}
+		if _, err := manager.Memory(ctx, alice.ID, "m"); !errors.Is(err, ErrSessionAccessDenied) {
+			t.Errorf("%s: expected Memory to be denied, got %v", name, err)
+		}
+		if err := manager.DeleteSession(ctx, alice.ID); !errors.Is(err, ErrSessionAccessDenied) {
+			t.Errorf("%s: expected DeleteSession to be denied, got %v", name, err)
+		}
+	}
+	if listed := manager.ListSessions(0); len(listed) != 2 || listed[0].Token != "" {
+		t.Errorf("Expected two sessions listed without tokens, got %+v", listed)
+	}
+	if history, err := manager.History(aliceCtx, alice.ID); err != nil || len(history) != 1 {
+		t.Errorf("Expected alice to read her message, got %v (%v)", history, err)
+	}
+}
+
+func TestSessionTokenRequiredForGeneration(t *testing.T) {
+	service := startFakeInferenceService(t, `{"default": "answer"}`)
+	session, err := service.CreateSession(1, "", "")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if _, err := service.GenerateText(context.Background(), session.ID, "", "hello", ""); !errors.Is(err, ErrSessionAccessDenied) {
+		t.Errorf("Expected generation without the token to be denied, got %v", err)
+	}
+	ctx := WithSessionToken(context.Background(), session.Token)
+	if _, err := service.GenerateText(ctx, session.ID, "", "hello", ""); err != nil {
+		t.Fatalf("GenerateText with the token failed: %v", err)
+	}
+	if history, err := service.GetSessionHistory(ctx, session.ID); err != nil || len(history) != 2 {
+		t.Errorf("Expected the prompt and answer in the history, got %v (%v)", history, err)
+	}
+}
+
+func TestIdleSessionsExpire(t *testing.T) {
+	manager := NewSessionManager(nil)
+	manager.SetIdleTimeout(30 * time.Millisecond)
+	session, err := manager.CreateSession(1, "m", "")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	ctx := WithSessionToken(context.Background(), session.Token)
+	if _, err := manager.Memory(ctx, session.ID, "m"); err != nil {
+		t.Fatalf("Memory failed before expiry: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if listed := manager.ListSessions(0); len(listed) != 0 {
+		t.Errorf("Expected expired sessions to be left out of the list, got %d", len(listed))
+	}
+	if _, err := manager.Memory(ctx, session.ID, "m"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected an expired session to be not found, got %v", err)
+	}
+	manager.SetIdleTimeout(0)
+	if _, err := manager.GetSession(ctx, session.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the expired session to have been deleted, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package inference
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"Agentic_Engine/database"
+
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+// SQLiteMemory implements ConversationMemory on top of the conversation
+// database so that a session's history survives restarts. Messages are
+// written through on every AddMessage and only read back the first time the
+// history is needed.
+type SQLiteMemory struct {
+	repo             *database.ConversationRepository
+	sessionID        string
+	defaultModelName string // Used for token counts stored with each message
+	messages         []gollm_types.MemoryMessage
+	loaded           bool
+	mu               sync.RWMutex
+}
+
+// NewSQLiteMemory creates a persistent memory for the given session.
+func NewSQLiteMemory(repo *database.ConversationRepository, sessionID string, defaultModelName string) *SQLiteMemory {
+	return &SQLiteMemory{
+		repo:             repo,
+		sessionID:        sessionID,
+		defaultModelName: defaultModelName,
+	}
+}
+
+// ensureLoaded reads the stored history on first use. Assumes the write lock is held.
+func (m *SQLiteMemory) ensureLoaded() {
+	if m.loaded {
+		return
+	}
+	stored, err := m.repo.GetMessages(context.Background(), m.sessionID, 0, 0)
+	if err != nil {
+		log.Printf("[ERROR] SQLiteMemory: Failed to load history for session %s: %v", m.sessionID, err)
+		return // Retry on next access
+	}
+	m.messages = make([]gollm_types.MemoryMessage, 0, len(stored))
+	for _, msg := range stored {
+		m.messages = append(m.messages, storedToMemoryMessage(msg))
+	}
+	m.loaded = true
+	log.Printf("SQLiteMemory: Loaded %d messages for session %s", len(m.messages), m.sessionID)
+}
+
+// AddMessage stores the message and appends it to the loaded history.
+func (m *SQLiteMemory) AddMessage(message gollm_types.MemoryMessage) {
+	if message.Tokens == 0 {
+		message.Tokens = estimateTokens(message.Content, m.defaultModelName)
+	}
+	stored := &database.ConversationMessage{
+		SessionID: m.sessionID,
+		Role:      message.Role,
+		Content:   message.Content,
+		Tokens:    message.Tokens,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// Hold the lock across the insert so a concurrent load cannot read the new
+	// row before it is appended, and rows are appended in the order they are stored
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.repo.AddMessage(context.Background(), stored); err != nil {
+		log.Printf("[ERROR] SQLiteMemory: Failed to persist message for session %s: %v", m.sessionID, err)
+	}
+	if m.loaded {
+		m.messages = append(m.messages, storedToMemoryMessage(stored))
+	}
+	log.Printf("SQLiteMemory: Added message (Role: %s) to session %s", message.Role, m.sessionID)
+}
+
+// GetMessagesForContext returns the most recent messages that fit within maxTokens.
+func (m *SQLiteMemory) GetMessagesForContext(maxTokens int, modelName string) []gollm_types.MemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensureLoaded()
+
+	var currentTokens int
+	var contextMessages []gollm_types.MemoryMessage
+
+	estimationModel := m.defaultModelName
+	if modelName != "" {
+		estimationModel = modelName
+	}
+	// Iterate backwards from the most recent message
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		msgTokens := msg.Tokens
+		if msgTokens == 0 || estimationModel != m.defaultModelName {
+			msgTokens = estimateTokens(msg.Content, estimationModel)
+		}
+
+		if currentTokens+msgTokens > maxTokens {
+			log.Printf("SQLiteMemory: Token limit (%d) reached. Returning %d messages (%d tokens).", maxTokens, len(contextMessages), currentTokens)
+			break
+		}
+		contextMessages = append([]gollm_types.MemoryMessage{msg}, contextMessages...)
+		currentTokens += msgTokens
+	}
+	return contextMessages
+}
+
+// Clear removes all stored messages of the session.
+func (m *SQLiteMemory) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.repo.DeleteMessages(context.Background(), m.sessionID); err != nil {
+		log.Printf("[ERROR] SQLiteMemory: Failed to clear history for session %s: %v", m.sessionID, err)
+		return
+	}
+	m.messages = make([]gollm_types.MemoryMessage, 0)
+	m.loaded = true
+	log.Printf("SQLiteMemory: History cleared for session %s.", m.sessionID)
+}
+
+// GetHistory returns a copy of all messages.
+func (m *SQLiteMemory) GetHistory() []gollm_types.MemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensureLoaded()
+	historyCopy := make([]gollm_types.MemoryMessage, len(m.messages))
+	copy(historyCopy, m.messages)
+	return historyCopy
+}
+
+// GetHistoryPage reads a page of the history directly from the database,
+// without loading the full history.
+func (m *SQLiteMemory) GetHistoryPage(offset, limit int) ([]gollm_types.MemoryMessage, int, error) {
+	ctx := context.Background()
+	total, err := m.repo.CountMessages(ctx, m.sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	stored, err := m.repo.GetMessages(ctx, m.sessionID, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	page := make([]gollm_types.MemoryMessage, 0, len(stored))
+	for _, msg := range stored {
+		page = append(page, storedToMemoryMessage(msg))
+	}
+	return page, total, nil
+}
+
+// storedToMemoryMessage converts a database row to a gollm message, keeping
+// the timestamp in the message metadata.
+func storedToMemoryMessage(msg *database.ConversationMessage) gollm_types.MemoryMessage {
+	return gollm_types.MemoryMessage{
+		Role:    msg.Role,
+		Content: msg.Content,
+		Tokens:  msg.Tokens,
+		Metadata: map[string]interface{}{
+			"id":         msg.ID,
+			"created_at": msg.CreatedAt,
+		},
+	}
+}
+
+// Compile-time check to ensure SQLiteMemory implements ConversationMemory
+var _ ConversationMemory = (*SQLiteMemory)(nil)
+var _ PagedMemory = (*SQLiteMemory)(nil)
@@ -0,0 +1,110 @@
+package inference
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"Agentic_Engine/database"
+
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+)
+
+// newTestConversationRepo opens a conversation database in a temporary directory.
+func newTestConversationRepo(t *testing.T) *database.ConversationRepository {
+	t.Helper()
+	db, err := database.NewConversationDB(filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatalf("NewConversationDB failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return database.NewConversationRepository(db.GetDB())
+}
+
+func TestSessionsPersistAcrossRestart(t *testing.T) {
+	repo := newTestConversationRepo(t)
+	session, err := NewPersistentSessionManager(repo).CreateSession(3, "m", MemoryStrategyWindow)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	ctx := WithSessionToken(context.Background(), session.Token)
+	first := NewPersistentSessionManager(repo)
+	memory, err := first.Memory(ctx, session.ID, "m")
+	if err != nil {
+		t.Fatalf("Memory failed: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: fmt.Sprintf("message %d", i)})
+	}
+
+	// A new manager on the same database sees the session, its token and history
+	restarted := NewPersistentSessionManager(repo)
+	if listed := restarted.ListSessions(3); len(listed) != 1 || listed[0].ID != session.ID {
+		t.Fatalf("Expected the session to be listed after the restart, got %+v", listed)
+	}
+	info, err := restarted.GetSession(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("GetSession after restart failed: %v", err)
+	}
+	if info.OwnerID != 3 || info.Model != "m" || info.MemoryStrategy != MemoryStrategyWindow {
+		t.Errorf("Expected the stored session details, got %+v", info)
+	}
+	if history, err := restarted.History(ctx, session.ID); err != nil || len(history) != 5 || history[4].Content != "message 5" {
+		t.Errorf("Expected 5 messages in order, got %v (%v)", history, err)
+	}
+
+	page, total, err := restarted.HistoryPage(ctx, session.ID, 1, 2)
+	if err != nil {
+		t.Fatalf("HistoryPage failed: %v", err)
+	}
+	if total != 5 || len(page) != 2 || page[0].Content != "message 2" || page[1].Content != "message 3" {
+		t.Errorf("Expected messages 2-3 of 5, got %v (total %d)", page, total)
+	}
+	if page[0].Metadata["created_at"] == nil {
+		t.Errorf("Expected the stored timestamp in the message metadata, got %v", page[0].Metadata)
+	}
+
+	if err := restarted.DeleteSession(ctx, session.ID); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if _, err := NewPersistentSessionManager(repo).GetSession(ctx, session.ID); err == nil {
+		t.Errorf("Expected the deleted session to be gone after another restart")
+	}
+}
+
+func TestSQLiteMemoryConcurrentAdds(t *testing.T) {
+	repo := newTestConversationRepo(t)
+	session, err := NewPersistentSessionManager(repo).CreateSession(3, "m", MemoryStrategyWindow)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	memory := NewSQLiteMemory(repo, session.ID, "m")
+
+	// Loads race with the adds; every message must be in memory once, in stored order
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: fmt.Sprintf("message %d", i)})
+		}(i)
+		go func() {
+			defer wg.Done()
+			memory.GetHistory()
+		}()
+	}
+	wg.Wait()
+
+	history := memory.GetHistory()
+	stored := NewSQLiteMemory(repo, session.ID, "m").GetHistory()
+	if len(history) != 20 || len(stored) != 20 {
+		t.Fatalf("Expected 20 messages in memory and in the database, got %d and %d", len(history), len(stored))
+	}
+	for i := range stored {
+		if history[i].Content != stored[i].Content {
+			t.Fatalf("Expected the in-memory order to match the stored order at %d, got %q and %q", i, history[i].Content, stored[i].Content)
+		}
+	}
+}
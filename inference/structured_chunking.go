@@ -0,0 +1,311 @@
+package inference
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// textChunk is a chunk of input together with the headings it sits under.
+type textChunk struct {
+	Text        string
+	HeadingPath []string // Outermost heading first; empty for unstructured strategies
+}
+
+// headingContext renders the heading path as a line for chunk prompts.
+func (c textChunk) headingContext() string {
+	if len(c.HeadingPath) == 0 {
+		return ""
+	}
+	return "Section: " + strings.Join(c.HeadingPath, " > ")
+}
+
+// prompt builds the prompt for one chunk: the instruction, the heading path if
+// known, and the chunk text between --- markers.
+func (c textChunk) prompt(instruction string) string {
+	var sb strings.Builder
+	if instruction != "" {
+		sb.WriteString(instruction)
+		sb.WriteString("\n\n")
+	}
+	if heading := c.headingContext(); heading != "" {
+		sb.WriteString(heading)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("---\n")
+	sb.WriteString(c.Text)
+	sb.WriteString("\n---")
+	return sb.String()
+}
+
+// chunkTexts drops heading information, for code paths that work on plain text.
+func chunkTexts(chunks []textChunk) []string {
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+	return texts
+}
+
+// structuredBlock is an indivisible piece of a document: a heading, paragraph,
+// list, table or code block.
+type structuredBlock struct {
+	Text         string
+	HeadingLevel int    // 1-6 for headings, 0 otherwise
+	HeadingTitle string // Plain-text heading title
+}
+
+var (
+	markdownHeadingRegex  = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.+?)\s*#*\s*$`)
+	markdownListItemRegex = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s+`)
+)
+
+// splitMarkdownBlocks splits Markdown into blocks on blank lines and ATX headings.
+// Fenced code blocks stay whole even when they contain blank lines, and loose
+// lists (items separated by blank lines) are kept together.
+func splitMarkdownBlocks(text string) []structuredBlock {
+	var blocks []structuredBlock
+	var buf []string
+	fence := "" // Opening fence marker while inside a code block
+
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		block := strings.Join(buf, "\n")
+		buf = nil
+		if strings.TrimSpace(block) == "" {
+			return
+		}
+		// Merge list items and indented continuations into the preceding list
+		if n := len(blocks); n > 0 && blocks[n-1].HeadingLevel == 0 &&
+			markdownListItemRegex.MatchString(blocks[n-1].Text) &&
+			(markdownListItemRegex.MatchString(block) || strings.HasPrefix(block, " ") || strings.HasPrefix(block, "\t")) {
+			blocks[n-1].Text += "\n\n" + block
+			return
+		}
+		blocks = append(blocks, structuredBlock{Text: block})
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			buf = append(buf, line)
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+				flush()
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			flush()
+			fence = trimmed[:3]
+			buf = append(buf, line)
+			continue
+		}
+		if m := markdownHeadingRegex.FindStringSubmatch(line); m != nil {
+			flush()
+			blocks = append(blocks, structuredBlock{Text: trimmed, HeadingLevel: len(m[1]), HeadingTitle: m[2]})
+			continue
+		}
+		if trimmed == "" {
+			flush()
+			continue
+		}
+		buf = append(buf, line)
+	}
+	flush() // An unterminated fence runs to the end of the document
+	return blocks
+}
+
+// htmlBlockTags are rendered as single blocks and never split.
+var htmlBlockTags = map[atom.Atom]bool{
+	atom.P: true, atom.Table: true, atom.Ul: true, atom.Ol: true, atom.Dl: true,
+	atom.Pre: true, atom.Blockquote: true, atom.Figure: true, atom.Hr: true,
+	atom.Details: true, atom.Form: true, atom.Iframe: true, atom.Video: true, atom.Audio: true,
+}
+
+// htmlContainerTags are descended into when they hold headings or are too large
+// for one chunk, and kept whole otherwise.
+var htmlContainerTags = map[atom.Atom]bool{
+	atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Nav: true,
+}
+
+var htmlHeadingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// splitHTMLBlocks splits HTML into headings and block elements. Tables, lists,
+// <pre> code and other block elements are kept as rendered HTML; runs of inline
+// content between them become their own block. Comments (such as WordPress block
+// delimiters) are dropped.
+func (cm *ContextManager) splitHTMLBlocks(text string) ([]structuredBlock, error) {
+	doc, err := html.Parse(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	body := findHTMLElement(doc, atom.Body)
+	if body == nil {
+		body = doc
+	}
+
+	var blocks []structuredBlock
+	var inline bytes.Buffer
+	flushInline := func() {
+		if content := strings.TrimSpace(inline.String()); content != "" {
+			blocks = append(blocks, structuredBlock{Text: content})
+		}
+		inline.Reset()
+	}
+
+	var walk func(parent *html.Node)
+	walk = func(parent *html.Node) {
+		for node := parent.FirstChild; node != nil; node = node.NextSibling {
+			switch node.Type {
+			case html.TextNode:
+				html.Render(&inline, node)
+			case html.ElementNode:
+				if level := htmlHeadingLevels[node.DataAtom]; level > 0 {
+					flushInline()
+					blocks = append(blocks, structuredBlock{
+						Text:         renderHTMLNode(node),
+						HeadingLevel: level,
+						HeadingTitle: strings.Join(strings.Fields(htmlNodeText(node)), " "),
+					})
+					continue
+				}
+				if htmlContainerTags[node.DataAtom] {
+					flushInline()
+					rendered := renderHTMLNode(node)
+					if containsHTMLHeading(node) || estimateTokens(rendered, cm.modelName) > cm.maxChunkSize {
+						walk(node)
+					} else {
+						blocks = append(blocks, structuredBlock{Text: rendered})
+					}
+					continue
+				}
+				if htmlBlockTags[node.DataAtom] {
+					flushInline()
+					blocks = append(blocks, structuredBlock{Text: renderHTMLNode(node)})
+					continue
+				}
+				html.Render(&inline, node) // Inline element
+			}
+		}
+		flushInline()
+	}
+	walk(body)
+	return blocks, nil
+}
+
+// packStructuredBlocks groups blocks into chunks of at most maxChunkSize tokens.
+// Every heading starts a new chunk, and each chunk records the heading path it
+// belongs to. A single block larger than the limit becomes its own chunk rather
+// than being cut.
+func (cm *ContextManager) packStructuredBlocks(blocks []structuredBlock, separator string) []textChunk {
+	type heading struct {
+		level int
+		title string
+	}
+	var stack []heading
+	var chunks []textChunk
+	var current []string
+	var currentPath []string
+	currentTokens := 0
+	onlyHeadings := true // Current chunk holds nothing but headings so far
+
+	currentHeadingPath := func() []string {
+		path := make([]string, len(stack))
+		for i, h := range stack {
+			path[i] = h.title
+		}
+		return path
+	}
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, textChunk{Text: strings.Join(current, separator), HeadingPath: currentPath})
+		}
+		current, currentTokens, onlyHeadings = nil, 0, true
+	}
+
+	for _, block := range blocks {
+		tokens := estimateTokens(block.Text, cm.modelName)
+		if block.HeadingLevel > 0 {
+			// A heading closes the previous section unless that section is only
+			// headings itself (e.g. an H1 directly followed by an H2)
+			if !onlyHeadings {
+				flush()
+			}
+			for len(stack) > 0 && stack[len(stack)-1].level >= block.HeadingLevel {
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, heading{level: block.HeadingLevel, title: block.HeadingTitle})
+			if len(current) == 0 || onlyHeadings {
+				currentPath = currentHeadingPath()
+			}
+			current = append(current, block.Text)
+			currentTokens += tokens
+			continue
+		}
+
+		if !onlyHeadings && currentTokens+tokens > cm.maxChunkSize {
+			flush() // Continue the same section in a new chunk
+		}
+		if len(current) == 0 {
+			currentPath = currentHeadingPath()
+		}
+		if tokens > cm.maxChunkSize {
+			log.Printf("ContextManager: Keeping a %d-token block intact in one chunk (max %d).", tokens, cm.maxChunkSize)
+		}
+		current = append(current, block.Text)
+		currentTokens += tokens
+		onlyHeadings = false
+	}
+	flush()
+	return chunks
+}
+
+func findHTMLElement(node *html.Node, tag atom.Atom) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == tag {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findHTMLElement(child, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func containsHTMLHeading(node *html.Node) bool {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && (htmlHeadingLevels[child.DataAtom] > 0 || containsHTMLHeading(child)) {
+			return true
+		}
+	}
+	return false
+}
+
+func htmlNodeText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var sb strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(htmlNodeText(child))
+	}
+	return sb.String()
+}
+
+func renderHTMLNode(node *html.Node) string {
+	var buf bytes.Buffer
+	if err := html.Render(&buf, node); err != nil {
+		return htmlNodeText(node)
+	}
+	return buf.String()
+}
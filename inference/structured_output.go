@@ -0,0 +1,220 @@
+package inference
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/guiperry/gollm_cerebras/llm"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+	"github.com/invopop/jsonschema"
+)
+
+// DefaultStructuredOutputRepairs is the number of times an invalid structured
+// response is sent back to the model together with its validation errors.
+const DefaultStructuredOutputRepairs = 2
+
+// ErrSchemaValidation is matched (via errors.Is) by a *SchemaValidationError.
+var ErrSchemaValidation = errors.New("response does not match schema")
+
+// SchemaValidationError reports a structured response that still failed
+// validation after all repair attempts.
+type SchemaValidationError struct {
+	Errors   []string // Validation errors of the last response
+	Response string   // Last response produced by the model
+	Attempts int      // Number of responses that were validated
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("%v after %d attempts: %s", ErrSchemaValidation, e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+// Is lets errors.Is match ErrSchemaValidation.
+func (e *SchemaValidationError) Is(target error) bool {
+	return target == ErrSchemaValidation
+}
+
+// structuredOutputRepairsFromEnv reads STRUCTURED_OUTPUT_MAX_REPAIRS. An unset
+// or invalid value yields the default; 0 disables repair prompts.
+func structuredOutputRepairsFromEnv() int {
+	raw := os.Getenv("STRUCTURED_OUTPUT_MAX_REPAIRS")
+	if raw == "" {
+		return DefaultStructuredOutputRepairs
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		log.Printf("[WARN] StructuredOutput: Invalid STRUCTURED_OUTPUT_MAX_REPAIRS '%s'. Using default %d.", raw, DefaultStructuredOutputRepairs)
+		return DefaultStructuredOutputRepairs
+	}
+	return value
+}
+
+// SchemaFor generates the JSON schema of a Go value (usually a pointer to a
+// struct) with invopop/jsonschema. Definitions are inlined so the schema is
+// self-contained when shown to the model.
+func SchemaFor(v interface{}) (string, error) {
+	reflector := jsonschema.Reflector{DoNotReference: true}
+	schema := reflector.Reflect(v)
+	if schema == nil {
+		return "", fmt.Errorf("failed to reflect jsonschema from type %T", v)
+	}
+	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return string(schemaJSON), nil
+}
+
+// parseSchema decodes a JSON schema document.
+func parseSchema(schema string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return doc, nil
+}
+
+// nativeSchemaCompatible reports whether gollm understands a schema: every
+// node has a simple type and every object lists its properties. Only such
+// schemas are sent to a provider's native schema mode, since gollm checks
+// native responses itself, or fully validated by validateStructuredResponse.
+func nativeSchemaCompatible(schema map[string]interface{}) bool {
+	schemaType, ok := schema["type"].(string)
+	if !ok {
+		return false
+	}
+	switch schemaType {
+	case "object":
+		properties, ok := schema["properties"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, property := range properties {
+			propertySchema, ok := property.(map[string]interface{})
+			if !ok || !nativeSchemaCompatible(propertySchema) {
+				return false
+			}
+		}
+		return true
+	case "array":
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			return nativeSchemaCompatible(items)
+		}
+		return true
+	case "string", "number", "integer", "boolean":
+		return true
+	}
+	return false
+}
+
+// generateNativeStructured asks the configured models that support a native
+// JSON schema mode (OpenAI-style response_format, Gemini responseSchema) for a
+// response constrained to the schema. It returns false if no model supports
+// it or every attempt failed, in which case the caller falls back to
+// prompt-based extraction.
+func (d *DelegatorService) generateNativeStructured(ctx context.Context, memory ConversationMemory, schemaDoc map[string]interface{}) (string, bool) {
+	if !nativeSchemaCompatible(schemaDoc) {
+		log.Println("DelegatorService (StructuredOutput): Schema is not supported by native schema mode. Using prompt-based extraction.")
+		return "", false
+	}
+	messagesForContext := memory.GetMessagesForContext(d.tokenLimitThreshold, d.tokenLimitCheckModel)
+	if len(messagesForContext) == 0 {
+		return "", false
+	}
+	prompt := llm.NewPrompt(formatMessagesToPrompt(messagesForContext))
+
+	for _, attempt := range append(append([]LLMAttempt{}, d.primaryAttempts...), d.fallbackAttempts...) {
+		if attempt.Instance == nil || !attempt.Instance.SupportsJSONSchema() {
+			continue
+		}
+		log.Printf("DelegatorService (StructuredOutput): Using native JSON schema mode of %s (Model: %s)...", attempt.Config.ProviderName, attempt.Config.ModelName)
+		response, err := attempt.Instance.GenerateWithSchema(ctx, prompt, schemaDoc)
+		if err != nil {
+			log.Printf("DelegatorService (StructuredOutput): Native schema mode with %s failed: %v", attempt.Config.ModelName, err)
+			if ctx.Err() != nil {
+				return "", false
+			}
+			continue
+		}
+		memory.AddMessage(gollm_types.MemoryMessage{Role: "assistant", Content: response})
+		return response, true
+	}
+	return "", false
+}
+
+// extractJSON returns the first JSON object or array in a model response,
+// ignoring markdown fences and any text around it.
+func extractJSON(response string) (string, error) {
+	text := strings.TrimSpace(response)
+	if start := strings.Index(text, "```"); start >= 0 {
+		fenced := text[start+3:]
+		// Drop the language tag, e.g. ```json
+		if newline := strings.Index(fenced, "\n"); newline >= 0 {
+			fenced = fenced[newline+1:]
+		}
+		if end := strings.Index(fenced, "```"); end >= 0 {
+			fenced = fenced[:end]
+		}
+		if value, ok := firstJSONValue(fenced); ok {
+			return value, nil
+		}
+	}
+	if value, ok := firstJSONValue(text); ok {
+		return value, nil
+	}
+	return "", errors.New("response does not contain a JSON object")
+}
+
+// firstJSONValue decodes the first complete JSON object or array in text.
+func firstJSONValue(text string) (string, bool) {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' && text[i] != '[' {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(text[i:]))
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == nil {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, raw); err == nil {
+				return compact.String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// validateStructuredResponse extracts the JSON of a response and validates
+// it with gollm's llm.ValidateAgainstSchema, returning the JSON and the
+// validation errors. Schemas gollm does not understand (see
+// nativeSchemaCompatible) are only checked for well-formed JSON.
+func validateStructuredResponse(response string, schema map[string]interface{}) (string, []string) {
+	jsonText, err := extractJSON(response)
+	if err != nil {
+		return "", []string{err.Error()}
+	}
+	if !json.Valid([]byte(jsonText)) {
+		return "", []string{"invalid JSON"}
+	}
+	if !nativeSchemaCompatible(schema) {
+		log.Println("[WARN] DelegatorService (StructuredOutput): Schema is not supported by the validator, only checking for well-formed JSON.")
+		return jsonText, nil
+	}
+	if err := llm.ValidateAgainstSchema(jsonText, schema); err != nil {
+		return jsonText, []string{err.Error()}
+	}
+	return jsonText, nil
+}
+
+func compactJSON(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
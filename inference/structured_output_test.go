@@ -0,0 +1,43 @@
+package inference
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateStructuredResponse(t *testing.T) {
+	schema, err := parseSchema(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["name"]
+	}`)
+	if err != nil {
+		t.Fatalf("parseSchema failed: %v", err)
+	}
+
+	jsonText, violations := validateStructuredResponse("Here you go:\n```json\n{\"name\": \"Ada\", \"tags\": [\"math\"]}\n```", schema)
+	if len(violations) != 0 || jsonText != `{"name":"Ada","tags":["math"]}` {
+		t.Errorf("Expected the fenced JSON to be valid, got %q (%v)", jsonText, violations)
+	}
+	for response, violation := range map[string]string{
+		`{"tags": []}`:                 "missing required field: name",
+		`{"name": "Ada", "tags": [1]}`: "invalid field 'tags'",
+		`{"name": 42}`:                 "expected string",
+	} {
+		if _, violations := validateStructuredResponse(response, schema); len(violations) != 1 || !strings.Contains(violations[0], violation) {
+			t.Errorf("Expected %q to fail with %q, got %v", response, violation, violations)
+		}
+	}
+	if _, violations := validateStructuredResponse("no JSON at all", schema); len(violations) == 0 {
+		t.Errorf("Expected a response without JSON to fail")
+	}
+
+	// Schemas gollm cannot read are only checked for well-formed JSON
+	refSchema, _ := parseSchema(`{"$ref": "#/$defs/user", "$defs": {"user": {"type": "object"}}}`)
+	if _, violations := validateStructuredResponse(`{"anything": true}`, refSchema); len(violations) != 0 {
+		t.Errorf("Expected well-formed JSON to pass an unsupported schema, got %v", violations)
+	}
+}
@@ -0,0 +1,578 @@
+package inference
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/guiperry/gollm_cerebras/providers"
+	gollm_types "github.com/guiperry/gollm_cerebras/types"
+	"github.com/guiperry/gollm_cerebras/utils"
+)
+
+const (
+	// DefaultMaxToolIterations bounds the number of model turns in a tool-calling loop.
+	DefaultMaxToolIterations = 5
+	// maxToolOutputBytes caps the size of a tool result fed back to the model.
+	maxToolOutputBytes = 16 * 1024
+	// httpToolTimeout is the request timeout used for HTTP endpoint tools.
+	httpToolTimeout = 30 * time.Second
+)
+
+var (
+	// ErrToolNotFound is returned when a tool name is not registered.
+	ErrToolNotFound = errors.New("tool not found")
+	// ErrToolEndpointNotAllowed is returned for HTTP tool endpoints whose host
+	// is not allowed (see SetAllowedHosts) or resolves to an internal address.
+	ErrToolEndpointNotAllowed = errors.New("tool endpoint not allowed")
+)
+
+// ToolHandler executes a tool call. It receives the JSON arguments produced by
+// the model and returns the result text that is fed back to the model.
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// ToolDefinition describes a tool the model may call. Tools are either backed
+// by a Go handler (registered with RegisterFunc) or by an HTTP endpoint that
+// receives the call arguments as a JSON body (registered with RegisterHTTP).
+// Headers are accepted on registration but never listed, as they usually
+// carry credentials.
+type ToolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"` // JSON schema of the arguments
+	Endpoint    string                 `json:"endpoint,omitempty"`   // HTTP tools only
+	Method      string                 `json:"method,omitempty"`     // HTTP tools only, POST (default) or GET
+	Headers     map[string]string      `json:"headers,omitempty"`    // HTTP tools only
+	Builtin     bool                   `json:"builtin"`
+
+	handler ToolHandler
+}
+
+// ToolRegistry holds the tools available to tool-calling generation.
+type ToolRegistry struct {
+	tools        map[string]*ToolDefinition
+	allowedHosts map[string]bool // Hosts HTTP tools may call; none by default
+	httpClient   *http.Client
+	mutex        sync.RWMutex
+}
+
+// NewToolRegistry creates an empty tool registry. HTTP tools can only be
+// registered for the hosts allowed with SetAllowedHosts.
+func NewToolRegistry() *ToolRegistry {
+	r := &ToolRegistry{
+		tools:        make(map[string]*ToolDefinition),
+		allowedHosts: make(map[string]bool),
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: rejectInternalAddress}
+	r.httpClient = &http.Client{
+		Timeout: httpToolTimeout,
+		// No proxy: the dialer must see the address of the tool's host
+		Transport:     &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		CheckRedirect: r.checkRedirect,
+	}
+	return r
+}
+
+// SetAllowedHosts replaces the hosts HTTP tools may call. Tools registered for
+// hosts that are no longer allowed are kept but their calls are refused.
+func (r *ToolRegistry) SetAllowedHosts(hosts []string) {
+	allowed := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			allowed[host] = true
+		}
+	}
+	r.mutex.Lock()
+	r.allowedHosts = allowed
+	r.mutex.Unlock()
+}
+
+// toolAllowedHostsFromEnv reads TOOL_HTTP_ALLOWED_HOSTS, a comma-separated
+// list of host names. HTTP tools are disabled when it is unset.
+func toolAllowedHostsFromEnv() []string {
+	raw := os.Getenv("TOOL_HTTP_ALLOWED_HOSTS")
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+// checkEndpoint reports whether endpoint may be called by an HTTP tool: its
+// host must be allowed and must not be a loopback, private or link-local
+// address. Host names are checked again when they are dialed.
+func (r *ToolRegistry) checkEndpoint(endpoint *url.URL) error {
+	host := strings.ToLower(endpoint.Hostname())
+	r.mutex.RLock()
+	allowed := r.allowedHosts[host]
+	r.mutex.RUnlock()
+	if !allowed {
+		return fmt.Errorf("%w: host %s is not in TOOL_HTTP_ALLOWED_HOSTS", ErrToolEndpointNotAllowed, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && isInternalIP(ip) {
+		return fmt.Errorf("%w: %s is an internal address", ErrToolEndpointNotAllowed, host)
+	}
+	return nil
+}
+
+func (r *ToolRegistry) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 5 {
+		return errors.New("stopped after 5 redirects")
+	}
+	return r.checkEndpoint(req.URL)
+}
+
+// rejectInternalAddress is the dialer's Control hook: it refuses connections
+// to internal addresses, whatever name resolved to them.
+func rejectInternalAddress(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+		return fmt.Errorf("%w: %s is an internal address", ErrToolEndpointNotAllowed, host)
+	}
+	return nil
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// RegisterFunc registers a tool backed by a Go handler, replacing any tool
+// with the same name.
+func (r *ToolRegistry) RegisterFunc(name, description string, parameters map[string]interface{}, handler ToolHandler) error {
+	if name == "" {
+		return errors.New("tool name is required")
+	}
+	if handler == nil {
+		return fmt.Errorf("tool %s: handler is required", name)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tools[name] = &ToolDefinition{
+		Name:        name,
+		Description: description,
+		Parameters:  parameters,
+		Builtin:     true,
+		handler:     handler,
+	}
+	log.Printf("ToolRegistry: Registered tool '%s'", name)
+	return nil
+}
+
+// RegisterHTTP registers a tool that is executed by calling an HTTP endpoint,
+// replacing any tool with the same name. POST tools receive the arguments as
+// the JSON request body; GET tools receive them as query parameters. The
+// endpoint's host must be allowed (see SetAllowedHosts).
+func (r *ToolRegistry) RegisterHTTP(def ToolDefinition) error {
+	if def.Name == "" {
+		return errors.New("tool name is required")
+	}
+	endpoint, err := url.Parse(def.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("tool %s: endpoint must be an absolute http(s) URL", def.Name)
+	}
+	if err := r.checkEndpoint(endpoint); err != nil {
+		return fmt.Errorf("tool %s: %w", def.Name, err)
+	}
+	def.Method = strings.ToUpper(def.Method)
+	if def.Method == "" {
+		def.Method = http.MethodPost
+	}
+	if def.Method != http.MethodPost && def.Method != http.MethodGet {
+		return fmt.Errorf("tool %s: unsupported method %s", def.Name, def.Method)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if existing, ok := r.tools[def.Name]; ok && existing.Builtin {
+		return fmt.Errorf("tool %s: cannot replace a built-in tool", def.Name)
+	}
+	def.Builtin = false
+	def.handler = r.httpHandler(def)
+	r.tools[def.Name] = &def
+	log.Printf("ToolRegistry: Registered HTTP tool '%s' (%s %s)", def.Name, def.Method, def.Endpoint)
+	return nil
+}
+
+// Unregister removes a tool.
+func (r *ToolRegistry) Unregister(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.tools[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrToolNotFound, name)
+	}
+	delete(r.tools, name)
+	log.Printf("ToolRegistry: Unregistered tool '%s'", name)
+	return nil
+}
+
+// List returns the registered tools sorted by name, without their headers.
+func (r *ToolRegistry) List() []ToolDefinition {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	tools := make([]ToolDefinition, 0, len(r.tools))
+	for _, tool := range r.tools {
+		listed := *tool
+		listed.Headers = nil
+		tools = append(tools, listed)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+// Select returns the named tools, or every registered tool when names is empty.
+func (r *ToolRegistry) Select(names []string) ([]ToolDefinition, error) {
+	if len(names) == 0 {
+		return r.List(), nil
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	tools := make([]ToolDefinition, 0, len(names))
+	for _, name := range names {
+		tool, ok := r.tools[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrToolNotFound, name)
+		}
+		tools = append(tools, *tool)
+	}
+	return tools, nil
+}
+
+// httpHandler builds the handler that forwards a call to the tool's endpoint.
+func (r *ToolRegistry) httpHandler(def ToolDefinition) ToolHandler {
+	return func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		endpoint, _ := url.Parse(def.Endpoint) // Validated on registration
+		if err := r.checkEndpoint(endpoint); err != nil {
+			return "", err
+		}
+		var req *http.Request
+		var err error
+		if def.Method == http.MethodGet {
+			var args map[string]interface{}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("arguments must be a JSON object: %w", err)
+			}
+			query := endpoint.Query()
+			for key, value := range args {
+				query.Set(key, fmt.Sprint(value))
+			}
+			endpoint.RawQuery = query.Encode()
+			req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+		} else {
+			req, err = http.NewRequestWithContext(ctx, http.MethodPost, def.Endpoint, bytes.NewReader(arguments))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		for key, value := range def.Headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := r.httpClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxToolOutputBytes))
+		if err != nil {
+			return "", fmt.Errorf("failed to read response: %w", err)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return "", fmt.Errorf("endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		return string(body), nil
+	}
+}
+
+// execute runs a single tool call and never fails: errors are reported in the
+// result so the model can react to them.
+func (r *ToolRegistry) execute(ctx context.Context, allowed map[string]bool, call ToolCall) ToolResult {
+	start := time.Now()
+	result := ToolResult{CallID: call.ID, Name: call.Name}
+
+	r.mutex.RLock()
+	tool, ok := r.tools[call.Name]
+	r.mutex.RUnlock()
+
+	var output string
+	var err error
+	switch {
+	case !ok || !allowed[call.Name]:
+		err = fmt.Errorf("%w: %s", ErrToolNotFound, call.Name)
+	default:
+		output, err = tool.handler(ctx, call.Arguments)
+	}
+
+	result.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("ToolRegistry: Tool '%s' (call %s) failed: %v", call.Name, call.ID, err)
+		return result
+	}
+	if len(output) > maxToolOutputBytes {
+		output = output[:maxToolOutputBytes] + "\n[output truncated]"
+	}
+	result.Output = output
+	return result
+}
+
+// ToolCall is a tool invocation requested by the model.
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolResult is the outcome of executing a ToolCall.
+type ToolResult struct {
+	CallID     string `json:"call_id"`
+	Name       string `json:"name"`
+	Output     string `json:"output,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// ToolStep records one model turn of a tool-calling loop.
+type ToolStep struct {
+	Iteration int          `json:"iteration"`
+	Response  string       `json:"response"`
+	ToolCalls []ToolCall   `json:"tool_calls,omitempty"`
+	Results   []ToolResult `json:"results,omitempty"`
+}
+
+// ToolTrace is the full record of a tool-calling generation.
+type ToolTrace struct {
+	Answer        string     `json:"answer"`
+	Steps         []ToolStep `json:"steps"`
+	Iterations    int        `json:"iterations"`
+	StoppedReason string     `json:"stopped_reason"` // "final_answer" or "max_iterations"
+}
+
+// providerTools converts tool definitions to the gollm tools that providers
+// send natively (see convertToolsToCerebras and convertToolsToAnthropic).
+func providerTools(tools []ToolDefinition) []utils.Tool {
+	converted := make([]utils.Tool, 0, len(tools))
+	for _, tool := range tools {
+		parameters := tool.Parameters
+		if parameters == nil {
+			parameters = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		converted = append(converted, utils.Tool{
+			Type:     "function",
+			Function: utils.Function{Name: tool.Name, Description: tool.Description, Parameters: parameters},
+		})
+	}
+	return converted
+}
+
+type toolCallsKey struct{}
+
+// toolCallCollector carries the tools of a tool-calling turn to ProviderLLM,
+// which sends them with the request and records the calls the provider's
+// HandleFunctionCalls finds in the response.
+type toolCallCollector struct {
+	tools []utils.Tool
+
+	mutex sync.Mutex
+	calls []utils.Tool
+}
+
+func withToolCallCollector(ctx context.Context, tools []utils.Tool) (context.Context, *toolCallCollector) {
+	collector := &toolCallCollector{tools: tools}
+	return context.WithValue(ctx, toolCallsKey{}, collector), collector
+}
+
+func toolCallCollectorFrom(ctx context.Context) *toolCallCollector {
+	collector, _ := ctx.Value(toolCallsKey{}).(*toolCallCollector)
+	return collector
+}
+
+// collect records the tool calls in a provider response, replacing those of
+// an earlier response. Providers return the body unchanged when it holds no
+// calls, which does not decode as a list of tools. PII placeholders in the
+// arguments are restored, so tools receive the user's values.
+func (c *toolCallCollector) collect(ctx context.Context, provider providers.Provider, body []byte) {
+	var calls []utils.Tool
+	if handled, err := provider.HandleFunctionCalls(body); err == nil {
+		if json.Unmarshal(handled, &calls) != nil {
+			calls = nil
+		}
+	}
+	if redaction := redactionFrom(ctx); redaction != nil {
+		for _, call := range calls {
+			redaction.restoreValue(call.Function.Parameters)
+		}
+	}
+	c.mutex.Lock()
+	c.calls = calls
+	c.mutex.Unlock()
+}
+
+// take returns the recorded calls as ToolCalls numbered for iteration and
+// clears them for the next turn.
+func (c *toolCallCollector) take(iteration int) []ToolCall {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var calls []ToolCall
+	for i, call := range c.calls {
+		if call.Function.Name == "" {
+			continue
+		}
+		arguments := json.RawMessage("{}")
+		if len(call.Function.Parameters) > 0 {
+			if encoded, err := json.Marshal(call.Function.Parameters); err == nil {
+				arguments = encoded
+			}
+		}
+		calls = append(calls, ToolCall{ID: fmt.Sprintf("call_%d_%d", iteration, i+1), Name: call.Function.Name, Arguments: arguments})
+	}
+	c.calls = nil
+	return calls
+}
+
+// formatToolResult renders a tool result as the content of a tool message.
+func formatToolResult(result ToolResult) string {
+	if result.Error != "" {
+		return fmt.Sprintf("Result of %s (call %s): ERROR: %s", result.Name, result.CallID, result.Error)
+	}
+	return fmt.Sprintf("Result of %s (call %s): %s", result.Name, result.CallID, result.Output)
+}
+
+// GenerateWithTools runs a tool-calling loop: the selected tools (all
+// registered tools when toolNames is empty) are offered to the model through
+// its provider's native tool support, and the calls it makes are executed and
+// fed back as tool messages until the model gives a final answer or
+// maxIterations model turns have been made. Models whose provider has no tool
+// support answer directly. The returned trace contains every turn, tool call
+// and result.
+func (d *DelegatorService) GenerateWithTools(ctx context.Context, sessionID string, modelName string, promptText string, instructionText string, registry *ToolRegistry, toolNames []string, maxIterations int) (*ToolTrace, error) {
+	if registry == nil {
+		return nil, errors.New("tool generation: no tool registry configured")
+	}
+	tools, err := registry.Select(toolNames)
+	if err != nil {
+		return nil, err
+	}
+	if len(tools) == 0 {
+		return nil, errors.New("tool generation: no tools available")
+	}
+	if maxIterations <= 0 {
+		maxIterations = DefaultMaxToolIterations
+	}
+
+	memory, err := d.sessions.Memory(ctx, sessionID, d.tokenLimitCheckModel)
+	if err != nil {
+		return nil, err
+	}
+	if modelName == "" && sessionID != "" {
+		if session, err := d.sessions.GetSession(ctx, sessionID); err == nil {
+			modelName = session.Model
+		}
+	}
+	tokenCheckModel := d.tokenLimitCheckModel
+	if modelName != "" {
+		tokenCheckModel = modelName
+	}
+
+	allowed := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		allowed[tool.Name] = true
+	}
+	// Hedged attempts would race to record their calls, so turns are not hedged
+	ctx, collector := withToolCallCollector(WithHedgeDelay(ctx, 0), providerTools(tools))
+	log.Printf("DelegatorService (Tools): Starting tool loop with %d tools (max %d iterations).", len(tools), maxIterations)
+
+	memory.AddMessage(gollm_types.MemoryMessage{Role: "user", Content: promptText})
+
+	trace := &ToolTrace{Steps: make([]ToolStep, 0, maxIterations)}
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		if err := ctx.Err(); err != nil {
+			return trace, err
+		}
+		messagesForContext := memory.GetMessagesForContext(d.tokenLimitThreshold, tokenCheckModel)
+		if len(messagesForContext) == 0 {
+			return trace, errors.New("tool generation: no messages fit context window")
+		}
+
+		response, err := d.executeGenerationWithRetry(ctx, memory, modelName, messagesForContext, instructionText, "Tools")
+		calls := collector.take(iteration)
+		if err != nil {
+			return trace, fmt.Errorf("tool generation failed at iteration %d: %w", iteration, err)
+		}
+		trace.Iterations = iteration
+		step := ToolStep{Iteration: iteration, Response: response}
+
+		if len(calls) == 0 {
+			trace.Steps = append(trace.Steps, step)
+			trace.Answer = response
+			trace.StoppedReason = "final_answer"
+			log.Printf("DelegatorService (Tools): Final answer after %d iterations.", iteration)
+			return trace, nil
+		}
+
+		step.ToolCalls = calls
+		for _, call := range calls {
+			log.Printf("DelegatorService (Tools): Iteration %d: executing tool '%s' (call %s)", iteration, call.Name, call.ID)
+			result := registry.execute(ctx, allowed, call)
+			step.Results = append(step.Results, result)
+			memory.AddMessage(gollm_types.MemoryMessage{Role: "tool", Content: formatToolResult(result)})
+		}
+		trace.Steps = append(trace.Steps, step)
+	}
+
+	log.Printf("DelegatorService (Tools): Stopped after reaching max iterations (%d).", maxIterations)
+	trace.StoppedReason = "max_iterations"
+	if len(trace.Steps) > 0 {
+		trace.Answer = trace.Steps[len(trace.Steps)-1].Response
+	}
+	return trace, nil
+}
+
+// registerBuiltinTools registers the Go tools available out of the box.
+func registerBuiltinTools(registry *ToolRegistry) {
+	registry.RegisterFunc("get_current_time", "Returns the current date and time, optionally in an IANA time zone.",
+		map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"timezone": map[string]interface{}{
+					"type":        "string",
+					"description": "IANA time zone name, e.g. Europe/Berlin. Defaults to UTC.",
+				},
+			},
+		},
+		func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Timezone string `json:"timezone"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			location := time.UTC
+			if args.Timezone != "" {
+				loc, err := time.LoadLocation(args.Timezone)
+				if err != nil {
+					return "", fmt.Errorf("unknown time zone %q", args.Timezone)
+				}
+				location = loc
+			}
+			return time.Now().In(location).Format(time.RFC3339), nil
+		})
+}
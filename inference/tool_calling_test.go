@@ -0,0 +1,109 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTPToolEndpointsMustBeAllowed(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	registry := NewToolRegistry()
+	tool := ToolDefinition{Name: "lookup", Endpoint: endpoint, Headers: map[string]string{"Authorization": "Bearer secret"}}
+	if err := registry.RegisterHTTP(tool); !errors.Is(err, ErrToolEndpointNotAllowed) {
+		t.Fatalf("Expected HTTP tools to be refused without allowed hosts, got %v", err)
+	}
+	registry.SetAllowedHosts([]string{"127.0.0.1", "169.254.169.254"})
+	for _, target := range []string{server.URL, "http://169.254.169.254/latest/meta-data"} {
+		if err := registry.RegisterHTTP(ToolDefinition{Name: "lookup", Endpoint: target}); !errors.Is(err, ErrToolEndpointNotAllowed) {
+			t.Errorf("Expected the internal address %s to be refused, got %v", target, err)
+		}
+	}
+
+	// An allowed name that resolves to an internal address is refused when dialed
+	registry.SetAllowedHosts([]string{"localhost"})
+	if err := registry.RegisterHTTP(tool); err != nil {
+		t.Fatalf("RegisterHTTP failed: %v", err)
+	}
+	result := registry.execute(context.Background(), map[string]bool{"lookup": true}, ToolCall{ID: "call_1", Name: "lookup", Arguments: json.RawMessage("{}")})
+	if !strings.Contains(result.Error, "internal address") || atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("Expected the call to be refused before reaching the server, got %+v (%d calls)", result, calls)
+	}
+	for _, listed := range registry.List() {
+		if listed.Headers != nil {
+			t.Errorf("Expected listed tools without headers, got %+v", listed)
+		}
+	}
+}
+
+func TestToolLoopUsesNativeToolCalls(t *testing.T) {
+	// The fake refuses calls to tools that were not sent with the request
+	service := startFakeInferenceService(t, `{
+		"rules": [
+			{"match": "Result of get_current_time", "response": "It is noon."},
+			{"match": "What time", "tool_calls": [{"name": "get_current_time", "arguments": {"timezone": "UTC"}}]}
+		]
+	}`)
+	trace, err := service.GenerateTextWithTools(context.Background(), "", "", "What time is it?", "", []string{"get_current_time"}, 0)
+	if err != nil {
+		t.Fatalf("GenerateTextWithTools failed: %v", err)
+	}
+	if trace.Answer != "It is noon." || trace.Iterations != 2 || trace.StoppedReason != "final_answer" {
+		t.Fatalf("Expected a final answer after one tool turn, got %+v", trace)
+	}
+	step := trace.Steps[0]
+	if len(step.ToolCalls) != 1 || string(step.ToolCalls[0].Arguments) != `{"timezone":"UTC"}` || len(step.Results) != 1 || step.Results[0].Error != "" {
+		t.Errorf("Expected one successful get_current_time call, got %+v", step)
+	}
+
+	// Cerebras sends the tool's parameter schema as it was registered
+	tools, _ := service.Tools().Select([]string{"get_current_time"})
+	converted, err := convertToolsToCerebras(providerTools(tools))
+	if err != nil {
+		t.Fatalf("convertToolsToCerebras failed: %v", err)
+	}
+	encoded, _ := json.Marshal(converted)
+	if !strings.Contains(string(encoded), `"timezone":{"type":"string"`) {
+		t.Errorf("Expected the timezone parameter in the Cerebras tool, got %s", encoded)
+	}
+}
+
+func TestToolCallsReceiveRedactedValues(t *testing.T) {
+	t.Setenv("PII_REDACTION", "true")
+	// The fake only sees placeholders, and calls the tool with one
+	service := startFakeInferenceService(t, `{
+		"rules": [
+			{"match": "Result of send_note", "response": "Sent."},
+			{"match": "note to \\[EMAIL_1\\]", "tool_calls": [{"name": "send_note", "arguments": {"to": "[EMAIL_1]", "cc": ["[EMAIL_1]"]}}]}
+		]
+	}`)
+	var received string
+	err := service.Tools().RegisterFunc("send_note", "Sends a note", nil, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		received = string(arguments)
+		return "sent", nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunc failed: %v", err)
+	}
+	trace, err := service.GenerateTextWithTools(context.Background(), "", "", "Send a note to jane@example.com", "", []string{"send_note"}, 0)
+	if err != nil {
+		t.Fatalf("GenerateTextWithTools failed: %v", err)
+	}
+	if trace.Answer != "Sent." {
+		t.Fatalf("Expected a final answer after the tool call, got %+v", trace)
+	}
+	if received != `{"cc":["jane@example.com"],"to":"jane@example.com"}` {
+		t.Errorf("Expected the tool to receive the user's email, got %s", received)
+	}
+}
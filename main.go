@@ -47,6 +47,7 @@ func main() {
 	dbDir := "./data"
 	domainDBPath := filepath.Join(dbDir, "domain.db")
 	authDBPath := filepath.Join(dbDir, "auth.db")
+	conversationDBPath := filepath.Join(dbDir, "conversations.db")
 
 	if *cleanDB {
 		log.Printf("🧹 Attempting to clean database directory: %s", dbDir)
@@ -76,11 +77,22 @@ func main() {
 	}
 	defer domainDB.Close()
 
+	// Initialize conversation database
+	conversationDB, err := database.NewConversationDB(conversationDBPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize conversation database: %v", err)
+	}
+	defer conversationDB.Close()
+
 	// Initialize inference service
 	inferenceService, err := inference.NewInferenceService(domainDB)
 	if err != nil {
 		log.Fatalf("Failed to initialize inference service: %v", err)
 	}
+	inferenceService.EnableConversationPersistence(database.NewConversationRepository(conversationDB.GetDB()))
+	if err := inferenceService.Start(); err != nil {
+		log.Printf("⚠️  Warning: Inference service failed to start: %v", err)
+	}
 
 	// Get JWT secret from environment or use a default
 	jwtSecret := os.Getenv("JWT_SECRET")